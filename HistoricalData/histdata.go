@@ -170,9 +170,32 @@ func FetchCandles(start, end time.Time) (models.Dataset, error) {
 }
 
 // Also need a simple function to get the most recent 50 candles
-// Could improve efficiency of this by using worker pool later on
+// Binance gives at most 1000 klines per call, so a bigger limit is fetched in pages going back in time
 func RecentCandles(symbol, interval string, limit int) ([]models.CandleStick, error) {
-	endTime := time.Now().UnixMilli()
+	now := time.Now().UnixMilli()
+	endTime := now
+	var candles []models.CandleStick
+	for len(candles) < limit {
+		// The last kline is the candle that is still open, so ask for one extra and drop it (the live stream gives that candle when it closes)
+		page, err := recentPage(symbol, interval, min(limit-len(candles)+1, 1000), endTime, now)
+		if err != nil {
+			return nil, err
+		}
+		if len(page) == 0 {
+			break // Back to the start of the symbol's history
+		}
+		candles = append(page, candles...)
+		endTime = page[0].OpenTime - 1
+	}
+	if len(candles) > limit {
+		candles = candles[len(candles)-limit:]
+	}
+
+	return candles, nil
+}
+
+// One call for the klines up to endTime, leaving out any that had not closed by now
+func recentPage(symbol, interval string, limit int, endTime, now int64) ([]models.CandleStick, error) {
 	url := fmt.Sprintf("https://api.binance.com/api/v3/klines?symbol=%s&interval=%s&limit=%d&endTime=%d", symbol, interval, limit, endTime)
 
 	resp, err := http.Get(url)
@@ -196,6 +219,9 @@ func RecentCandles(symbol, interval string, limit int) ([]models.CandleStick, er
 		volume, _ := strconv.ParseFloat(item[5].(string), 64)
 		closeTime := int64(item[6].(float64))
 		numTrades := int64(item[8].(float64))
+		if closeTime >= now {
+			continue
+		}
 
 		candles = append(candles, models.CandleStick{
 			OpenTime:    openTime,
@@ -209,6 +235,5 @@ func RecentCandles(symbol, interval string, limit int) ([]models.CandleStick, er
 			IsFinal:     true,
 		})
 	}
-
 	return candles, nil
 }
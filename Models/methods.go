@@ -23,3 +23,21 @@ func (c *CandleStick) Update(price float64,
 	c.NumOfTrades += deltaTrade
 	c.CloseTime = time.Now().Unix()
 }
+
+// Need to be able to get the plain candle back from the enriched candle (for example to feed the higher timeframes in the training run)
+func (c EnrichedCandle) CandleStick() CandleStick {
+	return CandleStick{
+		OpenTime: c.OpenTime,
+		Open:     c.Open,
+		High:     c.High,
+		Low:      c.Low,
+		Close:    c.Close,
+		Volume:   c.Volume,
+		IsFinal:  true,
+	}
+}
+
+// This lets the plain candle be used anywhere that takes any candle type (e.g. the generic sliding window)
+func (c CandleStick) CandleStick() CandleStick {
+	return c
+}
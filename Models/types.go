@@ -144,10 +144,11 @@ type DexResponse struct {
 
 // Will need a type for our aggregated data snapshots
 type AggregateSnapshot struct {
-	PriceUSD   float64
-	VolumeM5   float64
-	TxnsBuyM5  int64
-	TxnsSellM5 int64
+	PriceUSD     float64
+	VolumeM5     float64
+	TxnsBuyM5    int64
+	TxnsSellM5   int64
+	LiquidityUSD float64 // Total USD liquidity of the pools
 }
 
 // When working with the WebSocket, the format is a little different so need to define two other types
@@ -196,20 +197,29 @@ type EnrichedCandle struct {
 
 // Need the type for the data which will be inserted to MySQL DB to develop the ML component
 type DevData struct {
-	OpenTime int64
-	Open     float64
-	High     float64
-	Low      float64
-	Close    float64
-	Volume   float64
-	ADX      float64
-	Idx      int
-	SigEntry int
-	SigExit  int
+	OpenTime    int64
+	Open        float64
+	High        float64
+	Low         float64
+	Close       float64
+	Volume      float64
+	ADX         float64
+	Idx         int
+	Regime      int // The bot.Regime the candle was classified as
+	SigEntry    int
+	SigExit     int
+	Breakout    int     // 1 if a wick broke the resistance line, -1 if it broke the support line, 2 if it broke both
+	ResFilters  int     // Bitmask of the breakout confirmation filters the resistance break passed (see bot.BreakoutFilters.Mask)
+	SupFilters  int     // Same for the support break
+	Chart       int     // The bot.ChartPattern the candle arrived in
+	ChartBreak  int     // 1 if the candle closed above the chart pattern, -1 if below, 0 if neither
+	ChartTarget float64 // The measured-move target of that breakout, 0 if there was none
+	Patterns    []int   // One flag per candlestick pattern, in the order of the bot.Pattern constants
 }
 
 // Need the type for our trendlines
 type Trendline struct {
+	ID        int64   // Given by the window when the line is created, 0 means the line was never tracked
 	Gradient  float64 // Need the gradient and intercept for linear line: y = mx + c
 	Intercept float64
 	A1Time    int64 // Also need the Open time of the 2 anchor candles
@@ -217,3 +227,68 @@ type Trendline struct {
 	A1Price   float64 // Also need the 2 anchor candles respective close price
 	A2Price   float64
 }
+
+// Each trendline goes through a lifecycle (created, touched, broken, expired or replaced), these are published as events so they can be stored and replayed
+type TrendlineEvent struct {
+	LineID   int64
+	Kind     string // One of "created", "touched", "broken", "expired" or "replaced"
+	Symbol   string
+	Interval string
+	IsResist bool
+	OpenTime int64   // OpenTime of the candle the event happened on
+	Price    float64 // Price of the line at that candle
+	Touches  int     // Number of touches the line had at the time of the event
+	Line     Trendline
+}
+
+// What a strategy outputs, the same signals drive the training labels, the backtests, paper trading and live trading
+type Signal struct {
+	Strategy   string
+	Symbol     string
+	OpenTime   int64  // OpenTime of the candle the signal was made on
+	Kind       string // "entry" or "exit"
+	Side       int    // 1 for a long, -1 for a short
+	Price      float64
+	Intrabar   bool    // Made before the candle closed (Price is then the price at the time rather than the close)
+	Reason     string  // Why the strategy made the signal, e.g. "breakout", "adx" or "limit"
+	Order      string  // How the signal should be traded: "market" (the default if empty), "limit" or "stop"
+	OrderPrice float64 // Limit or stop price when Order is "limit" or "stop"
+	Confidence float64 // Confidence from the ML model in [0, 1], 0 if there is no score
+	Stop       float64 // For entries, the level the trade idea is wrong beyond (e.g. the broken trendline), 0 if there is none
+	Fraction   float64 // For exits, the fraction of the position to close, 0 for all of it
+	Target     float64 // For entries, a price target for the trade (e.g. a chart pattern's measured move), 0 if there is none
+}
+
+// An order made from a signal, used by the backtester and the brokers
+type Order struct {
+	ID         int64
+	ClientID   string // Our own ID for the order, the venue echoes it back on the fills so a retried submit is never placed twice
+	Symbol     string
+	Side       int    // 1 to buy, -1 to sell
+	Type       string // "market", "limit", "stop" or "stop-limit"
+	Qty        float64
+	Price      float64 // Limit price (limit, stop-limit) or stop trigger price (stop), unused for market orders
+	StopPrice  float64 // Trigger price of a stop-limit order
+	OCO        string  // Orders in the same one-cancels-the-other group, once one fills the others are cancelled
+	Expires    int64   // Unix time in ms after which an unfilled order is expired, 0 to never expire
+	Created    int64   // OpenTime of the candle the order was placed on
+	ReduceOnly bool    // Only closes the position (exits), up to Qty of it when it fills (all of it if Qty is 0)
+	Reason     string
+	Confidence float64 // Carried over from the signal so it can be logged with the fill
+}
+
+// A (simulated or real) execution of an order
+type Fill struct {
+	OrderID    int64
+	ClientID   string
+	Symbol     string
+	OpenTime   int64 // OpenTime of the candle the fill happened on
+	Side       int
+	Qty        float64
+	Price      float64 // Price after the spread and slippage
+	Fee        float64
+	Maker      bool
+	Type       string // Type of the order that filled
+	Reason     string
+	Confidence float64
+}
@@ -0,0 +1,373 @@
+package backtest
+
+import (
+	"context"
+	"math"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+	bot "github.com/Reece-Ogidih/CT-Bot/bot"
+)
+
+// Event-driven backtester, the candles are fed through a strategy one at a time exactly as they would arrive live
+// On each final candle:
+//  1. The orders placed on earlier candles are filled against this candle (so there is no look-ahead)
+//  2. The candle is given to the strategy and its signals are turned into orders
+//  3. Market orders fill at the next open (or straight away with FillOnClose), limit/stop orders wait for the price
+//  4. The cash, position and equity are recorded for the candle
+// Nothing is random, so the same candles and config always give the same result
+
+type Config struct {
+	InitialCash float64
+	Fraction    float64 // Fraction of the equity put into each entry
+	MakerFee    float64 // Fee as a fraction of the notional for limit fills
+	TakerFee    float64 // Fee as a fraction of the notional for market and stop fills
+	Spread      float64 // Full bid/ask spread as a fraction of the price, market and stop fills pay half of it
+	Slippage    float64 // Extra adverse move as a fraction of the price for market and stop fills
+	FillOnClose bool    // Fill market orders at the close of the signal candle instead of the next open
+	OrderExpiry int     // Candles an unfilled limit/stop entry stays open, 0 to keep it until it fills or the strategy exits
+	CloseAtEnd  bool    // Close any open position at the last close so it shows up in the trades
+}
+
+// Binance USD-M futures fees with a small spread and slippage for SOL
+func DefaultConfig() Config {
+	return Config{
+		InitialCash: 10000,
+		Fraction:    1,
+		MakerFee:    0.0002,
+		TakerFee:    0.0005,
+		Spread:      0.0001,
+		Slippage:    0.0002,
+		CloseAtEnd:  true,
+	}
+}
+
+// A completed round trip
+type Trade struct {
+	Side        int // 1 long, -1 short
+	EntryTime   int64
+	EntryPrice  float64
+	ExitTime    int64
+	ExitPrice   float64
+	Qty         float64
+	Fees        float64 // Entry and exit fees
+	PnL         float64 // After fees
+	Return      float64 // PnL over the entry notional
+	Bars        int     // Candles the trade was held for
+	EntryReason string
+	ExitReason  string
+}
+
+// The account at the close of each candle
+type EquityPoint struct {
+	OpenTime int64
+	Close    float64
+	Cash     float64
+	Position float64 // Signed quantity, negative when short
+	Equity   float64
+	Drawdown float64 // Fraction below the highest equity so far
+}
+
+type Result struct {
+	Config  Config
+	Trades  []Trade
+	Equity  []EquityPoint
+	Fills   []models.Fill
+	Summary Summary
+}
+
+type position struct {
+	qty         float64 // Signed
+	entryPrice  float64 // Average entry price
+	entryTime   int64
+	entryBar    int
+	fees        float64 // Entry fees of the open position
+	entryReason string
+}
+
+type Engine[C bot.Candle] struct {
+	Config   Config
+	Strategy bot.Strategy[C]
+	Sizer    *bot.Sizer // If set, the entries are sized from the confidence by this (see bot/sizing.go) instead of Config.Fraction
+	cash     float64
+	pos      position
+	pending  []models.Order
+	expiry   map[int64]int // Bar index each pending limit/stop entry expires on
+	nextID   int64
+	bar      int
+	peak     float64
+	last     models.CandleStick
+	symbol   string
+	result   Result
+}
+
+func New[C bot.Candle](strategy bot.Strategy[C], cfg Config) *Engine[C] {
+	return &Engine[C]{
+		Config:   cfg,
+		Strategy: strategy,
+		cash:     cfg.InitialCash,
+		peak:     cfg.InitialCash,
+		expiry:   make(map[int64]int),
+		result:   Result{Config: cfg},
+	}
+}
+
+// Run feeds every candle through the strategy, stopping early if the context is cancelled
+func (e *Engine[C]) Run(ctx context.Context, feed Feed[C]) (*Result, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		candle, ok := feed.Next()
+		if !ok {
+			break
+		}
+		e.Step(ctx, candle)
+	}
+	if err := feed.Err(); err != nil {
+		return nil, err
+	}
+	return e.Finish(), nil
+}
+
+// Step processes a single candle, Run calls this for each candle but it can be used directly to drive the engine from a stream
+func (e *Engine[C]) Step(ctx context.Context, candle C) {
+	c := candle.CandleStick()
+
+	// Candles that have not closed yet only go to the strategy, any market orders from them fill straight away at the signal price
+	if !c.IsFinal {
+		for _, sig := range e.Strategy.OnCandle(ctx, candle) {
+			e.signal(sig, c)
+		}
+		return
+	}
+
+	e.fillPending(c)
+	if e.Sizer != nil {
+		e.Sizer.Update(c)
+	}
+	for _, sig := range e.Strategy.OnCandle(ctx, candle) {
+		e.signal(sig, c)
+	}
+	e.record(c)
+	e.last = c
+	e.bar++
+}
+
+// Finish closes any open position (if CloseAtEnd) and works out the summary
+func (e *Engine[C]) Finish() *Result {
+	if e.Config.CloseAtEnd && e.pos.qty != 0 && len(e.result.Equity) > 0 {
+		side := -sign(e.pos.qty)
+		o := models.Order{ID: e.id(), Symbol: e.symbol, Side: side, Type: "market", Qty: math.Abs(e.pos.qty), ReduceOnly: true, Reason: "end"}
+		e.bar--
+		e.fill(o, e.Config.adverse(e.last.Close, side), false, e.last.OpenTime)
+		e.bar++
+		// The last point now has the position closed
+		e.result.Equity = e.result.Equity[:len(e.result.Equity)-1]
+		e.record(e.last)
+	}
+	e.result.Summary = Summarise(e.result.Config, e.result.Trades, e.result.Equity)
+	return &e.result
+}
+
+func (e *Engine[C]) id() int64 {
+	e.nextID++
+	return e.nextID
+}
+
+// Turns a strategy signal into an order
+func (e *Engine[C]) signal(sig models.Signal, c models.CandleStick) {
+	var o models.Order
+	if sig.Kind == bot.SignalExit {
+		// Only exit the position the signal is for, and drop any entries still waiting to fill
+		e.cancelEntries()
+		if sign(e.pos.qty) != sig.Side {
+			return
+		}
+		o = models.Order{Side: -sig.Side, Type: "market", ReduceOnly: true, Reason: sig.Reason}
+		// A partial exit (e.g. a take-profit) closes its fraction of the position as it is now
+		if sig.Fraction > 0 && sig.Fraction < 1 {
+			o.Qty = math.Abs(e.pos.qty) * sig.Fraction
+		}
+	} else {
+		if sign(e.pos.qty) == sig.Side {
+			return
+		}
+		price := sig.Price
+		if sig.OrderPrice > 0 {
+			price = sig.OrderPrice
+		}
+		equity := e.cash + e.pos.qty*sig.Price
+		// A reversal has to account for the cash freed by closing the old position first
+		if e.pos.qty != 0 {
+			equity = e.cash + e.pos.qty*sig.Price - e.Config.fee(math.Abs(e.pos.qty), sig.Price, false)
+		}
+		if equity <= 0 || price <= 0 {
+			return
+		}
+		o = models.Order{Side: sig.Side, Type: "market", Qty: equity * e.Config.Fraction / price, Reason: sig.Reason}
+		orderPrice := sig.OrderPrice
+		if e.Sizer != nil {
+			size := e.Sizer.Size(sig, equity)
+			if size.Qty <= 0 {
+				return
+			}
+			o.Qty, orderPrice, o.Reason = size.Qty, size.Price, sig.Reason+"; "+size.Rationale
+		}
+		if sig.Order == "limit" || sig.Order == "stop" {
+			o.Type, o.Price = sig.Order, orderPrice
+		}
+		// With no exit on the way the same order has to close the old position before opening the new one
+		if e.pos.qty != 0 && !e.exiting() {
+			o.Qty += math.Abs(e.pos.qty)
+		}
+	}
+	o.ID, o.Symbol, o.Created, o.Confidence = e.id(), sig.Symbol, c.OpenTime, sig.Confidence
+	e.symbol = sig.Symbol
+
+	switch {
+	case o.Type == "market" && sig.Intrabar:
+		e.fill(o, e.Config.adverse(sig.Price, o.Side), false, c.OpenTime)
+	case o.Type == "market" && e.Config.FillOnClose:
+		e.fill(o, e.Config.adverse(c.Close, o.Side), false, c.OpenTime)
+	default:
+		if o.Type != "market" && e.Config.OrderExpiry > 0 {
+			e.expiry[o.ID] = e.bar + e.Config.OrderExpiry
+		}
+		e.pending = append(e.pending, o)
+	}
+}
+
+// Whether an exit is waiting to fill
+func (e *Engine[C]) exiting() bool {
+	for _, o := range e.pending {
+		if o.ReduceOnly {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Engine[C]) cancelEntries() {
+	kept := e.pending[:0]
+	for _, o := range e.pending {
+		if o.ReduceOnly {
+			kept = append(kept, o)
+		} else {
+			delete(e.expiry, o.ID)
+		}
+	}
+	e.pending = kept
+}
+
+// Fills the waiting orders against the candle in the order they were placed
+func (e *Engine[C]) fillPending(c models.CandleStick) {
+	kept := e.pending[:0]
+	for _, o := range e.pending {
+		if exp, ok := e.expiry[o.ID]; ok && e.bar >= exp {
+			delete(e.expiry, o.ID)
+			continue
+		}
+		// A triggered stop-limit waits as a plain limit from then on
+		if o.Type == "stop-limit" && triggered(o, c) {
+			o.Type = "limit"
+		}
+		price, maker, ok := e.Config.fillOnBar(o, c)
+		if !ok {
+			kept = append(kept, o)
+			continue
+		}
+		delete(e.expiry, o.ID)
+		e.fill(o, price, maker, c.OpenTime)
+	}
+	e.pending = kept
+}
+
+// Applies a fill to the cash and position, closing (and recording) the trade when the position goes back through 0
+func (e *Engine[C]) fill(o models.Order, price float64, maker bool, openTime int64) {
+	qty := o.Qty
+	if o.ReduceOnly {
+		if sign(e.pos.qty) != -o.Side {
+			return
+		}
+		qty = math.Abs(e.pos.qty)
+		if o.Qty > 0 {
+			qty = math.Min(o.Qty, qty)
+		}
+	}
+	if qty <= 0 {
+		return
+	}
+	fee := e.Config.fee(qty, price, maker)
+	e.cash -= float64(o.Side)*qty*price + fee
+	e.result.Fills = append(e.result.Fills, models.Fill{OrderID: o.ID, Symbol: o.Symbol, OpenTime: openTime, Side: o.Side, Qty: qty, Price: price, Fee: fee, Maker: maker,
+		Type: o.Type, Reason: o.Reason, Confidence: o.Confidence})
+
+	// Closing part (or all) of the position
+	if e.pos.qty != 0 && sign(e.pos.qty) != o.Side {
+		closed := math.Min(qty, math.Abs(e.pos.qty))
+		share := closed / math.Abs(e.pos.qty)
+		entryFees := e.pos.fees * share
+		exitFees := fee * closed / qty
+		side := sign(e.pos.qty)
+		pnl := float64(side)*(price-e.pos.entryPrice)*closed - entryFees - exitFees
+		e.result.Trades = append(e.result.Trades, Trade{
+			Side:        side,
+			EntryTime:   e.pos.entryTime,
+			EntryPrice:  e.pos.entryPrice,
+			ExitTime:    openTime,
+			ExitPrice:   price,
+			Qty:         closed,
+			Fees:        entryFees + exitFees,
+			PnL:         pnl,
+			Return:      pnl / (e.pos.entryPrice * closed),
+			Bars:        e.bar - e.pos.entryBar,
+			EntryReason: e.pos.entryReason,
+			ExitReason:  o.Reason,
+		})
+		e.pos.fees -= entryFees
+		e.pos.qty += float64(o.Side) * closed
+		qty -= closed
+		fee -= exitFees
+		if e.pos.qty == 0 || math.Abs(e.pos.qty) < 1e-12 {
+			e.pos = position{}
+		}
+	}
+
+	// Opening (or adding to) a position with whatever is left
+	if qty > 0 {
+		if e.pos.qty == 0 {
+			e.pos = position{entryTime: openTime, entryBar: e.bar, entryReason: o.Reason}
+		}
+		total := math.Abs(e.pos.qty) + qty
+		e.pos.entryPrice = (e.pos.entryPrice*math.Abs(e.pos.qty) + price*qty) / total
+		e.pos.qty += float64(o.Side) * qty
+		e.pos.fees += fee
+	}
+}
+
+func (e *Engine[C]) record(c models.CandleStick) {
+	equity := e.cash + e.pos.qty*c.Close
+	e.peak = math.Max(e.peak, equity)
+	dd := 0.0
+	if e.peak > 0 {
+		dd = (e.peak - equity) / e.peak
+	}
+	e.result.Equity = append(e.result.Equity, EquityPoint{
+		OpenTime: c.OpenTime,
+		Close:    c.Close,
+		Cash:     e.cash,
+		Position: e.pos.qty,
+		Equity:   equity,
+		Drawdown: dd,
+	})
+}
+
+func sign(x float64) int {
+	if x > 0 {
+		return 1
+	}
+	if x < 0 {
+		return -1
+	}
+	return 0
+}
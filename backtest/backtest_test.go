@@ -0,0 +1,301 @@
+package backtest
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+	bot "github.com/Reece-Ogidih/CT-Bot/bot"
+)
+
+// The engine driven by a scripted strategy over a handful of hand made candles, so every fill price and PnL can be worked out by hand
+
+// Gives the signals set for each OpenTime and nothing else
+type script map[int64][]models.Signal
+
+func (s script) Name() string { return "script" }
+func (s script) OnCandle(ctx context.Context, c models.CandleStick) []models.Signal {
+	return s[c.OpenTime]
+}
+func (s script) Snapshot() (bot.StrategyState, error) { return bot.StrategyState{}, nil }
+func (s script) Restore(bot.StrategyState) error      { return nil }
+
+func entry(side int, price float64) models.Signal {
+	return models.Signal{Symbol: "SOLUSDT", Kind: bot.SignalEntry, Side: side, Price: price, Reason: "in"}
+}
+
+func exit(side int) models.Signal {
+	return models.Signal{Symbol: "SOLUSDT", Kind: bot.SignalExit, Side: side, Reason: "out"}
+}
+
+func candle(t int64, o, h, l, c float64) models.CandleStick {
+	return models.CandleStick{OpenTime: t, Open: o, High: h, Low: l, Close: c, IsFinal: true}
+}
+
+// No costs, so the fills are at the raw prices
+func plainConfig() Config {
+	return Config{InitialCash: 1000, Fraction: 1}
+}
+
+func run(t *testing.T, s script, cfg Config, candles ...models.CandleStick) *Result {
+	t.Helper()
+	res, err := New[models.CandleStick](s, cfg).Run(context.Background(), FromSlice(candles))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+func TestMarketOrders(t *testing.T) {
+	candles := []models.CandleStick{
+		candle(1, 100, 101, 99, 100),
+		candle(2, 102, 103, 101, 102),
+		candle(3, 105, 106, 104, 105),
+	}
+	s := script{1: {entry(1, 100)}, 2: {exit(1)}}
+
+	// Filled at the next open
+	res := run(t, s, plainConfig(), candles...)
+	if len(res.Fills) != 2 || res.Fills[0].Price != 102 || res.Fills[0].Qty != 10 || res.Fills[1].Price != 105 {
+		t.Fatalf("fills %+v", res.Fills)
+	}
+	if len(res.Trades) != 1 {
+		t.Fatalf("%d trades, want 1", len(res.Trades))
+	}
+	tr := res.Trades[0]
+	if tr.Side != 1 || tr.EntryTime != 2 || tr.ExitTime != 3 || tr.Bars != 1 || !near(tr.PnL, 30) || tr.EntryReason != "in" || tr.ExitReason != "out" {
+		t.Fatalf("trade %+v", tr)
+	}
+	if last := res.Equity[len(res.Equity)-1]; !near(last.Equity, 1030) || last.Position != 0 {
+		t.Fatalf("last equity point %+v", last)
+	}
+
+	// Filled at the close of the signal candle
+	cfg := plainConfig()
+	cfg.FillOnClose = true
+	res = run(t, s, cfg, candles...)
+	if len(res.Trades) != 1 || res.Trades[0].EntryPrice != 100 || res.Trades[0].ExitPrice != 102 || !near(res.Trades[0].PnL, 20) {
+		t.Fatalf("trades on close %+v", res.Trades)
+	}
+}
+
+func TestCosts(t *testing.T) {
+	cfg := Config{InitialCash: 1000, Fraction: 1, MakerFee: 0.0005, TakerFee: 0.001, Spread: 0.002, Slippage: 0.001}
+	res := run(t, script{1: {entry(1, 100)}, 2: {exit(1)}}, cfg,
+		candle(1, 100, 101, 99, 100),
+		candle(2, 102, 103, 101, 102),
+		candle(3, 105, 106, 104, 105),
+	)
+	// Half the spread and the slippage against each side, the taker fee on both
+	in, out := 102*1.002, 105*0.998
+	fees := 10*in*0.001 + 10*out*0.001
+	if len(res.Trades) != 1 {
+		t.Fatalf("%d trades, want 1", len(res.Trades))
+	}
+	tr := res.Trades[0]
+	if !near(tr.EntryPrice, in) || !near(tr.ExitPrice, out) || !near(tr.Fees, fees) || !near(tr.PnL, 10*(out-in)-fees) {
+		t.Fatalf("trade %+v, want %.4f -> %.4f with %.4f of fees", tr, in, out, fees)
+	}
+	if res.Fills[0].Maker || res.Fills[1].Maker {
+		t.Fatal("market fills as the maker")
+	}
+	if !near(res.Summary.FinalEquity, 1000+tr.PnL) || !near(res.Summary.Fees, fees) {
+		t.Fatalf("summary %+v", res.Summary)
+	}
+}
+
+func TestLimitAndStopOrders(t *testing.T) {
+	cfg := plainConfig()
+	cfg.MakerFee, cfg.TakerFee = 0.001, 0.002
+
+	// A buy limit at 98 waits until the price trades down to it, then fills at the limit as the maker (sized at the limit price)
+	limit := entry(1, 100)
+	limit.Order, limit.OrderPrice = "limit", 98
+	res := run(t, script{1: {limit}}, cfg,
+		candle(1, 100, 101, 99, 100),
+		candle(2, 100, 101, 99, 100),
+		candle(3, 99, 99, 97, 98),
+	)
+	if len(res.Fills) != 1 || res.Fills[0].OpenTime != 3 || !near(res.Fills[0].Qty, 1000.0/98) || res.Fills[0].Price != 98 || !res.Fills[0].Maker || !near(res.Fills[0].Fee, 1) {
+		t.Fatalf("limit fills %+v", res.Fills)
+	}
+
+	// A sell stop at 95 that is gapped through fills at the open, as the taker (sized at the stop price)
+	stop := entry(-1, 100)
+	stop.Order, stop.OrderPrice = "stop", 95
+	res = run(t, script{1: {stop}}, cfg,
+		candle(1, 100, 101, 99, 100),
+		candle(2, 94, 95, 93, 94),
+	)
+	if len(res.Fills) != 1 || res.Fills[0].Side != -1 || res.Fills[0].Price != 94 || res.Fills[0].Maker || !near(res.Fills[0].Fee, 1000.0/95*94*0.002) {
+		t.Fatalf("stop fills %+v", res.Fills)
+	}
+
+	// With an expiry of one candle the limit is gone before the price gets there
+	cfg.OrderExpiry = 1
+	res = run(t, script{1: {limit}}, cfg,
+		candle(1, 100, 101, 99, 100),
+		candle(2, 100, 101, 99, 100),
+		candle(3, 99, 99, 97, 98),
+	)
+	if len(res.Fills) != 0 {
+		t.Fatalf("expired limit filled %+v", res.Fills)
+	}
+
+	// An exit drops the entry still waiting
+	res = run(t, script{1: {limit}, 2: {exit(1)}}, plainConfig(),
+		candle(1, 100, 101, 99, 100),
+		candle(2, 100, 101, 99, 100),
+		candle(3, 99, 99, 97, 98),
+	)
+	if len(res.Fills) != 0 {
+		t.Fatalf("cancelled limit filled %+v", res.Fills)
+	}
+}
+
+func TestReversal(t *testing.T) {
+	res := run(t, script{1: {entry(1, 100)}, 2: {entry(-1, 110)}}, plainConfig(),
+		candle(1, 100, 101, 99, 100),
+		candle(2, 100, 111, 100, 110),
+		candle(3, 110, 111, 109, 110),
+	)
+	// One sell closes the 10 long and opens a short with all the equity (1100 at 110)
+	if len(res.Fills) != 2 || res.Fills[1].Side != -1 || !near(res.Fills[1].Qty, 20) {
+		t.Fatalf("fills %+v", res.Fills)
+	}
+	if len(res.Trades) != 1 || res.Trades[0].Side != 1 || res.Trades[0].Qty != 10 || !near(res.Trades[0].PnL, 100) {
+		t.Fatalf("trades %+v", res.Trades)
+	}
+	if last := res.Equity[len(res.Equity)-1]; !near(last.Position, -10) || !near(last.Equity, 1100) {
+		t.Fatalf("last equity point %+v", last)
+	}
+	// Already short, so another short entry does nothing
+	res = run(t, script{1: {entry(-1, 100)}, 2: {entry(-1, 100)}}, plainConfig(),
+		candle(1, 100, 101, 99, 100),
+		candle(2, 100, 101, 99, 100),
+		candle(3, 100, 101, 99, 100),
+	)
+	if len(res.Fills) != 1 {
+		t.Fatalf("fills %+v", res.Fills)
+	}
+}
+
+func TestCloseAtEnd(t *testing.T) {
+	candles := []models.CandleStick{
+		candle(1, 100, 101, 99, 100),
+		candle(2, 100, 101, 99, 100),
+		candle(3, 104, 105, 103, 104),
+	}
+	s := script{1: {entry(1, 100)}}
+	cfg := plainConfig()
+	res := run(t, s, cfg, candles...)
+	if len(res.Trades) != 0 || res.Equity[2].Position != 10 || !near(res.Summary.FinalEquity, 1040) {
+		t.Fatalf("left open: trades %+v, last point %+v", res.Trades, res.Equity[2])
+	}
+
+	cfg.CloseAtEnd = true
+	res = run(t, s, cfg, candles...)
+	if len(res.Trades) != 1 || res.Trades[0].ExitPrice != 104 || res.Trades[0].ExitReason != "end" || res.Trades[0].Bars != 1 {
+		t.Fatalf("trades %+v", res.Trades)
+	}
+	// The last point is replaced rather than added to
+	if len(res.Equity) != 3 || res.Equity[2].Position != 0 || !near(res.Equity[2].Cash, 1040) {
+		t.Fatalf("equity %+v", res.Equity)
+	}
+}
+
+// Random walk 1m candles from the seed
+func walk(n int, seed int64) []models.CandleStick {
+	r := rand.New(rand.NewSource(seed))
+	candles := make([]models.CandleStick, n)
+	price := 150.0
+	for i := range candles {
+		open := price
+		price *= math.Exp(r.NormFloat64() * 0.001)
+		high := math.Max(open, price) * (1 + r.Float64()*0.0005)
+		low := math.Min(open, price) * (1 - r.Float64()*0.0005)
+		candles[i] = candle(int64(i)*60000, open, high, low, price)
+	}
+	return candles
+}
+
+// Enters with the last 20 candles' move and exits 10 candles later, going through the market, limit and stop orders in turn
+type momentum struct {
+	closes []float64
+	held   int
+	side   int
+	n      int
+}
+
+func (m *momentum) Name() string { return "momentum" }
+func (m *momentum) OnCandle(ctx context.Context, c models.CandleStick) []models.Signal {
+	m.closes = append(m.closes, c.Close)
+	if m.side != 0 {
+		m.held++
+		if m.held < 10 {
+			return nil
+		}
+		side := m.side
+		m.side, m.held = 0, 0
+		return []models.Signal{exit(side)}
+	}
+	if len(m.closes) < 20 || len(m.closes)%20 != 0 {
+		return nil
+	}
+	side := 1
+	if c.Close < m.closes[len(m.closes)-20] {
+		side = -1
+	}
+	m.side = side
+	sig := entry(side, c.Close)
+	switch m.n++; m.n % 3 {
+	case 1:
+		sig.Order, sig.OrderPrice = "limit", c.Close*(1-float64(side)*0.0005)
+	case 2:
+		sig.Order, sig.OrderPrice = "stop", c.Close*(1+float64(side)*0.0005)
+	}
+	return []models.Signal{sig}
+}
+func (m *momentum) Snapshot() (bot.StrategyState, error) { return bot.StrategyState{}, nil }
+func (m *momentum) Restore(bot.StrategyState) error      { return nil }
+
+func TestDeterministic(t *testing.T) {
+	candles := walk(20000, 7)
+	cfg := DefaultConfig()
+	cfg.OrderExpiry = 5
+	first, err := New[models.CandleStick](&momentum{}, cfg).Run(context.Background(), FromSlice(candles))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := New[models.CandleStick](&momentum{}, cfg).Run(context.Background(), FromSlice(candles))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Trades) < 100 {
+		t.Fatalf("only %d trades, the test needs more to mean anything", len(first.Trades))
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Fatal("the same candles and config gave two different results")
+	}
+}
+
+// A year of 1m candles
+const benchCandles = 525600
+
+func BenchmarkEngine(b *testing.B) {
+	candles := walk(benchCandles, 1)
+	cfg := DefaultConfig()
+	cfg.OrderExpiry = 5
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := New[models.CandleStick](&momentum{}, cfg).Run(context.Background(), FromSlice(candles)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
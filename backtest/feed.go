@@ -0,0 +1,83 @@
+package backtest
+
+import (
+	"database/sql"
+	"fmt"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+	bot "github.com/Reece-Ogidih/CT-Bot/bot"
+)
+
+// A Feed gives the backtester its candles one at a time (oldest first), so the candles can come from anywhere
+// (a slice in memory, the DB, a channel from the historical fetcher or the live stream)
+type Feed[C bot.Candle] interface {
+	Next() (C, bool) // false once there are no more candles
+	Err() error      // Any error that stopped the feed early
+}
+
+type sliceFeed[C bot.Candle] struct {
+	candles []C
+	i       int
+}
+
+func FromSlice[C bot.Candle](candles []C) Feed[C] {
+	return &sliceFeed[C]{candles: candles}
+}
+
+func (f *sliceFeed[C]) Next() (C, bool) {
+	if f.i >= len(f.candles) {
+		var zero C
+		return zero, false
+	}
+	f.i++
+	return f.candles[f.i-1], true
+}
+
+func (f *sliceFeed[C]) Err() error { return nil }
+
+type chanFeed[C bot.Candle] struct {
+	ch <-chan C
+}
+
+// The feed ends when the channel is closed
+func FromChannel[C bot.Candle](ch <-chan C) Feed[C] {
+	return &chanFeed[C]{ch: ch}
+}
+
+func (f *chanFeed[C]) Next() (C, bool) {
+	c, ok := <-f.ch
+	return c, ok
+}
+
+func (f *chanFeed[C]) Err() error { return nil }
+
+// Streams the candles straight from a query so a year of candles never has to be held in memory
+// The query has to select open_times_ms, open, high, low, close, volume (in that order), e.g. from hist_candles_1m
+type rowsFeed struct {
+	rows *sql.Rows
+	err  error
+}
+
+func FromRows(rows *sql.Rows) Feed[models.CandleStick] {
+	return &rowsFeed{rows: rows}
+}
+
+func (f *rowsFeed) Next() (models.CandleStick, bool) {
+	var c models.CandleStick
+	if f.err != nil || !f.rows.Next() {
+		return c, false
+	}
+	if err := f.rows.Scan(&c.OpenTime, &c.Open, &c.High, &c.Low, &c.Close, &c.Volume); err != nil {
+		f.err = fmt.Errorf("scan error: %w", err)
+		return c, false
+	}
+	c.IsFinal = true
+	return c, true
+}
+
+func (f *rowsFeed) Err() error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.rows.Err()
+}
@@ -0,0 +1,55 @@
+package backtest
+
+import (
+	"math"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// How the orders are filled against a candle, only the OHLC is known so the fills are kept on the conservative side
+// Market: fills at the open (or the close/signal price when filled straight away), paying half the spread and the slippage
+// Limit: fills once the price trades at or through the limit, at the limit (or the open if it gapped past it), as a maker with no slippage
+// Stop: triggers once the price trades at or through the stop, at the stop (or the open if it gapped past it), then pays half the spread and the slippage
+// Stop-limit: once the price trades through the StopPrice it becomes a limit order at Price (which can fill on the same candle)
+
+// Moves the price against the side of the order
+func (cfg Config) adverse(price float64, side int) float64 {
+	return price * (1 + float64(side)*(cfg.Spread/2+cfg.Slippage))
+}
+
+func (cfg Config) fee(qty, price float64, maker bool) float64 {
+	if maker {
+		return qty * price * cfg.MakerFee
+	}
+	return qty * price * cfg.TakerFee
+}
+
+// Whether a stop-limit order is triggered by the candle
+func triggered(o models.Order, bar models.CandleStick) bool {
+	return (o.Side == 1 && bar.High >= o.StopPrice) || (o.Side == -1 && bar.Low <= o.StopPrice)
+}
+
+// Tries to fill the order against the candle, returns false if it did not fill
+func (cfg Config) fillOnBar(o models.Order, bar models.CandleStick) (price float64, maker bool, ok bool) {
+	switch o.Type {
+	case "limit":
+		if o.Side == 1 && bar.Low <= o.Price {
+			return math.Min(bar.Open, o.Price), true, true
+		}
+		if o.Side == -1 && bar.High >= o.Price {
+			return math.Max(bar.Open, o.Price), true, true
+		}
+	case "stop":
+		if o.Side == 1 && bar.High >= o.Price {
+			return cfg.adverse(math.Max(bar.Open, o.Price), 1), false, true
+		}
+		if o.Side == -1 && bar.Low <= o.Price {
+			return cfg.adverse(math.Min(bar.Open, o.Price), -1), false, true
+		}
+	case "stop-limit":
+		// Not triggered yet
+	default:
+		return cfg.adverse(bar.Open, o.Side), false, true
+	}
+	return 0, false, false
+}
@@ -0,0 +1,146 @@
+package backtest
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+type Summary struct {
+	Start        int64
+	End          int64
+	Bars         int
+	InitialCash  float64
+	FinalEquity  float64
+	NetPnL       float64
+	Return       float64 // Over the initial cash
+	Fees         float64
+	Trades       int
+	Wins         int
+	WinRate      float64
+	ProfitFactor float64 // Gross profit over gross loss, +Inf if there were no losing trades
+	AvgTrade     float64 // Average PnL per trade
+	AvgBars      float64 // Average candles a trade was held for
+	MaxDrawdown  float64 // Largest fraction the equity fell below its high
+	Sharpe       float64 // Annualised from the per candle returns (no risk free rate, the market is open 24/7)
+	Exposure     float64 // Fraction of the candles a position was held at the close
+}
+
+func Summarise(cfg Config, trades []Trade, equity []EquityPoint) Summary {
+	s := Summary{InitialCash: cfg.InitialCash, FinalEquity: cfg.InitialCash, Bars: len(equity), Trades: len(trades)}
+	if len(equity) == 0 {
+		return s
+	}
+	s.Start, s.End = equity[0].OpenTime, equity[len(equity)-1].OpenTime
+	s.FinalEquity = equity[len(equity)-1].Equity
+	s.NetPnL = s.FinalEquity - cfg.InitialCash
+	if cfg.InitialCash != 0 {
+		s.Return = s.NetPnL / cfg.InitialCash
+	}
+
+	var grossWin, grossLoss, bars float64
+	for _, t := range trades {
+		s.Fees += t.Fees
+		bars += float64(t.Bars)
+		if t.PnL > 0 {
+			s.Wins++
+			grossWin += t.PnL
+		} else {
+			grossLoss -= t.PnL
+		}
+	}
+	if len(trades) > 0 {
+		s.WinRate = float64(s.Wins) / float64(len(trades))
+		s.AvgTrade = (grossWin - grossLoss) / float64(len(trades))
+		s.AvgBars = bars / float64(len(trades))
+		s.ProfitFactor = math.Inf(1)
+		if grossLoss > 0 {
+			s.ProfitFactor = grossWin / grossLoss
+		}
+	}
+
+	// Per candle returns for the Sharpe ratio, the candle length is taken from the first two candles
+	var mean, m2 float64
+	n, held := 0, 0
+	prev := cfg.InitialCash
+	for _, p := range equity {
+		s.MaxDrawdown = math.Max(s.MaxDrawdown, p.Drawdown)
+		if p.Position != 0 {
+			held++
+		}
+		if prev > 0 {
+			r := p.Equity/prev - 1
+			n++
+			delta := r - mean
+			mean += delta / float64(n)
+			m2 += delta * (r - mean)
+		}
+		prev = p.Equity
+	}
+	s.Exposure = float64(held) / float64(len(equity))
+	if n > 1 && len(equity) > 1 {
+		std := math.Sqrt(m2 / float64(n-1))
+		step := time.Duration(equity[1].OpenTime-equity[0].OpenTime) * time.Millisecond
+		if std > 0 && step > 0 {
+			s.Sharpe = mean / std * math.Sqrt(float64(365*24*time.Hour)/float64(step))
+		}
+	}
+	return s
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf(`Candles:       %d (%s - %s)
+Equity:        %.2f -> %.2f (%+.2f%%)
+Net PnL:       %.2f (fees %.2f)
+Trades:        %d (%d wins, %.1f%% win rate)
+Avg trade:     %.2f over %.1f candles
+Profit factor: %.2f
+Max drawdown:  %.2f%%
+Sharpe:        %.2f
+Exposure:      %.1f%%`,
+		s.Bars, fmtTime(s.Start), fmtTime(s.End),
+		s.InitialCash, s.FinalEquity, 100*s.Return,
+		s.NetPnL, s.Fees,
+		s.Trades, s.Wins, 100*s.WinRate,
+		s.AvgTrade, s.AvgBars,
+		s.ProfitFactor,
+		100*s.MaxDrawdown,
+		s.Sharpe,
+		100*s.Exposure)
+}
+
+func fmtTime(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format(time.RFC3339)
+}
+
+func float(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// Writes the trade list as CSV
+func (r *Result) WriteTrades(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"side", "entry_time", "entry_price", "exit_time", "exit_price", "qty", "fees", "pnl", "return", "bars", "entry_reason", "exit_reason"})
+	for _, t := range r.Trades {
+		cw.Write([]string{
+			strconv.Itoa(t.Side), fmtTime(t.EntryTime), float(t.EntryPrice), fmtTime(t.ExitTime), float(t.ExitPrice),
+			float(t.Qty), float(t.Fees), float(t.PnL), float(t.Return), strconv.Itoa(t.Bars), t.EntryReason, t.ExitReason,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Writes the equity curve as CSV, one row per candle
+func (r *Result) WriteEquity(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"open_time", "close", "cash", "position", "equity", "drawdown"})
+	for _, p := range r.Equity {
+		cw.Write([]string{strconv.FormatInt(p.OpenTime, 10), float(p.Close), float(p.Cash), float(p.Position), float(p.Equity), float(p.Drawdown)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
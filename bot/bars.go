@@ -0,0 +1,424 @@
+package bot
+
+import (
+	"fmt"
+	"math"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// Trendlines drawn on the raw 1m candles break constantly due to noise
+// These transformers convert the candle stream into alternative bar types which filter out some of that noise
+// Everything is emitted as models.CandleStick so the sliding window and trendline code can run on them unchanged
+
+// Each transformer takes one candle at a time and returns the bars that were completed by it (can be none or several)
+type BarTransformer interface {
+	Update(c models.CandleStick) []models.CandleStick
+}
+
+// Batch version to transform a whole dataset (e.g. the historical candles)
+func TransformDataset(ds models.Dataset, t BarTransformer) models.Dataset {
+	var out []models.CandleStick
+	for _, c := range ds.Candles {
+		out = append(out, t.Update(c)...)
+	}
+	return models.Dataset{Candles: out}
+}
+
+// Several bars (Renko bricks, range bars) can complete within one candle, but the trendlines need unique OpenTimes
+// So bars completed within the same candle are spaced out by 1 unit of time from the previous bar
+type barClock struct {
+	last int64
+}
+
+func (b *barClock) next(t int64) int64 {
+	if t <= b.last {
+		t = b.last + 1
+	}
+	b.last = t
+	return t
+}
+
+// HEIKIN-ASHI
+// Each bar is a smoothed version of the candle, one bar is emitted per candle
+type HeikinAshi struct {
+	prevOpen  float64
+	prevClose float64
+	started   bool
+}
+
+func NewHeikinAshi() *HeikinAshi {
+	return &HeikinAshi{}
+}
+
+func (h *HeikinAshi) Update(c models.CandleStick) []models.CandleStick {
+	haClose := (c.Open + c.High + c.Low + c.Close) / 4
+	haOpen := (c.Open + c.Close) / 2 // The first bar has no previous bar so just use the midpoint of the body
+	if h.started {
+		haOpen = (h.prevOpen + h.prevClose) / 2
+	}
+	h.prevOpen, h.prevClose, h.started = haOpen, haClose, true
+
+	bar := c
+	bar.Open = haOpen
+	bar.Close = haClose
+	bar.High = math.Max(c.High, math.Max(haOpen, haClose))
+	bar.Low = math.Min(c.Low, math.Min(haOpen, haClose))
+	return []models.CandleStick{bar}
+}
+
+// RENKO
+// Bricks of a fixed size are emitted whenever the close moves a full brick beyond the last brick
+// A reversal needs the price to move two bricks (one to get back to the open of the last brick and one more)
+// The brick size can either be fixed or a multiple of the ATR (taken at the time each brick forms)
+type Renko struct {
+	BrickSize     float64
+	ATRMultiple   float64 // If set the brick size is ATRMultiple * ATR instead of BrickSize
+	atr           *ATRCalculator
+	last          float64 // Close of the last brick
+	direction     int     // 1 for up bricks, -1 for down bricks, 0 before the first brick
+	started       bool
+	pendingVolume float64
+	pendingTrades int64
+	clock         barClock
+}
+
+func NewRenko(brickSize float64) (*Renko, error) {
+	if brickSize <= 0 {
+		return nil, fmt.Errorf("renko brick size must be positive, got %v", brickSize)
+	}
+	return &Renko{BrickSize: brickSize}, nil
+}
+
+func NewATRRenko(period int, multiple float64) *Renko {
+	return &Renko{ATRMultiple: multiple, atr: &ATRCalculator{Period: period}}
+}
+
+func (r *Renko) Update(c models.CandleStick) []models.CandleStick {
+	size := r.BrickSize
+	if r.atr != nil {
+		atr, ok := r.atr.Update(c)
+		if !ok {
+			return nil // Can not start building bricks until the ATR is ready
+		}
+		size = r.ATRMultiple * atr
+	}
+	if !r.started {
+		r.last = c.Close
+		r.started = true
+		return nil
+	}
+	r.pendingVolume += c.Volume
+	r.pendingTrades += c.NumOfTrades
+	// Same as the range bars, a brick under the float spacing of the price would never move the last brick on (e.g. an ATR of almost 0)
+	if size <= 0 || size <= math.Max(math.Abs(r.last), math.Abs(c.Close))*1e-12 {
+		return nil
+	}
+
+	var bricks []models.CandleStick
+	for len(bricks) < maxBarsPerCandle {
+		var open, close float64
+		switch {
+		case r.direction >= 0 && c.Close >= r.last+size:
+			open, close = r.last, r.last+size
+			r.direction = 1
+		case r.direction <= 0 && c.Close <= r.last-size:
+			open, close = r.last, r.last-size
+			r.direction = -1
+		case r.direction == 1 && c.Close <= r.last-2*size:
+			// Reversal, the new brick starts from the open of the last up brick
+			open, close = r.last-size, r.last-2*size
+			r.direction = -1
+		case r.direction == -1 && c.Close >= r.last+2*size:
+			open, close = r.last+size, r.last+2*size
+			r.direction = 1
+		default:
+			return bricks
+		}
+		r.last = close
+		bricks = append(bricks, r.bar(c, open, close))
+	}
+	return bricks // The rest of the move is picked up by the next candles
+}
+
+func (r *Renko) bar(c models.CandleStick, open, close float64) models.CandleStick {
+	bar := models.CandleStick{
+		OpenTime:    r.clock.next(c.OpenTime),
+		Open:        open,
+		High:        math.Max(open, close),
+		Low:         math.Min(open, close),
+		Close:       close,
+		Volume:      r.pendingVolume, // The volume since the last brick goes to the first brick formed
+		NumOfTrades: r.pendingTrades,
+		CloseTime:   c.CloseTime,
+		IsFinal:     true,
+	}
+	r.pendingVolume, r.pendingTrades = 0, 0
+	return bar
+}
+
+// RANGE BARS
+// Each bar covers a fixed price range, once the range is reached the bar closes and the next one starts at that price
+// Since we only have candles (not trades) the path within the candle is assumed to be open -> low -> high -> close for up candles
+// and open -> high -> low -> close for down candles
+type RangeBars struct {
+	Range   float64
+	current *models.CandleStick
+	clock   barClock
+}
+
+// A range (or brick) that is too small for the price would emit a huge number of bars per candle, so there is a cap on the bars from one candle
+// past which the rest of the move just goes into the current bar (or waits for the next candle for the renko bricks)
+const maxBarsPerCandle = 10000
+
+func NewRangeBars(rangeSize float64) (*RangeBars, error) {
+	if rangeSize <= 0 {
+		return nil, fmt.Errorf("range bar size must be positive, got %v", rangeSize)
+	}
+	return &RangeBars{Range: rangeSize}, nil
+}
+
+func (r *RangeBars) Update(c models.CandleStick) []models.CandleStick {
+	// Under about 1e-12 of the price adding the range to the price does not change it (float spacing), so the bars would never move on
+	if r.Range <= 0 || r.Range <= math.Abs(c.Close)*1e-12 {
+		return nil
+	}
+	path := []float64{c.Open, c.High, c.Low, c.Close}
+	if c.Close >= c.Open {
+		path = []float64{c.Open, c.Low, c.High, c.Close}
+	}
+
+	if r.current == nil {
+		r.current = &models.CandleStick{OpenTime: r.clock.next(c.OpenTime), Open: c.Open, High: c.Open, Low: c.Open, Close: c.Open}
+	} else {
+		path = append([]float64{r.current.Close}, path...) // Walk across any gap from the last price as well
+	}
+	r.current.Volume += c.Volume
+	r.current.NumOfTrades += c.NumOfTrades
+
+	var bars []models.CandleStick
+	for k := 1; k < len(path); k++ {
+		from, to := path[k-1], path[k]
+		price := from
+		for price != to {
+			bar := r.current
+			// Work out how far the price can go before the bar hits its range
+			hit := false
+			if len(bars) >= maxBarsPerCandle {
+				price = to
+			} else if to > price {
+				price = to
+				if limit := bar.Low + r.Range; to >= limit {
+					price, hit = limit, true
+				}
+			} else {
+				price = to
+				if limit := bar.High - r.Range; to <= limit {
+					price, hit = limit, true
+				}
+			}
+			bar.High = math.Max(bar.High, price)
+			bar.Low = math.Min(bar.Low, price)
+			bar.Close = price
+
+			// Checking if the limit was hit rather than comparing the range avoids floating point issues
+			if hit {
+				bar.CloseTime = c.CloseTime
+				bar.IsFinal = true
+				bars = append(bars, *bar)
+				r.current = &models.CandleStick{OpenTime: r.clock.next(c.OpenTime), Open: price, High: price, Low: price, Close: price}
+			}
+		}
+	}
+	return bars
+}
+
+// KAGI
+// A Kagi line keeps going in the same direction until the close reverses by the reversal amount
+// Each completed line is emitted as a candle (open = start of the line, close = end of the line)
+type Kagi struct {
+	Reversal  float64
+	Percent   bool // If true, Reversal is a fraction of the price (e.g. 0.01 = 1%) rather than a fixed amount
+	start     float64
+	extreme   float64
+	direction int
+	startTime int64
+	volume    float64
+	trades    int64
+	started   bool
+	clock     barClock
+}
+
+func NewKagi(reversal float64, percent bool) *Kagi {
+	return &Kagi{Reversal: reversal, Percent: percent}
+}
+
+func (k *Kagi) Update(c models.CandleStick) []models.CandleStick {
+	if !k.started {
+		k.start, k.extreme, k.startTime, k.started = c.Close, c.Close, c.OpenTime, true
+		return nil
+	}
+	k.volume += c.Volume
+	k.trades += c.NumOfTrades
+
+	reversal := k.Reversal
+	if k.Percent {
+		reversal = k.Reversal * k.extreme
+	}
+
+	// Line extends in its direction (or picks a direction if it has not got one yet)
+	if (k.direction >= 0 && c.Close > k.extreme) || (k.direction <= 0 && c.Close < k.extreme) {
+		if k.direction == 0 && math.Abs(c.Close-k.start) < reversal {
+			return nil // Need the first move to be at least the reversal amount to set the direction
+		}
+		if c.Close > k.extreme {
+			k.direction = 1
+		} else {
+			k.direction = -1
+		}
+		k.extreme = c.Close
+		return nil
+	}
+	if k.direction == 0 {
+		return nil
+	}
+
+	// Now check for a reversal, if there is one the current line is complete
+	if math.Abs(k.extreme-c.Close) < reversal {
+		return nil
+	}
+	line := models.CandleStick{
+		OpenTime:    k.clock.next(k.startTime),
+		Open:        k.start,
+		High:        math.Max(k.start, k.extreme),
+		Low:         math.Min(k.start, k.extreme),
+		Close:       k.extreme,
+		Volume:      k.volume,
+		NumOfTrades: k.trades,
+		CloseTime:   c.CloseTime,
+		IsFinal:     true,
+	}
+	k.start, k.extreme, k.startTime = k.extreme, c.Close, c.OpenTime
+	k.direction = -k.direction
+	k.volume, k.trades = 0, 0
+	return []models.CandleStick{line}
+}
+
+// POINT AND FIGURE
+// Columns of X's (rising) and O's (falling), a column extends for every full box the price moves on,
+// and a new column starts when the price reverses by ReversalBoxes boxes
+// Each completed column is emitted as a candle (open = bottom/top of the column it started from, close = its last box)
+type PointAndFigure struct {
+	BoxSize       float64
+	ReversalBoxes int
+	colStart      float64
+	colEnd        float64
+	direction     int // 1 for an X column, -1 for an O column
+	startTime     int64
+	volume        float64
+	trades        int64
+	started       bool
+	clock         barClock
+}
+
+func NewPointAndFigure(boxSize float64, reversalBoxes int) *PointAndFigure {
+	return &PointAndFigure{BoxSize: boxSize, ReversalBoxes: reversalBoxes}
+}
+
+func (p *PointAndFigure) Update(c models.CandleStick) []models.CandleStick {
+	if p.BoxSize <= 0 {
+		return nil
+	}
+	if !p.started {
+		// Snap the starting price onto the box grid
+		p.colStart = math.Floor(c.Close/p.BoxSize) * p.BoxSize
+		p.colEnd = p.colStart
+		p.startTime = c.OpenTime
+		p.started = true
+		return nil
+	}
+	p.volume += c.Volume
+	p.trades += c.NumOfTrades
+	rev := float64(p.ReversalBoxes) * p.BoxSize
+
+	switch p.direction {
+	case 0:
+		// Have not picked a direction yet, the first full box either way decides it
+		if up := math.Floor((c.High-p.colEnd)/p.BoxSize) * p.BoxSize; up >= p.BoxSize {
+			p.colEnd += up
+			p.direction = 1
+		} else if down := math.Floor((p.colEnd-c.Low)/p.BoxSize) * p.BoxSize; down >= p.BoxSize {
+			p.colEnd -= down
+			p.direction = -1
+		}
+		return nil
+	case 1:
+		// In an X column, extending takes priority over reversing
+		if up := math.Floor((c.High-p.colEnd)/p.BoxSize) * p.BoxSize; up >= p.BoxSize {
+			p.colEnd += up
+			return nil
+		}
+		if p.colEnd-c.Low >= rev {
+			down := math.Floor((p.colEnd-c.Low)/p.BoxSize) * p.BoxSize
+			return p.reverse(c, p.colEnd-p.BoxSize, p.colEnd-down)
+		}
+	case -1:
+		if down := math.Floor((p.colEnd-c.Low)/p.BoxSize) * p.BoxSize; down >= p.BoxSize {
+			p.colEnd -= down
+			return nil
+		}
+		if c.High-p.colEnd >= rev {
+			up := math.Floor((c.High-p.colEnd)/p.BoxSize) * p.BoxSize
+			return p.reverse(c, p.colEnd+p.BoxSize, p.colEnd+up)
+		}
+	}
+	return nil
+}
+
+// Completes the current column and starts the next one (which begins one box away from the end of the last column)
+func (p *PointAndFigure) reverse(c models.CandleStick, newStart, newEnd float64) []models.CandleStick {
+	column := models.CandleStick{
+		OpenTime:    p.clock.next(p.startTime),
+		Open:        p.colStart,
+		High:        math.Max(p.colStart, p.colEnd),
+		Low:         math.Min(p.colStart, p.colEnd),
+		Close:       p.colEnd,
+		Volume:      p.volume,
+		NumOfTrades: p.trades,
+		CloseTime:   c.CloseTime,
+		IsFinal:     true,
+	}
+	p.colStart, p.colEnd = newStart, newEnd
+	p.startTime = c.OpenTime
+	p.direction = -p.direction
+	p.volume, p.trades = 0, 0
+	return []models.CandleStick{column}
+}
+
+// Helper so that the bar type can be picked from the .env (e.g. BAR_TYPE=renko, BAR_PARAMS=0.5)
+// Params are: renko (brick size), atr-renko (ATR period, multiple), range (range size), kagi (reversal as a fraction of price),
+// pnf (box size, reversal boxes), heikin-ashi takes none
+func NewBarTransformer(kind string, params []float64) (BarTransformer, error) {
+	need := map[string]int{"heikin-ashi": 0, "renko": 1, "atr-renko": 2, "range": 1, "kagi": 1, "pnf": 2}
+	n, ok := need[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown bar type: %q", kind)
+	}
+	if len(params) < n {
+		return nil, fmt.Errorf("bar type %s needs %d params, got %d", kind, n, len(params))
+	}
+
+	switch kind {
+	case "renko":
+		return NewRenko(params[0])
+	case "atr-renko":
+		return NewATRRenko(int(params[0]), params[1]), nil
+	case "range":
+		return NewRangeBars(params[0])
+	case "kagi":
+		return NewKagi(params[0], true), nil
+	case "pnf":
+		return NewPointAndFigure(params[0], int(params[1])), nil
+	}
+	return NewHeikinAshi(), nil
+}
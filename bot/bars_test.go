@@ -0,0 +1,67 @@
+package bot
+
+import (
+	"testing"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+func closeAt(openTime int64, price float64) models.CandleStick {
+	return models.CandleStick{OpenTime: openTime, Open: price, High: price, Low: price, Close: price, IsFinal: true}
+}
+
+func TestRenko(t *testing.T) {
+	r, err := NewRenko(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Update(closeAt(1, 100))
+	up := r.Update(closeAt(2, 103.5))
+	if len(up) != 3 || up[0].Open != 100 || up[2].Close != 103 {
+		t.Fatalf("up bricks %+v", up)
+	}
+	// One brick down is not enough to reverse, two is
+	if got := r.Update(closeAt(3, 102.2)); len(got) != 0 {
+		t.Fatalf("reversed on one brick: %+v", got)
+	}
+	down := r.Update(closeAt(4, 101))
+	if len(down) != 1 || down[0].Open != 102 || down[0].Close != 101 {
+		t.Fatalf("reversal brick %+v", down)
+	}
+}
+
+func TestRenkoBrickSize(t *testing.T) {
+	for _, size := range []float64{0, -1} {
+		if _, err := NewRenko(size); err == nil {
+			t.Errorf("NewRenko(%v) did not fail", size)
+		}
+	}
+	if _, err := NewBarTransformer("renko", []float64{0}); err == nil {
+		t.Error("BAR_PARAMS of 0 for renko did not fail")
+	}
+
+	// Under the float spacing of the price nothing can be built
+	r, _ := NewRenko(1e-14)
+	r.Update(closeAt(1, 150))
+	if got := r.Update(closeAt(2, 151)); len(got) != 0 {
+		t.Fatalf("%d bricks under the float spacing", len(got))
+	}
+
+	// Tiny but workable bricks are capped per candle, the rest come with the next candles
+	r, _ = NewRenko(1e-9)
+	r.Update(closeAt(1, 150))
+	if got := r.Update(closeAt(2, 151)); len(got) != maxBarsPerCandle {
+		t.Fatalf("%d bricks from one candle, want the cap of %d", len(got), maxBarsPerCandle)
+	}
+	if got := r.Update(closeAt(3, 151)); len(got) != maxBarsPerCandle || got[0].Close <= got[0].Open {
+		t.Fatalf("%d bricks from the next candle", len(got))
+	}
+
+	// A flat market gives an ATR of 0, which is no brick size at all
+	atr := NewATRRenko(3, 1)
+	for i := int64(1); i < 10; i++ {
+		if got := atr.Update(closeAt(i, 150)); len(got) != 0 {
+			t.Fatalf("%d bricks with an ATR of 0", len(got))
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package bot
+
+import (
+	"math"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// Chart patterns from the support and resistance lines of the window
+// The pair of lines is classified from how the two gradients relate to each other
+// Parallel lines make a channel, converging lines make a triangle (one line flat or both sloping towards each other) or a wedge (both sloping the same way)
+// If the pattern comes straight after a sharp move (the pole) and is short compared to it, it is a flag (parallel) or a pennant (converging) instead
+// Each pattern gives the direction it is expected to break and a measured-move target for when it does
+// The window's own lines can not be used for this, CreateResLine/CreateSupLine force the resistance to slope down and the support up,
+// so the channels and wedges (both lines sloping the same way) could never come out of them
+// FindChartPattern fits its own pair of multi-touch lines with no slope rule instead (falling back to the window's lines if none are found)
+// The trendline strategy classifies the pattern each time the lines are redrawn and puts the target on the entries that break it (see Signal.Target)
+
+type ChartPattern int
+
+const (
+	NoChartPattern ChartPattern = iota
+	AscendingChannel
+	DescendingChannel
+	HorizontalChannel
+	AscendingTriangle
+	DescendingTriangle
+	SymmetricalTriangle
+	RisingWedge
+	FallingWedge
+	BullFlag
+	BearFlag
+	BullPennant
+	BearPennant
+)
+
+var chartPatternNames = []string{
+	"none",
+	"ascending_channel",
+	"descending_channel",
+	"horizontal_channel",
+	"ascending_triangle",
+	"descending_triangle",
+	"symmetrical_triangle",
+	"rising_wedge",
+	"falling_wedge",
+	"bull_flag",
+	"bear_flag",
+	"bull_pennant",
+	"bear_pennant",
+}
+
+func (p ChartPattern) String() string {
+	if p < 0 || int(p) >= len(chartPatternNames) {
+		return "unknown"
+	}
+	return chartPatternNames[p]
+}
+
+// Direction the pattern is expected to break, 0 if it can go either way (channels and symmetrical triangles)
+func (p ChartPattern) Bias() int {
+	switch p {
+	case AscendingTriangle, FallingWedge, BullFlag, BullPennant:
+		return 1
+	case DescendingTriangle, RisingWedge, BearFlag, BearPennant:
+		return -1
+	}
+	return 0
+}
+
+type ChartPatternConfig struct {
+	FlatSlope      float64 // A line moving less than this fraction of price over the pattern counts as flat
+	ParallelTol    float64 // Lines are parallel if the gap between them changes by less than this fraction over the pattern
+	TouchTolerance float64 // Swing points within this many ATRs of a line count as a touch
+	MinTouches     int     // Touches needed on each line
+	SwingStrength  int
+	PoleBars       int            // Number of bars before the pattern that the pole is measured over
+	PoleMinATR     float64        // The pole has to move at least this many ATRs
+	MaxFlagBars    int            // A flag/pennant can be at most this many bars long
+	MaxFlagRetrace float64        // The flag/pennant can be at most this fraction of the pole's height
+	Fit            TrendFitConfig // How FindChartPattern fits the lines, without EnforceSlope so every pattern can come out
+}
+
+func DefaultChartPatternConfig() ChartPatternConfig {
+	fit := DefaultTrendFitConfig()
+	fit.EnforceSlope = false
+	fit.MinTouches = 2
+	return ChartPatternConfig{
+		FlatSlope:      0.002,
+		ParallelTol:    0.25,
+		TouchTolerance: 0.3,
+		MinTouches:     2,
+		SwingStrength:  2,
+		PoleBars:       10,
+		PoleMinATR:     4,
+		MaxFlagBars:    40,
+		MaxFlagRetrace: 0.5,
+		Fit:            fit,
+	}
+}
+
+type ChartPatternResult struct {
+	Pattern    ChartPattern
+	Bias       int
+	Res        models.Trendline
+	Sup        models.Trendline
+	StartX     float64 // x of the start of the pattern (the later of the two lines' first anchors)
+	EndX       float64 // x of the most recent candle
+	ApexX      float64 // x where the lines meet, +Inf if they are parallel
+	Height     float64 // Gap between the lines at the start of the pattern
+	PoleHeight float64 // Size of the move before the pattern (signed), 0 if there was no pole
+	ResTouches int
+	SupTouches int
+}
+
+type ChartPatternSignal struct {
+	Pattern   ChartPattern
+	Direction int     // Direction of the breakout
+	Price     float64 // Line price at the breakout
+	Target    float64 // Measured-move target
+	OpenTime  int64
+}
+
+// Fits a pair of lines to the window with no slope rule and classifies them
+func (w *Window[C]) FindChartPattern(cfg ChartPatternConfig) ChartPatternResult {
+	res, sup := w.ResLine, w.SupLine
+	if lines := w.FindTrendlines(true, cfg.Fit); len(lines) > 0 {
+		res = lines[0].Line
+	}
+	if lines := w.FindTrendlines(false, cfg.Fit); len(lines) > 0 {
+		sup = lines[0].Line
+	}
+	return w.ClassifyChartPattern(res, sup, cfg)
+}
+
+// Classifies the pair of lines over the candles in the window
+func (w *Window[C]) ClassifyChartPattern(res, sup models.Trendline, cfg ChartPatternConfig) ChartPatternResult {
+	out := ChartPatternResult{Res: res, Sup: sup, ApexX: math.Inf(1)}
+	n := w.Len()
+	if n < 2 || (res == models.Trendline{}) || (sup == models.Trendline{}) {
+		return out
+	}
+
+	// The pattern starts once both lines exist
+	startTime := max(res.A1Time, sup.A1Time)
+	start := 0
+	for start < n-1 && w.Bar(start).OpenTime < startTime {
+		start++
+	}
+	out.StartX, out.EndX = w.X(start), w.X(n-1)
+	if out.EndX <= out.StartX {
+		return out
+	}
+
+	bars := make([]models.CandleStick, n)
+	for i := range bars {
+		bars[i] = w.Bar(i)
+	}
+	atr := averageTrueRange(bars)
+
+	// Gap between the lines at the start and end of the pattern, if the lines have crossed it is not a pattern
+	startGap := LineAt(res, out.StartX) - LineAt(sup, out.StartX)
+	endGap := LineAt(res, out.EndX) - LineAt(sup, out.EndX)
+	if startGap <= 0 || endGap <= 0 {
+		return out
+	}
+	out.Height = startGap
+	if res.Gradient != sup.Gradient {
+		out.ApexX = (sup.Intercept - res.Intercept) / (res.Gradient - sup.Gradient)
+	}
+
+	// Touches are the swing points near each line within the pattern
+	tol := cfg.TouchTolerance * atr
+	for _, i := range SwingHighs(bars, cfg.SwingStrength) {
+		if i >= start && math.Abs(bars[i].High-LineAt(res, w.X(i))) <= tol {
+			out.ResTouches++
+		}
+	}
+	for _, i := range SwingLows(bars, cfg.SwingStrength) {
+		if i >= start && math.Abs(bars[i].Low-LineAt(sup, w.X(i))) <= tol {
+			out.SupTouches++
+		}
+	}
+	if out.ResTouches < cfg.MinTouches || out.SupTouches < cfg.MinTouches {
+		return out
+	}
+
+	// Slope of each line as a fraction of price over the length of the pattern, so the thresholds do not depend on the x-axis
+	mid := (LineAt(res, out.EndX) + LineAt(sup, out.EndX)) / 2
+	span := out.EndX - out.StartX
+	slope := func(line models.Trendline) int {
+		s := line.Gradient * span / mid
+		switch {
+		case s > cfg.FlatSlope:
+			return 1
+		case s < -cfg.FlatSlope:
+			return -1
+		}
+		return 0
+	}
+	resSlope, supSlope := slope(res), slope(sup)
+	change := (endGap - startGap) / startGap
+	parallel := math.Abs(change) <= cfg.ParallelTol
+	converging := change < -cfg.ParallelTol
+
+	// Pole, the move over the PoleBars before the pattern started
+	if start >= cfg.PoleBars && cfg.PoleBars > 0 {
+		move := bars[start].Close - bars[start-cfg.PoleBars].Close
+		if atr > 0 && math.Abs(move) >= cfg.PoleMinATR*atr {
+			out.PoleHeight = move
+		}
+	}
+	isFlag := out.PoleHeight != 0 && n-start <= cfg.MaxFlagBars && startGap <= cfg.MaxFlagRetrace*math.Abs(out.PoleHeight)
+
+	switch {
+	case isFlag && parallel && out.PoleHeight > 0 && resSlope <= 0:
+		out.Pattern = BullFlag
+	case isFlag && parallel && out.PoleHeight < 0 && supSlope >= 0:
+		out.Pattern = BearFlag
+	case isFlag && converging && out.PoleHeight > 0:
+		out.Pattern = BullPennant
+	case isFlag && converging && out.PoleHeight < 0:
+		out.Pattern = BearPennant
+	case parallel && resSlope > 0 && supSlope > 0:
+		out.Pattern = AscendingChannel
+	case parallel && resSlope < 0 && supSlope < 0:
+		out.Pattern = DescendingChannel
+	case parallel && resSlope == 0 && supSlope == 0:
+		out.Pattern = HorizontalChannel
+	case converging && out.ApexX > out.EndX:
+		switch {
+		case resSlope == 0 && supSlope > 0:
+			out.Pattern = AscendingTriangle
+		case supSlope == 0 && resSlope < 0:
+			out.Pattern = DescendingTriangle
+		case resSlope < 0 && supSlope > 0:
+			out.Pattern = SymmetricalTriangle
+		case resSlope > 0 && supSlope > 0:
+			out.Pattern = RisingWedge
+		case resSlope < 0 && supSlope < 0:
+			out.Pattern = FallingWedge
+		}
+	}
+	out.Bias = out.Pattern.Bias()
+	return out
+}
+
+// Checks a new candle for a close outside the pattern, x is the candle's x value
+// The target is the height of the pattern (or the pole for flags and pennants) measured from the breakout price
+func (p ChartPatternResult) CheckBreakout(c models.CandleStick, x float64) (ChartPatternSignal, bool) {
+	if p.Pattern == NoChartPattern {
+		return ChartPatternSignal{}, false
+	}
+	height := p.Height
+	if p.Pattern >= BullFlag {
+		height = math.Abs(p.PoleHeight)
+	}
+	if resY := LineAt(p.Res, x); c.Close > resY {
+		return ChartPatternSignal{Pattern: p.Pattern, Direction: 1, Price: resY, Target: resY + height, OpenTime: c.OpenTime}, true
+	}
+	if supY := LineAt(p.Sup, x); c.Close < supY {
+		return ChartPatternSignal{Pattern: p.Pattern, Direction: -1, Price: supY, Target: supY - height, OpenTime: c.OpenTime}, true
+	}
+	return ChartPatternSignal{}, false
+}
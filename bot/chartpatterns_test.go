@@ -0,0 +1,34 @@
+package bot
+
+import (
+	"math"
+	"testing"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// A rising zig-zag between two parallel lines, the window's own lines (resistance forced down, support up) could never call this a channel
+func TestFindChartPatternChannel(t *testing.T) {
+	window, err := NewSlidingWindow[models.CandleStick]("SOLUSDT", "1m", 100, AxisIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		mid := 100 + 0.05*float64(i) + math.Sin(float64(i)*2*math.Pi/20)
+		window.Push(models.CandleStick{OpenTime: int64(i) * 60000, Open: mid, High: mid + 0.1, Low: mid - 0.1, Close: mid, IsFinal: true})
+	}
+	window.ComputeTrendlines()
+
+	res := window.FindChartPattern(DefaultChartPatternConfig())
+	if res.Pattern != AscendingChannel {
+		t.Fatalf("expected an ascending channel, got %s", res.Pattern)
+	}
+
+	// A close well above the channel is an upside breakout with the channel's height as the target
+	x := window.X(window.Len()-1) + 1
+	top := LineAt(res.Res, x)
+	sig, ok := res.CheckBreakout(models.CandleStick{Open: top, High: top + 1, Low: top, Close: top + 0.5}, x)
+	if !ok || sig.Direction != 1 || math.Abs(sig.Target-(top+res.Height)) > 1e-9 {
+		t.Fatalf("expected an upside breakout with target %.4f, got %+v (ok %v)", top+res.Height, sig, ok)
+	}
+}
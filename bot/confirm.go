@@ -0,0 +1,216 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// CheckTrendlines2 reports a breakout as soon as a wick crosses the line by any amount, which gives a lot of false breakouts
+// These are extra rules a breakout can be made to pass before it counts
+// Every filter is evaluated and recorded on the signal even if it is not enabled, so it can be measured afterwards which filters actually help
+
+type ConfirmConfig struct {
+	CloseBeyond       bool    // The candle has to close beyond the line, not just wick through it
+	MinATR            float64 // Penetration has to be at least this many ATRs, 0 turns it off
+	MinPct            float64 // Penetration has to be at least this fraction of the line price (e.g. 0.001 = 0.1%), 0 turns it off
+	ConsecutiveCloses int     // This many closes in a row beyond the line (including the breakout candle), 0 or 1 turns it off
+	VolumeMultiple    float64 // Volume has to be at least this multiple of the average over VolumePeriod, 0 turns it off
+	VolumePeriod      int
+	ATRPeriod         int
+	Hours             []int // UTC hours a breakout is allowed in, empty allows any hour
+}
+
+func DefaultConfirmConfig() ConfirmConfig {
+	return ConfirmConfig{
+		VolumePeriod: 20,
+		ATRPeriod:    14,
+	}
+}
+
+// Parses a comma separated list of filters, for example "close,atr=0.5,pct=0.001,closes=2,volume=1.5,hours=8-20"
+// The hours are an inclusive range of UTC hours and can wrap round midnight (e.g. 22-2)
+func ParseConfirmConfig(s string) (ConfirmConfig, error) {
+	cfg := DefaultConfirmConfig()
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, val, hasVal := strings.Cut(part, "=")
+		if !hasVal && key != "close" {
+			return cfg, fmt.Errorf("breakout filter %q needs a value", key)
+		}
+		var err error
+		switch key {
+		case "close":
+			cfg.CloseBeyond = true
+		case "atr":
+			cfg.MinATR, err = strconv.ParseFloat(val, 64)
+		case "pct":
+			cfg.MinPct, err = strconv.ParseFloat(val, 64)
+		case "closes":
+			cfg.ConsecutiveCloses, err = strconv.Atoi(val)
+		case "volume":
+			cfg.VolumeMultiple, err = strconv.ParseFloat(val, 64)
+		case "hours":
+			from, to, ok := strings.Cut(val, "-")
+			if !ok {
+				return cfg, fmt.Errorf("breakout hours %q should be a range like 8-20", val)
+			}
+			var start, end int
+			if start, err = strconv.Atoi(from); err != nil {
+				break
+			}
+			if end, err = strconv.Atoi(to); err != nil {
+				break
+			}
+			if start < 0 || start > 23 || end < 0 || end > 23 {
+				return cfg, fmt.Errorf("breakout hours %q out of range", val)
+			}
+			for h := start; ; h = (h + 1) % 24 {
+				cfg.Hours = append(cfg.Hours, h)
+				if h == end {
+					break
+				}
+			}
+		default:
+			return cfg, fmt.Errorf("unknown breakout filter %q", key)
+		}
+		if err != nil {
+			return cfg, fmt.Errorf("invalid breakout filter %q: %w", part, err)
+		}
+	}
+	return cfg, nil
+}
+
+// Pass/fail of each filter for a breakout
+type BreakoutFilters struct {
+	CloseBeyond bool
+	ATR         bool
+	Pct         bool
+	Consecutive bool
+	Volume      bool
+	Time        bool
+}
+
+// Packs the filter results into a bitmask (close=1, atr=2, pct=4, closes=8, volume=16, time=32) for storing in the DB
+func (f BreakoutFilters) Mask() int {
+	mask := 0
+	for i, pass := range []bool{f.CloseBeyond, f.ATR, f.Pct, f.Consecutive, f.Volume, f.Time} {
+		if pass {
+			mask |= 1 << i
+		}
+	}
+	return mask
+}
+
+type BreakoutSignal struct {
+	Broke             bool // The wick crossed the line (same as CheckTrendlines2)
+	Confirmed         bool // Broke and every enabled filter passed
+	IsResist          bool
+	Direction         int // 1 for a break up through resistance, -1 for a break down through support
+	OpenTime          int64
+	LinePrice         float64
+	Penetration       float64 // Distance beyond the line, measured from the close if CloseBeyond is set and from the wick otherwise
+	PenetrationATR    float64
+	PenetrationPct    float64
+	ConsecutiveCloses int
+	VolumeRatio       float64 // Volume over the average volume of the previous VolumePeriod candles
+	Hour              int     // UTC hour of the candle
+	Filters           BreakoutFilters
+}
+
+// ConfirmBreakout is CheckTrendlines2 with the confirmation filters, the candle has not been pushed into the window yet
+// The ATR, average volume and previous closes all come from the candles already in the window, so there is no extra state to keep
+func (w *Window[C]) ConfirmBreakout(line models.Trendline, candle C, isResist bool, currPos int, cfg ConfirmConfig) BreakoutSignal {
+	c := candle.CandleStick()
+	y := LineAt(line, w.NextX(candle))
+	sig := BreakoutSignal{
+		Broke:     w.CheckTrendlines2(line, candle, isResist, currPos),
+		IsResist:  isResist,
+		Direction: -1,
+		OpenTime:  c.OpenTime,
+		LinePrice: y,
+		Hour:      time.UnixMilli(OpenTimeMs(c.OpenTime)).UTC().Hour(),
+	}
+	if isResist {
+		sig.Direction = 1
+	}
+	beyond := func(price, line float64) float64 {
+		return float64(sig.Direction) * (price - line)
+	}
+
+	// Close beyond the line
+	closeBeyond := beyond(c.Close, y)
+	sig.Filters.CloseBeyond = closeBeyond > 0
+
+	// Penetration
+	sig.Penetration = beyond(c.High, y)
+	if !isResist {
+		sig.Penetration = beyond(c.Low, y)
+	}
+	if cfg.CloseBeyond {
+		sig.Penetration = closeBeyond
+	}
+	n := w.Len()
+	if period := min(cfg.ATRPeriod, n-1); period > 0 {
+		var total float64
+		for i := n - period; i < n; i++ {
+			total += trueRange(w.Bar(i), w.Bar(i-1))
+		}
+		if atr := total / float64(period); atr > 0 {
+			sig.PenetrationATR = sig.Penetration / atr
+		}
+	}
+	if y != 0 {
+		sig.PenetrationPct = sig.Penetration / y
+	}
+	sig.Filters.ATR = sig.Penetration > 0 && sig.PenetrationATR >= cfg.MinATR
+	sig.Filters.Pct = sig.Penetration > 0 && sig.PenetrationPct >= cfg.MinPct
+
+	// Consecutive closes, counting back from the breakout candle through the window
+	if closeBeyond > 0 {
+		sig.ConsecutiveCloses = 1
+		for i := n - 1; i >= 0 && sig.ConsecutiveCloses < max(cfg.ConsecutiveCloses, 1); i-- {
+			if beyond(w.Bar(i).Close, LineAt(line, w.X(i))) <= 0 {
+				break
+			}
+			sig.ConsecutiveCloses++
+		}
+	}
+	sig.Filters.Consecutive = sig.ConsecutiveCloses >= max(cfg.ConsecutiveCloses, 1)
+
+	// Volume compared to the average of the previous candles
+	if period := min(cfg.VolumePeriod, n); period > 0 {
+		var total float64
+		for i := n - period; i < n; i++ {
+			total += w.Bar(i).Volume
+		}
+		if avg := total / float64(period); avg > 0 {
+			sig.VolumeRatio = c.Volume / avg
+		}
+	}
+	sig.Filters.Volume = sig.VolumeRatio >= cfg.VolumeMultiple
+
+	// Time of the breakout
+	sig.Filters.Time = len(cfg.Hours) == 0
+	for _, h := range cfg.Hours {
+		if h == sig.Hour {
+			sig.Filters.Time = true
+		}
+	}
+
+	// Only the enabled filters decide whether the breakout is confirmed
+	sig.Confirmed = sig.Broke &&
+		(!cfg.CloseBeyond || sig.Filters.CloseBeyond) &&
+		(cfg.MinATR == 0 || sig.Filters.ATR) &&
+		(cfg.MinPct == 0 || sig.Filters.Pct) &&
+		(cfg.ConsecutiveCloses <= 1 || sig.Filters.Consecutive) &&
+		(cfg.VolumeMultiple == 0 || sig.Filters.Volume) &&
+		sig.Filters.Time
+	return sig
+}
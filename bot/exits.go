@@ -0,0 +1,446 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// Protective exits, on top of the strategy's own exits (opposite breakout, ADX, the ACTIVE_LIMIT timeout)
+// Exits wraps any strategy, so the backtester, paper and live trading all get exactly the same stops and targets just by feeding it the candles
+// Once the strategy enters, the trade gets:
+//   - a stop, a fixed % or ATR multiple from the entry, or anchored just beyond the trendline the entry broke (LineStop)
+//   - a break-even move, the stop goes to the entry once the price has gone BreakEven in favour
+//   - a chandelier/trailing stop, Trail below the highest high since the entry (above the lowest low for a short), it only ever tightens
+//   - take-profits, each closing a fraction of the original position once the price reaches it (the last one closes the rest)
+//   - optionally the entry's own target (e.g. the measured move of the chart pattern it broke, see Signal.Target), which closes the rest
+// The distances can be a % of the entry ("1.5%"), ATR multiples ("2atr") or multiples of the initial risk, the entry to the first stop ("2r")
+// Each candle (final or not) is checked against the levels, using the high/low so a level touched inside the candle still counts
+// If the stop and a target are both in the same candle the stop is assumed to have come first
+// The exit signals are intrabar, priced at the level (or the open if the candle gapped through it) so the backtester fills them there
+// The trailing stop and break-even only move on final candles, so a level never depends on a candle that has not closed
+// When a protective exit closes the whole position, the strategy is told (see PositionResetter) so it does not think it is still in the trade
+// The ATR needs ATRPeriod closed candles, so Seed it with the history before the first candle. An entry whose stop can still not be set
+// (e.g. "stop=2atr" with no ATR yet) is refused rather than traded with no stop, and the strategy is told it is flat again
+
+type ExitUnit string
+
+const (
+	UnitPct ExitUnit = "pct" // Fraction of the entry price
+	UnitATR ExitUnit = "atr" // Multiple of the ATR at the entry
+	UnitR   ExitUnit = "r"   // Multiple of the initial risk (entry to the first stop)
+)
+
+type ExitDistance struct {
+	Value float64 // 0 turns it off
+	Unit  ExitUnit
+}
+
+// Parses "1.5%", "2atr" or "2r"
+func ParseExitDistance(s string) (ExitDistance, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	var d ExitDistance
+	var num string
+	switch {
+	case strings.HasSuffix(s, "%"):
+		d.Unit, num = UnitPct, strings.TrimSuffix(s, "%")
+	case strings.HasSuffix(s, "atr"):
+		d.Unit, num = UnitATR, strings.TrimSuffix(s, "atr")
+	case strings.HasSuffix(s, "r"):
+		d.Unit, num = UnitR, strings.TrimSuffix(s, "r")
+	default:
+		return d, fmt.Errorf("distance %q needs a unit (%%, atr or r)", s)
+	}
+	val, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return d, fmt.Errorf("invalid distance %q: %w", s, err)
+	}
+	if val < 0 {
+		return d, fmt.Errorf("distance %q can not be negative", s)
+	}
+	if d.Unit == UnitPct {
+		val /= 100
+	}
+	d.Value = val
+	return d, nil
+}
+
+func (d ExitDistance) String() string {
+	switch d.Unit {
+	case UnitPct:
+		return strconv.FormatFloat(d.Value*100, 'f', -1, 64) + "%"
+	case UnitATR:
+		return strconv.FormatFloat(d.Value, 'f', -1, 64) + "atr"
+	}
+	return strconv.FormatFloat(d.Value, 'f', -1, 64) + "r"
+}
+
+// The distance as a price difference, 0 if it is off or can not be worked out (e.g. an R distance with no stop)
+func (d ExitDistance) Price(entry, atr, risk float64) float64 {
+	switch d.Unit {
+	case UnitPct:
+		return d.Value * entry
+	case UnitATR:
+		return d.Value * atr
+	case UnitR:
+		return d.Value * risk
+	}
+	return 0
+}
+
+type TakeProfit struct {
+	At       ExitDistance
+	Fraction float64 // Of the original position
+}
+
+type ExitConfig struct {
+	Stop        ExitDistance // Initial stop from the entry
+	LineStop    bool         // Put the initial stop just beyond the line the entry broke instead (Stop is used if the entry has no line)
+	LineBuffer  ExitDistance // How far beyond the line the line stop goes
+	BreakEven   ExitDistance // Move the stop to the entry once the price has gone this far in favour
+	Trail       ExitDistance // Chandelier stop distance from the best price since the entry
+	TakeProfits []TakeProfit // In order of distance
+	Target      bool         // Close the rest at the entry signal's Target when it has one
+	ATRPeriod   int
+}
+
+func DefaultExitConfig() ExitConfig {
+	return ExitConfig{ATRPeriod: 14}
+}
+
+// Anything set at all
+func (cfg ExitConfig) Enabled() bool {
+	return cfg.Stop.Value > 0 || cfg.LineStop || cfg.BreakEven.Value > 0 || cfg.Trail.Value > 0 || len(cfg.TakeProfits) > 0 || cfg.Target
+}
+
+// Parses a comma separated list, for example "stop=2atr,line,buffer=0.1atr,breakeven=1r,trail=3atr,tp=1.5r:0.5;3r:1,target,atr=14"
+// The take-profits are distance:fraction of the original position, separated by semicolons
+func ParseExitConfig(s string) (ExitConfig, error) {
+	cfg := DefaultExitConfig()
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, val, hasVal := strings.Cut(part, "=")
+		if !hasVal && key != "line" && key != "target" {
+			return cfg, fmt.Errorf("exit setting %q needs a value", key)
+		}
+		var err error
+		switch key {
+		case "line":
+			cfg.LineStop = true
+		case "target":
+			cfg.Target = true
+		case "stop":
+			cfg.Stop, err = ParseExitDistance(val)
+		case "buffer":
+			cfg.LineBuffer, err = ParseExitDistance(val)
+		case "breakeven":
+			cfg.BreakEven, err = ParseExitDistance(val)
+		case "trail":
+			cfg.Trail, err = ParseExitDistance(val)
+		case "atr":
+			cfg.ATRPeriod, err = strconv.Atoi(val)
+		case "tp":
+			cfg.TakeProfits = nil
+			for _, target := range strings.Split(val, ";") {
+				at, fraction, ok := strings.Cut(target, ":")
+				if !ok {
+					return cfg, fmt.Errorf("take-profit %q should be distance:fraction", target)
+				}
+				var tp TakeProfit
+				if tp.At, err = ParseExitDistance(at); err != nil {
+					break
+				}
+				if tp.Fraction, err = strconv.ParseFloat(fraction, 64); err != nil {
+					break
+				}
+				if tp.Fraction <= 0 || tp.Fraction > 1 {
+					return cfg, fmt.Errorf("take-profit fraction %q should be in (0, 1]", fraction)
+				}
+				cfg.TakeProfits = append(cfg.TakeProfits, tp)
+			}
+		default:
+			return cfg, fmt.Errorf("unknown exit setting %q", key)
+		}
+		if err != nil {
+			return cfg, fmt.Errorf("invalid exit setting %q: %w", part, err)
+		}
+	}
+
+	// R distances need a stop to measure the risk from
+	usesR := cfg.BreakEven.Unit == UnitR || cfg.Trail.Unit == UnitR || cfg.Stop.Unit == UnitR || cfg.LineBuffer.Unit == UnitR
+	for _, tp := range cfg.TakeProfits {
+		usesR = usesR || tp.At.Unit == UnitR
+	}
+	if usesR && cfg.Stop.Value == 0 && !cfg.LineStop {
+		return cfg, fmt.Errorf("r distances need a stop or a line stop")
+	}
+	if cfg.Stop.Unit == UnitR || cfg.LineBuffer.Unit == UnitR {
+		return cfg, fmt.Errorf("the stop and line buffer can not be in r, that is what r is measured from")
+	}
+	return cfg, nil
+}
+
+// The open trade and its levels
+type ExitTrade struct {
+	Symbol     string
+	Side       int
+	OpenTime   int64 // OpenTime of the entry candle
+	Entry      float64
+	ATR        float64 // At the entry
+	Risk       float64 // Entry to the initial stop, 0 if there is no stop
+	Stop       float64 // 0 if there is no stop
+	StopReason string  // "stop", "line", "breakeven" or "trail"
+	Best       float64 // Highest high (long) or lowest low (short) since the entry
+	Remaining  float64 // Fraction of the original position still open
+	Targets    int     // Take-profits hit so far
+	Target     float64 // The entry's own target, 0 if there is none
+}
+
+// Strategies that keep their own position have to be told when a protective exit closes it
+type PositionResetter interface {
+	ResetPosition()
+}
+
+type Exits[C Candle] struct {
+	Strategy Strategy[C]
+	Config   ExitConfig
+	Trade    *ExitTrade // nil when flat
+	atr      ATRCalculator
+}
+
+func NewExits[C Candle](strategy Strategy[C], cfg ExitConfig) *Exits[C] {
+	return &Exits[C]{Strategy: strategy, Config: cfg, atr: ATRCalculator{Period: cfg.ATRPeriod}}
+}
+
+func (e *Exits[C]) Name() string {
+	return e.Strategy.Name() + "+exits"
+}
+
+// Seed warms the ATR up with the closed candles before the first one the strategy sees, so the ATR distances work straight away
+func (e *Exits[C]) Seed(candles []models.CandleStick) {
+	for _, c := range candles {
+		e.updateATR(c)
+	}
+}
+
+// Candles at or before the last one are skipped, so candles fed again after the seed (e.g. PrepTrain going over its window) are not counted twice
+// Compared in ms since the seed is usually Binance history and the live candles are in seconds
+func (e *Exits[C]) updateATR(c models.CandleStick) (float64, bool) {
+	if last := e.atr.PrevCandle.OpenTime; last != 0 && OpenTimeMs(c.OpenTime) <= OpenTimeMs(last) {
+		return 0, false
+	}
+	return e.atr.Update(c)
+}
+
+// Starts tracking a new trade from the entry signal, returns false if the stop is set but could not be worked out
+func (e *Exits[C]) open(sig models.Signal, atr float64) bool {
+	cfg := e.Config
+	entry := sig.Price
+	if sig.OrderPrice > 0 {
+		entry = sig.OrderPrice
+	}
+	side := float64(sig.Side)
+	t := &ExitTrade{Symbol: sig.Symbol, Side: sig.Side, OpenTime: sig.OpenTime, Entry: entry, ATR: atr, Best: entry, Remaining: 1, StopReason: "stop"}
+	if d := cfg.Stop.Price(entry, atr, 0); d > 0 {
+		t.Stop = entry - side*d
+	}
+	// The line stop only counts if it is on the losing side of the entry
+	if cfg.LineStop && sig.Stop > 0 {
+		line := sig.Stop - side*cfg.LineBuffer.Price(entry, atr, 0)
+		if side*(entry-line) > 0 {
+			t.Stop, t.StopReason = line, "line"
+		}
+	}
+	if cfg.Stop.Value > 0 && t.Stop == 0 {
+		return false
+	}
+	if t.Stop > 0 {
+		t.Risk = math.Abs(entry - t.Stop)
+	}
+	// Same for the target, it has to be on the winning side
+	if cfg.Target && sig.Target > 0 && side*(sig.Target-entry) > 0 {
+		t.Target = sig.Target
+	}
+	e.Trade = t
+	return true
+}
+
+// The price of a take-profit, 0 if it can not be worked out
+func (e *Exits[C]) target(tp TakeProfit) float64 {
+	t := e.Trade
+	d := tp.At.Price(t.Entry, t.ATR, t.Risk)
+	if d <= 0 {
+		return 0
+	}
+	return t.Entry + float64(t.Side)*d
+}
+
+// Checks the candle against the levels and returns the exits, the fill price is the level or the open if the candle gapped through it
+func (e *Exits[C]) check(c models.CandleStick) []models.Signal {
+	t := e.Trade
+	side := float64(t.Side)
+	exit := func(price float64, reason string, fraction float64) models.Signal {
+		return models.Signal{Strategy: e.Name(), Symbol: t.Symbol, OpenTime: c.OpenTime, Kind: SignalExit, Side: t.Side, Price: price, Intrabar: true,
+			Reason: reason, Fraction: fraction}
+	}
+	// Worst price of the candle for the trade, and the best
+	worst, best := c.Low, c.High
+	if t.Side == -1 {
+		worst, best = c.High, c.Low
+	}
+
+	if t.Stop > 0 && side*(worst-t.Stop) <= 0 {
+		price := t.Stop
+		if side*(c.Open-t.Stop) < 0 {
+			price = c.Open
+		}
+		e.Trade = nil
+		return []models.Signal{exit(price, t.StopReason, 0)}
+	}
+
+	var out []models.Signal
+	for t.Targets < len(e.Config.TakeProfits) {
+		tp := e.Config.TakeProfits[t.Targets]
+		level := e.target(tp)
+		if level <= 0 || side*(best-level) < 0 {
+			break
+		}
+		price := level
+		if side*(c.Open-level) > 0 {
+			price = c.Open
+		}
+		t.Targets++
+		closing := math.Min(tp.Fraction, t.Remaining)
+		// The last take-profit closes whatever is left
+		if t.Targets == len(e.Config.TakeProfits) || t.Remaining-closing < 1e-9 {
+			e.Trade = nil
+			return append(out, exit(price, "target", 0))
+		}
+		out = append(out, exit(price, "target", closing/t.Remaining))
+		t.Remaining -= closing
+	}
+	if t.Target > 0 && side*(best-t.Target) >= 0 {
+		price := t.Target
+		if side*(c.Open-t.Target) > 0 {
+			price = c.Open
+		}
+		e.Trade = nil
+		out = append(out, exit(price, "pattern-target", 0))
+	}
+	return out
+}
+
+// Moves the trailing stop and break-even on a final candle, the stop only ever tightens
+func (e *Exits[C]) trail(c models.CandleStick, atr float64) {
+	t := e.Trade
+	side := float64(t.Side)
+	if t.Side == 1 {
+		t.Best = math.Max(t.Best, c.High)
+	} else {
+		t.Best = math.Min(t.Best, c.Low)
+	}
+	tighten := func(stop float64, reason string) {
+		if t.Stop == 0 || side*(stop-t.Stop) > 0 {
+			t.Stop, t.StopReason = stop, reason
+		}
+	}
+	if d := e.Config.BreakEven.Price(t.Entry, t.ATR, t.Risk); d > 0 && side*(t.Best-t.Entry) >= d {
+		tighten(t.Entry, "breakeven")
+	}
+	// The chandelier uses the current ATR so the stop follows the volatility
+	if d := e.Config.Trail.Price(t.Best, atr, t.Risk); d > 0 {
+		tighten(t.Best-side*d, "trail")
+	}
+}
+
+func (e *Exits[C]) OnCandle(ctx context.Context, candle C) []models.Signal {
+	c := candle.CandleStick()
+	atr := e.atr.ATR
+	if c.IsFinal {
+		if val, ok := e.updateATR(c); ok {
+			atr = val
+		}
+	}
+
+	// The levels were set on earlier candles, so they are checked before the strategy sees this one
+	var out []models.Signal
+	closed := 0
+	if e.Trade != nil && e.Trade.OpenTime != c.OpenTime {
+		side := e.Trade.Side
+		out = e.check(c)
+		if e.Trade == nil {
+			closed = side
+			if r, ok := e.Strategy.(PositionResetter); ok {
+				r.ResetPosition()
+			}
+		}
+	}
+
+	for _, sig := range e.Strategy.OnCandle(ctx, candle) {
+		switch sig.Kind {
+		case SignalExit:
+			if sig.Side == closed {
+				continue // Already closed by the stop or target
+			}
+			if e.Trade != nil && e.Trade.Side == sig.Side {
+				e.Trade = nil
+			}
+		case SignalEntry:
+			if !e.open(sig, atr) {
+				log.Printf("Entry %d at %.4f on candle %d refused: no %s stop, the ATR is not ready", sig.Side, sig.Price, sig.OpenTime, e.Config.Stop)
+				if r, ok := e.Strategy.(PositionResetter); ok {
+					r.ResetPosition()
+				}
+				continue
+			}
+		}
+		out = append(out, sig)
+	}
+
+	if c.IsFinal && e.Trade != nil && e.Trade.OpenTime != c.OpenTime {
+		e.trail(c, atr)
+	}
+	return out
+}
+
+// The ATR is not kept, it has to be seeded again after a restore (the open trade keeps the ATR from its entry)
+type exitsState struct {
+	Strategy StrategyState
+	Trade    *ExitTrade
+}
+
+func (e *Exits[C]) Snapshot() (StrategyState, error) {
+	inner, err := e.Strategy.Snapshot()
+	if err != nil {
+		return StrategyState{}, err
+	}
+	data, err := json.Marshal(exitsState{Strategy: inner, Trade: e.Trade})
+	if err != nil {
+		return StrategyState{}, err
+	}
+	return StrategyState{Strategy: e.Name(), OpenTime: inner.OpenTime, Position: inner.Position, Data: data}, nil
+}
+
+func (e *Exits[C]) Restore(state StrategyState) error {
+	if state.Strategy != e.Name() {
+		return fmt.Errorf("state is for strategy %q, not %q", state.Strategy, e.Name())
+	}
+	var s exitsState
+	if err := json.Unmarshal(state.Data, &s); err != nil {
+		return fmt.Errorf("invalid %s state: %w", e.Name(), err)
+	}
+	if err := e.Strategy.Restore(s.Strategy); err != nil {
+		return err
+	}
+	e.Trade = s.Trade
+	return nil
+}
@@ -0,0 +1,39 @@
+package bot
+
+import (
+	"testing"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// The ATR is seeded with Binance history (ms) and then fed FetchSOLUSDT candles (seconds), see stampedCandles in setup_test.go
+
+func TestExitsLiveUnits(t *testing.T) {
+	walk := randomWalk(200, 4)
+	history, live := stampedCandles(walk[:100], false), stampedCandles(walk[100:], true)
+	cfg, err := ParseExitConfig("stop=2atr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewExits[models.CandleStick](nil, cfg)
+	e.Seed(history)
+	seeded := e.atr.ATR
+	if seeded <= 0 {
+		t.Fatal("no ATR after seeding")
+	}
+	for _, c := range live {
+		e.updateATR(c)
+	}
+	if e.atr.ATR == seeded {
+		t.Fatal("the ATR did not move on the live candles")
+	}
+
+	// Replaying the history after the seed is skipped
+	atr := e.atr.ATR
+	for _, c := range history {
+		e.updateATR(c)
+	}
+	if e.atr.ATR != atr {
+		t.Fatal("the ATR moved on candles it had already seen")
+	}
+}
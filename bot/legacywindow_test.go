@@ -0,0 +1,282 @@
+package bot
+
+import models "github.com/Reece-Ogidih/CT-Bot/Models"
+
+// This is the slice based SlidingWindowTrain the generic ring buffer window replaced, kept here as the baseline for the window tests and benchmarks
+// It re-slices the candles on every push, scans the whole window for the max high/min low and rescans for the anchor positions
+
+type legacyWindow struct {
+	Symbol      string // Will add this for scalability later when expanding to multiple coins
+	Interval    string
+	Size        int
+	Candles     []models.EnrichedCandle
+	SupLine     models.Trendline
+	ResLine     models.Trendline
+	Initialised bool
+	Idxs        []int // Will need to add an index to each candle in order for trendline slopes to not be near zero
+}
+
+func (sw *legacyWindow) NewWindowTrain(candle models.EnrichedCandle) {
+	sw.Candles = append(sw.Candles, candle)
+
+	// To keep the window at a fixed size, remove the oldest candle when a new one arrives
+	if len(sw.Candles) > sw.Size {
+		sw.Candles = sw.Candles[1:]
+	}
+}
+
+// Function to increase the index list when a new candle is considered. will separate this into two functions since I may want the index for next candle without updating window
+func (sw *legacyWindow) GetNextIdx() (nextIdx int) {
+	nextIdx = sw.Idxs[len(sw.Idxs)-1] + 1
+	return nextIdx
+}
+
+func (sw *legacyWindow) UpdateIdx(nextIdx int) {
+	sw.Idxs = append(sw.Idxs, nextIdx)
+
+	if len(sw.Idxs) > sw.Size {
+		sw.Idxs = sw.Idxs[1:]
+	}
+}
+
+func (sw *legacyWindow) CheckTrendline1(t models.Trendline, startIdx, endIdx int, isResist bool) int {
+	// Guard against edge case
+	if startIdx >= endIdx {
+		return -1
+	}
+
+	for i := endIdx - 1; i >= startIdx; i-- {
+		// Instead of OpenTime, use index from sw.Idxs
+		x := float64(sw.Idxs[i])
+
+		expected := t.Gradient*x + t.Intercept
+
+		if isResist {
+			if sw.Candles[i].High > expected {
+				return i
+			}
+		} else {
+			if sw.Candles[i].Low < expected {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func (sw *legacyWindow) MaxHigh() int {
+	maxIdx := -1
+	maxHigh := -1.0
+	for i := 0; i < len(sw.Candles); i++ {
+		if sw.Candles[i].High > maxHigh {
+			maxHigh = sw.Candles[i].High
+			maxIdx = i
+		}
+	}
+	return maxIdx
+}
+
+func (sw *legacyWindow) MinLow() int {
+	minIdx := -1
+	minLow := 10000000000000000000000.0
+	for i := 0; i < len(sw.Candles); i++ {
+		if sw.Candles[i].Low < minLow {
+			minLow = sw.Candles[i].Low
+			minIdx = i
+		}
+	}
+	return minIdx
+}
+
+func (sw *legacyWindow) CreateResLine() (models.Trendline, bool) {
+	maxIdx := sw.MaxHigh()
+	if maxIdx == -1 {
+		return models.Trendline{}, false
+	}
+	anchor1 := sw.Candles[maxIdx]
+	endIdx := len(sw.Candles) - 1 // Decided to use the most recent completed candle
+	anchor2 := sw.Candles[endIdx]
+
+	for {
+		// Find positions of anchor1 and anchor2 inside the window
+		pos1 := -1
+		pos2 := -1
+		for i, c := range sw.Candles {
+			if c.OpenTime == anchor1.OpenTime {
+				pos1 = i
+			}
+			if c.OpenTime == anchor2.OpenTime {
+				pos2 = i
+			}
+		}
+
+		// Will check to ensure that both of the candles are within the window
+		if pos1 == -1 || pos2 == -1 {
+			break
+		}
+
+		// Cannot have identical timestamps (Note: sw.Idxs[pos1] = index of anchor1 and sw.Idx[pos2] = index of anchor2)
+		if sw.Idxs[pos1] == sw.Idxs[pos2] {
+			break
+		}
+
+		// Need to ensure that the line has negative gradient
+		if anchor2.High >= anchor1.High {
+			// To guard against infinite loop, have to make sure to update the anchor2 before exiting the loop
+			if pos2-1 <= pos1 {
+				break // There are no more candles left to check
+			}
+			anchor2 = sw.Candles[pos2-1] // Can now update the 2nd anchor and skip to the next loop
+			continue
+		}
+
+		// Will get the required points to calculate equation of the line
+		x1 := float64(sw.Idxs[pos1])
+		x2 := float64(sw.Idxs[pos2])
+		y1 := anchor1.High
+		y2 := anchor2.High
+
+		// Can now calculate the gradient and intercept of the Resistance Line
+		gradient := (y2 - y1) / (x2 - x1)
+		intercept := y1 - gradient*x1
+
+		// Define the line
+		line := models.Trendline{
+			Gradient:  gradient,
+			Intercept: intercept,
+			A1Time:    anchor1.OpenTime,
+			A2Time:    anchor2.OpenTime,
+			A1Price:   y1,
+			A2Price:   y2,
+		}
+
+		// Now we can check for any breakouts on past candles and recalculate the line
+		breakoutIdx := sw.CheckTrendline1(line, maxIdx+1, endIdx, true)
+
+		// If no breakouts then the line is complete
+		if breakoutIdx == -1 {
+			return line, true
+		}
+
+		// Otherwise we shift anchor 2 to the position of the breakout
+		anchor2 = sw.Candles[breakoutIdx]
+		endIdx = breakoutIdx
+	}
+
+	return models.Trendline{}, false
+}
+
+// Want to use index positions instead of OpenTime for the timestamp. this is because it would otherwise result in practically 0 gradient
+func (sw *legacyWindow) CreateSupLine() (models.Trendline, bool) {
+	minIdx := sw.MinLow()
+	if minIdx == -1 {
+		return models.Trendline{}, false
+	}
+	anchor1 := sw.Candles[minIdx]
+	endIdx := len(sw.Candles) - 1 // Again, use the most recent completed candle to start
+	anchor2 := sw.Candles[endIdx]
+
+	for {
+		// Find positions of anchor1 and anchor2 inside the window
+		pos1 := -1
+		pos2 := -1
+		for i, c := range sw.Candles {
+			if c.OpenTime == anchor1.OpenTime {
+				pos1 = i
+			}
+			if c.OpenTime == anchor2.OpenTime {
+				pos2 = i
+			}
+		}
+
+		// Will check to ensure that both of the candles are within the window
+		if pos1 == -1 || pos2 == -1 {
+			break
+		}
+
+		// Cannot have identical timestamps (Note: sw.Idxs[pos1] = index of anchor1 and sw.Idx[pos2] = index of anchor2)
+		if sw.Idxs[pos1] == sw.Idxs[pos2] {
+			break
+		}
+
+		// Need to ensure that the line has positive gradient
+		if anchor2.Low <= anchor1.Low {
+			// To guard against infinite loop, have to make sure to update the anchor2 before exiting the loop
+			if pos2-1 <= pos1 {
+				break // There are no more candles left to check
+			}
+			anchor2 = sw.Candles[pos2-1] // Can now update the 2nd anchor and skip to the next loop
+			continue
+		}
+
+		// Will get the required points to calculate equation of the line
+		x1 := float64(sw.Idxs[pos1])
+		x2 := float64(sw.Idxs[pos2])
+		y1 := anchor1.Low
+		y2 := anchor2.Low
+
+		// Can now calculate the gradient and intercept of the Resistance Line
+		gradient := (y2 - y1) / (x2 - x1)
+		intercept := y1 - gradient*x1
+
+		// Define the line
+		line := models.Trendline{
+			Gradient:  gradient,
+			Intercept: intercept,
+			A1Time:    anchor1.OpenTime,
+			A2Time:    anchor2.OpenTime,
+			A1Price:   y1,
+			A2Price:   y2,
+		}
+
+		// Now we can check for any breakouts on past candles and recalculate the line
+		breakoutIdx := sw.CheckTrendline1(line, minIdx+1, endIdx, false)
+
+		// If no breakouts then the line is complete
+		if breakoutIdx == -1 {
+			return line, true
+		}
+
+		// Otherwise we shift anchor 2 to the position of the breakout
+		anchor2 = sw.Candles[breakoutIdx]
+		endIdx = breakoutIdx
+	}
+
+	return models.Trendline{}, false
+}
+
+// Now create CheckTrendlines2 which will check 1 candle for a breakout, this will be called as each new candle arrives live.
+func (sw *legacyWindow) CheckTrendlines2(line models.Trendline, candle models.EnrichedCandle, isResist bool, currPos int, nextIdx int) bool {
+	// This function is for the live candles being fed in, so we know that the Idx would end up being the one more than the last Idx in the window
+	x := float64(nextIdx)
+	y := line.Gradient*x + line.Intercept
+
+	if currPos == 0 {
+		if isResist {
+			return candle.High > y
+		}
+		return candle.Low < y
+	}
+	if currPos == 1 {
+		if !isResist {
+			return candle.Low < y
+		}
+		return false
+	}
+	if currPos == -1 {
+		if isResist {
+			return candle.High > y
+		}
+		return false
+	}
+	return false
+}
+
+func (sw *legacyWindow) ComputeTrendlines() {
+	if line, ok := sw.CreateResLine(); ok {
+		sw.ResLine = line
+	}
+	if line, ok := sw.CreateSupLine(); ok {
+		sw.SupLine = line
+	}
+}
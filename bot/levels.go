@@ -0,0 +1,449 @@
+package bot
+
+import (
+	"math"
+	"sort"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// Horizontal support/resistance levels, these complement the sloped trendlines
+// It works as follows
+// First the pivots (swing highs/lows) are found, either with fractals (same rule as SwingHighs/SwingLows) or a zig-zag
+// The pivots are then clustered by price into zones, each zone keeps its touch count, a recency weighted strength and the volume traded inside it
+// The LevelTracker keeps the pivots up to date as the window slides, and reports when a candle tests or breaks a zone
+// Whether a zone is support or resistance is not fixed, it depends on which side of it price last closed (so a broken resistance becomes support)
+
+type PivotMethod int
+
+const (
+	PivotFractal PivotMethod = iota // Swing high/low which is above/below the SwingStrength candles either side
+	PivotZigZag                     // Extreme which price has since reversed from by at least ZigZagPct
+)
+
+type LevelConfig struct {
+	Pivots        PivotMethod
+	SwingStrength int     // For the fractal pivots
+	ZigZagPct     float64 // For the zig-zag pivots, fraction of price (e.g. 0.005 = 0.5%)
+	ToleranceMode ToleranceMode
+	Tolerance     float64 // Max distance between pivots in the same zone, fraction of price or ATR multiple depending on the mode
+	MinTouches    int     // Zones with fewer pivots than this are not reported
+	HalfLife      float64 // Age in bars at which a touch counts half as much towards the strength, 0 means no recency weighting
+	VolumeWeight  float64 // Strength is multiplied by (1 + VolumeWeight * share of the window's volume traded inside the zone)
+	MaxZones      int     // Only the strongest zones are kept, 0 keeps them all
+}
+
+func DefaultLevelConfig() LevelConfig {
+	return LevelConfig{
+		Pivots:        PivotFractal,
+		SwingStrength: 3,
+		ZigZagPct:     0.005,
+		ToleranceMode: ToleranceATR,
+		Tolerance:     0.5,
+		MinTouches:    2,
+		HalfLife:      100,
+		VolumeWeight:  1,
+		MaxZones:      8,
+	}
+}
+
+type Pivot struct {
+	Idx      int // Absolute index of the candle (same numbering as the window)
+	OpenTime int64
+	Price    float64
+	IsHigh   bool
+}
+
+type Zone struct {
+	ID        int
+	Low       float64
+	High      float64
+	Price     float64 // Average price of the pivots in the zone
+	Touches   int
+	FirstTime int64 // OpenTime of the first and last pivot in the zone
+	LastTime  int64
+	Volume    float64 // Volume traded inside the zone over the window (each candle's volume is spread evenly over its range)
+	Strength  float64
+	Side      int // 1 if price last closed above the zone (so it is acting as support), -1 if below (resistance)
+}
+
+func (z Zone) IsSupport() bool {
+	return z.Side > 0
+}
+
+type LevelEventKind int
+
+const (
+	LevelTest  LevelEventKind = iota // Price came into the zone but did not close through it
+	LevelBreak                       // Price closed through the zone to the other side
+)
+
+func (k LevelEventKind) String() string {
+	if k == LevelBreak {
+		return "break"
+	}
+	return "test"
+}
+
+type LevelEvent struct {
+	Kind      LevelEventKind
+	Zone      Zone
+	Direction int // 1 for an upside break/test of support, -1 for a downside break/test of resistance
+	OpenTime  int64
+}
+
+// Batch version, finds the zones over a set of candles (for example a backtest window)
+func FindLevels(bars []models.CandleStick, cfg LevelConfig) []Zone {
+	var pivots []Pivot
+	if cfg.Pivots == PivotZigZag {
+		zz := zigZag{pct: cfg.ZigZagPct}
+		for i, b := range bars {
+			if p, ok := zz.update(i, b); ok {
+				pivots = append(pivots, p)
+			}
+		}
+	} else {
+		for _, i := range SwingHighs(bars, cfg.SwingStrength) {
+			pivots = append(pivots, Pivot{Idx: i, OpenTime: bars[i].OpenTime, Price: bars[i].High, IsHigh: true})
+		}
+		for _, i := range SwingLows(bars, cfg.SwingStrength) {
+			pivots = append(pivots, Pivot{Idx: i, OpenTime: bars[i].OpenTime, Price: bars[i].Low})
+		}
+	}
+	if len(bars) == 0 {
+		return nil
+	}
+	return buildZones(pivots, bars, 0, bars[len(bars)-1].Close, cfg)
+}
+
+// Clusters the pivots into zones, firstIdx is the absolute index of bars[0]
+func buildZones(pivots []Pivot, bars []models.CandleStick, firstIdx int, lastClose float64, cfg LevelConfig) []Zone {
+	if len(pivots) == 0 || len(bars) == 0 {
+		return nil
+	}
+	atr := averageTrueRange(bars)
+	tolerance := func(price float64) float64 {
+		if cfg.ToleranceMode == ToleranceATR {
+			return cfg.Tolerance * atr
+		}
+		return cfg.Tolerance * price
+	}
+
+	sorted := make([]Pivot, len(pivots))
+	copy(sorted, pivots)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Price < sorted[j].Price })
+
+	// A new cluster is started once a pivot is more than the tolerance above the lowest pivot of the current cluster
+	// This keeps the zones from chaining together into one wide zone
+	var clusters [][]Pivot
+	start := 0
+	for i := 1; i <= len(sorted); i++ {
+		if i == len(sorted) || sorted[i].Price-sorted[start].Price > tolerance(sorted[start].Price) {
+			clusters = append(clusters, sorted[start:i])
+			start = i
+		}
+	}
+
+	lastIdx := firstIdx + len(bars) - 1
+	var totalVolume float64
+	for _, b := range bars {
+		totalVolume += b.Volume
+	}
+
+	var zones []Zone
+	for _, cluster := range clusters {
+		if len(cluster) < cfg.MinTouches {
+			continue
+		}
+		z := Zone{
+			Low:       cluster[0].Price,
+			High:      cluster[len(cluster)-1].Price,
+			Touches:   len(cluster),
+			FirstTime: math.MaxInt64,
+		}
+		// Pad the zone by half the tolerance so a single price level still has some width
+		pad := tolerance(z.Low) / 2
+		z.Low -= pad
+		z.High += pad
+
+		var weighted float64
+		for _, p := range cluster {
+			z.Price += p.Price
+			z.FirstTime = min(z.FirstTime, p.OpenTime)
+			z.LastTime = max(z.LastTime, p.OpenTime)
+			weight := 1.0
+			if cfg.HalfLife > 0 {
+				weight = math.Pow(0.5, float64(lastIdx-p.Idx)/cfg.HalfLife)
+			}
+			weighted += weight
+		}
+		z.Price /= float64(len(cluster))
+
+		for _, b := range bars {
+			z.Volume += volumeInRange(b, z.Low, z.High)
+		}
+		z.Strength = weighted
+		if totalVolume > 0 {
+			z.Strength *= 1 + cfg.VolumeWeight*z.Volume/totalVolume
+		}
+
+		z.Side = 1
+		if lastClose < z.Price {
+			z.Side = -1
+		}
+		zones = append(zones, z)
+	}
+
+	sort.SliceStable(zones, func(i, j int) bool { return zones[i].Strength > zones[j].Strength })
+	if cfg.MaxZones > 0 && len(zones) > cfg.MaxZones {
+		zones = zones[:cfg.MaxZones]
+	}
+	return zones
+}
+
+// Portion of a candle's volume traded between lo and hi, assuming the volume is spread evenly over the candle's range
+func volumeInRange(b models.CandleStick, lo, hi float64) float64 {
+	rng := b.High - b.Low
+	if rng == 0 {
+		if b.Close >= lo && b.Close <= hi {
+			return b.Volume
+		}
+		return 0
+	}
+	overlap := math.Min(b.High, hi) - math.Max(b.Low, lo)
+	if overlap <= 0 {
+		return 0
+	}
+	return b.Volume * overlap / rng
+}
+
+// Zig-zag pivot finder, an extreme only becomes a pivot once price has reversed from it by at least pct
+type zigZag struct {
+	pct     float64
+	dir     int // 1 if currently tracking a high, -1 if tracking a low, 0 before the first move
+	extreme Pivot
+	started bool
+	first   models.CandleStick
+	firstI  int
+}
+
+func (z *zigZag) update(idx int, b models.CandleStick) (Pivot, bool) {
+	if !z.started {
+		z.started = true
+		z.first, z.firstI = b, idx
+		return Pivot{}, false
+	}
+
+	// Before the first move is known, wait until price has moved far enough from the first candle in either direction
+	if z.dir == 0 {
+		switch {
+		case b.High >= z.first.Low*(1+z.pct):
+			z.dir = 1
+			z.extreme = Pivot{Idx: idx, OpenTime: b.OpenTime, Price: b.High, IsHigh: true}
+			return Pivot{Idx: z.firstI, OpenTime: z.first.OpenTime, Price: z.first.Low}, true
+		case b.Low <= z.first.High*(1-z.pct):
+			z.dir = -1
+			z.extreme = Pivot{Idx: idx, OpenTime: b.OpenTime, Price: b.Low}
+			return Pivot{Idx: z.firstI, OpenTime: z.first.OpenTime, Price: z.first.High, IsHigh: true}, true
+		}
+		return Pivot{}, false
+	}
+
+	if z.dir == 1 {
+		if b.High > z.extreme.Price {
+			z.extreme = Pivot{Idx: idx, OpenTime: b.OpenTime, Price: b.High, IsHigh: true}
+			return Pivot{}, false
+		}
+		if b.Low <= z.extreme.Price*(1-z.pct) {
+			confirmed := z.extreme
+			z.dir = -1
+			z.extreme = Pivot{Idx: idx, OpenTime: b.OpenTime, Price: b.Low}
+			return confirmed, true
+		}
+		return Pivot{}, false
+	}
+
+	if b.Low < z.extreme.Price {
+		z.extreme = Pivot{Idx: idx, OpenTime: b.OpenTime, Price: b.Low}
+		return Pivot{}, false
+	}
+	if b.High >= z.extreme.Price*(1+z.pct) {
+		confirmed := z.extreme
+		z.dir = 1
+		z.extreme = Pivot{Idx: idx, OpenTime: b.OpenTime, Price: b.High, IsHigh: true}
+		return confirmed, true
+	}
+	return Pivot{}, false
+}
+
+// Streaming version for the live channel
+// The pivots are added as they are confirmed and dropped once they slide out of the window, so the whole window never has to be rescanned for pivots
+type LevelTracker[C Candle] struct {
+	Config LevelConfig
+	Window *Window[C]
+	pivots []Pivot // Oldest first
+	zones  []Zone
+	zz     zigZag
+	nextID int
+	inZone map[int]bool // Zone IDs that the previous candle was inside, so a test is only reported when price first comes into the zone
+}
+
+func NewLevelTracker[C Candle](symbol, interval string, size int, cfg LevelConfig) (*LevelTracker[C], error) {
+	window, err := NewSlidingWindow[C](symbol, interval, size, AxisIndex)
+	if err != nil {
+		return nil, err
+	}
+	return &LevelTracker[C]{
+		Config: cfg,
+		Window: window,
+		zz:     zigZag{pct: cfg.ZigZagPct},
+		inZone: make(map[int]bool),
+	}, nil
+}
+
+// Update checks the new candle against the current zones, then adds it to the window and updates the zones
+// The events only use zones built from earlier candles, so there is no look-ahead
+func (t *LevelTracker[C]) Update(candle C) []LevelEvent {
+	c := candle.CandleStick()
+	var events []LevelEvent
+	inZone := make(map[int]bool)
+	for i := range t.zones {
+		z := &t.zones[i]
+		switch {
+		case z.Side < 0 && c.Close > z.High:
+			events = append(events, LevelEvent{Kind: LevelBreak, Zone: *z, Direction: 1, OpenTime: c.OpenTime})
+			z.Side = 1
+		case z.Side > 0 && c.Close < z.Low:
+			events = append(events, LevelEvent{Kind: LevelBreak, Zone: *z, Direction: -1, OpenTime: c.OpenTime})
+			z.Side = -1
+		case c.High >= z.Low && c.Low <= z.High:
+			inZone[z.ID] = true
+			if !t.inZone[z.ID] {
+				events = append(events, LevelEvent{Kind: LevelTest, Zone: *z, Direction: z.Side, OpenTime: c.OpenTime})
+			}
+		}
+	}
+	t.inZone = inZone
+
+	t.Window.Push(candle)
+	t.addPivots(c)
+	t.rebuild(c.Close)
+	return events
+}
+
+// Fill primes the tracker with history without reporting any events
+func (t *LevelTracker[C]) Fill(candles []C) {
+	for _, c := range candles {
+		t.Window.Push(c)
+		t.addPivots(c.CandleStick())
+	}
+	if len(candles) > 0 {
+		t.rebuild(candles[len(candles)-1].CandleStick().Close)
+	}
+}
+
+func (t *LevelTracker[C]) addPivots(c models.CandleStick) {
+	w := t.Window
+	if t.Config.Pivots == PivotZigZag {
+		if p, ok := t.zz.update(w.Idx(w.Len()-1), c); ok {
+			t.pivots = append(t.pivots, p)
+		}
+	} else {
+		// The candle SwingStrength back now has enough candles after it to say whether it is a swing point
+		k := t.Config.SwingStrength
+		pos := w.Len() - 1 - k
+		if pos-k >= 0 {
+			mid := w.Bar(pos)
+			isHigh, isLow := true, true
+			for j := 1; j <= k; j++ {
+				before, after := w.Bar(pos-j), w.Bar(pos+j)
+				if before.High > mid.High || after.High >= mid.High {
+					isHigh = false
+				}
+				if before.Low < mid.Low || after.Low <= mid.Low {
+					isLow = false
+				}
+			}
+			if isHigh {
+				t.pivots = append(t.pivots, Pivot{Idx: w.Idx(pos), OpenTime: mid.OpenTime, Price: mid.High, IsHigh: true})
+			}
+			if isLow {
+				t.pivots = append(t.pivots, Pivot{Idx: w.Idx(pos), OpenTime: mid.OpenTime, Price: mid.Low})
+			}
+		}
+	}
+
+	// Drop the pivots which have slid out of the window
+	oldest := w.Idx(0)
+	drop := 0
+	for drop < len(t.pivots) && t.pivots[drop].Idx < oldest {
+		drop++
+	}
+	t.pivots = t.pivots[drop:]
+}
+
+// Rebuilds the zones from the current pivots, zones which overlap one from before keep its ID and side
+func (t *LevelTracker[C]) rebuild(lastClose float64) {
+	w := t.Window
+	bars := make([]models.CandleStick, w.Len())
+	for i := range bars {
+		bars[i] = w.Bar(i)
+	}
+	zones := buildZones(t.pivots, bars, w.Idx(0), lastClose, t.Config)
+
+	// Match the new zones to the old ones, largest overlap first so a strong new zone which only just overlaps an old one does not take its ID
+	type match struct {
+		newZone, oldZone int
+		overlap          float64
+	}
+	var matches []match
+	for i, z := range zones {
+		for j, old := range t.zones {
+			if overlap := math.Min(z.High, old.High) - math.Max(z.Low, old.Low); overlap > 0 {
+				matches = append(matches, match{i, j, overlap})
+			}
+		}
+	}
+	sort.Slice(matches, func(a, b int) bool { return matches[a].overlap > matches[b].overlap })
+	newUsed := make([]bool, len(zones))
+	oldUsed := make([]bool, len(t.zones))
+	for _, m := range matches {
+		if newUsed[m.newZone] || oldUsed[m.oldZone] {
+			continue
+		}
+		newUsed[m.newZone], oldUsed[m.oldZone] = true, true
+		z, old := &zones[m.newZone], t.zones[m.oldZone]
+		z.ID = old.ID
+		// Price closing inside the zone does not change which side it is on
+		if lastClose >= z.Low && lastClose <= z.High {
+			z.Side = old.Side
+		}
+	}
+	for i := range zones {
+		if !newUsed[i] {
+			t.nextID++
+			zones[i].ID = t.nextID
+		}
+	}
+	t.zones = zones
+}
+
+// Zones strongest first
+func (t *LevelTracker[C]) Zones() []Zone {
+	out := make([]Zone, len(t.zones))
+	copy(out, t.zones)
+	return out
+}
+
+// Gives the closest support zone below price and resistance zone above price
+func (t *LevelTracker[C]) Nearest(price float64) (support Zone, supOK bool, resistance Zone, resOK bool) {
+	for _, z := range t.zones {
+		if z.High <= price && (!supOK || z.High > support.High) {
+			support, supOK = z, true
+		}
+		if z.Low >= price && (!resOK || z.Low < resistance.Low) {
+			resistance, resOK = z, true
+		}
+	}
+	return support, supOK, resistance, resOK
+}
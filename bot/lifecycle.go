@@ -0,0 +1,147 @@
+package bot
+
+import (
+	"database/sql"
+	"log"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// Trendline lifecycle, every line drawn by ComputeTrendlines gets an ID and the window publishes what happens to it
+// created:  the line was drawn
+// touched:  the price came within TouchTolerance of the line without crossing it, once per approach (it has to leave the band again before the next touch counts)
+// broken:   a confirmed breakout of the line, the caller passes in the breakouts it acted on (the trader's ConfirmBreakout results) so the events agree with the trades
+// expired:  the line was dropped because it was not broken in time (ExpireTrendlines)
+// replaced: a new line was drawn while the old one was still live
+// The events are only sent if the window's Events channel is set, and the channel has to be drained (e.g. with LogTrendlineEvents)
+
+const (
+	TrendlineCreated  = "created"
+	TrendlineTouched  = "touched"
+	TrendlineBroken   = "broken"
+	TrendlineExpired  = "expired"
+	TrendlineReplaced = "replaced"
+)
+
+// Lifecycle state of the current support or resistance line
+type lineLife struct {
+	touches int
+	near    bool // The last candle was within the touch band
+	done    bool // Broken or expired, so there are no more events for the line
+}
+
+func (w *Window[C]) lineState(isResist bool) (*models.Trendline, *lineLife) {
+	if isResist {
+		return &w.ResLine, &w.resLife
+	}
+	return &w.SupLine, &w.supLife
+}
+
+func (w *Window[C]) emit(kind string, line models.Trendline, life lineLife, isResist bool, openTime int64, price float64) {
+	if w.Events == nil {
+		return
+	}
+	w.Events <- models.TrendlineEvent{
+		LineID:   line.ID,
+		Kind:     kind,
+		Symbol:   w.Symbol,
+		Interval: w.Interval,
+		IsResist: isResist,
+		OpenTime: openTime,
+		Price:    price,
+		Touches:  life.touches,
+		Line:     line,
+	}
+}
+
+// Swaps in a newly drawn line, a line with the same anchors and equation as the current one keeps its ID
+func (w *Window[C]) setLine(isResist bool, line models.Trendline) {
+	curr, life := w.lineState(isResist)
+	line.ID = curr.ID
+	if curr.ID != 0 && line == *curr {
+		return
+	}
+
+	var openTime int64
+	var x float64
+	if w.count > 0 {
+		openTime = w.Bar(w.count - 1).OpenTime
+		x = w.X(w.count - 1)
+	}
+	if curr.ID != 0 && !life.done {
+		w.emit(TrendlineReplaced, *curr, *life, isResist, openTime, LineAt(*curr, x))
+	}
+
+	w.lineIDs++
+	line.ID = w.lineIDs
+	*curr = line
+	*life = lineLife{}
+	w.emit(TrendlineCreated, line, *life, isResist, openTime, LineAt(line, x))
+}
+
+// TrackTrendlines checks a new candle for touches of the current lines and records the breaks, call it before the candle is pushed
+// brokeRes/brokeSup are whether the candle was taken as a breakout of each line (e.g. TradeSignal.Res.Confirmed)
+func (w *Window[C]) TrackTrendlines(candle C, brokeRes, brokeSup bool) {
+	c := candle.CandleStick()
+	x := w.NextX(candle)
+	for _, isResist := range []bool{true, false} {
+		line, life := w.lineState(isResist)
+		if line.ID == 0 || life.done {
+			continue
+		}
+		y := LineAt(*line, x)
+		tol := w.TouchTolerance * y
+		near := (isResist && c.High >= y-tol) || (!isResist && c.Low <= y+tol)
+		switch {
+		case (isResist && brokeRes) || (!isResist && brokeSup):
+			life.done = true
+			w.emit(TrendlineBroken, *line, *life, isResist, c.OpenTime, y)
+		case near && !life.near:
+			life.touches++
+			w.emit(TrendlineTouched, *line, *life, isResist, c.OpenTime, y)
+		}
+		life.near = near
+	}
+}
+
+// ExpireTrendlines marks the current lines as expired, call it before redrawing the lines when they have run out of time
+func (w *Window[C]) ExpireTrendlines() {
+	var openTime int64
+	var x float64
+	if w.count > 0 {
+		openTime = w.Bar(w.count - 1).OpenTime
+		x = w.X(w.count - 1)
+	}
+	for _, isResist := range []bool{true, false} {
+		line, life := w.lineState(isResist)
+		if line.ID == 0 || life.done {
+			continue
+		}
+		life.done = true
+		w.emit(TrendlineExpired, *line, *life, isResist, openTime, LineAt(*line, x))
+	}
+}
+
+// Stores the trendline events in the trendline_events table until the channel is closed
+// runID separates the lines of different runs, since the line IDs start from 1 in every window
+func LogTrendlineEvents(conn *sql.DB, runID int64, events <-chan models.TrendlineEvent) {
+	stmt, err := conn.Prepare(`
+	INSERT INTO trendline_events (run_id, line_id, symbol, interval_str, kind, is_resist, open_times_ms, price, touches,
+		gradient, intercept, a1_time, a2_time, a1_price, a2_price)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		log.Println("Preparation error for trendline events:", err)
+		for range events { // Still need to drain the channel so the window does not block
+		}
+		return
+	}
+	defer stmt.Close()
+
+	for e := range events {
+		_, err := stmt.Exec(runID, e.LineID, e.Symbol, e.Interval, e.Kind, e.IsResist, e.OpenTime, e.Price, e.Touches,
+			e.Line.Gradient, e.Line.Intercept, e.Line.A1Time, e.Line.A2Time, e.Line.A1Price, e.Line.A2Price)
+		if err != nil {
+			log.Println("Error inserting trendline event:", err)
+		}
+	}
+}
@@ -10,9 +10,11 @@ import (
 	"context" // This is to contrul runtime/cancellations since using Websocket instead of polling (Binance implementation)
 	"database/sql"
 	"encoding/json" // To unmashall
-	"fmt"           // Standard format lib
-	"log"           // For logging errors
-	"net/http"      // To make the HTTP Requests to DEX Screener
+	"errors"
+	"fmt" // Standard format lib
+	"io/fs"
+	"log"      // For logging errors
+	"net/http" // To make the HTTP Requests to DEX Screener
 	"os"
 	"strconv" // For when I need to convert the strings to different types (Binance implementation)
 	"strings" // For manipulation of strings (specifically make sure symbol is lower case) (Binance implementation)
@@ -31,6 +33,7 @@ func AggregateDexData(
 	var totalWeighted float64 // Initially is 0.0
 	var totalLiquidity float64
 	var totalVol, totalBuys, totalSells float64
+	var poolLiquidity float64 // Liquidity of the valid pools, counted once (used for the price impact in paper trading)
 
 	for _, p := range pairs {
 		price, err1 := p.PriceUSD.Float64()
@@ -42,6 +45,7 @@ func AggregateDexData(
 		if err1 == nil && err2 == nil && liq > 0 {
 			totalWeighted += price * liq
 			totalLiquidity += liq
+			poolLiquidity += liq
 		}
 
 		if err3 == nil {
@@ -62,13 +66,32 @@ func AggregateDexData(
 	}
 	price := totalWeighted / totalLiquidity
 	return models.AggregateSnapshot{
-		PriceUSD:   price,
-		VolumeM5:   totalVol,
-		TxnsBuyM5:  int64(totalBuys),
-		TxnsSellM5: int64(totalSells),
+		PriceUSD:     price,
+		VolumeM5:     totalVol,
+		TxnsBuyM5:    int64(totalBuys),
+		TxnsSellM5:   int64(totalSells),
+		LiquidityUSD: poolLiquidity,
 	}, nil
 }
 
+// Mint address of wrapped SOL, which is what DEX Screener lists the SOL pools under
+const SOLToken = "So11111111111111111111111111111111111111112"
+
+// Gets a single aggregated snapshot of a token over all of its pools (e.g. for the liquidity when modelling fills)
+func DexSnapshot(token string) (models.AggregateSnapshot, error) {
+	resp, err := http.Get(fmt.Sprintf("https://api.dexscreener.com/latest/dex/tokens/%s", token))
+	if err != nil {
+		return models.AggregateSnapshot{}, fmt.Errorf("fetch error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var parsed models.DexResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return models.AggregateSnapshot{}, fmt.Errorf("parse error: %w", err)
+	}
+	return AggregateDexData(parsed.Pairs)
+}
+
 func FetchSOLUSDT() (<-chan models.CandleStick, error) {
 
 	// Make the channel
@@ -196,6 +219,29 @@ func FetchSOLUSDT() (<-chan models.CandleStick, error) {
 	return candleChan, nil
 }
 
+// StreamFilter skips the candles from a live stream that were already seen (e.g. the stream sends one that had closed before the history was fetched)
+// The history comes from Binance (OpenTime in ms) while FetchSOLUSDT stamps its candles in seconds, so everything is compared in ms
+type StreamFilter struct {
+	last int64 // OpenTime of the last final candle, in ms
+}
+
+// Mark records a candle as already seen, e.g. the last candle of the history or the window
+func (f *StreamFilter) Mark(openTime int64) {
+	f.last = max(f.last, OpenTimeMs(openTime))
+}
+
+// Skip is true for a candle at or before the last final one, a final candle that is not skipped is marked
+func (f *StreamFilter) Skip(c models.CandleStick) bool {
+	t := OpenTimeMs(c.OpenTime)
+	if t <= f.last {
+		return true
+	}
+	if c.IsFinal {
+		f.last = t
+	}
+	return false
+}
+
 // BINANCE WEB-SOCKET IMPLEMENTATION
 
 // For the function's input and output declarations, I am passing a ctx var as input to allow the caller to timeout (ctx short for context)
@@ -208,9 +254,10 @@ func FetchSOLUSDT() (<-chan models.CandleStick, error) {
 // This function is so that as the candle data is sent through the channel, it is stored in MySQL database for future checks/calculations
 
 // First a function to load the .env info
+// A missing .env is fine here (the settings can come from the environment, and the tests and benchmarks do not need it), the commands fail on any setting they need that is not set
 func init() {
 	err := godotenv.Load()
-	if err != nil {
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		log.Fatal("Error loading .env file")
 	}
 }
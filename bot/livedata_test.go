@@ -0,0 +1,36 @@
+package bot
+
+import (
+	"testing"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// The history is from Binance (ms) and the live candles from FetchSOLUSDT (seconds), the filter has to line them up
+
+func TestStreamFilter(t *testing.T) {
+	const minute = 60
+	last := int64(1_760_000_040) // A minute start in seconds, in 2025
+	var f StreamFilter
+	f.Mark(last * 1000) // The end of the ms history
+	f.Mark((last - minute) * 1000)
+
+	steps := []struct {
+		name string
+		c    models.CandleStick
+		skip bool
+	}{
+		{"last history candle again", models.CandleStick{OpenTime: last, IsFinal: true}, true},
+		{"older candle", models.CandleStick{OpenTime: last - minute, IsFinal: true}, true},
+		{"next candle still open", models.CandleStick{OpenTime: last + minute}, false},
+		{"next candle closed", models.CandleStick{OpenTime: last + minute, IsFinal: true}, false},
+		{"next candle closed again", models.CandleStick{OpenTime: last + minute, IsFinal: true}, true},
+		{"same candle in ms", models.CandleStick{OpenTime: (last + minute) * 1000, IsFinal: true}, true},
+		{"the one after", models.CandleStick{OpenTime: last + 2*minute, IsFinal: true}, false},
+	}
+	for _, s := range steps {
+		if skip := f.Skip(s.c); skip != s.skip {
+			t.Errorf("%s: skip %v, want %v", s.name, skip, s.skip)
+		}
+	}
+}
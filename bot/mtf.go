@@ -0,0 +1,189 @@
+package bot
+
+import (
+	"fmt"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// This is so that the bot can use indicators on higher timeframes (e.g. require the 15m ADX to be above threshold before taking a 1m breakout)
+// The higher timeframe candles are all built from the one base candle stream so there is no need for a second feed
+// To avoid any look-ahead, the higher timeframe indicators only update once their candle has closed
+// This means a lookup as of a 1m candle gives the value from the last completed 15m candle, exactly what the live bot would have seen
+// The first higher timeframe candle is dropped unless the stream started on its boundary, since it would be missing the start of its period
+
+// Any streaming indicator can be used on a timeframe as long as it has this Update method (for example ATRCalculator)
+type Indicator interface {
+	Update(c models.CandleStick) (float64, bool)
+}
+
+// The ADXCalculator fetches from Binance on its first update, so for other timeframes it needs to seed itself from its own first candles
+type ADXIndicator struct {
+	Calc   ADXCalculator
+	warmup []models.CandleStick
+}
+
+func NewADXIndicator(period int) *ADXIndicator {
+	return &ADXIndicator{Calc: ADXCalculator{Period: period}}
+}
+
+func (a *ADXIndicator) Update(c models.CandleStick) (float64, bool) {
+	if a.Calc.Count == 0 {
+		a.warmup = append(a.warmup, c)
+		if len(a.warmup) <= a.Calc.Period {
+			return 0, false
+		}
+		if err := a.Calc.Seed(a.warmup); err != nil {
+			return 0, false
+		}
+		a.warmup = nil
+		return a.Calc.PrevADX, true
+	}
+	return a.Calc.Update(c)
+}
+
+// Wrapper so the SMA (which takes a price) can be used as an indicator on the closes
+type SMAIndicator struct {
+	Calc SMACalculator
+}
+
+func NewSMAIndicator(period int) *SMAIndicator {
+	return &SMAIndicator{Calc: SMACalculator{Period: period}}
+}
+
+func (s *SMAIndicator) Update(c models.CandleStick) (float64, bool) {
+	return s.Calc.Update(c.Close)
+}
+
+// Each higher timeframe keeps the candle it is currently building, its last closed candle and its indicators
+type timeframe struct {
+	interval   string
+	durMs      int64
+	current    *models.CandleStick
+	bucket     int64 // Start of the current candle's period in ms
+	last       models.CandleStick
+	hasLast    bool
+	started    bool // Seen a candle yet, only the first one can be partial
+	partial    bool // The current candle is missing the start of its period
+	indicators map[string]Indicator
+	values     map[string]float64 // Values as of the last closed candle
+}
+
+type MultiTimeframe struct {
+	BaseInterval string
+	baseMs       int64
+	frames       map[string]*timeframe
+	order        []string // Keep the order the timeframes were added so updates are deterministic
+}
+
+func NewMultiTimeframe(baseInterval string) (*MultiTimeframe, error) {
+	base, err := IntervalDuration(baseInterval)
+	if err != nil {
+		return nil, err
+	}
+	return &MultiTimeframe{
+		BaseInterval: baseInterval,
+		baseMs:       base.Milliseconds(),
+		frames:       make(map[string]*timeframe),
+	}, nil
+}
+
+// Adds an indicator on a timeframe, the name is what it is looked up by (e.g. AddIndicator("15m", "ADX14", NewADXIndicator(14)))
+func (m *MultiTimeframe) AddIndicator(interval, name string, ind Indicator) error {
+	tf, ok := m.frames[interval]
+	if !ok {
+		dur, err := IntervalDuration(interval)
+		if err != nil {
+			return err
+		}
+		durMs := dur.Milliseconds()
+		if durMs < m.baseMs || durMs%m.baseMs != 0 {
+			return fmt.Errorf("timeframe %s is not a multiple of the base interval %s", interval, m.BaseInterval)
+		}
+		tf = &timeframe{
+			interval:   interval,
+			durMs:      durMs,
+			indicators: make(map[string]Indicator),
+			values:     make(map[string]float64),
+		}
+		m.frames[interval] = tf
+		m.order = append(m.order, interval)
+	}
+	tf.indicators[name] = ind
+	return nil
+}
+
+// Update should be called with each final base candle, in order
+// It returns the intervals which had a candle close on this update
+func (m *MultiTimeframe) Update(c models.CandleStick) []string {
+	var closed []string
+	openMs := OpenTimeMs(c.OpenTime)
+
+	for _, interval := range m.order {
+		tf := m.frames[interval]
+		bucket := openMs - (openMs % tf.durMs)
+
+		// If this candle belongs to a new period then the previous one has closed (covers any gaps in the data)
+		if tf.current != nil && bucket != tf.bucket && tf.close() {
+			closed = append(closed, interval)
+		}
+
+		if tf.current == nil {
+			candle := c
+			candle.IsFinal = false
+			tf.current = &candle
+			tf.bucket = bucket
+			tf.partial = !tf.started && openMs != bucket
+			tf.started = true
+		} else {
+			tf.current.Update(c.Close, c.Volume, c.NumOfTrades)
+			tf.current.High = max(tf.current.High, c.High) // Update only knows about the close, so need to carry over the base candle's range
+			tf.current.Low = min(tf.current.Low, c.Low)
+			tf.current.CloseTime = c.CloseTime
+		}
+
+		// If this was the last base candle of the period, can close it now rather than waiting for the next one
+		if openMs+m.baseMs >= tf.bucket+tf.durMs && tf.close() {
+			closed = append(closed, interval)
+		}
+	}
+	return closed
+}
+
+// Returns false if the candle was partial and so was dropped rather than closed
+func (tf *timeframe) close() bool {
+	if tf.partial {
+		tf.current, tf.partial = nil, false
+		return false
+	}
+	candle := *tf.current
+	candle.IsFinal = true
+	for name, ind := range tf.indicators {
+		if val, ok := ind.Update(candle); ok {
+			tf.values[name] = val
+		}
+	}
+	tf.last = candle
+	tf.hasLast = true
+	tf.current = nil
+	return true
+}
+
+// Gives the indicator value on the timeframe as of the most recent base candle, false if it is not available yet
+func (m *MultiTimeframe) Lookup(interval, name string) (float64, bool) {
+	tf, ok := m.frames[interval]
+	if !ok {
+		return 0, false
+	}
+	val, ok := tf.values[name]
+	return val, ok
+}
+
+// Gives the last closed candle on the timeframe
+func (m *MultiTimeframe) LastCandle(interval string) (models.CandleStick, bool) {
+	tf, ok := m.frames[interval]
+	if !ok || !tf.hasLast {
+		return models.CandleStick{}, false
+	}
+	return tf.last, true
+}
@@ -0,0 +1,256 @@
+package bot
+
+import (
+	"math"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// Candlestick pattern recognition, the idea is to use reversal/continuation patterns to help confirm trendline breakouts
+// These are purely based on the shape of the candles, there is no check on the preceding trend (that is left to the trendlines and ADX)
+// Note that gaps are not required for the star patterns since in crypto the open is practically always the previous close
+
+type Pattern int
+
+const (
+	BullishEngulfing Pattern = iota
+	BearishEngulfing
+	Hammer
+	ShootingStar
+	Doji
+	DragonflyDoji
+	GravestoneDoji
+	LongLeggedDoji
+	MorningStar
+	EveningStar
+	ThreeWhiteSoldiers
+	ThreeBlackCrows
+	InsideBar
+	OutsideBar
+	NumPatterns // Keep this last, it is the number of patterns (used for the training export columns)
+)
+
+// Names are used for logging and as the column names in the training export (with a "pat_" prefix)
+var patternNames = [NumPatterns]string{
+	"bullish_engulfing",
+	"bearish_engulfing",
+	"hammer",
+	"shooting_star",
+	"doji",
+	"dragonfly_doji",
+	"gravestone_doji",
+	"long_legged_doji",
+	"morning_star",
+	"evening_star",
+	"three_white_soldiers",
+	"three_black_crows",
+	"inside_bar",
+	"outside_bar",
+}
+
+func (p Pattern) String() string {
+	if p < 0 || p >= NumPatterns {
+		return "unknown"
+	}
+	return patternNames[p]
+}
+
+// Direction the pattern points to, 1 for bullish, -1 for bearish and 0 for neutral/indecision
+func (p Pattern) Direction() int {
+	switch p {
+	case BullishEngulfing, Hammer, DragonflyDoji, MorningStar, ThreeWhiteSoldiers:
+		return 1
+	case BearishEngulfing, ShootingStar, GravestoneDoji, EveningStar, ThreeBlackCrows:
+		return -1
+	}
+	return 0
+}
+
+// All tolerances are fractions of the candle's range (high - low) unless stated otherwise
+type PatternConfig struct {
+	DojiBody          float64 // Max body for a doji
+	LongBody          float64 // Min body for a "long" candle (first candle of the stars, the soldiers/crows)
+	ShadowMultiple    float64 // For hammer/shooting star the long shadow has to be at least this many times the body
+	MaxOppositeShadow float64 // Max size of the other shadow for hammer/shooting star and the dragonfly/gravestone doji
+	LongLegShadow     float64 // Min size of both shadows for a long legged doji
+	StarBody          float64 // Max body of the middle star candle, as a fraction of the first candle's body
+	SoldierShadow     float64 // Max shadow beyond the close for the soldiers/crows
+	EngulfTolerance   float64 // How far (as a fraction of the previous body) the engulfing body can fall short on each side
+}
+
+func DefaultPatternConfig() PatternConfig {
+	return PatternConfig{
+		DojiBody:          0.1,
+		LongBody:          0.6,
+		ShadowMultiple:    2.0,
+		MaxOppositeShadow: 0.1,
+		LongLegShadow:     0.3,
+		StarBody:          0.3,
+		SoldierShadow:     0.3,
+		EngulfTolerance:   0.0,
+	}
+}
+
+type PatternEvent struct {
+	Pattern   Pattern
+	Direction int
+	OpenTime  int64 // OpenTime of the last candle in the pattern (the candle it was confirmed on)
+	Candles   int   // Number of candles that make up the pattern
+}
+
+// Helper functions for the anatomy of a candle
+func body(c models.CandleStick) float64        { return math.Abs(c.Close - c.Open) }
+func candleRange(c models.CandleStick) float64 { return c.High - c.Low }
+func upperShadow(c models.CandleStick) float64 { return c.High - math.Max(c.Open, c.Close) }
+func lowerShadow(c models.CandleStick) float64 { return math.Min(c.Open, c.Close) - c.Low }
+func isBull(c models.CandleStick) bool         { return c.Close > c.Open }
+func isBear(c models.CandleStick) bool         { return c.Close < c.Open }
+
+// Checks the candles ending at index i for every pattern and returns the ones that match
+func DetectPatterns(candles []models.CandleStick, i int, cfg PatternConfig) []PatternEvent {
+	if i < 0 || i >= len(candles) {
+		return nil
+	}
+	var events []PatternEvent
+	emit := func(p Pattern, n int) {
+		events = append(events, PatternEvent{Pattern: p, Direction: p.Direction(), OpenTime: candles[i].OpenTime, Candles: n})
+	}
+
+	// Single candle patterns
+	c := candles[i]
+	rng := candleRange(c)
+	if rng > 0 {
+		b := body(c)
+		up := upperShadow(c)
+		low := lowerShadow(c)
+
+		if b <= cfg.DojiBody*rng {
+			emit(Doji, 1)
+			switch {
+			case up <= cfg.MaxOppositeShadow*rng:
+				emit(DragonflyDoji, 1)
+			case low <= cfg.MaxOppositeShadow*rng:
+				emit(GravestoneDoji, 1)
+			case up >= cfg.LongLegShadow*rng && low >= cfg.LongLegShadow*rng:
+				emit(LongLeggedDoji, 1)
+			}
+		} else {
+			if low >= cfg.ShadowMultiple*b && up <= cfg.MaxOppositeShadow*rng {
+				emit(Hammer, 1)
+			}
+			if up >= cfg.ShadowMultiple*b && low <= cfg.MaxOppositeShadow*rng {
+				emit(ShootingStar, 1)
+			}
+		}
+	}
+
+	// Two candle patterns
+	if i >= 1 {
+		prev := candles[i-1]
+		tol := cfg.EngulfTolerance * body(prev)
+
+		if isBear(prev) && isBull(c) && c.Open <= prev.Close+tol && c.Close >= prev.Open-tol && body(c) > body(prev) {
+			emit(BullishEngulfing, 2)
+		}
+		if isBull(prev) && isBear(c) && c.Open >= prev.Close-tol && c.Close <= prev.Open+tol && body(c) > body(prev) {
+			emit(BearishEngulfing, 2)
+		}
+		if c.High < prev.High && c.Low > prev.Low {
+			emit(InsideBar, 2)
+		}
+		if c.High > prev.High && c.Low < prev.Low {
+			emit(OutsideBar, 2)
+		}
+	}
+
+	// Three candle patterns
+	if i >= 2 {
+		first, mid := candles[i-2], candles[i-1]
+		firstLong := candleRange(first) > 0 && body(first) >= cfg.LongBody*candleRange(first)
+		smallMid := body(mid) <= cfg.StarBody*body(first)
+		firstMidpoint := (first.Open + first.Close) / 2
+
+		if firstLong && smallMid && isBear(first) && isBull(c) && c.Close > firstMidpoint {
+			emit(MorningStar, 3)
+		}
+		if firstLong && smallMid && isBull(first) && isBear(c) && c.Close < firstMidpoint {
+			emit(EveningStar, 3)
+		}
+
+		three := candles[i-2 : i+1]
+		if soldiers(three, cfg, true) {
+			emit(ThreeWhiteSoldiers, 3)
+		}
+		if soldiers(three, cfg, false) {
+			emit(ThreeBlackCrows, 3)
+		}
+	}
+	return events
+}
+
+// Three long candles in the same direction, each closing further on and opening within the previous body
+func soldiers(three []models.CandleStick, cfg PatternConfig, bullish bool) bool {
+	for k, c := range three {
+		rng := candleRange(c)
+		if rng == 0 || body(c) < cfg.LongBody*rng {
+			return false
+		}
+		if bullish && (!isBull(c) || upperShadow(c) > cfg.SoldierShadow*rng) {
+			return false
+		}
+		if !bullish && (!isBear(c) || lowerShadow(c) > cfg.SoldierShadow*rng) {
+			return false
+		}
+		if k == 0 {
+			continue
+		}
+		prev := three[k-1]
+		lo, hi := math.Min(prev.Open, prev.Close), math.Max(prev.Open, prev.Close)
+		if c.Open < lo || c.Open > hi {
+			return false
+		}
+		if bullish && c.Close <= prev.Close {
+			return false
+		}
+		if !bullish && c.Close >= prev.Close {
+			return false
+		}
+	}
+	return true
+}
+
+// Streaming version for the live channel, only needs to keep the last three candles
+type PatternDetector struct {
+	Config  PatternConfig
+	candles []models.CandleStick
+}
+
+func NewPatternDetector(cfg PatternConfig) *PatternDetector {
+	return &PatternDetector{Config: cfg}
+}
+
+func (d *PatternDetector) Update(c models.CandleStick) []PatternEvent {
+	d.candles = append(d.candles, c)
+	if len(d.candles) > 3 {
+		d.candles = d.candles[len(d.candles)-3:]
+	}
+	return DetectPatterns(d.candles, len(d.candles)-1, d.Config)
+}
+
+// For the training export, gives one flag per pattern (in the order of the Pattern constants) for the candle at index i
+func PatternFlags(candles []models.CandleStick, i int, cfg PatternConfig) []int {
+	flags := make([]int, NumPatterns)
+	for _, e := range DetectPatterns(candles, i, cfg) {
+		flags[e.Pattern] = 1
+	}
+	return flags
+}
+
+// Column names for the training export, same order as PatternFlags
+func PatternColumns() []string {
+	cols := make([]string, NumPatterns)
+	for i, name := range patternNames {
+		cols[i] = "pat_" + name
+	}
+	return cols
+}
@@ -0,0 +1,465 @@
+package bot
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// Rather than two fixed ADX thresholds deciding if a trend exists, this labels each candle with a market regime
+// It uses the ADX with +DI/-DI for trend strength and direction, the ATR percentile for volatility and the slope of a moving average
+// Strategies can then decide which regimes they are allowed to enter trades in
+
+type Regime int
+
+const (
+	RegimeUnknown   Regime = iota // Not enough candles yet to classify
+	RegimeTrendUp                 // Strong trend with +DI above -DI and a rising moving average
+	RegimeTrendDown               // Strong trend with -DI above +DI and a falling moving average
+	RegimeRanging                 // No strong trend
+	RegimeHighVol                 // Volatility is in the top percentiles, takes priority over the trend labels
+)
+
+func (r Regime) String() string {
+	switch r {
+	case RegimeTrendUp:
+		return "trending-up"
+	case RegimeTrendDown:
+		return "trending-down"
+	case RegimeRanging:
+		return "ranging"
+	case RegimeHighVol:
+		return "high-volatility"
+	}
+	return "unknown"
+}
+
+func ParseRegime(s string) (Regime, error) {
+	for r := RegimeUnknown; r <= RegimeHighVol; r++ {
+		if r.String() == strings.TrimSpace(strings.ToLower(s)) {
+			return r, nil
+		}
+	}
+	return RegimeUnknown, fmt.Errorf("unknown regime: %q", s)
+}
+
+type RegimeConfig struct {
+	ADXPeriod        int
+	ADXTrend         float64 // ADX needed to count as trending (this is what ADX_THRESHOLD was doing)
+	ATRPeriod        int
+	PercentileWindow int     // Number of past ATR values the current ATR is ranked against
+	HighVolPct       float64 // ATR percentile (0-100) above which the market is labelled high volatility
+	MAPeriod         int
+	SlopeLookback    int     // Number of candles the moving average slope is measured over
+	MinSlope         float64 // Minimum fractional change of the moving average per candle for a trend (e.g. 0.0001 = 0.01% per candle)
+	HMM              *GaussianHMM
+}
+
+func DefaultRegimeConfig() RegimeConfig {
+	return RegimeConfig{
+		ADXPeriod:        14,
+		ADXTrend:         25,
+		ATRPeriod:        14,
+		PercentileWindow: 500,
+		HighVolPct:       90,
+		MAPeriod:         50,
+		SlopeLookback:    10,
+		MinSlope:         0.00005,
+	}
+}
+
+// This is everything that went into the classification so that it can be logged alongside the regime
+type RegimeState struct {
+	Regime        Regime
+	ADX           float64
+	PlusDI        float64
+	MinusDI       float64
+	ATR           float64
+	ATRPercentile float64
+	Slope         float64
+	HMMState      int
+}
+
+// Streaming regime detector, can be fed each final candle from the live channel or looped over historical data
+type RegimeDetector struct {
+	Config    RegimeConfig
+	adx       *ADXIndicator
+	atr       ATRCalculator
+	sma       SMACalculator
+	maHist    []float64
+	atrHist   []float64
+	prevClose float64
+	hmmFilter []float64 // Forward probabilities of each HMM state
+}
+
+func NewRegimeDetector(cfg RegimeConfig) *RegimeDetector {
+	return &RegimeDetector{
+		Config: cfg,
+		adx:    NewADXIndicator(cfg.ADXPeriod),
+		atr:    ATRCalculator{Period: cfg.ATRPeriod},
+		sma:    SMACalculator{Period: cfg.MAPeriod},
+	}
+}
+
+func (d *RegimeDetector) Update(c models.CandleStick) (RegimeState, bool) {
+	state := RegimeState{Regime: RegimeUnknown, HMMState: -1}
+
+	// The HMM works off log returns so will track that first
+	if d.Config.HMM != nil && d.prevClose > 0 {
+		state.HMMState = d.Config.HMM.filterStep(&d.hmmFilter, math.Log(c.Close/d.prevClose))
+	}
+	d.prevClose = c.Close
+
+	// ATR and its percentile over the recent history
+	atr, atrOK := d.atr.Update(c)
+	if atrOK {
+		norm := atr / c.Close // Normalise by price so that the percentile is not skewed by the price level
+		d.atrHist = append(d.atrHist, norm)
+		if len(d.atrHist) > d.Config.PercentileWindow {
+			d.atrHist = d.atrHist[1:]
+		}
+		state.ATR = atr
+		state.ATRPercentile = percentileRank(d.atrHist, norm)
+	}
+
+	// Slope of the moving average, as a fraction of price per candle
+	ma, maOK := d.sma.Update(c.Close)
+	if maOK {
+		d.maHist = append(d.maHist, ma)
+		if len(d.maHist) > d.Config.SlopeLookback+1 {
+			d.maHist = d.maHist[1:]
+		}
+		if len(d.maHist) == d.Config.SlopeLookback+1 && d.maHist[0] != 0 {
+			state.Slope = (ma - d.maHist[0]) / d.maHist[0] / float64(d.Config.SlopeLookback)
+		}
+	}
+
+	// The ADX indicator seeds itself with the first candles rather than fetching from Binance
+	var adxOK bool
+	state.ADX, adxOK = d.adx.Update(c)
+	state.PlusDI, state.MinusDI = d.adx.Calc.DI()
+
+	if !adxOK || !atrOK || len(d.maHist) < d.Config.SlopeLookback+1 {
+		return state, false
+	}
+	state.Regime = d.classify(state)
+	return state, true
+}
+
+func (d *RegimeDetector) classify(s RegimeState) Regime {
+	// When a HMM has been fitted it decides the high volatility regime instead of the ATR percentile
+	if d.Config.HMM != nil {
+		if s.HMMState >= 0 && s.HMMState == d.Config.HMM.HighVolState() {
+			return RegimeHighVol
+		}
+	} else if s.ATRPercentile >= d.Config.HighVolPct {
+		return RegimeHighVol
+	}
+
+	if s.ADX >= d.Config.ADXTrend {
+		if s.PlusDI > s.MinusDI && s.Slope >= d.Config.MinSlope {
+			return RegimeTrendUp
+		}
+		if s.MinusDI > s.PlusDI && s.Slope <= -d.Config.MinSlope {
+			return RegimeTrendDown
+		}
+	}
+	return RegimeRanging
+}
+
+// Gives the percentage of values in the history that are at or below the current value
+func percentileRank(hist []float64, val float64) float64 {
+	if len(hist) == 0 {
+		return 0
+	}
+	count := 0
+	for _, h := range hist {
+		if h <= val {
+			count++
+		}
+	}
+	return 100 * float64(count) / float64(len(hist))
+}
+
+// Batch version, runs the detector over historical candles so that there is no look-ahead and it matches the live output exactly
+func ClassifyRegimes(candles []models.CandleStick, cfg RegimeConfig) []RegimeState {
+	d := NewRegimeDetector(cfg)
+	states := make([]RegimeState, len(candles))
+	for i, c := range candles {
+		states[i], _ = d.Update(c)
+	}
+	return states
+}
+
+// The gate lets strategies choose which regimes they can enter in, a nil gate allows everything
+type RegimeGate map[Regime]bool
+
+func (g RegimeGate) Allows(r Regime) bool {
+	if g == nil {
+		return true
+	}
+	return g[r]
+}
+
+// Parses a comma separated list, for example "trending-up,trending-down" (this is how it will be set in the .env)
+func ParseRegimeGate(s string) (RegimeGate, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	gate := RegimeGate{}
+	for _, part := range strings.Split(s, ",") {
+		r, err := ParseRegime(part)
+		if err != nil {
+			return nil, err
+		}
+		gate[r] = true
+	}
+	return gate, nil
+}
+
+// Optionally the high volatility regime can come from a hidden Markov model fitted on the historical log returns
+// This is a 1D Gaussian HMM fitted with Baum-Welch, each state has its own mean and variance of returns
+type GaussianHMM struct {
+	Pi    []float64   // Initial state probabilities
+	Trans [][]float64 // Transition matrix, Trans[i][j] = P(state j | state i)
+	Mean  []float64
+	Var   []float64
+}
+
+const minHMMVar = 1e-12 // Floor on the variance so a state can not collapse onto one point
+
+func gaussianPDF(x, mean, variance float64) float64 {
+	d := x - mean
+	p := math.Exp(-d*d/(2*variance)) / math.Sqrt(2*math.Pi*variance)
+	return math.Max(p, 1e-300) // Guard against underflow, otherwise the scaling would divide by 0
+}
+
+// Fits the HMM with the given number of states on the observations (log returns)
+func FitHMM(obs []float64, states int, iterations int) (*GaussianHMM, error) {
+	T := len(obs)
+	if states < 2 || T < states*10 {
+		return nil, fmt.Errorf("not enough observations to fit %d states: got %d", states, T)
+	}
+
+	// Initialise the means from quantiles of the data, and give each state the overall variance
+	sorted := append([]float64(nil), obs...)
+	sort.Float64s(sorted)
+	overallVar := math.Max(sampleVariance(obs), minHMMVar)
+
+	h := &GaussianHMM{
+		Pi:    make([]float64, states),
+		Trans: make([][]float64, states),
+		Mean:  make([]float64, states),
+		Var:   make([]float64, states),
+	}
+	for i := 0; i < states; i++ {
+		h.Pi[i] = 1 / float64(states)
+		h.Trans[i] = make([]float64, states)
+		for j := range h.Trans[i] {
+			if i == j {
+				h.Trans[i][j] = 0.9 // Regimes are sticky so start with a high probability of staying in the same state
+			} else {
+				h.Trans[i][j] = 0.1 / float64(states-1)
+			}
+		}
+		h.Mean[i] = sorted[(2*i+1)*T/(2*states)]
+		h.Var[i] = overallVar * float64(i+1) / float64(states) // Spread the variances out so the states can separate
+	}
+
+	alpha := make([][]float64, T)
+	beta := make([][]float64, T)
+	emit := make([][]float64, T)
+	scale := make([]float64, T)
+	for t := 0; t < T; t++ {
+		alpha[t] = make([]float64, states)
+		beta[t] = make([]float64, states)
+		emit[t] = make([]float64, states)
+	}
+
+	for iter := 0; iter < iterations; iter++ {
+		for t := 0; t < T; t++ {
+			for i := 0; i < states; i++ {
+				emit[t][i] = gaussianPDF(obs[t], h.Mean[i], h.Var[i])
+			}
+		}
+
+		// Forward pass (scaled so that it does not underflow)
+		for t := 0; t < T; t++ {
+			scale[t] = 0
+			for j := 0; j < states; j++ {
+				if t == 0 {
+					alpha[t][j] = h.Pi[j] * emit[t][j]
+				} else {
+					var s float64
+					for i := 0; i < states; i++ {
+						s += alpha[t-1][i] * h.Trans[i][j]
+					}
+					alpha[t][j] = s * emit[t][j]
+				}
+				scale[t] += alpha[t][j]
+			}
+			for j := 0; j < states; j++ {
+				alpha[t][j] /= scale[t]
+			}
+		}
+
+		// Backward pass
+		for i := 0; i < states; i++ {
+			beta[T-1][i] = 1
+		}
+		for t := T - 2; t >= 0; t-- {
+			for i := 0; i < states; i++ {
+				var s float64
+				for j := 0; j < states; j++ {
+					s += h.Trans[i][j] * emit[t+1][j] * beta[t+1][j]
+				}
+				beta[t][i] = s / scale[t+1]
+			}
+		}
+
+		// Now re-estimate the parameters
+		gammaSum := make([]float64, states)   // Sum over all t
+		gammaSumT1 := make([]float64, states) // Sum over t < T-1, for the transitions
+		meanNum := make([]float64, states)
+		xiSum := make([][]float64, states)
+		for i := range xiSum {
+			xiSum[i] = make([]float64, states)
+		}
+
+		for t := 0; t < T; t++ {
+			var norm float64
+			for i := 0; i < states; i++ {
+				norm += alpha[t][i] * beta[t][i]
+			}
+			for i := 0; i < states; i++ {
+				g := alpha[t][i] * beta[t][i] / norm
+				if t == 0 {
+					h.Pi[i] = g
+				}
+				gammaSum[i] += g
+				meanNum[i] += g * obs[t]
+				if t < T-1 {
+					gammaSumT1[i] += g
+					for j := 0; j < states; j++ {
+						xiSum[i][j] += alpha[t][i] * h.Trans[i][j] * emit[t+1][j] * beta[t+1][j] / scale[t+1]
+					}
+				}
+			}
+		}
+
+		for i := 0; i < states; i++ {
+			for j := 0; j < states; j++ {
+				h.Trans[i][j] = xiSum[i][j] / gammaSumT1[i]
+			}
+			h.Mean[i] = meanNum[i] / gammaSum[i]
+		}
+		for i := 0; i < states; i++ {
+			var varNum float64
+			for t := 0; t < T; t++ {
+				var norm float64
+				for k := 0; k < states; k++ {
+					norm += alpha[t][k] * beta[t][k]
+				}
+				d := obs[t] - h.Mean[i]
+				varNum += alpha[t][i] * beta[t][i] / norm * d * d
+			}
+			h.Var[i] = math.Max(varNum/gammaSum[i], minHMMVar)
+		}
+	}
+	return h, nil
+}
+
+// The state with the largest variance of returns is the high volatility regime
+func (h *GaussianHMM) HighVolState() int {
+	best := 0
+	for i := range h.Var {
+		if h.Var[i] > h.Var[best] {
+			best = i
+		}
+	}
+	return best
+}
+
+// Viterbi decoding gives the most likely state sequence over the whole history (this does look ahead so is for analysis only)
+func (h *GaussianHMM) Viterbi(obs []float64) []int {
+	T := len(obs)
+	states := len(h.Pi)
+	if T == 0 {
+		return nil
+	}
+	delta := make([][]float64, T)
+	back := make([][]int, T)
+	for t := range delta {
+		delta[t] = make([]float64, states)
+		back[t] = make([]int, states)
+	}
+	for i := 0; i < states; i++ {
+		delta[0][i] = math.Log(h.Pi[i]) + math.Log(gaussianPDF(obs[0], h.Mean[i], h.Var[i]))
+	}
+	for t := 1; t < T; t++ {
+		for j := 0; j < states; j++ {
+			best, bestIdx := math.Inf(-1), 0
+			for i := 0; i < states; i++ {
+				if v := delta[t-1][i] + math.Log(h.Trans[i][j]); v > best {
+					best, bestIdx = v, i
+				}
+			}
+			delta[t][j] = best + math.Log(gaussianPDF(obs[t], h.Mean[j], h.Var[j]))
+			back[t][j] = bestIdx
+		}
+	}
+
+	path := make([]int, T)
+	for i := 1; i < states; i++ {
+		if delta[T-1][i] > delta[T-1][path[T-1]] {
+			path[T-1] = i
+		}
+	}
+	for t := T - 1; t > 0; t-- {
+		path[t-1] = back[t][path[t]]
+	}
+	return path
+}
+
+// For the live stream only the forward (filtered) probabilities can be used, this does one step and returns the most likely state
+func (h *GaussianHMM) filterStep(probs *[]float64, x float64) int {
+	states := len(h.Pi)
+	prev := *probs
+	next := make([]float64, states)
+	var total float64
+	for j := 0; j < states; j++ {
+		if prev == nil {
+			next[j] = h.Pi[j]
+		} else {
+			for i := 0; i < states; i++ {
+				next[j] += prev[i] * h.Trans[i][j]
+			}
+		}
+		next[j] *= gaussianPDF(x, h.Mean[j], h.Var[j])
+		total += next[j]
+	}
+	best := 0
+	for j := range next {
+		next[j] /= total
+		if next[j] > next[best] {
+			best = j
+		}
+	}
+	*probs = next
+	return best
+}
+
+// Helper to get the log returns of the closes, which is what the HMM is fitted on
+func LogReturns(candles []models.CandleStick) []float64 {
+	if len(candles) < 2 {
+		return nil
+	}
+	returns := make([]float64, len(candles)-1)
+	for i := 1; i < len(candles); i++ {
+		returns[i-1] = math.Log(candles[i].Close / candles[i-1].Close)
+	}
+	return returns
+}
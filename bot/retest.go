@@ -0,0 +1,233 @@
+package bot
+
+import (
+	"math"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// Break and retest detection
+// Rather than entering straight on the breakout, the idea is to wait for price to come back to the level it broke and be rejected from it
+// It works as a small state machine for each broken trendline or level
+// First CheckTrendlines2 (or a LevelTracker break) reports a breakout and the level is handed to the RetestDetector
+// The detector then waits for price to return to within the tolerance of the level (the retest)
+// Once it has, a candle closing back on the breakout side confirms the retest (optionally also needing above average volume or a candlestick pattern)
+// If price closes through the level the wrong way, or nothing happens within MaxBars, the breakout is dropped
+
+type RetestState int
+
+const (
+	RetestAwaitReturn    RetestState = iota // Broken, waiting for price to come back to the level
+	RetestAwaitRejection                    // Price has come back, waiting for a close back on the breakout side
+)
+
+func (s RetestState) String() string {
+	if s == RetestAwaitRejection {
+		return "awaiting-rejection"
+	}
+	return "awaiting-return"
+}
+
+// The level that was broken, either a trendline (so its price depends on x) or a horizontal zone
+type BrokenLevel struct {
+	Line   models.Trendline
+	Sloped bool    // True if Line is used, otherwise the level is the zone between Low and High
+	Low    float64 // Bottom and top of the zone for horizontal levels
+	High   float64
+}
+
+func LineLevel(line models.Trendline) BrokenLevel {
+	return BrokenLevel{Line: line, Sloped: true}
+}
+
+func ZoneLevel(z Zone) BrokenLevel {
+	return BrokenLevel{Low: z.Low, High: z.High}
+}
+
+// Gives the bottom and top of the level at x (these are the same for a trendline)
+func (l BrokenLevel) At(x float64) (lo, hi float64) {
+	if l.Sloped {
+		y := LineAt(l.Line, x)
+		return y, y
+	}
+	return l.Low, l.High
+}
+
+type RetestConfig struct {
+	ToleranceMode  ToleranceMode
+	Tolerance      float64 // How close price has to come back to the level to count as a retest, fraction of price or ATR multiple
+	MaxBars        int     // Number of bars after the breakout that the retest has to be confirmed within
+	RequireVolume  bool    // The rejection candle needs volume of at least VolumeMultiple times the average over VolumePeriod
+	VolumeMultiple float64
+	VolumePeriod   int
+	RequirePattern bool // The rejection candle has to complete a candlestick pattern pointing in the breakout direction
+	Patterns       PatternConfig
+	ATRPeriod      int // For the ATR tolerance
+}
+
+func DefaultRetestConfig() RetestConfig {
+	return RetestConfig{
+		ToleranceMode:  ToleranceATR,
+		Tolerance:      0.5,
+		MaxBars:        20,
+		VolumeMultiple: 1.2,
+		VolumePeriod:   20,
+		Patterns:       DefaultPatternConfig(),
+		ATRPeriod:      14,
+	}
+}
+
+type RetestSignal struct {
+	Direction    int // 1 for a long (broke up and held the level as support), -1 for a short
+	Level        BrokenLevel
+	BreakoutTime int64 // OpenTime of the breakout candle
+	RetestTime   int64 // OpenTime of the candle that confirmed the retest
+	BarsToRetest int
+	Entry        float64 // Close of the confirming candle
+	Invalidation float64 // Lowest low (long) or highest high (short) since price came back to the level, a close through this means the setup has failed
+}
+
+type pendingRetest struct {
+	level        BrokenLevel
+	direction    int
+	state        RetestState
+	breakoutTime int64
+	bars         int
+	extreme      float64 // Lowest low (long) or highest high (short) since the return
+}
+
+type RetestDetector struct {
+	Config  RetestConfig
+	pending []pendingRetest
+	atr     ATRCalculator
+	volumes []float64 // Last VolumePeriod volumes (not including the current candle)
+	recent  []models.CandleStick
+}
+
+func NewRetestDetector(cfg RetestConfig) *RetestDetector {
+	return &RetestDetector{Config: cfg, atr: ATRCalculator{Period: cfg.ATRPeriod}}
+}
+
+// Breakout starts tracking a level that was just broken, direction is 1 for an upside break and -1 for a downside break
+// This should be called after Update for the breakout candle, so the breakout candle itself cannot count as the retest
+// An intrabar breakout comes before that candle's Update though, so Update also skips the breakouts from the candle it is given
+func (d *RetestDetector) Breakout(level BrokenLevel, direction int, c models.CandleStick) {
+	d.pending = append(d.pending, pendingRetest{
+		level:        level,
+		direction:    direction,
+		breakoutTime: c.OpenTime,
+	})
+}
+
+// Number of breakouts currently being tracked
+func (d *RetestDetector) Pending() int {
+	return len(d.pending)
+}
+
+// Drops every tracked breakout, for example after entering a position
+func (d *RetestDetector) Reset() {
+	d.pending = d.pending[:0]
+}
+
+// Update moves each tracked breakout along with the new candle, x is the candle's x value on the axis the trendlines were drawn on
+// Returns the confirmed retests (normally at most one)
+func (d *RetestDetector) Update(c models.CandleStick, x float64) []RetestSignal {
+	atr, atrOK := d.atr.Update(c)
+	tolerance := func(price float64) float64 {
+		if d.Config.ToleranceMode == ToleranceATR {
+			if !atrOK {
+				return 0
+			}
+			return d.Config.Tolerance * atr
+		}
+		return d.Config.Tolerance * price
+	}
+
+	d.recent = append(d.recent, c)
+	if len(d.recent) > 3 {
+		d.recent = d.recent[len(d.recent)-3:]
+	}
+	volumeOK := true
+	if d.Config.RequireVolume {
+		volumeOK = false
+		if len(d.volumes) >= d.Config.VolumePeriod && d.Config.VolumePeriod > 0 {
+			volumeOK = c.Volume >= d.Config.VolumeMultiple*sum(d.volumes)/float64(len(d.volumes))
+		}
+	}
+	var patternDirs []int
+	if d.Config.RequirePattern {
+		for _, e := range DetectPatterns(d.recent, len(d.recent)-1, d.Config.Patterns) {
+			patternDirs = append(patternDirs, e.Direction)
+		}
+	}
+
+	var signals []RetestSignal
+	kept := d.pending[:0]
+	for _, p := range d.pending {
+		if p.breakoutTime == c.OpenTime {
+			kept = append(kept, p) // Broke out intrabar on this same candle, the retest can only start on the next one
+			continue
+		}
+		p.bars++
+		lo, hi := p.level.At(x)
+		tol := tolerance((lo + hi) / 2)
+
+		// Closing back through the level the wrong way means the breakout has failed
+		failed := (p.direction == 1 && c.Close < lo-tol) || (p.direction == -1 && c.Close > hi+tol)
+		if failed {
+			continue
+		}
+
+		// Has price come back to the level
+		if p.state == RetestAwaitReturn {
+			if (p.direction == 1 && c.Low <= hi+tol) || (p.direction == -1 && c.High >= lo-tol) {
+				p.state = RetestAwaitRejection
+				p.extreme = c.Low
+				if p.direction == -1 {
+					p.extreme = c.High
+				}
+			}
+		} else if p.direction == 1 {
+			p.extreme = math.Min(p.extreme, c.Low)
+		} else {
+			p.extreme = math.Max(p.extreme, c.High)
+		}
+
+		// Rejection is a close back on the breakout side of the level (the returning candle can also be the rejection candle)
+		if p.state == RetestAwaitRejection {
+			rejected := (p.direction == 1 && c.Close > hi) || (p.direction == -1 && c.Close < lo)
+			patternOK := !d.Config.RequirePattern
+			for _, dir := range patternDirs {
+				if dir == p.direction {
+					patternOK = true
+				}
+			}
+			if rejected && volumeOK && patternOK {
+				signals = append(signals, RetestSignal{
+					Direction:    p.direction,
+					Level:        p.level,
+					BreakoutTime: p.breakoutTime,
+					RetestTime:   c.OpenTime,
+					BarsToRetest: p.bars,
+					Entry:        c.Close,
+					Invalidation: p.extreme,
+				})
+				continue
+			}
+		}
+
+		if d.Config.MaxBars > 0 && p.bars >= d.Config.MaxBars {
+			continue
+		}
+		kept = append(kept, p)
+	}
+	d.pending = kept
+
+	if d.Config.VolumePeriod > 0 {
+		d.volumes = append(d.volumes, c.Volume)
+		if len(d.volumes) > d.Config.VolumePeriod {
+			d.volumes = d.volumes[len(d.volumes)-d.Config.VolumePeriod:]
+		}
+	}
+	return signals
+}
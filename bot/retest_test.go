@@ -0,0 +1,74 @@
+package bot
+
+import (
+	"testing"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// A level at 100 broken upwards, then retested from above
+
+func retestCandle(openTime int64, open, high, low, close float64) models.CandleStick {
+	return models.CandleStick{OpenTime: openTime, Open: open, High: high, Low: low, Close: close, IsFinal: true}
+}
+
+func newTestRetest() *RetestDetector {
+	cfg := DefaultRetestConfig()
+	cfg.ToleranceMode, cfg.Tolerance = TolerancePercent, 0.001
+	cfg.MaxBars = 5
+	return NewRetestDetector(cfg)
+}
+
+func TestRetestConfirm(t *testing.T) {
+	d := newTestRetest()
+	level := ZoneLevel(Zone{Low: 100, High: 100})
+	breakout := retestCandle(60, 99, 101, 99, 100.8)
+	d.Update(breakout, 0)
+	d.Breakout(level, 1, breakout)
+
+	// Pulls away, then comes back to the level and closes above it
+	if got := d.Update(retestCandle(120, 100.8, 102, 100.7, 101.5), 1); len(got) != 0 {
+		t.Fatalf("retest before price came back: %+v", got)
+	}
+	got := d.Update(retestCandle(180, 101.5, 101.6, 100.05, 101), 2)
+	if len(got) != 1 || got[0].Direction != 1 || got[0].BarsToRetest != 2 || got[0].Invalidation != 100.05 {
+		t.Fatalf("retest %+v", got)
+	}
+	if d.Pending() != 0 {
+		t.Fatalf("%d breakouts still pending after the retest", d.Pending())
+	}
+}
+
+func TestRetestFailedAndExpired(t *testing.T) {
+	d := newTestRetest()
+	level := ZoneLevel(Zone{Low: 100, High: 100})
+	d.Breakout(level, 1, retestCandle(60, 99, 101, 99, 100.8))
+	// Closing back under the level drops it
+	if got := d.Update(retestCandle(120, 100.8, 100.9, 99, 99.5), 1); len(got) != 0 || d.Pending() != 0 {
+		t.Fatalf("failed breakout: %+v, %d pending", got, d.Pending())
+	}
+
+	d.Breakout(level, 1, retestCandle(180, 99, 101, 99, 100.8))
+	for i := int64(0); i < 5; i++ {
+		d.Update(retestCandle(240+i*60, 102, 103, 101.5, 102.5), float64(i))
+	}
+	if d.Pending() != 0 {
+		t.Fatalf("breakout still pending after MaxBars")
+	}
+}
+
+// With INTRABAR the breakout is handed over while its candle is still open, the final version of that candle must not confirm it
+func TestRetestIntrabarBreakout(t *testing.T) {
+	d := newTestRetest()
+	level := ZoneLevel(Zone{Low: 100, High: 100})
+	d.Breakout(level, 1, retestCandle(60, 99.9, 100.3, 99.9, 100.3))
+
+	// The breakout candle closes back near the level but above it, which would be a retest from any later candle
+	if got := d.Update(retestCandle(60, 99.9, 100.6, 99.9, 100.4), 0); len(got) != 0 {
+		t.Fatalf("breakout candle confirmed its own retest: %+v", got)
+	}
+	got := d.Update(retestCandle(120, 100.4, 100.5, 100.02, 100.4), 1)
+	if len(got) != 1 || got[0].BarsToRetest != 1 {
+		t.Fatalf("retest on the next candle %+v", got)
+	}
+}
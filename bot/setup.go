@@ -0,0 +1,226 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// PrepTrain, the backtests and the live bot all have to trade exactly the same strategy, otherwise the labels are for a different strategy
+// than the one being traded, so the strategy is put together here from the one set of .env settings:
+//   ADX_THRESHOLD, ADX_MIN, ADX_PERIOD, IDLE_LIMIT, ACTIVE_LIMIT, WINDOW_SIZE   the trader and the window
+//   BREAKOUT_CONFIRM                                                          the breakout filters (see confirm.go)
+//   TRENDLINE_FIT=multitouch                                                  the multi-touch trendlines (see trendfit.go)
+//   RETEST_BARS                                                               enter on a break and retest within that many bars (see retest.go)
+//   REGIME_GATE                                                               the regimes entries are allowed in (see regime.go)
+//   HTF_INTERVAL, HTF_ADX_THRESHOLD                                           the higher timeframe ADX entries need (see mtf.go)
+//   EXITS                                                                     the protective stops and targets (see exits.go)
+// The chart patterns are always found, so the entries that break one carry its target
+
+type StrategySettings struct {
+	Trader       TraderConfig
+	ADXPeriod    int
+	WindowSize   int
+	MultiTouch   bool
+	RetestBars   int // 0 for entries on the breakout itself
+	Regime       RegimeConfig
+	RegimeGate   RegimeGate
+	HTFInterval  string // "" for no higher timeframe filter
+	HTFThreshold float64
+	Exits        ExitConfig
+}
+
+func envInt(key string) (int, error) {
+	val, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return 0, fmt.Errorf("error parsing %s: %w", key, err)
+	}
+	return val, nil
+}
+
+// Reads the strategy settings from the environment (.env), the required ones are the integers of the trader and the window
+func StrategySettingsFromEnv() (StrategySettings, error) {
+	var s StrategySettings
+	var err error
+	ints := []struct {
+		key string
+		val *int
+	}{
+		{"ADX_PERIOD", &s.ADXPeriod},
+		{"WINDOW_SIZE", &s.WindowSize},
+		{"IDLE_LIMIT", &s.Trader.IdleLimit},
+		{"ACTIVE_LIMIT", &s.Trader.ActiveLimit},
+	}
+	for _, i := range ints {
+		if *i.val, err = envInt(i.key); err != nil {
+			return s, err
+		}
+	}
+	threshold, err := envInt("ADX_THRESHOLD")
+	if err != nil {
+		return s, err
+	}
+	adxMin, err := envInt("ADX_MIN")
+	if err != nil {
+		return s, err
+	}
+	s.Trader.ADXThreshold, s.Trader.ADXMin = float64(threshold), float64(adxMin)
+
+	if s.Trader.Confirm, err = ParseConfirmConfig(os.Getenv("BREAKOUT_CONFIRM")); err != nil {
+		return s, fmt.Errorf("error parsing BREAKOUT_CONFIRM: %w", err)
+	}
+	s.MultiTouch = os.Getenv("TRENDLINE_FIT") == "multitouch"
+	if bars := os.Getenv("RETEST_BARS"); bars != "" {
+		if s.RetestBars, err = envInt("RETEST_BARS"); err != nil {
+			return s, err
+		}
+	}
+
+	// The regimes are classified with the same ADX as the trader
+	s.Regime = DefaultRegimeConfig()
+	s.Regime.ADXTrend = s.Trader.ADXThreshold
+	s.Regime.ADXPeriod = s.ADXPeriod
+	if s.RegimeGate, err = ParseRegimeGate(os.Getenv("REGIME_GATE")); err != nil {
+		return s, fmt.Errorf("error parsing REGIME_GATE: %w", err)
+	}
+	if s.HTFInterval = os.Getenv("HTF_INTERVAL"); s.HTFInterval != "" {
+		if s.HTFThreshold, err = strconv.ParseFloat(os.Getenv("HTF_ADX_THRESHOLD"), 64); err != nil {
+			return s, fmt.Errorf("error parsing HTF_ADX_THRESHOLD: %w", err)
+		}
+	}
+	if s.Exits, err = ParseExitConfig(os.Getenv("EXITS")); err != nil {
+		return s, fmt.Errorf("error parsing EXITS: %w", err)
+	}
+	return s, nil
+}
+
+// The extra conditions on the entries, the regime and the higher timeframe ADX, exits are never gated
+// Update has to be given every final candle before the strategy sees it, so the decision on a candle uses that candle's regime
+// Candles at or before the last one are skipped, so candles fed again after the priming (e.g. PrepTrain going over its window) are not counted twice
+type EntryGates struct {
+	Allowed      RegimeGate
+	Regimes      *RegimeDetector // nil if Regime is set by the caller instead (e.g. PrepTrain's labels)
+	Regime       Regime
+	RegimeState  RegimeState // Everything that went into the last regime
+	RegimeReady  bool        // False until the detector has seen enough candles
+	MTF          *MultiTimeframe
+	HTFInterval  string
+	HTFThreshold float64
+	htfOK        bool
+	last         int64 // OpenTime of the last candle, in ms since the history and the live candles can be in different units
+}
+
+func NewEntryGates(s StrategySettings, interval string) (*EntryGates, error) {
+	g := &EntryGates{Allowed: s.RegimeGate, Regimes: NewRegimeDetector(s.Regime), HTFInterval: s.HTFInterval, HTFThreshold: s.HTFThreshold}
+	if s.HTFInterval != "" {
+		mtf, err := NewMultiTimeframe(interval)
+		if err != nil {
+			return nil, err
+		}
+		if err := mtf.AddIndicator(s.HTFInterval, "ADX", NewADXIndicator(s.ADXPeriod)); err != nil {
+			return nil, err
+		}
+		g.MTF = mtf
+	}
+	return g, nil
+}
+
+func (g *EntryGates) Update(c models.CandleStick) {
+	t := OpenTimeMs(c.OpenTime)
+	if g.last != 0 && t <= g.last {
+		return
+	}
+	g.last = t
+	if g.Regimes != nil {
+		g.RegimeState, g.RegimeReady = g.Regimes.Update(c)
+		g.Regime = g.RegimeState.Regime
+	}
+	if g.MTF != nil {
+		// If this was the last base candle of a higher timeframe candle, that one has closed as well
+		g.MTF.Update(c)
+		adx, ok := g.MTF.Lookup(g.HTFInterval, "ADX")
+		g.htfOK = ok && adx >= g.HTFThreshold
+	}
+}
+
+func (g *EntryGates) Allows() bool {
+	return g.Allowed.Allows(g.Regime) && (g.MTF == nil || g.htfOK)
+}
+
+// The trendline strategy with all the settings wired in, it is a Strategy itself so it is what gets fed the candles
+type TrendlineSetup[C Candle] struct {
+	Strategy *TrendlineStrategy[C]
+	Signals  Strategy[C] // The strategy, wrapped in the protective exits if they are set
+	Gates    *EntryGates
+}
+
+// The window has to be filled already, history is the closed candles to warm the gates up with (it can overlap the window),
+// if it is empty the window's candles are used
+func NewTrendlineSetup[C Candle](window *Window[C], s StrategySettings, adx ADXSource[C], history []models.CandleStick) (*TrendlineSetup[C], error) {
+	if s.MultiTouch {
+		fit := DefaultTrendFitConfig()
+		window.Fit = &fit
+	}
+	strategy := NewTrendlineStrategy(window, s.Trader, adx)
+	candles := window.Candles()
+
+	// The retest detector needs the ATR of the window candles, they get no x since there is no breakout to retest yet
+	if s.RetestBars > 0 {
+		cfg := DefaultRetestConfig()
+		cfg.MaxBars = s.RetestBars
+		strategy.Retest = NewRetestDetector(cfg)
+		for _, c := range candles {
+			strategy.Retest.Update(c.CandleStick(), 0)
+		}
+	}
+
+	gates, err := NewEntryGates(s, window.Interval)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		for _, c := range candles {
+			history = append(history, c.CandleStick())
+		}
+	}
+	for _, c := range history {
+		gates.Update(c)
+	}
+	strategy.Allow = func(C) bool { return gates.Allows() }
+
+	charts := DefaultChartPatternConfig()
+	strategy.Charts = &charts
+	strategy.Start()
+
+	// The exits' ATR is warmed up the same as the gates so an ATR stop is there for the first entry
+	setup := &TrendlineSetup[C]{Strategy: strategy, Signals: strategy, Gates: gates}
+	if s.Exits.Enabled() {
+		exits := NewExits[C](strategy, s.Exits)
+		exits.Seed(history)
+		setup.Signals = exits
+	}
+	return setup, nil
+}
+
+func (t *TrendlineSetup[C]) Name() string {
+	return t.Signals.Name()
+}
+
+// The gates are updated with a final candle before the strategy sees it
+func (t *TrendlineSetup[C]) OnCandle(ctx context.Context, candle C) []models.Signal {
+	if c := candle.CandleStick(); c.IsFinal {
+		t.Gates.Update(c)
+	}
+	return t.Signals.OnCandle(ctx, candle)
+}
+
+func (t *TrendlineSetup[C]) Snapshot() (StrategyState, error) {
+	return t.Signals.Snapshot()
+}
+
+func (t *TrendlineSetup[C]) Restore(state StrategyState) error {
+	return t.Signals.Restore(state)
+}
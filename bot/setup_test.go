@@ -0,0 +1,56 @@
+package bot
+
+import (
+	"testing"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// The gates (and the exits, see exits_test.go) are primed with Binance history (ms) and then fed FetchSOLUSDT candles (seconds), the live candles must still move them on
+
+// The random walk candles with the OpenTimes moved to a real date, in ms from Binance or seconds from the DEX stream
+func stampedCandles(candles []models.EnrichedCandle, seconds bool) []models.CandleStick {
+	out := make([]models.CandleStick, len(candles))
+	for i, c := range candles {
+		out[i] = c.CandleStick()
+		out[i].OpenTime += 1_760_000_000_000
+		if seconds {
+			out[i].OpenTime /= 1000
+		}
+		out[i].IsFinal = true
+	}
+	return out
+}
+
+func TestGatesLiveUnits(t *testing.T) {
+	walk := randomWalk(600, 3)
+	history, live := stampedCandles(walk[:500], false), stampedCandles(walk[500:], true)
+
+	s := StrategySettings{ADXPeriod: 14, Regime: DefaultRegimeConfig(), HTFInterval: "5m", HTFThreshold: 0}
+	gates, err := NewEntryGates(s, "1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range history {
+		gates.Update(c)
+	}
+	primed := gates.RegimeState
+	htfPrimed, _ := gates.MTF.Lookup("5m", "ADX")
+
+	// The last history candle again, in seconds this time, changes nothing
+	again := history[len(history)-1]
+	again.OpenTime /= 1000
+	gates.Update(again)
+	if gates.RegimeState != primed {
+		t.Fatal("the regime moved on a candle it had already seen")
+	}
+	for _, c := range live {
+		gates.Update(c)
+	}
+	if gates.RegimeState == primed {
+		t.Fatal("the regime did not move on the live candles")
+	}
+	if htf, _ := gates.MTF.Lookup("5m", "ADX"); htf == htfPrimed {
+		t.Fatal("the higher timeframe ADX did not move on the live candles")
+	}
+}
@@ -0,0 +1,281 @@
+package bot
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// Position sizing, this is where the ML confidence scales the trade size (e.g. a confidence of 0.65 turns a $5 trade into $3.25)
+// The rule-based signal decides whether to trade, the sizing decides how much:
+//   size = equity * Fraction * confidence scale * volatility scale, capped between the min and max notional, then rounded to the lot size
+// The confidence scale comes from one of the mappings:
+//   linear: the confidence itself
+//   threshold: nothing under MinConfidence, full size from it
+//   piecewise: straight lines between the given (confidence, scale) points, flat past the ends
+//   kelly: KellyFraction of the Kelly fraction p - (1-p)/b, taking the confidence as the win probability p and PayoffRatio as b
+//          (as a fraction of the equity directly, capped at Fraction)
+// A signal with no confidence score (0) is sized at the full Fraction, and any score under MinConfidence is not traded
+// The volatility scale is TargetVol / current realised vol (capped at MaxVolScale), so the risk stays about the same as the market gets wilder or calmer
+// Every step goes in the rationale, which is put on the order's reason so it ends up in bot_trades.notes
+
+type SizingMode string
+
+const (
+	SizeLinear    SizingMode = "linear"
+	SizeThreshold SizingMode = "threshold"
+	SizePiecewise SizingMode = "piecewise"
+	SizeKelly     SizingMode = "kelly"
+)
+
+type SizingPoint struct {
+	Confidence float64
+	Scale      float64
+}
+
+type SizingConfig struct {
+	Mode          SizingMode
+	Fraction      float64       // Fraction of the equity at full size
+	MinConfidence float64       // Scores under this are not traded, also the step for threshold
+	Points        []SizingPoint // For piecewise, sorted by confidence
+	PayoffRatio   float64       // For kelly, the average win over the average loss
+	KellyFraction float64       // For kelly, e.g. 0.5 for half Kelly (full Kelly is far too aggressive with a noisy probability)
+	TargetVol     float64       // Annualised, 0 turns the volatility scaling off
+	MaxVolScale   float64       // Cap on the volatility scale so a very quiet market does not blow the size up
+	VolWindow     int           // Candles the realised vol is measured over
+	MinNotional   float64       // Trades smaller than this are skipped (e.g. the exchange minimum)
+	MaxNotional   float64       // 0 for no cap
+	StepSize      float64       // Lot size the quantity is rounded down to, 0 for no rounding
+	TickSize      float64       // Tick the limit/stop price is rounded to, 0 for no rounding
+}
+
+func DefaultSizingConfig() SizingConfig {
+	return SizingConfig{
+		Mode:          SizeLinear,
+		Fraction:      1,
+		PayoffRatio:   1.5,
+		KellyFraction: 0.5,
+		MaxVolScale:   2,
+		VolWindow:     60,
+	}
+}
+
+// Parses a comma separated list, for example "piecewise,points=0.5:0.25;0.7:0.75;0.9:1,min=0.55,vol=0.8,max_notional=1000"
+// The mode is one of linear, threshold, piecewise or kelly, the other keys are
+// fraction, min, points, payoff, kelly, vol, max_vol, vol_window, min_notional, max_notional, step and tick
+func ParseSizingConfig(s string) (SizingConfig, error) {
+	cfg := DefaultSizingConfig()
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, val, hasVal := strings.Cut(part, "=")
+		if !hasVal {
+			switch mode := SizingMode(key); mode {
+			case SizeLinear, SizeThreshold, SizePiecewise, SizeKelly:
+				cfg.Mode = mode
+				continue
+			}
+			return cfg, fmt.Errorf("unknown sizing mode %q", key)
+		}
+		var err error
+		switch key {
+		case "fraction":
+			cfg.Fraction, err = strconv.ParseFloat(val, 64)
+		case "min":
+			cfg.MinConfidence, err = strconv.ParseFloat(val, 64)
+		case "points":
+			cfg.Points = nil
+			for _, point := range strings.Split(val, ";") {
+				conf, scale, ok := strings.Cut(point, ":")
+				if !ok {
+					return cfg, fmt.Errorf("sizing point %q should be confidence:scale", point)
+				}
+				var p SizingPoint
+				if p.Confidence, err = strconv.ParseFloat(conf, 64); err != nil {
+					break
+				}
+				if p.Scale, err = strconv.ParseFloat(scale, 64); err != nil {
+					break
+				}
+				cfg.Points = append(cfg.Points, p)
+			}
+			sort.Slice(cfg.Points, func(i, j int) bool { return cfg.Points[i].Confidence < cfg.Points[j].Confidence })
+		case "payoff":
+			cfg.PayoffRatio, err = strconv.ParseFloat(val, 64)
+		case "kelly":
+			cfg.KellyFraction, err = strconv.ParseFloat(val, 64)
+		case "vol":
+			cfg.TargetVol, err = strconv.ParseFloat(val, 64)
+		case "max_vol":
+			cfg.MaxVolScale, err = strconv.ParseFloat(val, 64)
+		case "vol_window":
+			cfg.VolWindow, err = strconv.Atoi(val)
+		case "min_notional":
+			cfg.MinNotional, err = strconv.ParseFloat(val, 64)
+		case "max_notional":
+			cfg.MaxNotional, err = strconv.ParseFloat(val, 64)
+		case "step":
+			cfg.StepSize, err = strconv.ParseFloat(val, 64)
+		case "tick":
+			cfg.TickSize, err = strconv.ParseFloat(val, 64)
+		default:
+			return cfg, fmt.Errorf("unknown sizing setting %q", key)
+		}
+		if err != nil {
+			return cfg, fmt.Errorf("invalid sizing setting %q: %w", part, err)
+		}
+	}
+	if cfg.Mode == SizePiecewise && len(cfg.Points) == 0 {
+		return cfg, fmt.Errorf("piecewise sizing needs points")
+	}
+	return cfg, nil
+}
+
+// Scale gives the multiplier on Fraction for the confidence, with how it got there
+func (cfg SizingConfig) Scale(confidence float64) (float64, string) {
+	if confidence <= 0 {
+		return 1, "no confidence score -> x1"
+	}
+	if confidence < cfg.MinConfidence {
+		return 0, fmt.Sprintf("confidence %.3f under the minimum %.3f -> no trade", confidence, cfg.MinConfidence)
+	}
+	switch cfg.Mode {
+	case SizeThreshold:
+		return 1, fmt.Sprintf("threshold: confidence %.3f >= %.3f -> x1", confidence, cfg.MinConfidence)
+	case SizePiecewise:
+		scale := piecewise(cfg.Points, confidence)
+		return scale, fmt.Sprintf("piecewise: confidence %.3f -> x%.3f", confidence, scale)
+	case SizeKelly:
+		kelly := confidence - (1-confidence)/cfg.PayoffRatio
+		fraction := math.Max(0, cfg.KellyFraction*kelly)
+		if cfg.Fraction <= 0 {
+			return 0, "kelly: no fraction"
+		}
+		scale := math.Min(1, fraction/cfg.Fraction)
+		return scale, fmt.Sprintf("kelly: p %.3f, b %.2f -> f* %.3f, x%.2f Kelly = %.2f%% of equity -> x%.3f",
+			confidence, cfg.PayoffRatio, kelly, cfg.KellyFraction, fraction*100, scale)
+	}
+	return confidence, fmt.Sprintf("linear: confidence %.3f -> x%.3f", confidence, confidence)
+}
+
+func piecewise(points []SizingPoint, x float64) float64 {
+	if len(points) == 0 {
+		return 1
+	}
+	if x <= points[0].Confidence {
+		return points[0].Scale
+	}
+	for i := 1; i < len(points); i++ {
+		if x <= points[i].Confidence {
+			a, b := points[i-1], points[i]
+			if b.Confidence == a.Confidence {
+				return b.Scale
+			}
+			return a.Scale + (b.Scale-a.Scale)*(x-a.Confidence)/(b.Confidence-a.Confidence)
+		}
+	}
+	return points[len(points)-1].Scale
+}
+
+// The result of sizing an entry
+type Size struct {
+	Qty       float64 // 0 if the trade should be skipped
+	Price     float64 // The price the quantity was worked out at (the limit/stop price rounded to the tick for those orders)
+	Notional  float64
+	Scale     float64 // Confidence scale
+	VolScale  float64
+	Rationale string
+}
+
+// Size works out the quantity for an entry signal, vol is the current annualised realised vol (0 if not known)
+func (cfg SizingConfig) Size(sig models.Signal, equity, vol float64) Size {
+	price := sig.Price
+	if sig.OrderPrice > 0 {
+		price = sig.OrderPrice
+		if cfg.TickSize > 0 {
+			price = math.Round(price/cfg.TickSize) * cfg.TickSize
+		}
+	}
+	s := Size{Price: price, VolScale: 1}
+	var steps []string
+	s.Scale, s.Rationale = cfg.Scale(sig.Confidence)
+	steps = append(steps, s.Rationale)
+	if s.Scale <= 0 || equity <= 0 || price <= 0 {
+		s.Rationale = "size " + strings.Join(steps, ", ")
+		return s
+	}
+
+	if cfg.TargetVol > 0 && vol > 0 {
+		s.VolScale = cfg.TargetVol / vol
+		if cfg.MaxVolScale > 0 {
+			s.VolScale = math.Min(s.VolScale, cfg.MaxVolScale)
+		}
+		steps = append(steps, fmt.Sprintf("vol %.1f%% vs target %.1f%% -> x%.3f", vol*100, cfg.TargetVol*100, s.VolScale))
+	}
+	fraction := cfg.Fraction * s.Scale * s.VolScale
+	s.Notional = equity * fraction
+	steps = append(steps, fmt.Sprintf("%.2f%% of equity %.2f = %.2f", fraction*100, equity, s.Notional))
+	if cfg.MaxNotional > 0 && s.Notional > cfg.MaxNotional {
+		s.Notional = cfg.MaxNotional
+		steps = append(steps, fmt.Sprintf("capped at %.2f", cfg.MaxNotional))
+	}
+
+	s.Qty = s.Notional / price
+	if cfg.StepSize > 0 {
+		// The small nudge stops a float error rounding down a whole step
+		s.Qty = math.Floor(s.Qty/cfg.StepSize+1e-9) * cfg.StepSize
+		s.Notional = s.Qty * price
+	}
+	if s.Notional < cfg.MinNotional || s.Qty <= 0 {
+		steps = append(steps, fmt.Sprintf("%.2f under the minimum %.2f -> no trade", s.Notional, cfg.MinNotional))
+		s.Qty, s.Notional = 0, 0
+	} else {
+		steps = append(steps, fmt.Sprintf("qty %.8g @ %.8g", s.Qty, price))
+	}
+	s.Rationale = "size " + strings.Join(steps, ", ")
+	return s
+}
+
+// Sizer keeps the realised vol up to date for the volatility scaling, it should be given every closed candle
+// It can be sized from another goroutine than the one updating it (e.g. the on-chain swaps are made off the candle loop)
+type Sizer struct {
+	Config SizingConfig
+	Vol    VolatilityCalculator
+	mu     sync.Mutex
+	vol    float64
+}
+
+// The interval is the candle interval, for annualising the vol
+func NewSizer(cfg SizingConfig, interval time.Duration) *Sizer {
+	return &Sizer{Config: cfg, Vol: VolatilityCalculator{Estimator: YangZhang, Window: cfg.VolWindow, Interval: interval}}
+}
+
+func (s *Sizer) Update(c models.CandleStick) {
+	if s.Config.TargetVol <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if vol, ok := s.Vol.Update(c); ok {
+		s.vol = vol
+	}
+}
+
+// The current annualised realised vol, 0 until the window has filled
+func (s *Sizer) CurrentVol() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.vol
+}
+
+func (s *Sizer) Size(sig models.Signal, equity float64) Size {
+	return s.Config.Size(sig, equity, s.CurrentVol())
+}
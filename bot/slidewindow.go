@@ -1,85 +1,234 @@
 package bot
 
 import (
-	"log"
+	"fmt"
 
-	histdata "github.com/Reece-Ogidih/CT-Bot/HistoricalData"
 	models "github.com/Reece-Ogidih/CT-Bot/Models"
 )
 
-// Need the type for the SlidingWindow so that everything to do with the slidingwindow can be encapsulated
-// Did this here since methods can only be defined on local types
-type SlidingWindow struct {
-	Symbol      string // Will add this for scalability later when expanding to multiple coins
-	Interval    string
-	Size        int
-	Candles     []models.CandleStick
-	SupLine     models.Trendline
-	ResLine     models.Trendline
-	Initialised bool
-	// Will need to add the indices list that I am using in the simulation bot
+// There used to be one sliding window for the live bot (SlidingWindow) and one for the training run (SlidingWindowTrain)
+// The trendline code was duplicated between the two and had started to diverge, so now there is one window that is generic over the candle type
+// This way the live bot and the training run use the exact same trendline algorithm
+
+// Any candle type can be used in the window as long as it can give back its plain candlestick data
+// (models.CandleStick and models.EnrichedCandle both have this method)
+type Candle interface {
+	CandleStick() models.CandleStick
+}
+
+// The x-axis used for the trendlines is pluggable
+type XAxis int
+
+const (
+	AxisIndex   XAxis = iota // Bar index, each candle is one unit along (this keeps the gradients from being near zero)
+	AxisSeconds              // Seconds since the Anchor time
+	AxisTime                 // The raw OpenTime of the candle (what the live window originally used)
+)
+
+// Need the type for the window so that everything to do with the sliding window can be encapsulated
+// The candles are kept in a fixed capacity ring buffer, so sliding the window never reallocates or leaks the backing array
+// The max high and min low are tracked with monotonic deques so they are O(1) to look up instead of scanning the whole window
+type Window[C Candle] struct {
+	Symbol         string // Will add this for scalability later when expanding to multiple coins
+	Interval       string
+	Size           int
+	Axis           XAxis
+	Anchor         int64 // OpenTime (in ms) that AxisSeconds is measured from, needs to be set before any candles are pushed
+	SupLine        models.Trendline
+	ResLine        models.Trendline
+	Initialised    bool
+	Fit            *TrendFitConfig              // If set, ComputeTrendlines uses the best multi-touch line (see trendfit.go)
+	Events         chan<- models.TrendlineEvent // If set, the trendline lifecycle events are sent here (see lifecycle.go)
+	TouchTolerance float64                      // Fraction of the line price a candle has to come within to count as touching it
+	resLife        lineLife
+	supLife        lineLife
+	lineIDs        int64                // Last trendline ID given out
+	candles        []C                  // Ring buffer of the candles
+	bars           []models.CandleStick // The plain candle data of each slot, saves converting every time the trendlines are calculated
+	highs          []float64            // The highs, lows and x values of each slot are also kept in their own arrays
+	lows           []float64            // since the trendline checks loop over them on every recalculation
+	xs             []float64
+	start          int // Slot of the oldest candle
+	count          int
+	nextIdx        int      // Absolute index the next candle will get, the oldest candle's index is nextIdx - count
+	maxQ           idxDeque // Absolute indices with decreasing highs, the front is the max high
+	minQ           idxDeque // Absolute indices with increasing lows, the front is the min low
 }
 
-func (sw *SlidingWindow) Init() error {
+// The size has to be at least 2, a trendline needs two anchors (and the ring buffer slots are taken modulo the size)
+func NewSlidingWindow[C Candle](symbol, interval string, size int, axis XAxis) (*Window[C], error) {
+	if size < 2 {
+		return nil, fmt.Errorf("window size must be at least 2, got %d", size)
+	}
+	return &Window[C]{
+		Symbol:         symbol,
+		Interval:       interval,
+		Size:           size,
+		Axis:           axis,
+		TouchTolerance: 0.0005,
+	}, nil
+}
+
+// Init fills the window with the most recent candles using the given fetch function (e.g. histdata.RecentCandles)
+func (w *Window[C]) Init(fetch func(symbol, interval string, limit int) ([]C, error)) error {
 	// Add the case when the window has already been initialised
-	if sw.Initialised {
+	if w.Initialised {
 		return nil
 	}
 
-	// Now make the call to fetch recent candles to fill the initial window
-	candles, err := histdata.RecentCandles(sw.Symbol, sw.Interval, sw.Size)
+	candles, err := fetch(w.Symbol, w.Interval, w.Size)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("failed to initialise window: %w", err)
 	}
-
-	// Adjust the states of the struct
-	sw.Candles = candles
-	sw.Initialised = true
+	w.Fill(candles)
 	return nil
 }
 
-// Next we define the method to update the sliding window live as new candles come in
-func (sw *SlidingWindow) NewWindow(candle models.CandleStick) {
+// Fill pushes a set of candles (oldest first) into the window, for example the first N historical candles
+func (w *Window[C]) Fill(candles []C) {
+	for _, c := range candles {
+		w.Push(c)
+	}
+	w.Initialised = true
+}
+
+// Push adds the newest candle to the window, once the window is full this overwrites the oldest candle
+func (w *Window[C]) Push(c C) {
+	if w.candles == nil {
+		w.candles = make([]C, w.Size)
+		w.bars = make([]models.CandleStick, w.Size)
+		w.highs = make([]float64, w.Size)
+		w.lows = make([]float64, w.Size)
+		w.xs = make([]float64, w.Size)
+		w.maxQ = newIdxDeque(w.Size)
+		w.minQ = newIdxDeque(w.Size)
+	}
+
+	var slot int
+	if w.count < w.Size {
+		slot = (w.start + w.count) % w.Size
+		w.count++
+	} else {
+		slot = w.start
+		w.start = (w.start + 1) % w.Size
+	}
+	bar := c.CandleStick()
+	w.candles[slot] = c
+	w.bars[slot] = bar
+	w.highs[slot] = bar.High
+	w.lows[slot] = bar.Low
+	idx := w.nextIdx
+	w.xs[slot] = w.xOf(idx, bar.OpenTime)
+	w.nextIdx++
 
-	sw.Candles = append(sw.Candles, candle)
+	// Drop any indices that have slid out of the window
+	oldest := w.nextIdx - w.count
+	for !w.maxQ.empty() && w.maxQ.front() < oldest {
+		w.maxQ.popFront()
+	}
+	for !w.minQ.empty() && w.minQ.front() < oldest {
+		w.minQ.popFront()
+	}
 
-	// To keep the window at a fixed size, remove the oldest candle when a new one arrives
-	if len(sw.Candles) > sw.Size {
-		sw.Candles = sw.Candles[1:]
+	// Any candles with a lower high than the new one can never be the max again (ties keep the older candle, same as a scan would)
+	for !w.maxQ.empty() && w.highs[w.slot(w.maxQ.back())] < bar.High {
+		w.maxQ.popBack()
+	}
+	w.maxQ.pushBack(idx)
+	for !w.minQ.empty() && w.lows[w.slot(w.minQ.back())] > bar.Low {
+		w.minQ.popBack()
 	}
+	w.minQ.pushBack(idx)
+}
+
+// Gives the slot in the ring buffer of an absolute index
+func (w *Window[C]) slot(idx int) int {
+	return (w.start + idx - (w.nextIdx - w.count)) % w.Size
 }
 
-// Also add a version for the bot run to prepare for ML training
-type SlidingWindowTrain struct {
-	Symbol      string // Will add this for scalability later when expanding to multiple coins
-	Interval    string
-	Size        int
-	Candles     []models.EnrichedCandle
-	SupLine     models.Trendline
-	ResLine     models.Trendline
-	Initialised bool
-	Idxs        []int // Will need to add an index to each candle in order for trendline slopes to not be near zero
+func (w *Window[C]) Len() int {
+	return w.count
 }
 
-func (sw *SlidingWindowTrain) NewWindowTrain(candle models.EnrichedCandle) {
-	sw.Candles = append(sw.Candles, candle)
+// Candle at position i in the window (0 is the oldest)
+func (w *Window[C]) At(i int) C {
+	return w.candles[(w.start+i)%w.Size]
+}
+
+// Plain candle data at position i in the window
+func (w *Window[C]) Bar(i int) models.CandleStick {
+	return w.bars[(w.start+i)%w.Size]
+}
 
-	// To keep the window at a fixed size, remove the oldest candle when a new one arrives
-	if len(sw.Candles) > sw.Size {
-		sw.Candles = sw.Candles[1:]
+// Absolute index of the candle at position i in the window
+func (w *Window[C]) Idx(i int) int {
+	return w.nextIdx - w.count + i
+}
+
+// Gives the absolute index the next candle pushed will get
+func (w *Window[C]) NextIdx() int {
+	return w.nextIdx
+}
+
+// Candles in the window from oldest to newest
+func (w *Window[C]) Candles() []C {
+	out := make([]C, w.count)
+	for i := range out {
+		out[i] = w.At(i)
 	}
+	return out
 }
 
-// Function to increase the index list when a new candle is considered. will separate this into two functions since I may want the index for next candle without updating window
-func (sw *SlidingWindowTrain) GetNextIdx() (nextIdx int) {
-	nextIdx = sw.Idxs[len(sw.Idxs)-1] + 1
-	return nextIdx
+// Gives the x-axis value of the candle at position i in the window
+func (w *Window[C]) X(i int) float64 {
+	return w.xs[(w.start+i)%w.Size]
 }
 
-func (sw *SlidingWindowTrain) UpdateIdx(nextIdx int) {
-	sw.Idxs = append(sw.Idxs, nextIdx)
+// Gives the x-axis value a candle would have if it was pushed next (used to check new candles against the trendlines)
+func (w *Window[C]) NextX(c C) float64 {
+	return w.xOf(w.nextIdx, c.CandleStick().OpenTime)
+}
 
-	if len(sw.Idxs) > sw.Size {
-		sw.Idxs = sw.Idxs[1:]
+func (w *Window[C]) xOf(idx int, openTime int64) float64 {
+	switch w.Axis {
+	case AxisSeconds:
+		return float64(OpenTimeMs(openTime)-OpenTimeMs(w.Anchor)) / 1000
+	case AxisTime:
+		return float64(openTime)
 	}
+	return float64(idx)
+}
+
+// Value of a trendline at a given x
+func LineAt(line models.Trendline, x float64) float64 {
+	return line.Gradient*x + line.Intercept
+}
+
+// Fixed capacity double ended queue of absolute indices, used for the max high and min low
+type idxDeque struct {
+	buf   []int
+	head  int
+	count int
+}
+
+func newIdxDeque(capacity int) idxDeque {
+	return idxDeque{buf: make([]int, capacity)}
+}
+
+func (q *idxDeque) empty() bool { return q.count == 0 }
+func (q *idxDeque) front() int  { return q.buf[q.head] }
+func (q *idxDeque) back() int   { return q.buf[(q.head+q.count-1)%len(q.buf)] }
+
+func (q *idxDeque) pushBack(v int) {
+	q.buf[(q.head+q.count)%len(q.buf)] = v
+	q.count++
+}
+
+func (q *idxDeque) popFront() {
+	q.head = (q.head + 1) % len(q.buf)
+	q.count--
+}
+
+func (q *idxDeque) popBack() {
+	q.count--
 }
@@ -0,0 +1,127 @@
+package bot
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// The ring buffer window against the old slice based window (legacywindow_test.go), replaying the same window/trendline calls cmd/PrepTrain makes
+// Uses a random walk so it runs without the database, the benchmarks go over a year of 1m candles, e.g. go test ./bot -run '^$' -bench Window
+
+const (
+	benchCandles = 525600 // One year of 1m candles
+	benchSize    = 100    // WINDOW_SIZE
+	benchIdle    = 30     // IDLE_LIMIT
+)
+
+// Generates the synthetic candles, a log-normal random walk starting at a similar price to SOL
+func randomWalk(n int, seed int64) []models.EnrichedCandle {
+	r := rand.New(rand.NewSource(seed))
+	candles := make([]models.EnrichedCandle, n)
+	price := 150.0
+	for i := range candles {
+		open := price
+		price *= math.Exp(r.NormFloat64() * 0.001)
+		candles[i] = models.EnrichedCandle{
+			OpenTime: int64(i) * 60000,
+			Open:     open,
+			High:     math.Max(open, price) * (1 + r.Float64()*0.0005),
+			Low:      math.Min(open, price) * (1 - r.Float64()*0.0005),
+			Close:    price,
+			Volume:   r.Float64() * 1000,
+		}
+	}
+	return candles
+}
+
+// Same flow as the PrepTrain loop: check both lines for a breakout, push the candle and redraw on a breakout or every idleLimit candles
+func runRing(tb testing.TB, candles []models.EnrichedCandle, size, idleLimit int) (models.Trendline, models.Trendline) {
+	window, err := NewSlidingWindow[models.EnrichedCandle]("SOLUSDT", "1m", size, AxisIndex)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	window.Fill(candles[:size])
+	window.ComputeTrendlines()
+
+	sinceUpdate := 0
+	for _, c := range candles[size:] {
+		brokeRes := window.CheckTrendlines2(window.ResLine, c, true, 0)
+		brokeSup := window.CheckTrendlines2(window.SupLine, c, false, 0)
+		window.Push(c)
+		sinceUpdate++
+		if brokeRes || brokeSup || sinceUpdate >= idleLimit {
+			sinceUpdate = 0
+			window.ComputeTrendlines()
+		}
+	}
+	return window.SupLine, window.ResLine
+}
+
+func runLegacy(candles []models.EnrichedCandle, size, idleLimit int) (models.Trendline, models.Trendline) {
+	window := legacyWindow{Size: size, Candles: append([]models.EnrichedCandle(nil), candles[:size]...), Idxs: make([]int, size)}
+	for i := range window.Idxs {
+		window.Idxs[i] = i
+	}
+	window.ComputeTrendlines()
+
+	sinceUpdate := 0
+	for _, c := range candles[size:] {
+		nextIdx := window.GetNextIdx()
+		brokeRes := window.CheckTrendlines2(window.ResLine, c, true, 0, nextIdx)
+		brokeSup := window.CheckTrendlines2(window.SupLine, c, false, 0, nextIdx)
+		window.NewWindowTrain(c)
+		window.UpdateIdx(nextIdx)
+		sinceUpdate++
+		if brokeRes || brokeSup || sinceUpdate >= idleLimit {
+			sinceUpdate = 0
+			window.ComputeTrendlines()
+		}
+	}
+	return window.SupLine, window.ResLine
+}
+
+// The two windows have to draw exactly the same trendlines, otherwise the benchmark is not comparing like for like
+// (the legacy window does not give its lines IDs, so those are cleared first)
+func TestWindowMatchesLegacy(t *testing.T) {
+	for _, size := range []int{2, 20, 100} {
+		candles := randomWalk(20000, int64(size))
+		ringSup, ringRes := runRing(t, candles, size, benchIdle)
+		legacySup, legacyRes := runLegacy(candles, size, benchIdle)
+		ringSup.ID, ringRes.ID = 0, 0
+		if ringSup != legacySup || ringRes != legacyRes {
+			t.Fatalf("size %d: ring window drew sup %+v res %+v, legacy drew sup %+v res %+v", size, ringSup, ringRes, legacySup, legacyRes)
+		}
+	}
+}
+
+func TestNewSlidingWindowSize(t *testing.T) {
+	for _, size := range []int{-1, 0, 1} {
+		if _, err := NewSlidingWindow[models.CandleStick]("SOLUSDT", "1m", size, AxisIndex); err == nil {
+			t.Errorf("size %d: expected an error", size)
+		}
+	}
+	if _, err := NewSlidingWindow[models.CandleStick]("SOLUSDT", "1m", 2, AxisIndex); err != nil {
+		t.Errorf("size 2: %v", err)
+	}
+}
+
+func BenchmarkWindowRing(b *testing.B) {
+	candles := randomWalk(benchCandles, 1)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runRing(b, candles, benchSize, benchIdle)
+	}
+}
+
+func BenchmarkWindowLegacy(b *testing.B) {
+	candles := randomWalk(benchCandles, 1)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runLegacy(candles, benchSize, benchIdle)
+	}
+}
@@ -0,0 +1,171 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// A Strategy takes the candles one at a time and returns the entries/exits it wants
+// The same strategy is used to label the training data, in the backtests, in paper trading and live, so each of these only has to feed it candles
+// Candles that are not final (IsFinal false) can be passed in as well, it is up to the strategy if it acts on them
+// The state can be snapshotted so a run can be stopped and carried on later (e.g. restarting the live bot)
+
+const (
+	SignalEntry = "entry"
+	SignalExit  = "exit"
+)
+
+type Strategy[C Candle] interface {
+	Name() string
+	OnCandle(ctx context.Context, candle C) []models.Signal
+	Snapshot() (StrategyState, error)
+	Restore(state StrategyState) error
+}
+
+// The saved state of a strategy, Data is whatever the strategy needs to carry on (as JSON so it can be stored anywhere)
+type StrategyState struct {
+	Strategy string
+	OpenTime int64 // OpenTime of the last candle the strategy saw
+	Position int   // 1 long, -1 short, 0 flat
+	Data     json.RawMessage
+}
+
+// Where the trendline strategy gets the ADX for a candle from, ok is false if it is not ready yet (then no entries are made)
+type ADXSource[C Candle] func(candle C) (adx, plusDI, minusDI float64, ok bool)
+
+// For the training run the ADX is already on the candle
+func EnrichedADX(c models.EnrichedCandle) (adx, plusDI, minusDI float64, ok bool) {
+	return c.ADX, c.PlusDI, c.MinusDI, true
+}
+
+// For the live stream the ADX is updated on each final candle, the candles that have not closed yet get the value of the last close
+func LiveADX(calc *ADXCalculator) ADXSource[models.CandleStick] {
+	var adx, plusDI, minusDI float64
+	ready := calc.Count > 0
+	if ready {
+		adx = calc.PrevADX
+		plusDI, minusDI = calc.DI()
+	}
+	return func(c models.CandleStick) (float64, float64, float64, bool) {
+		if c.IsFinal {
+			if val, ok := calc.Update(c); ok {
+				adx, ready = val, true
+				plusDI, minusDI = calc.DI()
+			}
+		}
+		return adx, plusDI, minusDI, ready
+	}
+}
+
+// The trendline breakout + ADX strategy, the first strategy and the one the bot has always used (see trader.go for the logic)
+type TrendlineStrategy[C Candle] struct {
+	*Trader[C]
+	ADX         ADXSource[C]
+	Allow       func(candle C) bool // Optional extra gate on the entries (e.g. regime or higher timeframe), exits are never gated
+	UseIntrabar bool                // Act on breakouts in candles that have not closed yet
+	Last        TradeSignal         // The full decision for the last candle (breakout filters, lines checked against etc.)
+	Charts      *ChartPatternConfig // If set, the chart pattern is found each time the lines are redrawn and the entries that break it get its target
+	Chart       ChartPatternResult  // The current chart pattern, found when the lines were last redrawn
+	ChartBreak  ChartPatternSignal  // The pattern breakout on the last final candle, Direction is 0 if there was none
+	lastTime    int64
+}
+
+func NewTrendlineStrategy[C Candle](window *Window[C], cfg TraderConfig, adx ADXSource[C]) *TrendlineStrategy[C] {
+	return &TrendlineStrategy[C]{Trader: NewTrader(window, cfg), ADX: adx}
+}
+
+// Draws the first trendlines (and finds the chart pattern), the window has to be filled first
+func (s *TrendlineStrategy[C]) Start() {
+	s.Trader.Start()
+	s.classify()
+}
+
+func (s *TrendlineStrategy[C]) classify() {
+	if s.Charts != nil {
+		s.Chart = s.Window.FindChartPattern(*s.Charts)
+	}
+}
+
+func (s *TrendlineStrategy[C]) Name() string {
+	return "trendline-adx"
+}
+
+func (s *TrendlineStrategy[C]) OnCandle(ctx context.Context, candle C) []models.Signal {
+	if ctx.Err() != nil {
+		return nil
+	}
+	c := candle.CandleStick()
+	adx, plusDI, minusDI, ok := s.ADX(candle)
+	allow := ok && (s.Allow == nil || s.Allow(candle))
+
+	if !c.IsFinal {
+		if !s.UseIntrabar || !ok {
+			return nil
+		}
+		sig, acted := s.Trader.Intrabar(candle, adx, plusDI, minusDI, allow)
+		if !acted {
+			return nil
+		}
+		s.Last = sig
+		return s.signals(sig, c)
+	}
+
+	// The pattern is checked against the lines it was found on, before the candle goes into the window
+	s.ChartBreak = ChartPatternSignal{}
+	if s.Charts != nil {
+		s.ChartBreak, _ = s.Chart.CheckBreakout(c, s.Window.NextX(candle))
+	}
+	sig := s.Step(candle, adx, plusDI, minusDI, allow)
+	s.Last = sig
+	s.lastTime = c.OpenTime
+	out := s.signals(sig, c)
+	for i := range out {
+		if out[i].Kind == SignalEntry && s.ChartBreak.Direction == out[i].Side {
+			out[i].Target = s.ChartBreak.Target
+			out[i].Reason += fmt.Sprintf("; %s breakout, target %.6g", s.ChartBreak.Pattern, s.ChartBreak.Target)
+		}
+	}
+	if sig.Recalibrated {
+		s.classify()
+	}
+	return out
+}
+
+// Exits come before entries so a reversal closes the old position first
+func (s *TrendlineStrategy[C]) signals(sig TradeSignal, c models.CandleStick) []models.Signal {
+	var out []models.Signal
+	if sig.Exit != 0 {
+		out = append(out, models.Signal{Strategy: s.Name(), Symbol: s.Window.Symbol, OpenTime: c.OpenTime, Kind: SignalExit,
+			Side: sig.Exit, Price: c.Close, Intrabar: sig.Intrabar, Reason: sig.ExitReason})
+	}
+	if sig.Entry != 0 {
+		out = append(out, models.Signal{Strategy: s.Name(), Symbol: s.Window.Symbol, OpenTime: c.OpenTime, Kind: SignalEntry,
+			Side: sig.Entry, Price: c.Close, Intrabar: sig.Intrabar, Reason: sig.EntryReason, Stop: sig.EntryStop})
+	}
+	return out
+}
+
+func (s *TrendlineStrategy[C]) Snapshot() (StrategyState, error) {
+	data, err := json.Marshal(s.Trader.Snapshot())
+	if err != nil {
+		return StrategyState{}, err
+	}
+	return StrategyState{Strategy: s.Name(), OpenTime: s.lastTime, Position: s.Position, Data: data}, nil
+}
+
+func (s *TrendlineStrategy[C]) Restore(state StrategyState) error {
+	if state.Strategy != s.Name() {
+		return fmt.Errorf("state is for strategy %q, not %q", state.Strategy, s.Name())
+	}
+	var ts TraderState
+	if err := json.Unmarshal(state.Data, &ts); err != nil {
+		return fmt.Errorf("invalid %s state: %w", s.Name(), err)
+	}
+	s.Trader.Restore(ts)
+	s.lastTime = state.OpenTime
+	s.classify()
+	return nil
+}
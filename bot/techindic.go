@@ -1,6 +1,7 @@
 package bot
 
 import (
+	"fmt"
 	"log"
 	"math"
 
@@ -215,3 +216,102 @@ func (a *ADXCalculator) Update(curr models.CandleStick) (adx float64, ok bool) {
 
 	return a.PrevADX, true
 }
+
+// Need to be able to get the directional indicators from the live calculator as well (for direction of trend)
+func (a *ADXCalculator) DI() (plusDI float64, minusDI float64) {
+	if a.PrevTR == 0 {
+		return 0, 0
+	}
+	return 100 * (a.PrevPosDM / a.PrevTR), 100 * (a.PrevNegDM / a.PrevTR)
+}
+
+// Update fetches the most recent SOLUSDT candles on its first run, this is fine for the live bot but anything running on
+// its own candles (historical data, other timeframes) needs to seed the calculator itself instead
+func (a *ADXCalculator) Seed(candles []models.CandleStick) error {
+	if len(candles) <= a.Period {
+		return fmt.Errorf("not enough candles: expected at least %d, got %d", a.Period+1, len(candles))
+	}
+	var err error
+	_, _, _, a.PrevTR, a.PrevPosDM, a.PrevNegDM, a.PrevADX, err = CalculateADX(candles, a.Period)
+	if err != nil {
+		return err
+	}
+	a.PrevCandle = candles[len(candles)-1]
+	a.Count = 1
+	return nil
+}
+
+// Helper for the True Range, which is also used in the ADX calculation
+func trueRange(curr, prev models.CandleStick) float64 {
+	return math.Max(
+		curr.High-curr.Low,
+		math.Max(
+			math.Abs(curr.High-prev.Close),
+			math.Abs(curr.Low-prev.Close)),
+	)
+}
+
+// Average True Range using Wilder's smoothing, the first N candles are flagged as NaN
+func CalculateATR(candles []models.CandleStick, period int) []float64 {
+	calc := ATRCalculator{Period: period}
+	atr := make([]float64, len(candles))
+	for i, c := range candles {
+		val, ok := calc.Update(c)
+		if !ok {
+			val = math.NaN()
+		}
+		atr[i] = val
+	}
+	return atr
+}
+
+// Streaming ATR, the first ATR is the simple average of the first N true ranges, then it is smoothed
+type ATRCalculator struct {
+	Period     int
+	ATR        float64
+	count      int
+	trSum      float64
+	PrevCandle models.CandleStick
+}
+
+func (a *ATRCalculator) Update(curr models.CandleStick) (float64, bool) {
+	if a.count == 0 {
+		a.count = 1
+		a.PrevCandle = curr
+		return 0, false
+	}
+	tr := trueRange(curr, a.PrevCandle)
+	a.PrevCandle = curr
+
+	if a.count <= a.Period {
+		a.trSum += tr
+		a.count++
+		if a.count <= a.Period {
+			return 0, false
+		}
+		a.ATR = a.trSum / float64(a.Period)
+		return a.ATR, true
+	}
+	a.ATR = (a.ATR*float64(a.Period-1) + tr) / float64(a.Period)
+	return a.ATR, true
+}
+
+// Simple moving average over the last N closes, used for the trend slope
+type SMACalculator struct {
+	Period int
+	closes []float64
+	total  float64
+}
+
+func (s *SMACalculator) Update(price float64) (float64, bool) {
+	s.closes = append(s.closes, price)
+	s.total += price
+	if len(s.closes) > s.Period {
+		s.total -= s.closes[0]
+		s.closes = s.closes[1:]
+	}
+	if len(s.closes) < s.Period {
+		return 0, false
+	}
+	return s.total / float64(s.Period), true
+}
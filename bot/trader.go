@@ -0,0 +1,258 @@
+package bot
+
+import (
+	"fmt"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// This is the breakout + ADX state machine that used to live in the loop of cmd/PrepTrain
+// It has been moved here so the training run and the live bot make their decisions with exactly the same logic
+// It works as follows
+// Each final candle is checked against the trendlines (with the optional confirmation filters)
+// If we are in a trade and the ADX has dropped below ADXMin the trade is exited (little momentum so anticipating a reversal)
+// On a breakout any trade against it is exited, and if the ADX is above ADXThreshold (and +DI/-DI agree) a trade is entered in the breakout direction
+// No matter if a trade is triggered or not, once there is a breakout or IdleLimit/ActiveLimit candles pass, new trendlines are drawn
+// When the limit runs out whilst in a trade, the trade is exited
+
+type TraderConfig struct {
+	ADXThreshold float64 // Required ADX for a trade to be placed
+	ADXMin       float64 // Required ADX for a position to be held
+	IdleLimit    int     // Candles before the trendlines are redrawn when not in a trade
+	ActiveLimit  int     // Candles a trade can be held for before the trendlines are redrawn (and the trade exited)
+	Confirm      ConfirmConfig
+}
+
+// What the trader decided on a candle
+type TradeSignal struct {
+	OpenTime     int64
+	Entry        int     // 1 to enter a long, -1 to enter a short, 0 for no entry
+	Exit         int     // 1 to exit a long, -1 to exit a short, 0 for hold
+	EntryReason  string  // "breakout" or "retest"
+	EntryStop    float64 // Where the entry is proven wrong: the broken line for a breakout, the retest's invalidation for a retest
+	ExitReason   string  // "adx", "breakout", "reverse", "limit" or "retest"
+	Res          BreakoutSignal
+	Sup          BreakoutSignal
+	ResLine      models.Trendline // The lines the candle was checked against (they may have been redrawn since)
+	SupLine      models.Trendline
+	Recalibrated bool // The trendlines were redrawn after this candle
+	Intrabar     bool // The decision was made before the candle closed
+}
+
+type Trader[C Candle] struct {
+	Config      TraderConfig
+	Window      *Window[C]
+	Retest      *RetestDetector // If set, breakouts are handed over to be retested and the entries come from the confirmed retests
+	OnRecompute func()          // Called each time the trendlines are redrawn (e.g. to classify the chart pattern)
+	Position    int             // 1 long, -1 short, 0 flat
+	sinceUpdate int             // Candles since the trendlines were last drawn
+	intrabar    int64           // OpenTime of the candle that already broke out intrabar, 0 if none
+}
+
+// The state of the trader that is not in the window, so a run can be saved and carried on later (the lines are kept so they can be restored without redrawing)
+type TraderState struct {
+	Position    int
+	SinceUpdate int
+	Intrabar    int64
+	ResLine     models.Trendline
+	SupLine     models.Trendline
+}
+
+func NewTrader[C Candle](window *Window[C], cfg TraderConfig) *Trader[C] {
+	return &Trader[C]{Config: cfg, Window: window}
+}
+
+func (t *Trader[C]) recompute() {
+	t.Window.ComputeTrendlines()
+	if t.OnRecompute != nil {
+		t.OnRecompute()
+	}
+}
+
+// Draws the first trendlines, the window has to be filled first
+func (t *Trader[C]) Start() {
+	t.sinceUpdate = 0
+	t.recompute()
+}
+
+// Checks the candle for a breakout and applies the exits/entries, does not touch the window
+func (t *Trader[C]) decide(candle C, adx, plusDI, minusDI float64, allowEntry bool, sig *TradeSignal) bool {
+	w := t.Window
+	sig.Res = w.ConfirmBreakout(w.ResLine, candle, true, t.Position, t.Config.Confirm)
+	sig.Sup = w.ConfirmBreakout(w.SupLine, candle, false, t.Position, t.Config.Confirm)
+	brokeRes, brokeSup := sig.Res.Confirmed, sig.Sup.Confirmed
+
+	// If we are in a trade and the adx drops (so little momentum in market), exit the position (anticipating reversal)
+	if t.Position != 0 && adx < t.Config.ADXMin {
+		sig.Exit, sig.ExitReason = t.Position, "adx"
+		t.Position = 0
+	}
+
+	if !brokeRes && !brokeSup {
+		return false
+	}
+
+	// First need to check to see if there was an ongoing trade against the breakout as the bot would exit here
+	if brokeRes && t.Position == -1 {
+		sig.Exit, sig.ExitReason = -1, "breakout"
+		t.Position = 0
+	}
+	if brokeSup && t.Position == 1 {
+		sig.Exit, sig.ExitReason = 1, "breakout"
+		t.Position = 0
+	}
+
+	if !allowEntry || adx < t.Config.ADXThreshold {
+		return true
+	}
+	c := candle.CandleStick()
+
+	// In break and retest mode the broken line is only handed over to be watched, the entry comes from the retest
+	if t.Retest != nil {
+		if brokeRes && plusDI > minusDI {
+			t.Retest.Breakout(LineLevel(w.ResLine), 1, c)
+		} else if brokeSup && plusDI < minusDI {
+			t.Retest.Breakout(LineLevel(w.SupLine), -1, c)
+		}
+		return true
+	}
+
+	// Strong trend, so enter in the direction of the breakout (reversing out of an opposite position)
+	if brokeRes && plusDI > minusDI {
+		if t.Position == 1 {
+			fmt.Println("Error, proposing long whilst in long position:", c)
+		} else {
+			if t.Position == -1 {
+				sig.Exit, sig.ExitReason = -1, "reverse"
+			}
+			sig.Entry, sig.EntryReason, sig.EntryStop = 1, "breakout", sig.Res.LinePrice
+			t.Position = 1
+		}
+	} else if brokeSup && plusDI < minusDI {
+		if t.Position == -1 {
+			fmt.Println("Error, proposing short whilst in short position", c)
+		} else {
+			if t.Position == 1 {
+				sig.Exit, sig.ExitReason = 1, "reverse"
+			}
+			sig.Entry, sig.EntryReason, sig.EntryStop = -1, "breakout", sig.Sup.LinePrice
+			t.Position = -1
+		}
+	}
+	return true
+}
+
+// Step is called with each final candle, adx/plusDI/minusDI are the values for that candle
+// allowEntry is for any extra gates on the entries (e.g. the regime or higher timeframe ADX), exits are never gated
+func (t *Trader[C]) Step(candle C, adx, plusDI, minusDI float64, allowEntry bool) TradeSignal {
+	w := t.Window
+	c := candle.CandleStick()
+	sig := TradeSignal{OpenTime: c.OpenTime, ResLine: w.ResLine, SupLine: w.SupLine}
+
+	// Move any breakouts waiting for a retest along, this is done before the breakout check so a breakout candle cannot also be its own retest
+	// (a breakout found intrabar on this candle is already with the detector, but it skips the breakouts from the candle it is given)
+	var retests []RetestSignal
+	if t.Retest != nil {
+		retests = t.Retest.Update(c, w.NextX(candle))
+	}
+
+	// If this candle already broke out intrabar then the decision has been made, only the window needs updating
+	var breakout bool
+	if t.intrabar == c.OpenTime && t.intrabar != 0 {
+		breakout = true
+		sig.Intrabar = true
+		sig.Res = w.ConfirmBreakout(w.ResLine, candle, true, t.Position, t.Config.Confirm)
+		sig.Sup = w.ConfirmBreakout(w.SupLine, candle, false, t.Position, t.Config.Confirm)
+	} else {
+		breakout = t.decide(candle, adx, plusDI, minusDI, allowEntry, &sig)
+	}
+	t.intrabar = 0
+
+	// Record any touches or breaks of the current lines
+	w.TrackTrendlines(candle, sig.Res.Confirmed, sig.Sup.Confirmed)
+	w.Push(candle)
+
+	if breakout {
+		// After a breakout, trendlines will be redrawn regardless of trend strength
+		t.sinceUpdate = 0
+		t.recompute()
+		sig.Recalibrated = true
+	} else {
+		// Check for too long idle or holding a position
+		t.sinceUpdate++
+		limit := t.Config.IdleLimit
+		if t.Position != 0 {
+			limit = t.Config.ActiveLimit
+		}
+		if t.sinceUpdate >= limit {
+			if t.Position != 0 {
+				sig.Exit, sig.ExitReason = t.Position, "limit"
+				t.Position = 0
+			}
+			t.sinceUpdate = 0
+			w.ExpireTrendlines()
+			t.recompute()
+			sig.Recalibrated = true
+		}
+	}
+
+	// A confirmed retest enters in its direction, reversing out of an opposite position if needed
+	for _, r := range retests {
+		if sig.Entry != 0 || t.Position == r.Direction {
+			continue
+		}
+		if t.Position != 0 {
+			sig.Exit, sig.ExitReason = t.Position, "retest"
+		}
+		sig.Entry, sig.EntryReason, sig.EntryStop = r.Direction, "retest", r.Invalidation
+		t.Position = r.Direction
+		t.sinceUpdate = 0
+		t.Retest.Reset()
+	}
+	return sig
+}
+
+// Intrabar checks a candle that has not closed yet, so a breakout can be acted on straight away instead of waiting for the close
+// Only the first breakout of each candle is acted on, Step still has to be called with the final candle to update the window
+// The ADX values should be the ones from the last final candle since the ADX is only calculated on closes
+func (t *Trader[C]) Intrabar(candle C, adx, plusDI, minusDI float64, allowEntry bool) (TradeSignal, bool) {
+	c := candle.CandleStick()
+	if t.intrabar == c.OpenTime {
+		return TradeSignal{}, false
+	}
+	sig := TradeSignal{OpenTime: c.OpenTime, ResLine: t.Window.ResLine, SupLine: t.Window.SupLine, Intrabar: true}
+	pos := t.Position
+	if !t.decide(candle, adx, plusDI, minusDI, allowEntry, &sig) {
+		// No breakout, but the ADX exit may still have fired, that is left for the close so undo it
+		t.Position = pos
+		return TradeSignal{}, false
+	}
+	t.intrabar = c.OpenTime
+	return sig, true
+}
+
+// ResetPosition is for when the position was closed by something other than the trader (e.g. a stop-loss, see exits.go)
+func (t *Trader[C]) ResetPosition() {
+	t.Position = 0
+}
+
+func (t *Trader[C]) Snapshot() TraderState {
+	return TraderState{
+		Position:    t.Position,
+		SinceUpdate: t.sinceUpdate,
+		Intrabar:    t.intrabar,
+		ResLine:     t.Window.ResLine,
+		SupLine:     t.Window.SupLine,
+	}
+}
+
+// Restore puts the trader back to a snapshot, the window has to be refilled separately
+func (t *Trader[C]) Restore(s TraderState) {
+	t.Position = s.Position
+	t.sinceUpdate = s.SinceUpdate
+	t.intrabar = s.Intrabar
+	t.Window.ResLine = s.ResLine
+	t.Window.SupLine = s.SupLine
+	// Carry on the line IDs from the restored lines so new lines never reuse them
+	t.Window.lineIDs = max(t.Window.lineIDs, s.ResLine.ID, s.SupLine.ID)
+}
@@ -0,0 +1,324 @@
+package bot
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// CreateResLine/CreateSupLine draw a line through the extreme of the window and the most recent candle, so the lines often only have two touches
+// This is a second way of finding trendlines which looks for lines that the price has actually respected several times
+// It works as follows
+// First the swing highs/lows in the window are found (fractals)
+// Every pair of swing points is then a candidate line, the touches and violations of each candidate are counted
+// Optionally the line is then refit through its touch points (least squares or RANSAC)
+// The candidates are then scored and returned best first
+// The touches are allowed to be within the tolerance of the line either side, but the breakout check (CheckTrendlines2) has no tolerance
+// So the line that is returned is shifted out onto the envelope of the candles within the tolerance, then none of them are through it
+// (the violations are left out of that, they are already counted against the line)
+
+type ToleranceMode int
+
+const (
+	TolerancePercent ToleranceMode = iota // Touch tolerance is a fraction of the line's price
+	ToleranceATR                          // Touch tolerance is a multiple of the ATR over the window
+)
+
+type RefitMethod int
+
+const (
+	RefitNone RefitMethod = iota
+	RefitLeastSquares
+	RefitRANSAC
+)
+
+type TrendFitConfig struct {
+	SwingStrength    int // Number of candles either side a swing high/low has to be above/below
+	ToleranceMode    ToleranceMode
+	Tolerance        float64 // Fraction of price (e.g. 0.001 = 0.1%) or ATR multiple depending on the mode
+	MinTouches       int
+	MaxViolations    int     // Candidates with more violations than this are thrown out
+	ViolationPenalty float64 // Score taken off for each violation
+	SpanWeight       float64 // Score added for the line covering more of the window (span/window size * weight)
+	EnforceSlope     bool    // Resistance lines must slope down and support lines up (same rule as CreateResLine/CreateSupLine)
+	Refit            RefitMethod
+	RANSACIterations int
+	MaxCandidates    int
+	Seed             int64 // Seed for RANSAC so the results are reproducible
+}
+
+func DefaultTrendFitConfig() TrendFitConfig {
+	return TrendFitConfig{
+		SwingStrength:    2,
+		ToleranceMode:    ToleranceATR,
+		Tolerance:        0.25,
+		MinTouches:       3,
+		MaxViolations:    1,
+		ViolationPenalty: 1.5,
+		SpanWeight:       1,
+		EnforceSlope:     true,
+		Refit:            RefitLeastSquares,
+		RANSACIterations: 50,
+		MaxCandidates:    5,
+	}
+}
+
+type ScoredTrendline struct {
+	Line       models.Trendline
+	IsResist   bool
+	Touches    int
+	Violations int
+	Score      float64
+	TouchPos   []int // Positions in the window of the touching swing points
+}
+
+// Finds the swing highs in a set of candles, a swing high is higher than the k candles either side of it
+// Ties with the candles before are allowed so that a flat top still gives one swing point
+func SwingHighs(bars []models.CandleStick, k int) []int {
+	var swings []int
+	for i := k; i < len(bars)-k; i++ {
+		isSwing := true
+		for j := 1; j <= k && isSwing; j++ {
+			if bars[i-j].High > bars[i].High || bars[i+j].High >= bars[i].High {
+				isSwing = false
+			}
+		}
+		if isSwing {
+			swings = append(swings, i)
+		}
+	}
+	return swings
+}
+
+func SwingLows(bars []models.CandleStick, k int) []int {
+	var swings []int
+	for i := k; i < len(bars)-k; i++ {
+		isSwing := true
+		for j := 1; j <= k && isSwing; j++ {
+			if bars[i-j].Low < bars[i].Low || bars[i+j].Low <= bars[i].Low {
+				isSwing = false
+			}
+		}
+		if isSwing {
+			swings = append(swings, i)
+		}
+	}
+	return swings
+}
+
+// Simple average true range over a set of candles, used for the ATR tolerance
+func averageTrueRange(bars []models.CandleStick) float64 {
+	if len(bars) < 2 {
+		return 0
+	}
+	var total float64
+	for i := 1; i < len(bars); i++ {
+		total += trueRange(bars[i], bars[i-1])
+	}
+	return total / float64(len(bars)-1)
+}
+
+// Finds the multi-touch trendlines in the window, best scoring first
+func (w *Window[C]) FindTrendlines(isResist bool, cfg TrendFitConfig) []ScoredTrendline {
+	n := w.Len()
+	bars := make([]models.CandleStick, n)
+	xs := make([]float64, n)
+	for i := 0; i < n; i++ {
+		bars[i] = w.Bar(i)
+		xs[i] = w.X(i)
+	}
+	return fitTrendlines(bars, xs, isResist, cfg)
+}
+
+// This does the actual work on plain slices so it is independent of the window
+func fitTrendlines(bars []models.CandleStick, xs []float64, isResist bool, cfg TrendFitConfig) []ScoredTrendline {
+	price := func(i int) float64 {
+		if isResist {
+			return bars[i].High
+		}
+		return bars[i].Low
+	}
+	var swings []int
+	if isResist {
+		swings = SwingHighs(bars, cfg.SwingStrength)
+	} else {
+		swings = SwingLows(bars, cfg.SwingStrength)
+	}
+	atr := averageTrueRange(bars)
+	tolerance := func(linePrice float64) float64 {
+		if cfg.ToleranceMode == ToleranceATR {
+			return cfg.Tolerance * atr
+		}
+		return cfg.Tolerance * linePrice
+	}
+
+	// Scores a line which starts at the swing point at position first
+	evaluate := func(gradient, intercept float64, first int) (ScoredTrendline, bool) {
+		if cfg.EnforceSlope && ((isResist && gradient >= 0) || (!isResist && gradient <= 0)) {
+			return ScoredTrendline{}, false
+		}
+		s := ScoredTrendline{IsResist: isResist}
+
+		// Touches are swing points close to the line
+		for _, p := range swings {
+			if p < first {
+				continue
+			}
+			y := gradient*xs[p] + intercept
+			if math.Abs(price(p)-y) <= tolerance(y) {
+				s.TouchPos = append(s.TouchPos, p)
+			}
+		}
+		s.Touches = len(s.TouchPos)
+		if s.Touches < cfg.MinTouches || s.Touches < 2 {
+			return ScoredTrendline{}, false
+		}
+
+		// Violations are any candles after the start of the line which went through it by more than the tolerance
+		// The furthest any other candle goes through the line is how far it has to be shifted out
+		var shift float64
+		for i := first; i < len(bars); i++ {
+			y := gradient*xs[i] + intercept
+			through := price(i) - y
+			if !isResist {
+				through = y - price(i)
+			}
+			if through > tolerance(y) {
+				s.Violations++
+			} else if through > shift {
+				shift = through
+			}
+		}
+		if s.Violations > cfg.MaxViolations {
+			return ScoredTrendline{}, false
+		}
+
+		firstTouch, lastTouch := s.TouchPos[0], s.TouchPos[len(s.TouchPos)-1]
+		span := float64(lastTouch-firstTouch) / float64(len(bars))
+		s.Score = float64(s.Touches) + cfg.SpanWeight*span - cfg.ViolationPenalty*float64(s.Violations)
+		// A hair more (1e-9 of the price) so float rounding does not leave the candle that set the envelope just through the line
+		shift += 1e-9 * math.Abs(price(first))
+		if !isResist {
+			shift = -shift
+		}
+		s.Line = models.Trendline{
+			Gradient:  gradient,
+			Intercept: intercept + shift,
+			A1Time:    bars[firstTouch].OpenTime,
+			A2Time:    bars[lastTouch].OpenTime,
+			A1Price:   price(firstTouch),
+			A2Price:   price(lastTouch),
+		}
+		return s, true
+	}
+
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	var candidates []ScoredTrendline
+	for a := 0; a < len(swings); a++ {
+		for b := a + 1; b < len(swings); b++ {
+			p1, p2 := swings[a], swings[b]
+			if xs[p1] == xs[p2] {
+				continue
+			}
+			gradient := (price(p2) - price(p1)) / (xs[p2] - xs[p1])
+			intercept := price(p1) - gradient*xs[p1]
+			cand, ok := evaluate(gradient, intercept, p1)
+			if !ok {
+				continue
+			}
+
+			// Refit the line through its touch points, only keep the refit if it scores at least as well
+			if cfg.Refit != RefitNone {
+				var g, c float64
+				var fitted bool
+				if cfg.Refit == RefitRANSAC {
+					g, c, fitted = ransacLine(cand.TouchPos, xs, price, tolerance, cfg.RANSACIterations, rng)
+				} else {
+					g, c, fitted = leastSquaresLine(cand.TouchPos, xs, price)
+				}
+				if fitted {
+					if refit, ok := evaluate(g, c, p1); ok && refit.Score >= cand.Score {
+						cand = refit
+					}
+				}
+			}
+			candidates = append(candidates, cand)
+		}
+	}
+
+	// Best first, then throw out near duplicates (lines that share the same touches)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+	var ranked []ScoredTrendline
+	for _, cand := range candidates {
+		duplicate := false
+		for _, r := range ranked {
+			if cand.TouchPos[0] == r.TouchPos[0] && cand.TouchPos[len(cand.TouchPos)-1] == r.TouchPos[len(r.TouchPos)-1] {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			continue
+		}
+		ranked = append(ranked, cand)
+		if cfg.MaxCandidates > 0 && len(ranked) >= cfg.MaxCandidates {
+			break
+		}
+	}
+	return ranked
+}
+
+// Least squares line through the given points
+func leastSquaresLine(points []int, xs []float64, price func(int) float64) (gradient, intercept float64, ok bool) {
+	n := float64(len(points))
+	if n < 2 {
+		return 0, 0, false
+	}
+	var sx, sy, sxx, sxy float64
+	for _, p := range points {
+		x, y := xs[p], price(p)
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+	}
+	denom := n*sxx - sx*sx
+	if denom == 0 {
+		return 0, 0, false
+	}
+	gradient = (n*sxy - sx*sy) / denom
+	intercept = (sy - gradient*sx) / n
+	return gradient, intercept, true
+}
+
+// RANSAC picks random pairs of points and keeps the line with the most inliers, then does least squares on those inliers
+// This stops a single touch point which is slightly off from dragging the whole line
+func ransacLine(points []int, xs []float64, price func(int) float64, tolerance func(float64) float64, iterations int, rng *rand.Rand) (float64, float64, bool) {
+	if len(points) < 2 {
+		return 0, 0, false
+	}
+	var best []int
+	for it := 0; it < iterations; it++ {
+		a, b := points[rng.Intn(len(points))], points[rng.Intn(len(points))]
+		if xs[a] == xs[b] {
+			continue
+		}
+		g := (price(b) - price(a)) / (xs[b] - xs[a])
+		c := price(a) - g*xs[a]
+		var inliers []int
+		for _, p := range points {
+			y := g*xs[p] + c
+			if math.Abs(price(p)-y) <= tolerance(y) {
+				inliers = append(inliers, p)
+			}
+		}
+		if len(inliers) > len(best) {
+			best = inliers
+		}
+	}
+	return leastSquaresLine(best, xs, price)
+}
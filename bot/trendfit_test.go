@@ -0,0 +1,40 @@
+package bot
+
+import (
+	"testing"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// A fitted line must not count as broken by its own touch candles, so apart from the violations none of the candles since its start can be through it
+func TestFitTrendlinesEnvelope(t *testing.T) {
+	cfg := DefaultTrendFitConfig()
+	walk := randomWalk(5000, 7)
+	checked := 0
+	for start := 0; start+200 <= len(walk); start += 200 {
+		bars := make([]models.CandleStick, 200)
+		xs := make([]float64, 200)
+		for i := range bars {
+			bars[i] = walk[start+i].CandleStick()
+			xs[i] = float64(i)
+		}
+		for _, isResist := range []bool{true, false} {
+			for _, line := range fitTrendlines(bars, xs, isResist, cfg) {
+				through := 0
+				for i := line.TouchPos[0]; i < len(bars); i++ {
+					y := LineAt(line.Line, xs[i])
+					if (isResist && bars[i].High > y) || (!isResist && bars[i].Low < y) {
+						through++
+					}
+				}
+				if through > line.Violations {
+					t.Fatalf("window %d resist=%v: %d candles through the line but only %d violations", start, isResist, through, line.Violations)
+				}
+				checked++
+			}
+		}
+	}
+	if checked == 0 {
+		t.Fatal("no lines were fitted")
+	}
+}
@@ -11,405 +11,111 @@ import (
 // First an initial trendline is generated on the sliding window using CreateResLine() or CreateSupLine
 // The line created will be dictated by direction of trend (ie +DI or -DI)
 // They will use CheckTrendline1 to ensure no past violations of the trendline
-// Now that the trendline is active, any new candles will be assessed individually to look for breakout using CheckTrenlines2 fuction
+// Now that the trendline is active, any new candles will be assessed individually to look for breakout using CheckTrendlines2 fuction
 // Once a breakout is detected, new trendline will be created (ie support line -> resistance line)
 
+// These are all defined on the generic Window so the live bot and the training run share the exact same algorithm
+// The x coordinate of each candle comes from the window's x-axis (bar index, seconds since anchor or OpenTime)
+
 // I named this CheckTrendline1 since it is going to check for a breakout in trendline over entire window, this is for trendline generation
-func (sw *SlidingWindow) CheckTrendline1(t models.Trendline, startIdx, endIdx int, isResist bool) int {
+func (w *Window[C]) CheckTrendline1(t models.Trendline, startIdx, endIdx int, isResist bool) int {
 	// First guard against edge case
 	if startIdx >= endIdx {
 		return -1
 	}
 
-	// Now define the anchor point for normalising.
-	//anchor1 := sw.Candles[startIdx]
+	// Work with the ring buffer slots directly, going backwards from the slot of endIdx-1 and wrapping round at 0
+	slot := (w.start + endIdx - 1) % w.Size
 	for i := endIdx - 1; i >= startIdx; i-- {
-		candle := sw.Candles[i]
-
-		// Can use OpenTime as x axis
-		x := float64(candle.OpenTime)
-
 		// Can now get the expected value of the price
-		expected := t.Gradient*x + t.Intercept
+		expected := t.Gradient*w.xs[slot] + t.Intercept
 
 		if isResist {
-			price := candle.High
-			if price > expected {
+			if w.highs[slot] > expected {
 				return i
 			}
 		} else {
-			price := candle.Low
-			if price < expected {
+			if w.lows[slot] < expected {
 				return i
 			}
 		}
-	}
-	return -1
-}
 
-// Next need some helper functions to find the max high or max low, it will output the index of this candle
-func (sw *SlidingWindow) MaxHigh() int {
-	maxIdx := -1
-	maxHigh := -1.0
-	for i := 0; i < len(sw.Candles); i++ {
-		if sw.Candles[i].High > maxHigh {
-			maxHigh = sw.Candles[i].High
-			maxIdx = i
+		slot--
+		if slot < 0 {
+			slot = w.Size - 1
 		}
 	}
-	return maxIdx
+	return -1
 }
 
-func (sw *SlidingWindow) MinLow() int {
-	minIdx := -1
-	minLow := 10000000000000000000000.0
-	for i := 0; i < len(sw.Candles); i++ {
-		if sw.Candles[i].Low < minLow {
-			minLow = sw.Candles[i].Low
-			minIdx = i
-		}
+// Next need some helper functions to find the max high or max low, it will output the position of this candle in the window
+// These are tracked as candles are pushed so there is no need to scan the window
+func (w *Window[C]) MaxHigh() int {
+	if w.maxQ.empty() {
+		return -1
 	}
-	return minIdx
+	return w.maxQ.front() - (w.nextIdx - w.count)
 }
 
-func (sw *SlidingWindow) CreateResLine() (models.Trendline, bool) {
-	maxIdx := sw.MaxHigh()
-	if maxIdx == -1 {
-		return models.Trendline{}, false
-	}
-	anchor1 := sw.Candles[maxIdx]
-	endIdx := len(sw.Candles) - 1 // Decided to use the most recent completed candle
-	anchor2 := sw.Candles[endIdx]
-
-	for {
-		// Cannot have identical timestamps
-		if anchor2.OpenTime == anchor1.OpenTime {
-			break
-		}
-
-		// Will get the required points to calculate equation of the line
-		x1 := float64(anchor1.OpenTime)
-		x2 := float64(anchor2.OpenTime)
-		y1 := anchor1.High
-		y2 := anchor2.High
-
-		// Can now calculate the gradient and intercept of the Resistance Line
-		gradient := (y2 - y1) / (x2 - x1)
-		intercept := y1 - gradient*x1
-
-		// Define the line
-		line := models.Trendline{
-			Gradient:  gradient,
-			Intercept: intercept,
-			A1Time:    anchor1.OpenTime,
-			A2Time:    anchor2.OpenTime,
-			A1Price:   y1,
-			A2Price:   y2,
-		}
-
-		// Now we can check for any breakouts on past candles and recalculate the line
-		breakoutIdx := sw.CheckTrendline1(line, maxIdx+1, endIdx, true)
-
-		// If no breakouts then the line is complete
-		if breakoutIdx == -1 {
-			return line, true
-		}
-
-		// Otherwise we shift anchor 2 to the position of the breakout
-		anchor2 = sw.Candles[breakoutIdx]
-		endIdx = breakoutIdx
+func (w *Window[C]) MinLow() int {
+	if w.minQ.empty() {
+		return -1
 	}
-
-	return models.Trendline{}, false
+	return w.minQ.front() - (w.nextIdx - w.count)
 }
 
-func (sw *SlidingWindow) CreateSupLine() (models.Trendline, bool) {
-	minIdx := sw.MinLow()
-	if minIdx == -1 {
-		return models.Trendline{}, false
-	}
-	anchor1 := sw.Candles[minIdx]
-	endIdx := len(sw.Candles) - 1 // Again, use the most recent completed candle
-	anchor2 := sw.Candles[endIdx]
-
-	for {
-		// Cannot have identical timestamps
-		if anchor2.OpenTime == anchor1.OpenTime {
-			break
-		}
-
-		// Will get the required points to calculate equation of the line
-		x1 := float64(anchor1.OpenTime)
-		x2 := float64(anchor2.OpenTime)
-		y1 := anchor1.Low
-		y2 := anchor2.Low
-
-		// Can now calculate the gradient and intercept of the Resistance Line
-		gradient := (y2 - y1) / (x2 - x1)
-		intercept := y1 - gradient*x1
-
-		// Define the line
-		line := models.Trendline{
-			Gradient:  gradient,
-			Intercept: intercept,
-			A1Time:    anchor1.OpenTime,
-			A2Time:    anchor2.OpenTime,
-			A1Price:   y1,
-			A2Price:   y2,
-		}
-
-		// Now we can check for any breakouts on past candles and recalculate the line
-		breakoutIdx := sw.CheckTrendline1(line, minIdx+1, endIdx, false)
-
-		// If no breakouts then the line is complete
-		if breakoutIdx == -1 {
-			return line, true
-		}
-
-		// Otherwise we shift anchor 2 to the position of the breakout
-		anchor2 = sw.Candles[breakoutIdx]
-		endIdx = breakoutIdx
-	}
-
-	return models.Trendline{}, false
+// The resistance line is anchored on the highest high, and the second anchor starts at the most recent candle
+// The line has to slope down from the first anchor, and no candle in between can break above it
+func (w *Window[C]) CreateResLine() (models.Trendline, bool) {
+	return w.createLine(w.MaxHigh(), true)
 }
 
-// Now create CheckTrendlines2 which will check 1 candle for a breakout, this will be called as each new candle arrives live.
-func (sw *SlidingWindow) CheckTrenlines2(line models.Trendline, candle models.CandleStick, isResist bool) bool {
-	// First get the OpenTime as the x coordinate, and trendline price as y coordinate
-	x2 := float64(candle.OpenTime)
-	y2 := line.Gradient*x2 + line.Intercept
-
-	// Can now break the logic into a break in either support or resitance trendlines
-	if isResist {
-		// For resistance trendline, breakout would be above the trendline price (since it is upper limit)
-		if candle.High > y2 {
-			return true
-		}
-	} else {
-		// For support trendline, breakout would be below the trendline price (since it is lower limit)
-		if candle.Low < y2 {
-			return true
-		}
-	}
-
-	// If no breakouts are detected can return false
-	return false
+// The support line is anchored on the lowest low and has to slope up, no candle in between can break below it
+func (w *Window[C]) CreateSupLine() (models.Trendline, bool) {
+	return w.createLine(w.MinLow(), false)
 }
 
-// This is the end of what will be used for live bot
-
-// Now need to basically duplicate everything for the historical simullation run to dev ML model
-
-// func (sw *SlidingWindowTrain) CheckTrendline1(t models.Trendline, startIdx, endIdx int, isResist bool) int {
-// 	// First guard against edge case
-// 	if startIdx >= endIdx {
-// 		return -1
-// 	}
-
-// 	// Now define the anchor point for normalising.
-// 	//anchor1 := sw.Candles[startIdx]
-// 	for i := endIdx - 1; i >= startIdx; i-- {
-// 		candle := sw.Candles[i]
-
-// 		// Can use OpenTime as x axis
-// 		x := float64(candle.OpenTime)
-
-// 		// Can now get the expected value of the price
-// 		expected := t.Gradient*x + t.Intercept
-
-// 		if isResist {
-// 			price := candle.High
-// 			if price > expected {
-// 				return i
-// 			}
-// 		} else {
-// 			price := candle.Low
-// 			if price < expected {
-// 				return i
-// 			}
-// 		}
-// 	}
-// 	return -1
-// }
-
-func (sw *SlidingWindowTrain) CheckTrendline1(t models.Trendline, startIdx, endIdx int, isResist bool) int {
-	// Guard against edge case
-	if startIdx >= endIdx {
-		return -1
+func (w *Window[C]) createLine(pos1 int, isResist bool) (models.Trendline, bool) {
+	if pos1 == -1 {
+		return models.Trendline{}, false
 	}
 
-	for i := endIdx - 1; i >= startIdx; i-- {
-		// Instead of OpenTime, use index from sw.Idxs
-		x := float64(sw.Idxs[i])
-
-		expected := t.Gradient*x + t.Intercept
-
+	// For the resistance line the anchors use the highs, for the support line the lows
+	price := func(c models.CandleStick) float64 {
 		if isResist {
-			if sw.Candles[i].High > expected {
-				return i
-			}
-		} else {
-			if sw.Candles[i].Low < expected {
-				return i
-			}
+			return c.High
 		}
+		return c.Low
 	}
-	return -1
-}
 
-func (sw *SlidingWindowTrain) MaxHigh() int {
-	maxIdx := -1
-	maxHigh := -1.0
-	for i := 0; i < len(sw.Candles); i++ {
-		if sw.Candles[i].High > maxHigh {
-			maxHigh = sw.Candles[i].High
-			maxIdx = i
-		}
-	}
-	return maxIdx
-}
-
-func (sw *SlidingWindowTrain) MinLow() int {
-	minIdx := -1
-	minLow := 10000000000000000000000.0
-	for i := 0; i < len(sw.Candles); i++ {
-		if sw.Candles[i].Low < minLow {
-			minLow = sw.Candles[i].Low
-			minIdx = i
-		}
-	}
-	return minIdx
-}
-
-func (sw *SlidingWindowTrain) CreateResLine() (models.Trendline, bool) {
-	maxIdx := sw.MaxHigh()
-	if maxIdx == -1 {
-		return models.Trendline{}, false
-	}
-	anchor1 := sw.Candles[maxIdx]
-	endIdx := len(sw.Candles) - 1 // Decided to use the most recent completed candle
-	anchor2 := sw.Candles[endIdx]
+	anchor1 := w.Bar(pos1)
+	endIdx := w.count - 1 // Decided to use the most recent completed candle
+	pos2 := endIdx
 
 	for {
-		// Find positions of anchor1 and anchor2 inside the window
-		pos1 := -1
-		pos2 := -1
-		for i, c := range sw.Candles {
-			if c.OpenTime == anchor1.OpenTime {
-				pos1 = i
-			}
-			if c.OpenTime == anchor2.OpenTime {
-				pos2 = i
-			}
-		}
-
-		// Will check to ensure that both of the candles are within the window
-		if pos1 == -1 || pos2 == -1 {
-			break
-		}
-
-		// Cannot have identical timestamps (Note: sw.Idxs[pos1] = index of anchor1 and sw.Idx[pos2] = index of anchor2)
-		if sw.Idxs[pos1] == sw.Idxs[pos2] {
+		// Cannot have identical x values (this also covers the case where the extreme is the most recent candle)
+		if pos2 <= pos1 {
 			break
 		}
+		anchor2 := w.Bar(pos2)
 
-		// Need to ensure that the line has negative gradient
-		if anchor2.High >= anchor1.High {
+		// Need to ensure that a resistance line has negative gradient and a support line has positive gradient
+		if (isResist && anchor2.High >= anchor1.High) || (!isResist && anchor2.Low <= anchor1.Low) {
 			// To guard against infinite loop, have to make sure to update the anchor2 before exiting the loop
-			if pos2-1 <= pos1 {
-				break // There are no more candles left to check
-			}
-			anchor2 = sw.Candles[pos2-1] // Can now update the 2nd anchor and skip to the next loop
+			pos2--
 			continue
 		}
 
 		// Will get the required points to calculate equation of the line
-		x1 := float64(sw.Idxs[pos1])
-		x2 := float64(sw.Idxs[pos2])
-		y1 := anchor1.High
-		y2 := anchor2.High
-
-		// Can now calculate the gradient and intercept of the Resistance Line
-		gradient := (y2 - y1) / (x2 - x1)
-		intercept := y1 - gradient*x1
-
-		// Define the line
-		line := models.Trendline{
-			Gradient:  gradient,
-			Intercept: intercept,
-			A1Time:    anchor1.OpenTime,
-			A2Time:    anchor2.OpenTime,
-			A1Price:   y1,
-			A2Price:   y2,
-		}
-
-		// Now we can check for any breakouts on past candles and recalculate the line
-		breakoutIdx := sw.CheckTrendline1(line, maxIdx+1, endIdx, true)
-
-		// If no breakouts then the line is complete
-		if breakoutIdx == -1 {
-			return line, true
-		}
-
-		// Otherwise we shift anchor 2 to the position of the breakout
-		anchor2 = sw.Candles[breakoutIdx]
-		endIdx = breakoutIdx
-	}
-
-	return models.Trendline{}, false
-}
-
-// Want to use index positions instead of OpenTime for the timestamp. this is because it would otherwise result in practically 0 gradient
-func (sw *SlidingWindowTrain) CreateSupLine() (models.Trendline, bool) {
-	minIdx := sw.MinLow()
-	if minIdx == -1 {
-		return models.Trendline{}, false
-	}
-	anchor1 := sw.Candles[minIdx]
-	endIdx := len(sw.Candles) - 1 // Again, use the most recent completed candle to start
-	anchor2 := sw.Candles[endIdx]
-
-	for {
-		// Find positions of anchor1 and anchor2 inside the window
-		pos1 := -1
-		pos2 := -1
-		for i, c := range sw.Candles {
-			if c.OpenTime == anchor1.OpenTime {
-				pos1 = i
-			}
-			if c.OpenTime == anchor2.OpenTime {
-				pos2 = i
-			}
-		}
-
-		// Will check to ensure that both of the candles are within the window
-		if pos1 == -1 || pos2 == -1 {
+		x1 := w.X(pos1)
+		x2 := w.X(pos2)
+		y1 := price(anchor1)
+		y2 := price(anchor2)
+		if x1 == x2 {
 			break
 		}
 
-		// Cannot have identical timestamps (Note: sw.Idxs[pos1] = index of anchor1 and sw.Idx[pos2] = index of anchor2)
-		if sw.Idxs[pos1] == sw.Idxs[pos2] {
-			break
-		}
-
-		// Need to ensure that the line has positive gradient
-		if anchor2.Low <= anchor1.Low {
-			// To guard against infinite loop, have to make sure to update the anchor2 before exiting the loop
-			if pos2-1 <= pos1 {
-				break // There are no more candles left to check
-			}
-			anchor2 = sw.Candles[pos2-1] // Can now update the 2nd anchor and skip to the next loop
-			continue
-		}
-
-		// Will get the required points to calculate equation of the line
-		x1 := float64(sw.Idxs[pos1])
-		x2 := float64(sw.Idxs[pos2])
-		y1 := anchor1.Low
-		y2 := anchor2.Low
-
-		// Can now calculate the gradient and intercept of the Resistance Line
+		// Can now calculate the gradient and intercept of the line
 		gradient := (y2 - y1) / (x2 - x1)
 		intercept := y1 - gradient*x1
 
@@ -424,7 +130,7 @@ func (sw *SlidingWindowTrain) CreateSupLine() (models.Trendline, bool) {
 		}
 
 		// Now we can check for any breakouts on past candles and recalculate the line
-		breakoutIdx := sw.CheckTrendline1(line, minIdx+1, endIdx, false)
+		breakoutIdx := w.CheckTrendline1(line, pos1+1, endIdx, isResist)
 
 		// If no breakouts then the line is complete
 		if breakoutIdx == -1 {
@@ -432,81 +138,66 @@ func (sw *SlidingWindowTrain) CreateSupLine() (models.Trendline, bool) {
 		}
 
 		// Otherwise we shift anchor 2 to the position of the breakout
-		anchor2 = sw.Candles[breakoutIdx]
+		pos2 = breakoutIdx
 		endIdx = breakoutIdx
 	}
 
 	return models.Trendline{}, false
 }
 
-// Now create CheckTrendlines2 which will check 1 candle for a breakout, this will be called as each new candle arrives live.
-// func (sw *SlidingWindowTrain) CheckTrenlines2(line models.Trendline, candle models.EnrichedCandle, isResist bool, currPos int) bool {
-// 	// First get the OpenTime as the x coordinate, and trendline price as y coordinate
-// 	x2 := float64(candle.OpenTime)
-// 	y2 := line.Gradient*x2 + line.Intercept
-
-// 	// Can now break the logic into a break in either support or resitance trendlines
-
-// 	// If not already in a trade, only need to do standard check
-// 	if currPos == 0 {
-// 		if isResist {
-// 			// For resistance trendline, breakout would be above the trendline price (since it is upper limit)
-// 			return candle.High > y2
-// 		} else {
-// 			// For support trendline, breakout would be below the trendline price (since it is lower limit)
-// 			return candle.Low < y2
-// 		}
-// 	}
-// 	// In a long → only support breaks matter
-// 	if currPos == 1 {
-// 		if !isResist {
-// 			return candle.Low < y2
-// 		}
-// 		return false
-// 	}
-// 	// In a short → only resistance breaks matter
-// 	if currPos == -1 {
-// 		if isResist {
-// 			return candle.High > y2
-// 		}
-// 		return false
-// 	}
-// 	// If no breakouts are detected can return false
-// 	return false
-// }
-
-func (sw *SlidingWindowTrain) CheckTrendlines2(line models.Trendline, candle models.EnrichedCandle, isResist bool, currPos int, nextIdx int) bool {
-	// This function is for the live candles being fed in, so we know that the Idx would end up being the one more than the last Idx in the window
-	x := float64(nextIdx)
-	y := line.Gradient*x + line.Intercept
+// Now create CheckTrendlines2 which will check 1 new candle for a breakout, this will be called as each new candle arrives.
+// The candle has not been pushed into the window yet, so it gets the next x value
+// If we are in a position only the breakouts which matter for that position are checked:
+// In a long (currPos = 1) only support breaks matter, and in a short (currPos = -1) only resistance breaks matter
+func (w *Window[C]) CheckTrendlines2(line models.Trendline, candle C, isResist bool, currPos int) bool {
+	y := LineAt(line, w.NextX(candle))
+	c := candle.CandleStick()
 
-	if currPos == 0 {
+	switch currPos {
+	case 1:
 		if isResist {
-			return candle.High > y
+			return false
 		}
-		return candle.Low < y
-	}
-	if currPos == 1 {
+	case -1:
 		if !isResist {
-			return candle.Low < y
+			return false
 		}
-		return false
 	}
-	if currPos == -1 {
-		if isResist {
-			return candle.High > y
-		}
-		return false
+
+	// For resistance trendline, breakout would be above the trendline price (since it is upper limit)
+	// For support trendline, breakout would be below the trendline price (since it is lower limit)
+	if isResist {
+		return c.High > y
 	}
-	return false
+	return c.Low < y
 }
 
 // Will be easier to use in the bot with an additional function to compute the two trendlines
-func (sw *SlidingWindowTrain) ComputeTrendlines() {
-	if line, ok := sw.CreateResLine(); ok {
-		sw.ResLine = line
+// When the window has a fit config the best scoring multi-touch lines are used, falling back to the two anchor lines if none are found
+// If no line can be drawn the previous line is kept
+func (w *Window[C]) ComputeTrendlines() {
+	var res, sup models.Trendline
+	var resFound, supFound bool
+	if w.Fit != nil {
+		if lines := w.FindTrendlines(true, *w.Fit); len(lines) > 0 {
+			res, resFound = lines[0].Line, true
+		}
+		if lines := w.FindTrendlines(false, *w.Fit); len(lines) > 0 {
+			sup, supFound = lines[0].Line, true
+		}
+	}
+	if !resFound {
+		res, resFound = w.CreateResLine()
+	}
+	if !supFound {
+		sup, supFound = w.CreateSupLine()
+	}
+
+	// setLine gives the new lines their IDs and publishes the lifecycle events
+	if resFound {
+		w.setLine(true, res)
 	}
-	if line, ok := sw.CreateSupLine(); ok {
-		sw.SupLine = line
+	if supFound {
+		w.setLine(false, sup)
 	}
 }
@@ -0,0 +1,185 @@
+package bot
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// This file holds the realised volatility estimators, these are for the volatility based trade logic and for position sizing
+// Each estimator is computed over a window of N candles and then annualised
+// Since crypto trades 24/7 there are 365 full days in a trading year rather than the usual 252
+
+type VolEstimator int
+
+const (
+	CloseToClose   VolEstimator = iota // Standard deviation of log returns, only uses the closes
+	Parkinson                          // Uses the high-low range, more efficient but ignores gaps and drift
+	GarmanKlass                        // Uses open, high, low and close, assumes no drift
+	RogersSatchell                     // Uses open, high, low and close, handles drift
+	YangZhang                          // Combines the open-to-close gap, close-to-open and Rogers-Satchell, handles both gaps and drift
+)
+
+func (e VolEstimator) String() string {
+	switch e {
+	case CloseToClose:
+		return "close-to-close"
+	case Parkinson:
+		return "parkinson"
+	case GarmanKlass:
+		return "garman-klass"
+	case RogersSatchell:
+		return "rogers-satchell"
+	case YangZhang:
+		return "yang-zhang"
+	}
+	return "unknown"
+}
+
+// Need to be able to turn Binance style intervals ("1m", "15m", "4h", "1d", "1w") into a duration for annualising
+func IntervalDuration(interval string) (time.Duration, error) {
+	if len(interval) < 2 {
+		return 0, fmt.Errorf("invalid interval: %q", interval)
+	}
+	n, err := strconv.Atoi(interval[:len(interval)-1])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid interval: %q", interval)
+	}
+
+	var unit time.Duration
+	switch interval[len(interval)-1] {
+	case 's':
+		unit = time.Second
+	case 'm':
+		unit = time.Minute
+	case 'h':
+		unit = time.Hour
+	case 'd':
+		unit = 24 * time.Hour
+	case 'w':
+		unit = 7 * 24 * time.Hour
+	default:
+		return 0, fmt.Errorf("invalid interval unit: %q", interval)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// The annualisation factor is the sqrt of the number of bars in a (24/7) year
+func AnnualisationFactor(barInterval time.Duration) float64 {
+	year := 365 * 24 * time.Hour
+	return math.Sqrt(float64(year) / float64(barInterval))
+}
+
+// Helper function for the sample variance (uses N-1 since it is an estimate)
+func sampleVariance(data []float64) float64 {
+	if len(data) < 2 {
+		return 0
+	}
+	mean := sum(data) / float64(len(data))
+	var ss float64
+	for _, v := range data {
+		ss += (v - mean) * (v - mean)
+	}
+	return ss / float64(len(data)-1)
+}
+
+// This computes the per-bar variance of the chosen estimator (not annualised)
+// Note that candles should have one more candle than the window, the first candle is only used for its close
+func estimateVariance(candles []models.CandleStick, est VolEstimator) float64 {
+	n := len(candles) - 1
+	if n < 2 {
+		return math.NaN()
+	}
+	window := candles[1:]
+
+	switch est {
+	case CloseToClose:
+		returns := make([]float64, n)
+		for i := 1; i <= n; i++ {
+			returns[i-1] = math.Log(candles[i].Close / candles[i-1].Close)
+		}
+		return sampleVariance(returns)
+
+	case Parkinson:
+		var total float64
+		for _, c := range window {
+			hl := math.Log(c.High / c.Low)
+			total += hl * hl
+		}
+		return total / (4 * math.Ln2 * float64(n))
+
+	case GarmanKlass:
+		var total float64
+		for _, c := range window {
+			hl := math.Log(c.High / c.Low)
+			co := math.Log(c.Close / c.Open)
+			total += 0.5*hl*hl - (2*math.Ln2-1)*co*co
+		}
+		return total / float64(n)
+
+	case RogersSatchell:
+		return rogersSatchell(window)
+
+	case YangZhang:
+		// Overnight (close to open) and open to close returns, in crypto the "overnight" gap is just the gap between candles
+		overnight := make([]float64, n)
+		openClose := make([]float64, n)
+		for i := 1; i <= n; i++ {
+			overnight[i-1] = math.Log(candles[i].Open / candles[i-1].Close)
+			openClose[i-1] = math.Log(candles[i].Close / candles[i].Open)
+		}
+		k := 0.34 / (1.34 + float64(n+1)/float64(n-1))
+		return sampleVariance(overnight) + k*sampleVariance(openClose) + (1-k)*rogersSatchell(window)
+	}
+	return math.NaN()
+}
+
+func rogersSatchell(candles []models.CandleStick) float64 {
+	var total float64
+	for _, c := range candles {
+		total += math.Log(c.High/c.Close)*math.Log(c.High/c.Open) + math.Log(c.Low/c.Close)*math.Log(c.Low/c.Open)
+	}
+	return total / float64(len(candles))
+}
+
+// Batch version over a whole dataset, gives the annualised volatility of each candle over the previous window candles
+// Same as the other indicators, the first candles without enough history are flagged as NaN
+func RealisedVol(ds models.Dataset, est VolEstimator, window int, barInterval time.Duration) []float64 {
+	vols := make([]float64, len(ds.Candles))
+	factor := AnnualisationFactor(barInterval)
+	for i := range ds.Candles {
+		if i < window {
+			vols[i] = math.NaN()
+			continue
+		}
+		variance := estimateVariance(ds.Candles[i-window:i+1], est)
+		vols[i] = math.Sqrt(math.Max(variance, 0)) * factor
+	}
+	return vols
+}
+
+// Streaming version for the live channel, keeps the last window+1 candles
+type VolatilityCalculator struct {
+	Estimator VolEstimator
+	Window    int
+	Interval  time.Duration
+	candles   []models.CandleStick
+}
+
+func (v *VolatilityCalculator) Update(c models.CandleStick) (float64, bool) {
+	v.candles = append(v.candles, c)
+	if len(v.candles) > v.Window+1 {
+		v.candles = v.candles[1:]
+	}
+	if len(v.candles) < v.Window+1 {
+		return 0, false
+	}
+	variance := estimateVariance(v.candles, v.Estimator)
+	if math.IsNaN(variance) {
+		return 0, false
+	}
+	return math.Sqrt(math.Max(variance, 0)) * AnnualisationFactor(v.Interval), true
+}
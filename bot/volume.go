@@ -0,0 +1,298 @@
+package bot
+
+import (
+	"math"
+	"time"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// This file holds the volume based indicators (VWAP, anchored VWAP, OBV, MFI and CMF)
+// Same as techindic.go, each indicator has a batch version which runs over a slice of candles (historical data)
+// and a calculator struct with an Update method which can be fed each final candle from the live channel
+
+// Binance gives OpenTime in ms whilst the DEX Screener stream (FetchSOLUSDT) builds candles with OpenTime in seconds
+// Will use this helper so that anything time based works regardless of which source the candle came from (the other packages use it too)
+func OpenTimeMs(t int64) int64 {
+	if t < 1e12 { // 1e12 ms is in 2001, so anything smaller than this has to be in seconds
+		return t * 1000
+	}
+	return t
+}
+
+// Session VWAP resets at the start of each session, since crypto trades 24/7 I use the UTC day as the session
+func sessionStart(openTime int64) int64 {
+	ms := OpenTimeMs(openTime)
+	day := int64(24 * time.Hour / time.Millisecond)
+	return ms - (ms % day)
+}
+
+// Most of the volume indicators use the typical price instead of just the close
+func typicalPrice(c models.CandleStick) float64 {
+	return (c.High + c.Low + c.Close) / 3
+}
+
+// The VWAP and its standard deviation bands, the bands are VWAP +/- k*stdDev for each multiple given in the config
+type VWAPBands struct {
+	VWAP   float64
+	StdDev float64
+	Upper  []float64
+	Lower  []float64
+}
+
+// VWAPCalculator is used for both the session VWAP and the anchored VWAP
+// For the session VWAP leave Anchor as 0 and it will reset at the start of each UTC day
+// For anchored VWAP set Anchor to the OpenTime of the anchor candle (for example a trendline's A1Time)
+type VWAPCalculator struct {
+	Anchor      int64     // OpenTime of the anchor candle, 0 means session VWAP
+	Multipliers []float64 // Standard deviation multiples for the bands, (e.g. 1, 2)
+	session     int64
+	sumPV       float64 // Running sum of price*volume
+	sumV        float64 // Running sum of volume
+	sumP2V      float64 // Running sum of price^2*volume, needed for the variance
+}
+
+func (v *VWAPCalculator) Update(c models.CandleStick) (VWAPBands, bool) {
+	if v.Anchor != 0 {
+		// Anything before the anchor does not count towards the anchored VWAP
+		if OpenTimeMs(c.OpenTime) < OpenTimeMs(v.Anchor) {
+			return VWAPBands{}, false
+		}
+	} else if s := sessionStart(c.OpenTime); s != v.session {
+		// New session so need to reset the running sums
+		v.session = s
+		v.sumPV, v.sumV, v.sumP2V = 0, 0, 0
+	}
+
+	tp := typicalPrice(c)
+	v.sumPV += tp * c.Volume
+	v.sumV += c.Volume
+	v.sumP2V += tp * tp * c.Volume
+
+	// Guard against divide by 0, which can happen at the start of a session if there has been no volume
+	if v.sumV == 0 {
+		return VWAPBands{}, false
+	}
+
+	vwap := v.sumPV / v.sumV
+	variance := v.sumP2V/v.sumV - vwap*vwap
+	if variance < 0 { // Can go marginally negative due to floating point errors
+		variance = 0
+	}
+	stdDev := math.Sqrt(variance)
+
+	bands := VWAPBands{
+		VWAP:   vwap,
+		StdDev: stdDev,
+		Upper:  make([]float64, len(v.Multipliers)),
+		Lower:  make([]float64, len(v.Multipliers)),
+	}
+	for i, k := range v.Multipliers {
+		bands.Upper[i] = vwap + k*stdDev
+		bands.Lower[i] = vwap - k*stdDev
+	}
+	return bands, true
+}
+
+// Batch version of the session VWAP over historical candles, the bool slice flags which entries are valid
+func CalculateVWAP(candles []models.CandleStick, multipliers []float64) ([]VWAPBands, []bool) {
+	calc := VWAPCalculator{Multipliers: multipliers}
+	return runVWAP(&calc, candles)
+}
+
+// Batch version of the anchored VWAP, candles before the anchor are flagged invalid
+func CalculateAnchoredVWAP(candles []models.CandleStick, anchor int64, multipliers []float64) ([]VWAPBands, []bool) {
+	calc := VWAPCalculator{Anchor: anchor, Multipliers: multipliers}
+	return runVWAP(&calc, candles)
+}
+
+func runVWAP(calc *VWAPCalculator, candles []models.CandleStick) ([]VWAPBands, []bool) {
+	bands := make([]VWAPBands, len(candles))
+	valid := make([]bool, len(candles))
+	for i, c := range candles {
+		bands[i], valid[i] = calc.Update(c)
+	}
+	return bands, valid
+}
+
+// On-Balance Volume, adds the volume on up closes and subtracts it on down closes
+type OBVCalculator struct {
+	OBV       float64
+	PrevClose float64
+	started   bool
+}
+
+func (o *OBVCalculator) Update(c models.CandleStick) float64 {
+	// The first candle has nothing to compare against so OBV just starts at 0
+	if !o.started {
+		o.started = true
+		o.PrevClose = c.Close
+		return o.OBV
+	}
+
+	if c.Close > o.PrevClose {
+		o.OBV += c.Volume
+	} else if c.Close < o.PrevClose {
+		o.OBV -= c.Volume
+	}
+	o.PrevClose = c.Close
+	return o.OBV
+}
+
+func CalculateOBV(candles []models.CandleStick) []float64 {
+	var calc OBVCalculator
+	obv := make([]float64, len(candles))
+	for i, c := range candles {
+		obv[i] = calc.Update(c)
+	}
+	return obv
+}
+
+// Money Flow Index, basically a volume weighted RSI over the typical price
+// Keeps the last N positive/negative money flows so that the sums can be updated as the window slides
+type MFICalculator struct {
+	Period  int
+	prevTP  float64
+	started bool
+	posFlow []float64
+	negFlow []float64
+	sumPos  float64
+	sumNeg  float64
+}
+
+func (m *MFICalculator) Update(c models.CandleStick) (float64, bool) {
+	tp := typicalPrice(c)
+	if !m.started {
+		m.started = true
+		m.prevTP = tp
+		return 0, false
+	}
+
+	// Raw money flow goes to the positive or negative side depending on the typical price direction
+	flow := tp * c.Volume
+	var pos, neg float64
+	if tp > m.prevTP {
+		pos = flow
+	} else if tp < m.prevTP {
+		neg = flow
+	}
+	m.prevTP = tp
+
+	m.posFlow = append(m.posFlow, pos)
+	m.negFlow = append(m.negFlow, neg)
+	m.sumPos += pos
+	m.sumNeg += neg
+
+	// Drop the oldest flow once we have more than the period
+	if len(m.posFlow) > m.Period {
+		m.sumPos -= m.posFlow[0]
+		m.sumNeg -= m.negFlow[0]
+		m.posFlow = m.posFlow[1:]
+		m.negFlow = m.negFlow[1:]
+	}
+	if len(m.posFlow) < m.Period {
+		return 0, false
+	}
+
+	// If there was no negative flow at all over the period the MFI is at its max
+	if m.sumNeg <= 0 {
+		return 100, true
+	}
+	ratio := m.sumPos / m.sumNeg
+	return 100 - 100/(1+ratio), true
+}
+
+// Like with EMA, the first N candles can not have a value so they are flagged as NaN
+func CalculateMFI(candles []models.CandleStick, period int) []float64 {
+	calc := MFICalculator{Period: period}
+	mfi := make([]float64, len(candles))
+	for i, c := range candles {
+		val, ok := calc.Update(c)
+		if !ok {
+			val = math.NaN()
+		}
+		mfi[i] = val
+	}
+	return mfi
+}
+
+// Chaikin Money Flow, the sum of money flow volume over the sum of volume for the last N candles
+type CMFCalculator struct {
+	Period  int
+	mfv     []float64
+	vols    []float64
+	sumMFV  float64
+	sumVols float64
+}
+
+func (m *CMFCalculator) Update(c models.CandleStick) (float64, bool) {
+	// The money flow multiplier is where the close sits within the candle's range, from -1 (at the low) to 1 (at the high)
+	var multiplier float64
+	if rng := c.High - c.Low; rng > 0 {
+		multiplier = ((c.Close - c.Low) - (c.High - c.Close)) / rng
+	}
+	mfv := multiplier * c.Volume
+
+	m.mfv = append(m.mfv, mfv)
+	m.vols = append(m.vols, c.Volume)
+	m.sumMFV += mfv
+	m.sumVols += c.Volume
+
+	if len(m.mfv) > m.Period {
+		m.sumMFV -= m.mfv[0]
+		m.sumVols -= m.vols[0]
+		m.mfv = m.mfv[1:]
+		m.vols = m.vols[1:]
+	}
+	if len(m.mfv) < m.Period || m.sumVols == 0 {
+		return 0, false
+	}
+	return m.sumMFV / m.sumVols, true
+}
+
+func CalculateCMF(candles []models.CandleStick, period int) []float64 {
+	calc := CMFCalculator{Period: period}
+	cmf := make([]float64, len(candles))
+	for i, c := range candles {
+		val, ok := calc.Update(c)
+		if !ok {
+			val = math.NaN()
+		}
+		cmf[i] = val
+	}
+	return cmf
+}
+
+// To make it easier to use on the live channel, will wrap all of the volume indicators into one struct
+type VolumeIndicators struct {
+	VWAP VWAPBands
+	OBV  float64
+	MFI  float64
+	CMF  float64
+}
+
+type VolumeCalculator struct {
+	Session VWAPCalculator
+	OBVCalc OBVCalculator
+	MFICalc MFICalculator
+	CMFCalc CMFCalculator
+}
+
+func NewVolumeCalculator(period int, multipliers []float64) *VolumeCalculator {
+	return &VolumeCalculator{
+		Session: VWAPCalculator{Multipliers: multipliers},
+		MFICalc: MFICalculator{Period: period},
+		CMFCalc: CMFCalculator{Period: period},
+	}
+}
+
+// Returns false until every indicator has enough candles to be valid
+func (v *VolumeCalculator) Update(c models.CandleStick) (VolumeIndicators, bool) {
+	var out VolumeIndicators
+	var ok1, ok2, ok3 bool
+	out.VWAP, ok1 = v.Session.Update(c)
+	out.OBV = v.OBVCalc.Update(c)
+	out.MFI, ok2 = v.MFICalc.Update(c)
+	out.CMF, ok3 = v.CMFCalc.Update(c)
+	return out, ok1 && ok2 && ok3
+}
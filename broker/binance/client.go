@@ -0,0 +1,212 @@
+package binance // Execution on Binance, kept apart from the broker package since it is only needed for live trading
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256" // Binance signs the requests with HMAC-SHA256
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// REST client for the Binance spot and USDⓈ-M futures APIs
+// The private endpoints are signed with HMAC-SHA256 over the query string (all the params are sent in the query, Binance accepts that for every method)
+// The local clock can drift from Binance's, so the offset is synced from the server time and the request is retried once on a -1021 error
+// BaseURL and WSURL can be pointed at the mock exchange (see mock.go) to try things out without an account
+
+type Market string
+
+const (
+	Spot    Market = "spot"
+	Futures Market = "futures" // USDⓈ-M perpetual futures
+)
+
+// The endpoints differ between the two markets, the rest of the API is mostly the same
+type endpoints struct {
+	time, exchangeInfo, order, account, ticker, listenKey string
+}
+
+var paths = map[Market]endpoints{
+	Spot: {
+		time:         "/api/v3/time",
+		exchangeInfo: "/api/v3/exchangeInfo",
+		order:        "/api/v3/order",
+		account:      "/api/v3/account",
+		ticker:       "/api/v3/ticker/price",
+		listenKey:    "/api/v3/userDataStream",
+	},
+	Futures: {
+		time:         "/fapi/v1/time",
+		exchangeInfo: "/fapi/v1/exchangeInfo",
+		order:        "/fapi/v1/order",
+		account:      "/fapi/v2/account",
+		ticker:       "/fapi/v1/ticker/price",
+		listenKey:    "/fapi/v1/listenKey",
+	},
+}
+
+// Some of the error codes worth handling, the full list is in the Binance API docs
+const (
+	ErrDisconnected     = -1001
+	ErrTooManyRequests  = -1003
+	ErrTimestamp        = -1021 // Timestamp outside the recvWindow
+	ErrSignature        = -1022
+	ErrMandatoryParam   = -1102
+	ErrFilterFailure    = -1013
+	ErrPrecision        = -1111
+	ErrNewOrderRejected = -2010 // Spot: insufficient balance, duplicate client order ID, ...
+	ErrCancelRejected   = -2011
+	ErrNoSuchOrder      = -2013
+	ErrAPIKey           = -2015
+	ErrMarginShort      = -2019 // Futures: not enough margin
+	ErrReduceOnly       = -2022
+	ErrMinNotional      = -4164 // Futures: notional under the minimum
+	ErrDuplicateClient  = -4116 // Futures: client order ID already used
+)
+
+type APIError struct {
+	Status int    `json:"-"` // HTTP status
+	Code   int    `json:"code"`
+	Msg    string `json:"msg"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("binance error %d: %s (HTTP %d)", e.Code, e.Msg, e.Status)
+}
+
+type Client struct {
+	Market     Market
+	BaseURL    string
+	WSURL      string // Base of the user data stream, the listen key goes on the end
+	APIKey     string
+	Secret     string
+	RecvWindow int64 // ms the request is valid for after its timestamp
+	HTTP       *http.Client
+	offset     atomic.Int64 // Server time minus local time in ms
+}
+
+// The default URLs are the real exchange
+func NewClient(market Market, apiKey, secret string) *Client {
+	c := &Client{
+		Market:     market,
+		APIKey:     apiKey,
+		Secret:     secret,
+		RecvWindow: 5000,
+		HTTP:       &http.Client{Timeout: 10 * time.Second},
+	}
+	if market == Futures {
+		c.BaseURL, c.WSURL = "https://fapi.binance.com", "wss://fstream.binance.com/ws/"
+	} else {
+		c.BaseURL, c.WSURL = "https://api.binance.com", "wss://stream.binance.com:9443/ws/"
+	}
+	return c
+}
+
+func (c *Client) paths() endpoints {
+	return paths[c.Market]
+}
+
+func (c *Client) sign(query string) string {
+	mac := hmac.New(sha256.New, []byte(c.Secret))
+	mac.Write([]byte(query))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SyncTime sets the clock offset from the server time
+func (c *Client) SyncTime(ctx context.Context) error {
+	var resp struct {
+		ServerTime int64 `json:"serverTime"`
+	}
+	start := time.Now()
+	if err := c.do(ctx, http.MethodGet, c.paths().time, nil, false, false, &resp); err != nil {
+		return err
+	}
+	// The server time is taken as being half way through the request
+	local := start.Add(time.Since(start) / 2).UnixMilli()
+	c.offset.Store(resp.ServerTime - local)
+	return nil
+}
+
+// Sends the request and decodes the response into out, signed requests get the timestamp and signature added
+// keyed requests only need the API key header (e.g. the listen key endpoints)
+func (c *Client) do(ctx context.Context, method, path string, params url.Values, signed, keyed bool, out any) error {
+	err := c.send(ctx, method, path, params, signed, keyed, out)
+	if apiErr, ok := err.(*APIError); ok && apiErr.Code == ErrTimestamp && signed {
+		// The clock has drifted, resync and try once more
+		if err := c.SyncTime(ctx); err != nil {
+			return err
+		}
+		return c.send(ctx, method, path, params, signed, keyed, out)
+	}
+	return err
+}
+
+func (c *Client) send(ctx context.Context, method, path string, params url.Values, signed, keyed bool, out any) error {
+	query := url.Values{}
+	for k, v := range params {
+		query[k] = v
+	}
+	raw := query.Encode()
+	if signed {
+		query.Set("timestamp", strconv.FormatInt(time.Now().UnixMilli()+c.offset.Load(), 10))
+		if c.RecvWindow > 0 {
+			query.Set("recvWindow", strconv.FormatInt(c.RecvWindow, 10))
+		}
+		raw = query.Encode()
+		// The signature has to be the last param, since it is over everything before it
+		raw += "&signature=" + c.sign(raw)
+	}
+
+	u := c.BaseURL + path
+	if raw != "" {
+		u += "?" + raw
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return err
+	}
+	if signed || keyed {
+		req.Header.Set("X-MBX-APIKEY", c.APIKey)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		apiErr := &APIError{Status: resp.StatusCode}
+		if json.Unmarshal(body, apiErr) != nil || apiErr.Code == 0 {
+			apiErr.Msg = string(body)
+		}
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decoding %s: %w", path, err)
+	}
+	return nil
+}
+
+// Price gets the last price of the symbol
+func (c *Client) Price(ctx context.Context, symbol string) (float64, error) {
+	var resp struct {
+		Price string `json:"price"`
+	}
+	if err := c.do(ctx, http.MethodGet, c.paths().ticker, url.Values{"symbol": {symbol}}, false, false, &resp); err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(resp.Price, 64)
+}
@@ -0,0 +1,347 @@
+package binance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+	"github.com/Reece-Ogidih/CT-Bot/broker"
+)
+
+// Exchange is the Binance execution venue, it is a broker.Broker so it can go behind the OMS in place of the paper broker
+// The fills come from the user data stream and are sent on Fills (for the OMS to track), matched to the orders by the client order ID
+// If the stream reconnects, the open orders are queried over REST and any fills that were missed are sent then
+// The fees are converted to the quote asset when Binance charges them in the base asset, fees paid in other assets (e.g. BNB) are passed on as they are
+// Only an error response from Binance means an order was not placed, if the request failed in transport (or Binance answered that the
+// execution status is unknown) the order may still be live, so it is looked up by its client order ID until there is an answer
+
+type tracked struct {
+	order    models.Order
+	filled   float64
+	notional float64 // Sum of qty*price of the fills
+	done     bool
+}
+
+type Exchange struct {
+	Client  *Client
+	Fills   chan<- models.Fill
+	Quote   string // The asset the cash is held in on spot
+	mu      sync.Mutex
+	filters map[string]Filters
+	orders  map[string]*tracked // By client order ID
+	ids     map[int64]string    // Binance order ID to client order ID
+	seq     int64
+}
+
+// Syncs the clock and loads the symbol filters
+func NewExchange(ctx context.Context, client *Client) (*Exchange, error) {
+	if err := client.SyncTime(ctx); err != nil {
+		return nil, fmt.Errorf("syncing the time: %w", err)
+	}
+	filters, err := client.ExchangeInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting the exchange info: %w", err)
+	}
+	return &Exchange{
+		Client:  client,
+		Quote:   "USDT",
+		filters: filters,
+		orders:  make(map[string]*tracked),
+		ids:     make(map[int64]string),
+	}, nil
+}
+
+// Start listens on the user data stream until the context is cancelled, Fills is closed once the stream ends
+func (e *Exchange) Start(ctx context.Context) error {
+	updates, err := e.Client.UserStream(ctx, func() { e.reconcile(ctx) })
+	if err != nil {
+		return err
+	}
+	go func() {
+		for u := range updates {
+			e.onUpdate(u)
+		}
+		if e.Fills != nil {
+			close(e.Fills)
+		}
+	}()
+	return nil
+}
+
+func (e *Exchange) Filters(symbol string) (Filters, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	f, ok := e.filters[symbol]
+	return f, ok
+}
+
+// Rounds the quantity down to the lot size the same as PlaceOrder does (see broker.QtyRounder)
+func (e *Exchange) RoundQty(symbol string, qty float64) float64 {
+	if f, ok := e.Filters(symbol); ok {
+		return f.RoundQty(qty)
+	}
+	return qty
+}
+
+// Converts the fee to the quote asset where possible
+func (e *Exchange) fee(u OrderUpdate) float64 {
+	f, ok := e.filters[u.Symbol]
+	if ok && u.FeeAsset == f.BaseAsset {
+		return u.Fee * u.LastPrice
+	}
+	return u.Fee
+}
+
+// Records a fill against the order and fills in the order's details, must be called with the lock held
+func (e *Exchange) fill(t *tracked, f models.Fill) models.Fill {
+	t.filled += f.Qty
+	t.notional += f.Qty * f.Price
+	f.ClientID, f.Symbol, f.Side = t.order.ClientID, t.order.Symbol, t.order.Side
+	f.Type, f.Reason, f.Confidence = t.order.Type, t.order.Reason, t.order.Confidence
+	return f
+}
+
+// Sends a fill on Fills, must be called without the lock held since the OMS can cancel an order (e.g. the other side of an OCO) from it
+func (e *Exchange) send(f models.Fill) {
+	if e.Fills != nil {
+		e.Fills <- f
+	}
+}
+
+func (e *Exchange) onUpdate(u OrderUpdate) {
+	if f, ok := e.update(u); ok {
+		e.send(f)
+	}
+}
+
+// Applies the update to the tracked order, returns the fill if it was one
+func (e *Exchange) update(u OrderUpdate) (f models.Fill, filled bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	clientID, ok := e.ids[u.OrderID]
+	if !ok {
+		// The fill can arrive before PlaceOrder has returned the order ID
+		clientID = u.ClientID
+	}
+	t, ok := e.orders[clientID]
+	if !ok {
+		return f, false // Not one of ours (e.g. placed by hand on the website)
+	}
+	e.ids[u.OrderID] = clientID
+	if u.Exec == "TRADE" && u.LastQty > 0 {
+		f = e.fill(t, models.Fill{OrderID: u.OrderID, OpenTime: u.Time, Qty: u.LastQty, Price: u.LastPrice, Fee: e.fee(u), Maker: u.Maker})
+		filled = true
+	}
+	switch u.Status {
+	case "FILLED", "CANCELED", "REJECTED", "EXPIRED", "EXPIRED_IN_MATCH":
+		t.done = true
+	}
+	return f, filled
+}
+
+// Checks the open orders over REST after a reconnect, any fills missed while disconnected are sent at their average price
+// The fees of the missed fills are not in the order status, so they are left at 0
+func (e *Exchange) reconcile(ctx context.Context) {
+	e.mu.Lock()
+	var open []string
+	for id, t := range e.orders {
+		if !t.done {
+			open = append(open, id)
+		}
+	}
+	e.mu.Unlock()
+
+	for _, clientID := range open {
+		e.mu.Lock()
+		t := e.orders[clientID]
+		symbol := t.order.Symbol
+		e.mu.Unlock()
+		status, err := e.Client.QueryOrder(ctx, symbol, 0, clientID)
+		if err != nil {
+			log.Printf("Could not query order %s: %v", clientID, err)
+			continue
+		}
+
+		e.mu.Lock()
+		e.ids[status.OrderID] = clientID
+		var missedFill *models.Fill
+		if missed := status.Filled() - t.filled; missed > 1e-12 {
+			price := (status.Average()*status.Filled() - t.notional) / missed
+			log.Printf("Order %s: %.8f filled while the stream was down", clientID, missed)
+			f := e.fill(t, models.Fill{OrderID: status.OrderID, OpenTime: time.Now().UnixMilli(), Qty: missed, Price: price})
+			missedFill = &f
+		}
+		switch status.Status {
+		case "FILLED", "CANCELED", "REJECTED", "EXPIRED", "EXPIRED_IN_MATCH":
+			t.done = true
+		}
+		e.mu.Unlock()
+		if missedFill != nil {
+			e.send(*missedFill)
+		}
+	}
+}
+
+// How long to wait before each lookup of an order whose submit had no answer, the last one repeats until the context is done
+var resolveDelays = []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second, 5 * time.Second}
+
+// An error response from Binance is a definite reject, apart from the 5xx ones which say the execution status is unknown
+func rejected(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Status < 500
+}
+
+// Looks up an order whose submit had no answer, found is false if Binance says there is no such order
+// An error means there was still no answer when the context was done
+func (e *Exchange) resolve(ctx context.Context, symbol, clientID string) (status OrderStatus, found bool, err error) {
+	for i := 0; ; i++ {
+		select {
+		case <-ctx.Done():
+			return status, false, ctx.Err()
+		case <-time.After(resolveDelays[min(i, len(resolveDelays)-1)]):
+		}
+		status, err = e.Client.QueryOrder(ctx, symbol, 0, clientID)
+		if err == nil {
+			return status, true, nil
+		}
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.Code == ErrNoSuchOrder {
+			return status, false, nil
+		}
+		log.Printf("Could not look up order %s: %v", clientID, err)
+	}
+}
+
+// Submit places the order on Binance and returns the Binance order ID
+// If the submit had no answer the order is looked up until it is found or Binance says it does not exist, if the context ends first
+// it stays tracked (so its fills are still matched and reconcile checks it again after a reconnect) and the error is returned
+func (e *Exchange) Submit(ctx context.Context, o models.Order) (int64, error) {
+	f, ok := e.Filters(o.Symbol)
+	if !ok {
+		return 0, fmt.Errorf("unknown symbol %s", o.Symbol)
+	}
+	if o.Type == "" {
+		o.Type = "market"
+	}
+	var last float64
+	if o.Type == "market" {
+		// Only needed for the min notional check, the order can still go without it
+		var err error
+		if last, err = e.Client.Price(ctx, o.Symbol); err != nil {
+			log.Println("Could not get the price:", err)
+		}
+	}
+
+	// Anything the filters reject is never sent
+	if _, err := e.Client.orderParams(o, f, last); err != nil {
+		return 0, err
+	}
+
+	e.mu.Lock()
+	if o.ClientID == "" {
+		e.seq++
+		o.ClientID = fmt.Sprintf("ct-%d-%d", time.Now().UnixMilli(), e.seq)
+	}
+	if _, ok := e.orders[o.ClientID]; ok {
+		e.mu.Unlock()
+		return 0, fmt.Errorf("client order ID %s already used", o.ClientID)
+	}
+	// Registered before sending so a fill that beats the response can be matched
+	e.orders[o.ClientID] = &tracked{order: o}
+	e.mu.Unlock()
+
+	status, err := e.Client.PlaceOrder(ctx, o, f, last)
+	if err != nil && !rejected(err) {
+		log.Printf("Order %s: no answer from Binance (%v), looking it up", o.ClientID, err)
+		var found bool
+		var lookupErr error
+		if status, found, lookupErr = e.resolve(ctx, o.Symbol, o.ClientID); lookupErr != nil {
+			return 0, fmt.Errorf("order %s may or may not have been placed: %w", o.ClientID, err)
+		}
+		if found {
+			err = nil
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil {
+		delete(e.orders, o.ClientID)
+		return 0, err
+	}
+	e.ids[status.OrderID] = o.ClientID
+	return status.OrderID, nil
+}
+
+func (e *Exchange) Cancel(ctx context.Context, id int64) error {
+	e.mu.Lock()
+	clientID, ok := e.ids[id]
+	var symbol string
+	if ok {
+		symbol = e.orders[clientID].order.Symbol
+	}
+	e.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown order %d", id)
+	}
+	_, err := e.Client.CancelOrder(ctx, symbol, id)
+	return err
+}
+
+// Account gives the balances in the same form as the paper broker
+// On futures the cash is the wallet balance and the equity includes the unrealised PnL
+// On spot the cash is the quote asset, and each other asset with a quote pair is a long position (the entry price is not known)
+func (e *Exchange) Account(ctx context.Context) (broker.Account, error) {
+	acct := broker.Account{Positions: make(map[string]broker.Position)}
+	if e.Client.Market == Futures {
+		fa, err := e.Client.FuturesAccount(ctx)
+		if err != nil {
+			return acct, err
+		}
+		acct.Cash, acct.Equity = parseFloat(fa.TotalWalletBalance), parseFloat(fa.TotalMarginBalance)
+		for _, p := range fa.Positions {
+			if qty := parseFloat(p.PositionAmt); qty != 0 {
+				acct.Positions[p.Symbol] = broker.Position{Symbol: p.Symbol, Qty: qty, EntryPrice: parseFloat(p.EntryPrice)}
+			}
+		}
+		return acct, nil
+	}
+
+	sa, err := e.Client.SpotAccount(ctx)
+	if err != nil {
+		return acct, err
+	}
+	e.mu.Lock()
+	symbols := make(map[string]Filters) // Base asset to its filters against the quote asset
+	for _, f := range e.filters {
+		if f.QuoteAsset == e.Quote {
+			symbols[f.BaseAsset] = f
+		}
+	}
+	e.mu.Unlock()
+	for _, b := range sa.Balances {
+		total := parseFloat(b.Free) + parseFloat(b.Locked)
+		if b.Asset == e.Quote {
+			acct.Cash += total
+			continue
+		}
+		f, ok := symbols[b.Asset]
+		// Dust under the minimum quantity can not be sold, so it does not count as a position
+		if !ok || total < math.Max(f.MinQty, 1e-12) {
+			continue
+		}
+		price, err := e.Client.Price(ctx, f.Symbol)
+		if err != nil {
+			return acct, err
+		}
+		acct.Positions[f.Symbol] = broker.Position{Symbol: f.Symbol, Qty: total}
+		acct.Equity += total * price
+	}
+	acct.Equity += acct.Cash
+	return acct, nil
+}
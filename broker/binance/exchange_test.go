@@ -0,0 +1,436 @@
+package binance
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// The client and the exchange against the mock exchange (mock.go) over a real HTTP server, so nothing here needs an account
+// Every request goes through a recorder first, which can also drop the connection to stand in for a network failure
+
+type recorder struct {
+	mock *Mock
+	mu   sync.Mutex
+	reqs []*http.Request
+	// Called before the mock sees the request, returning true drops the connection without an answer
+	// after is true for the order to still reach the mock first (i.e. it was placed but the answer was lost)
+	drop func(r *http.Request) (drop, after bool)
+}
+
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec.mu.Lock()
+	rec.reqs = append(rec.reqs, r)
+	drop := rec.drop
+	rec.mu.Unlock()
+	if drop != nil {
+		if ok, after := drop(r); ok {
+			if after {
+				rec.mock.ServeHTTP(httptest.NewRecorder(), r)
+			}
+			panic(http.ErrAbortHandler)
+		}
+	}
+	rec.mock.ServeHTTP(w, r)
+}
+
+func (rec *recorder) setDrop(drop func(r *http.Request) (bool, bool)) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.drop = drop
+}
+
+// The number of requests with the method to the path
+func (rec *recorder) count(method, path string) int {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	n := 0
+	for _, r := range rec.reqs {
+		if r.Method == method && r.URL.Path == path {
+			n++
+		}
+	}
+	return n
+}
+
+func (rec *recorder) last(method, path string) *http.Request {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	for i := len(rec.reqs) - 1; i >= 0; i-- {
+		if r := rec.reqs[i]; r.Method == method && r.URL.Path == path {
+			return r
+		}
+	}
+	return nil
+}
+
+func newTestClient(t *testing.T, market Market) (*Client, *recorder) {
+	t.Helper()
+	cfg := DefaultMockConfig(market)
+	rec := &recorder{mock: NewMock(cfg)}
+	srv := httptest.NewServer(rec)
+	t.Cleanup(srv.Close)
+	client := NewClient(market, cfg.APIKey, cfg.Secret)
+	client.BaseURL = srv.URL
+	client.WSURL = "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/"
+	return client, rec
+}
+
+func newTestExchange(t *testing.T, market Market) (*Exchange, *recorder, chan models.Fill) {
+	t.Helper()
+	client, rec := newTestClient(t, market)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	exchange, err := NewExchange(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fills := make(chan models.Fill, 16)
+	exchange.Fills = fills
+	if err := exchange.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return exchange, rec, fills
+}
+
+func waitFill(t *testing.T, fills <-chan models.Fill) models.Fill {
+	t.Helper()
+	select {
+	case f := <-fills:
+		return f
+	case <-time.After(5 * time.Second):
+		t.Fatal("no fill")
+	}
+	return models.Fill{}
+}
+
+func TestSignedRequest(t *testing.T) {
+	client, rec := newTestClient(t, Spot)
+	if _, err := client.SpotAccount(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	r := rec.last(http.MethodGet, paths[Spot].account)
+	if r.Header.Get("X-MBX-APIKEY") != client.APIKey {
+		t.Errorf("API key header %q", r.Header.Get("X-MBX-APIKEY"))
+	}
+	raw := r.URL.RawQuery
+	i := strings.LastIndex(raw, "&signature=")
+	if i < 0 {
+		t.Fatalf("signature is not the last param: %s", raw)
+	}
+	mac := hmac.New(sha256.New, []byte(client.Secret))
+	mac.Write([]byte(raw[:i]))
+	if want := hex.EncodeToString(mac.Sum(nil)); raw[i+len("&signature="):] != want {
+		t.Errorf("signature %s, want %s", raw[i+len("&signature="):], want)
+	}
+	q := r.URL.Query()
+	ts, _ := strconv.ParseInt(q.Get("timestamp"), 10, 64)
+	if d := time.Now().UnixMilli() - ts; d < 0 || d > 5000 {
+		t.Errorf("timestamp %d is %d ms off", ts, d)
+	}
+	if q.Get("recvWindow") != "5000" {
+		t.Errorf("recvWindow %q", q.Get("recvWindow"))
+	}
+}
+
+// A request with a drifted clock is rejected with -1021, the client resyncs the time and sends it once more
+func TestClockRetry(t *testing.T) {
+	client, rec := newTestClient(t, Futures)
+	client.offset.Store(-60000)
+	if _, err := client.FuturesAccount(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := rec.count(http.MethodGet, paths[Futures].account); n != 2 {
+		t.Errorf("%d account requests, want 2", n)
+	}
+	if n := rec.count(http.MethodGet, paths[Futures].time); n != 1 {
+		t.Errorf("%d time requests, want 1", n)
+	}
+	if off := client.offset.Load(); off < -1000 || off > 1000 {
+		t.Errorf("offset %d after the resync", off)
+	}
+}
+
+func TestFilters(t *testing.T) {
+	client, rec := newTestClient(t, Spot)
+	filters, err := client.ExchangeInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, ok := filters["SOLUSDT"]
+	if !ok {
+		t.Fatal("no SOLUSDT filters")
+	}
+	if f.TickSize != 0.01 || f.StepSize != 0.001 || f.MinQty != 0.001 || f.MinNotional != 5 {
+		t.Fatalf("filters %+v", f)
+	}
+	if got := f.FormatQty(f.RoundQty(1.23456)); got != "1.234" {
+		t.Errorf("RoundQty(1.23456) = %s", got)
+	}
+	if got := f.FormatQty(f.RoundQty(0.3)); got != "0.300" {
+		t.Errorf("RoundQty(0.3) = %s", got)
+	}
+	if got := f.FormatPrice(f.RoundPrice(140.016)); got != "140.02" {
+		t.Errorf("RoundPrice(140.016) = %s", got)
+	}
+
+	// The rounded order is what gets sent
+	o := models.Order{ClientID: "round", Symbol: "SOLUSDT", Side: 1, Type: "limit", Qty: 1.23456, Price: 140.016}
+	if _, err := client.PlaceOrder(context.Background(), o, f, 0); err != nil {
+		t.Fatal(err)
+	}
+	q := rec.last(http.MethodPost, paths[Spot].order).URL.Query()
+	if q.Get("quantity") != "1.234" || q.Get("price") != "140.02" {
+		t.Errorf("sent quantity %s price %s", q.Get("quantity"), q.Get("price"))
+	}
+
+	// Orders the filters reject never leave the bot
+	sent := rec.count(http.MethodPost, paths[Spot].order)
+	for _, o := range []models.Order{
+		{Symbol: "SOLUSDT", Side: 1, Type: "limit", Qty: 0.0004, Price: 140},      // Under the min quantity once rounded
+		{Symbol: "SOLUSDT", Side: 1, Type: "limit", Qty: 0.01, Price: 140},        // Under the min notional
+		{Symbol: "SOLUSDT", Side: 1, Type: "limit", Qty: 1, Price: 2000000},       // Over the max price
+		{Symbol: "SOLUSDT", Side: 1, Type: "market", Qty: 0.02},                   // Under the min notional at the last price
+		{Symbol: "SOLUSDT", Side: 1, Type: "limit", Qty: 10000000, Price: 140.01}, // Over the max quantity
+	} {
+		if _, err := client.PlaceOrder(context.Background(), o, f, 150); err == nil {
+			t.Errorf("%+v was not rejected", o)
+		}
+	}
+	if n := rec.count(http.MethodPost, paths[Spot].order); n != sent {
+		t.Errorf("%d rejected orders were sent", n-sent)
+	}
+}
+
+func TestOrderTypes(t *testing.T) {
+	cases := []struct {
+		market            Market
+		order             models.Order
+		typ               string
+		price, stopPrice  string
+		reduceOnly, fills bool
+	}{
+		{Spot, models.Order{Type: "market", Qty: 1}, "MARKET", "", "", false, true},
+		{Spot, models.Order{Type: "limit", Qty: 1, Price: 140}, "LIMIT", "140.00", "", false, false},
+		{Spot, models.Order{Type: "stop", Qty: 1, Price: 160}, "STOP_LOSS", "", "160.00", false, false},
+		{Spot, models.Order{Type: "stop-limit", Qty: 1, Price: 161, StopPrice: 160}, "STOP_LOSS_LIMIT", "161.00", "160.00", false, false},
+		{Futures, models.Order{Type: "market", Qty: 1}, "MARKET", "", "", false, true},
+		{Futures, models.Order{Type: "limit", Qty: 1, Price: 140}, "LIMIT", "140.00", "", false, false},
+		{Futures, models.Order{Type: "stop", Qty: 1, Price: 160}, "STOP_MARKET", "", "160.00", false, false},
+		{Futures, models.Order{Type: "stop-limit", Qty: 1, Price: 161, StopPrice: 160}, "STOP", "161.00", "160.00", false, false},
+		{Futures, models.Order{Type: "market", Qty: 1, Side: -1, ReduceOnly: true}, "MARKET", "", "", true, true},
+	}
+	exchanges := map[Market]*Exchange{}
+	recs := map[Market]*recorder{}
+	fills := map[Market]chan models.Fill{}
+	for _, market := range []Market{Spot, Futures} {
+		exchanges[market], recs[market], fills[market] = newTestExchange(t, market)
+	}
+	for _, c := range cases {
+		o := c.order
+		o.Symbol = "SOLUSDT"
+		if o.Side == 0 {
+			o.Side = 1
+		}
+		if _, err := exchanges[c.market].Submit(context.Background(), o); err != nil {
+			t.Fatalf("%s %s: %v", c.market, o.Type, err)
+		}
+		q := recs[c.market].last(http.MethodPost, paths[c.market].order).URL.Query()
+		if q.Get("type") != c.typ || q.Get("price") != c.price || q.Get("stopPrice") != c.stopPrice {
+			t.Errorf("%s %s: sent type %s price %q stopPrice %q, want %s %q %q", c.market, o.Type, q.Get("type"), q.Get("price"), q.Get("stopPrice"),
+				c.typ, c.price, c.stopPrice)
+		}
+		if (q.Get("reduceOnly") == "true") != c.reduceOnly {
+			t.Errorf("%s %s: reduceOnly %q", c.market, o.Type, q.Get("reduceOnly"))
+		}
+		if c.fills {
+			f := waitFill(t, fills[c.market])
+			if f.Qty != 1 || f.Price != 150 || f.Side != o.Side || f.Type != o.Type {
+				t.Errorf("%s %s: fill %+v", c.market, o.Type, f)
+			}
+		}
+	}
+}
+
+func TestCancelAndQuery(t *testing.T) {
+	for _, market := range []Market{Spot, Futures} {
+		client, _ := newTestClient(t, market)
+		ctx := context.Background()
+		filters, err := client.ExchangeInfo(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o := models.Order{ClientID: "cq-1", Symbol: "SOLUSDT", Side: 1, Type: "limit", Qty: 1, Price: 140}
+		placed, err := client.PlaceOrder(ctx, o, filters["SOLUSDT"], 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		byID, err := client.QueryOrder(ctx, "SOLUSDT", placed.OrderID, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		byClient, err := client.QueryOrder(ctx, "SOLUSDT", 0, "cq-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if byID.Status != "NEW" || byClient.OrderID != placed.OrderID || byClient.ClientID != "cq-1" {
+			t.Fatalf("%s: query by ID %+v, by client ID %+v", market, byID, byClient)
+		}
+
+		cancelled, err := client.CancelOrder(ctx, "SOLUSDT", placed.OrderID)
+		if err != nil || cancelled.Status != "CANCELED" {
+			t.Fatalf("%s: cancel gave %+v, %v", market, cancelled, err)
+		}
+		if s, _ := client.QueryOrder(ctx, "SOLUSDT", placed.OrderID, ""); s.Status != "CANCELED" {
+			t.Errorf("%s: status %s after the cancel", market, s.Status)
+		}
+		var apiErr *APIError
+		if _, err := client.CancelOrder(ctx, "SOLUSDT", placed.OrderID); !errors.As(err, &apiErr) || apiErr.Code != ErrCancelRejected {
+			t.Errorf("%s: second cancel gave %v", market, err)
+		}
+		if _, err := client.QueryOrder(ctx, "SOLUSDT", 0, "nope"); !errors.As(err, &apiErr) || apiErr.Code != ErrNoSuchOrder {
+			t.Errorf("%s: query of an unknown order gave %v", market, err)
+		}
+	}
+}
+
+// A limit that fills while the stream is down is only found by the reconcile after the reconnect
+func TestStreamReconnectReconcile(t *testing.T) {
+	exchange, rec, fills := newTestExchange(t, Futures)
+	ctx := context.Background()
+	id, err := exchange.Submit(ctx, models.Order{ClientID: "rc-1", Symbol: "SOLUSDT", Side: 1, Type: "limit", Qty: 2, Price: 140})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.mock.DropStreams()
+	rec.mock.SetPrice(139)
+
+	f := waitFill(t, fills)
+	if f.ClientID != "rc-1" || f.OrderID != id || f.Qty != 2 || f.Price != 140 || f.Side != 1 {
+		t.Fatalf("recovered fill %+v", f)
+	}
+	if n := rec.count(http.MethodGet, paths[Futures].order); n == 0 {
+		t.Error("the order was not reconciled over REST")
+	}
+
+	// Fills on the new stream still come through, and only once
+	if _, err := exchange.Submit(ctx, models.Order{ClientID: "rc-2", Symbol: "SOLUSDT", Side: -1, Type: "market", Qty: 2, ReduceOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+	if f := waitFill(t, fills); f.ClientID != "rc-2" || f.Qty != 2 || f.Price != 139 {
+		t.Fatalf("fill after the reconnect %+v", f)
+	}
+	select {
+	case f := <-fills:
+		t.Fatalf("unexpected fill %+v", f)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// The OMS cancels the other side of an OCO from the fill, so a fill waiting to be taken off Fills must not hold the exchange's lock
+func TestFillWithoutLock(t *testing.T) {
+	exchange, rec, _ := newTestExchange(t, Futures)
+	fills := make(chan models.Fill) // Unbuffered, so the stream is stuck on the send until the fill is taken
+	exchange.Fills = fills
+	ctx := context.Background()
+	if _, err := exchange.Submit(ctx, models.Order{ClientID: "oco-1", Symbol: "SOLUSDT", Side: 1, Type: "limit", Qty: 1, Price: 140}); err != nil {
+		t.Fatal(err)
+	}
+	other, err := exchange.Submit(ctx, models.Order{ClientID: "oco-2", Symbol: "SOLUSDT", Side: 1, Type: "limit", Qty: 1, Price: 130})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.mock.SetPrice(139)
+	time.Sleep(200 * time.Millisecond) // Gives the stream time to get to the send
+
+	cancelled := make(chan error, 1)
+	go func() { cancelled <- exchange.Cancel(ctx, other) }()
+	select {
+	case err := <-cancelled:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Cancel blocked behind the fill")
+	}
+	if f := waitFill(t, fills); f.ClientID != "oco-1" {
+		t.Fatalf("fill %+v", f)
+	}
+}
+
+// Only an answer from Binance is a reject, a dropped connection is looked up by the client order ID
+func TestSubmitNoAnswer(t *testing.T) {
+	saved := resolveDelays
+	resolveDelays = []time.Duration{10 * time.Millisecond}
+	t.Cleanup(func() { resolveDelays = saved })
+
+	exchange, rec, fills := newTestExchange(t, Spot)
+	ctx := context.Background()
+	order := paths[Spot].order
+	dropPost := func(after bool) func(r *http.Request) (bool, bool) {
+		return func(r *http.Request) (bool, bool) {
+			return r.Method == http.MethodPost && r.URL.Path == order, after
+		}
+	}
+
+	// Placed, but the answer was lost
+	rec.setDrop(dropPost(true))
+	id, err := exchange.Submit(ctx, models.Order{ClientID: "na-1", Symbol: "SOLUSDT", Side: 1, Type: "market", Qty: 1})
+	if err != nil {
+		t.Fatalf("placed order gave %v", err)
+	}
+	if f := waitFill(t, fills); f.ClientID != "na-1" || f.OrderID != id {
+		t.Fatalf("fill %+v for order %d", f, id)
+	}
+	if q := rec.last(http.MethodGet, order).URL.Query(); q.Get("origClientOrderId") != "na-1" {
+		t.Errorf("looked up %s", q.Encode())
+	}
+
+	// Never reached Binance
+	rec.setDrop(dropPost(false))
+	if _, err := exchange.Submit(ctx, models.Order{ClientID: "na-2", Symbol: "SOLUSDT", Side: 1, Type: "limit", Qty: 1, Price: 140}); err == nil {
+		t.Fatal("order that was never placed gave no error")
+	}
+	exchange.mu.Lock()
+	_, tracked := exchange.orders["na-2"]
+	exchange.mu.Unlock()
+	if tracked {
+		t.Error("order that was never placed is still tracked")
+	}
+
+	// No answer to the lookups either, the order stays tracked for the reconcile
+	rec.setDrop(func(r *http.Request) (bool, bool) { return r.URL.Path == order, true })
+	timeout, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if _, err := exchange.Submit(timeout, models.Order{ClientID: "na-3", Symbol: "SOLUSDT", Side: 1, Type: "limit", Qty: 1, Price: 140}); err == nil {
+		t.Fatal("unresolved order gave no error")
+	}
+	exchange.mu.Lock()
+	_, tracked = exchange.orders["na-3"]
+	exchange.mu.Unlock()
+	if !tracked {
+		t.Error("unresolved order is no longer tracked")
+	}
+
+	// A reject from Binance is final straight away
+	rec.setDrop(nil)
+	lookups := rec.count(http.MethodGet, order)
+	var apiErr *APIError
+	if _, err := exchange.Submit(ctx, models.Order{ClientID: "na-4", Symbol: "SOLUSDT", Side: -1, Type: "limit", Qty: 100, Price: 200}); !errors.As(err, &apiErr) {
+		t.Fatalf("insufficient balance gave %v", err)
+	}
+	if n := rec.count(http.MethodGet, order); n != lookups {
+		t.Error("a rejected order was looked up")
+	}
+}
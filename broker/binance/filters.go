@@ -0,0 +1,154 @@
+package binance
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The symbol filters from exchangeInfo, orders that break them are rejected by Binance with a filter failure
+// The quantities are rounded down to the step size (so an order is never bigger than asked for) and the prices to the nearest tick
+// before sending, then checked against the limits so an order that would be rejected never leaves the bot
+
+type Filters struct {
+	Symbol      string
+	BaseAsset   string
+	QuoteAsset  string
+	TickSize    float64
+	MinPrice    float64
+	MaxPrice    float64
+	StepSize    float64
+	MinQty      float64
+	MaxQty      float64
+	MarketMax   float64 // Max quantity of a market order (MARKET_LOT_SIZE), 0 if there is no separate limit
+	MinNotional float64
+	priceDec    int // Decimals to format the prices/quantities with
+	qtyDec      int
+}
+
+// The filters come as a list of objects with different fields depending on the filterType, all the numbers are strings
+type rawFilter struct {
+	FilterType  string `json:"filterType"`
+	MinPrice    string `json:"minPrice"`
+	MaxPrice    string `json:"maxPrice"`
+	TickSize    string `json:"tickSize"`
+	MinQty      string `json:"minQty"`
+	MaxQty      string `json:"maxQty"`
+	StepSize    string `json:"stepSize"`
+	MinNotional string `json:"minNotional"` // Spot NOTIONAL/MIN_NOTIONAL
+	Notional    string `json:"notional"`    // Futures MIN_NOTIONAL
+}
+
+type rawSymbol struct {
+	Symbol     string      `json:"symbol"`
+	Status     string      `json:"status"`
+	BaseAsset  string      `json:"baseAsset"`
+	QuoteAsset string      `json:"quoteAsset"`
+	Filters    []rawFilter `json:"filters"`
+}
+
+type exchangeInfo struct {
+	Symbols []rawSymbol `json:"symbols"`
+}
+
+func parseFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+// Number of decimals of a step like "0.00100000"
+func decimals(step string) int {
+	i := strings.IndexByte(step, '.')
+	if i < 0 {
+		return 0
+	}
+	return len(strings.TrimRight(step[i+1:], "0"))
+}
+
+func parseFilters(s rawSymbol) Filters {
+	f := Filters{Symbol: s.Symbol, BaseAsset: s.BaseAsset, QuoteAsset: s.QuoteAsset}
+	for _, raw := range s.Filters {
+		switch raw.FilterType {
+		case "PRICE_FILTER":
+			f.MinPrice, f.MaxPrice, f.TickSize = parseFloat(raw.MinPrice), parseFloat(raw.MaxPrice), parseFloat(raw.TickSize)
+			f.priceDec = decimals(raw.TickSize)
+		case "LOT_SIZE":
+			f.MinQty, f.MaxQty, f.StepSize = parseFloat(raw.MinQty), parseFloat(raw.MaxQty), parseFloat(raw.StepSize)
+			f.qtyDec = decimals(raw.StepSize)
+		case "MARKET_LOT_SIZE":
+			f.MarketMax = parseFloat(raw.MaxQty)
+		case "NOTIONAL", "MIN_NOTIONAL":
+			if raw.MinNotional != "" {
+				f.MinNotional = parseFloat(raw.MinNotional)
+			} else {
+				f.MinNotional = parseFloat(raw.Notional)
+			}
+		}
+	}
+	return f
+}
+
+// ExchangeInfo gets the filters of the symbols that are trading
+func (c *Client) ExchangeInfo(ctx context.Context) (map[string]Filters, error) {
+	var info exchangeInfo
+	if err := c.do(ctx, http.MethodGet, c.paths().exchangeInfo, nil, false, false, &info); err != nil {
+		return nil, err
+	}
+	filters := make(map[string]Filters, len(info.Symbols))
+	for _, s := range info.Symbols {
+		if s.Status == "TRADING" {
+			filters[s.Symbol] = parseFilters(s)
+		}
+	}
+	return filters, nil
+}
+
+// Rounds the quantity down to the step size
+func (f Filters) RoundQty(qty float64) float64 {
+	if f.StepSize <= 0 {
+		return qty
+	}
+	// The small nudge stops 0.3/0.1 becoming 2.9999 and rounding down a whole step
+	return math.Floor(qty/f.StepSize+1e-9) * f.StepSize
+}
+
+// Rounds the price to the nearest tick
+func (f Filters) RoundPrice(price float64) float64 {
+	if f.TickSize <= 0 {
+		return price
+	}
+	return math.Round(price/f.TickSize) * f.TickSize
+}
+
+func (f Filters) FormatQty(qty float64) string {
+	return strconv.FormatFloat(qty, 'f', f.qtyDec, 64)
+}
+
+func (f Filters) FormatPrice(price float64) string {
+	return strconv.FormatFloat(price, 'f', f.priceDec, 64)
+}
+
+// Check gives the reason the (already rounded) order would be rejected, price is the limit/stop price or the last price for a market order
+func (f Filters) Check(qty, price float64, market bool) error {
+	if qty < f.MinQty || qty <= 0 {
+		return fmt.Errorf("filter failure: LOT_SIZE (quantity %s under the minimum %s)", f.FormatQty(qty), f.FormatQty(f.MinQty))
+	}
+	if f.MaxQty > 0 && qty > f.MaxQty {
+		return fmt.Errorf("filter failure: LOT_SIZE (quantity %s over the maximum %s)", f.FormatQty(qty), f.FormatQty(f.MaxQty))
+	}
+	if market && f.MarketMax > 0 && qty > f.MarketMax {
+		return fmt.Errorf("filter failure: MARKET_LOT_SIZE (quantity %s over the maximum %s)", f.FormatQty(qty), f.FormatQty(f.MarketMax))
+	}
+	if price > 0 {
+		if !market && (price < f.MinPrice || (f.MaxPrice > 0 && price > f.MaxPrice)) {
+			return fmt.Errorf("filter failure: PRICE_FILTER (price %s outside %s-%s)", f.FormatPrice(price), f.FormatPrice(f.MinPrice), f.FormatPrice(f.MaxPrice))
+		}
+		if qty*price < f.MinNotional {
+			return fmt.Errorf("filter failure: NOTIONAL (%.2f under the minimum %.2f)", qty*price, f.MinNotional)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,717 @@
+package binance
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// A local mock of the Binance endpoints the client uses, so the execution can be developed and tried out without an account or real funds
+// It serves one market (spot or futures) and one symbol, checks the API key, signature and timestamp the same as Binance,
+// enforces the symbol filters, balances/margin and reduce-only, and answers with Binance's error codes
+// Orders fill against a price that is set with SetPrice (or POST /mock/price?price=...), there is no order book so every fill is for the whole order
+// The fills and order updates are pushed on the user data stream websocket in the same format as Binance
+// Run it standalone with cmd/MockExchange, or embed it with http.Handler
+
+type MockConfig struct {
+	Market      Market
+	APIKey      string
+	Secret      string
+	Symbol      string
+	Base        string
+	Quote       string
+	Price       float64 // Starting price
+	Balance     float64 // Starting balance of the quote asset (the futures wallet)
+	MakerFee    float64
+	TakerFee    float64
+	Leverage    float64 // Futures only
+	TickSize    float64
+	StepSize    float64
+	MinQty      float64
+	MaxQty      float64
+	MinNotional float64
+}
+
+// SOLUSDT with roughly the real filters and the standard fees
+func DefaultMockConfig(market Market) MockConfig {
+	cfg := MockConfig{
+		Market:      market,
+		APIKey:      "mock-key",
+		Secret:      "mock-secret",
+		Symbol:      "SOLUSDT",
+		Base:        "SOL",
+		Quote:       "USDT",
+		Price:       150,
+		Balance:     10000,
+		MakerFee:    0.001,
+		TakerFee:    0.001,
+		Leverage:    1,
+		TickSize:    0.01,
+		StepSize:    0.001,
+		MinQty:      0.001,
+		MaxQty:      9000000,
+		MinNotional: 5,
+	}
+	if market == Futures {
+		cfg.MakerFee, cfg.TakerFee = 0.0002, 0.0005
+		cfg.StepSize, cfg.MinQty, cfg.MaxQty = 0.01, 0.01, 1000000
+	}
+	return cfg
+}
+
+type mockOrder struct {
+	id         int64
+	clientID   string
+	side       int
+	typ        string
+	qty        float64
+	price      float64
+	stop       float64
+	reduceOnly bool
+	status     string
+	executed   float64
+	quote      float64 // Cumulative quote quantity
+	triggered  bool    // Stop-limit that has become a limit
+	time       int64
+}
+
+type Mock struct {
+	Config   MockConfig
+	mu       sync.Mutex
+	price    float64
+	nextID   int64
+	orders   map[int64]*mockOrder
+	clients  map[string]int64
+	balances map[string]float64 // Spot balances
+	wallet   float64            // Futures wallet balance
+	position float64            // Futures position, signed
+	entry    float64            // Futures entry price
+	keys     map[string][]chan []byte
+	mux      *http.ServeMux
+}
+
+func NewMock(cfg MockConfig) *Mock {
+	m := &Mock{
+		Config:   cfg,
+		price:    cfg.Price,
+		orders:   make(map[int64]*mockOrder),
+		clients:  make(map[string]int64),
+		balances: map[string]float64{cfg.Quote: cfg.Balance},
+		wallet:   cfg.Balance,
+		keys:     make(map[string][]chan []byte),
+		mux:      http.NewServeMux(),
+	}
+	p := paths[cfg.Market]
+	m.mux.HandleFunc("GET "+p.time, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]int64{"serverTime": time.Now().UnixMilli()})
+	})
+	m.mux.HandleFunc("GET "+p.exchangeInfo, m.exchangeInfo)
+	m.mux.HandleFunc("GET "+p.ticker, m.ticker)
+	m.mux.HandleFunc(p.order, m.signed(m.order))
+	m.mux.HandleFunc("GET "+p.account, m.signed(m.account))
+	m.mux.HandleFunc(p.listenKey, m.listenKey)
+	m.mux.HandleFunc("GET /ws/{key}", m.stream)
+	m.mux.HandleFunc("POST /mock/price", func(w http.ResponseWriter, r *http.Request) {
+		price, err := strconv.ParseFloat(r.URL.Query().Get("price"), 64)
+		if err != nil || price <= 0 {
+			mockError(w, http.StatusBadRequest, ErrMandatoryParam, "Mandatory parameter 'price' was not sent, was empty/null, or malformed.")
+			return
+		}
+		m.SetPrice(price)
+		writeJSON(w, map[string]string{"price": fmtNum(price)})
+	})
+	return m
+}
+
+func (m *Mock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mux.ServeHTTP(w, r)
+}
+
+func fmtNum(x float64) string {
+	return strconv.FormatFloat(x, 'f', -1, 64)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func mockError(w http.ResponseWriter, status, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(APIError{Code: code, Msg: msg})
+}
+
+func missing(w http.ResponseWriter, param string) {
+	mockError(w, http.StatusBadRequest, ErrMandatoryParam, fmt.Sprintf("Mandatory parameter '%s' was not sent, was empty/null, or malformed.", param))
+}
+
+func (m *Mock) exchangeInfo(w http.ResponseWriter, r *http.Request) {
+	cfg := m.Config
+	notional := map[string]string{"filterType": "NOTIONAL", "minNotional": fmtNum(cfg.MinNotional)}
+	if cfg.Market == Futures {
+		notional = map[string]string{"filterType": "MIN_NOTIONAL", "notional": fmtNum(cfg.MinNotional)}
+	}
+	writeJSON(w, map[string]any{"symbols": []map[string]any{{
+		"symbol": cfg.Symbol, "status": "TRADING", "baseAsset": cfg.Base, "quoteAsset": cfg.Quote,
+		"filters": []map[string]string{
+			{"filterType": "PRICE_FILTER", "minPrice": fmtNum(cfg.TickSize), "maxPrice": "1000000", "tickSize": fmtNum(cfg.TickSize)},
+			{"filterType": "LOT_SIZE", "minQty": fmtNum(cfg.MinQty), "maxQty": fmtNum(cfg.MaxQty), "stepSize": fmtNum(cfg.StepSize)},
+			{"filterType": "MARKET_LOT_SIZE", "minQty": fmtNum(cfg.MinQty), "maxQty": fmtNum(cfg.MaxQty), "stepSize": fmtNum(cfg.StepSize)},
+			notional,
+		},
+	}}})
+}
+
+func (m *Mock) ticker(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("symbol") != m.Config.Symbol {
+		mockError(w, http.StatusBadRequest, -1121, "Invalid symbol.")
+		return
+	}
+	m.mu.Lock()
+	price := m.price
+	m.mu.Unlock()
+	writeJSON(w, map[string]string{"symbol": m.Config.Symbol, "price": fmtNum(price)})
+}
+
+// Checks the API key, the signature and the timestamp before passing the request on
+func (m *Mock) signed(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-MBX-APIKEY") != m.Config.APIKey {
+			mockError(w, http.StatusUnauthorized, ErrAPIKey, "Invalid API-key, IP, or permissions for action.")
+			return
+		}
+		raw := r.URL.RawQuery
+		i := strings.LastIndex(raw, "signature=")
+		if i < 0 {
+			missing(w, "signature")
+			return
+		}
+		payload := strings.TrimSuffix(raw[:i], "&")
+		mac := hmac.New(sha256.New, []byte(m.Config.Secret))
+		mac.Write([]byte(payload))
+		if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(raw[i+len("signature="):])) {
+			mockError(w, http.StatusBadRequest, ErrSignature, "Signature for this request is not valid.")
+			return
+		}
+		q := r.URL.Query()
+		ts, err := strconv.ParseInt(q.Get("timestamp"), 10, 64)
+		if err != nil {
+			missing(w, "timestamp")
+			return
+		}
+		recv := int64(5000)
+		if s := q.Get("recvWindow"); s != "" {
+			recv, _ = strconv.ParseInt(s, 10, 64)
+		}
+		now := time.Now().UnixMilli()
+		if ts > now+1000 || now-ts > recv {
+			mockError(w, http.StatusBadRequest, ErrTimestamp, "Timestamp for this request is outside of the recvWindow.")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Whether x is a whole number of steps
+func onStep(x, step float64) bool {
+	n := x / step
+	return math.Abs(n-math.Round(n)) < 1e-6
+}
+
+func (m *Mock) order(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("symbol") != m.Config.Symbol {
+		mockError(w, http.StatusBadRequest, -1121, "Invalid symbol.")
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		m.newOrder(w, q)
+	case http.MethodDelete, http.MethodGet:
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		o := m.lookup(q.Get("orderId"), q.Get("origClientOrderId"))
+		if r.Method == http.MethodGet {
+			if o == nil {
+				mockError(w, http.StatusBadRequest, ErrNoSuchOrder, "Order does not exist.")
+				return
+			}
+			writeJSON(w, m.status(o))
+			return
+		}
+		if o == nil || (o.status != "NEW" && o.status != "PARTIALLY_FILLED") {
+			mockError(w, http.StatusBadRequest, ErrCancelRejected, "Unknown order sent.")
+			return
+		}
+		o.status = "CANCELED"
+		m.publish(o, "CANCELED", 0, 0, 0, false)
+		writeJSON(w, m.status(o))
+	default:
+		mockError(w, http.StatusMethodNotAllowed, -1000, "Unsupported method.")
+	}
+}
+
+// Must be called with the lock held
+func (m *Mock) lookup(orderID, clientID string) *mockOrder {
+	if id, err := strconv.ParseInt(orderID, 10, 64); err == nil {
+		return m.orders[id]
+	}
+	if id, ok := m.clients[clientID]; ok {
+		return m.orders[id]
+	}
+	return nil
+}
+
+func (m *Mock) newOrder(w http.ResponseWriter, q map[string][]string) {
+	get := func(k string) string {
+		if v := q[k]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	cfg, futures := m.Config, m.Config.Market == Futures
+	o := &mockOrder{clientID: get("newClientOrderId"), typ: get("type"), time: time.Now().UnixMilli()}
+	switch get("side") {
+	case "BUY":
+		o.side = 1
+	case "SELL":
+		o.side = -1
+	default:
+		missing(w, "side")
+		return
+	}
+
+	valid := map[string]bool{"MARKET": true, "LIMIT": true, "STOP_LOSS": !futures, "STOP_LOSS_LIMIT": !futures, "STOP_MARKET": futures, "STOP": futures}
+	if !valid[o.typ] {
+		mockError(w, http.StatusBadRequest, -1116, "Invalid orderType.")
+		return
+	}
+	var err error
+	if o.qty, err = strconv.ParseFloat(get("quantity"), 64); err != nil {
+		missing(w, "quantity")
+		return
+	}
+	limit := o.typ == "LIMIT" || o.typ == "STOP_LOSS_LIMIT" || o.typ == "STOP"
+	stop := o.typ != "MARKET" && o.typ != "LIMIT"
+	if limit {
+		if o.price, err = strconv.ParseFloat(get("price"), 64); err != nil {
+			missing(w, "price")
+			return
+		}
+		if get("timeInForce") == "" {
+			missing(w, "timeInForce")
+			return
+		}
+	}
+	if stop {
+		if o.stop, err = strconv.ParseFloat(get("stopPrice"), 64); err != nil {
+			missing(w, "stopPrice")
+			return
+		}
+	}
+	if get("reduceOnly") != "" {
+		if !futures {
+			mockError(w, http.StatusBadRequest, -1106, "Parameter 'reduceOnly' sent when not required.")
+			return
+		}
+		o.reduceOnly = get("reduceOnly") == "true"
+	}
+
+	// The filters
+	if !onStep(o.qty, cfg.StepSize) || (limit && !onStep(o.price, cfg.TickSize)) || (stop && !onStep(o.stop, cfg.TickSize)) {
+		if futures {
+			mockError(w, http.StatusBadRequest, ErrPrecision, "Precision is over the maximum defined for this asset.")
+		} else {
+			mockError(w, http.StatusBadRequest, ErrFilterFailure, "Filter failure: LOT_SIZE")
+		}
+		return
+	}
+	if o.qty < cfg.MinQty || o.qty > cfg.MaxQty {
+		mockError(w, http.StatusBadRequest, ErrFilterFailure, "Filter failure: LOT_SIZE")
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	px := m.price
+	if limit {
+		px = o.price
+	} else if stop {
+		px = o.stop
+	}
+	if o.qty*px < cfg.MinNotional && !o.reduceOnly {
+		if futures {
+			mockError(w, http.StatusBadRequest, ErrMinNotional, fmt.Sprintf("Order's notional must be no smaller than %s (unless you choose reduce only).", fmtNum(cfg.MinNotional)))
+		} else {
+			mockError(w, http.StatusBadRequest, ErrFilterFailure, "Filter failure: NOTIONAL")
+		}
+		return
+	}
+	if o.clientID != "" {
+		if _, ok := m.clients[o.clientID]; ok {
+			if futures {
+				mockError(w, http.StatusBadRequest, ErrDuplicateClient, "ClientOrderId is duplicated.")
+			} else {
+				mockError(w, http.StatusBadRequest, ErrNewOrderRejected, "Duplicate order sent.")
+			}
+			return
+		}
+	}
+	if stop && ((o.side == 1 && m.price >= o.stop) || (o.side == -1 && m.price <= o.stop)) {
+		if futures {
+			mockError(w, http.StatusBadRequest, -2021, "Order would immediately trigger.")
+		} else {
+			mockError(w, http.StatusBadRequest, ErrNewOrderRejected, "Stop price would trigger immediately.")
+		}
+		return
+	}
+	if err := m.afford(o, px); err != nil {
+		mockError(w, http.StatusBadRequest, err.Code, err.Msg)
+		return
+	}
+
+	m.nextID++
+	o.id, o.status = m.nextID, "NEW"
+	if o.clientID == "" {
+		o.clientID = fmt.Sprintf("mock-%d", o.id)
+	}
+	m.orders[o.id] = o
+	m.clients[o.clientID] = o.id
+	m.publish(o, "NEW", 0, 0, 0, false)
+	m.match(o, true)
+	writeJSON(w, m.status(o))
+}
+
+// Checks the balance (spot) or margin (futures) covers the order at px, must be called with the lock held
+func (m *Mock) afford(o *mockOrder, px float64) *APIError {
+	cfg := m.Config
+	if cfg.Market == Futures {
+		if o.reduceOnly {
+			if o.side == sgn(m.position) || m.position == 0 {
+				return &APIError{Code: ErrReduceOnly, Msg: "ReduceOnly Order is rejected."}
+			}
+			return nil
+		}
+		after := math.Abs(m.position + float64(o.side)*o.qty)
+		if after > math.Abs(m.position) && after*px/cfg.Leverage > m.wallet+m.unrealised() {
+			return &APIError{Code: ErrMarginShort, Msg: "Margin is insufficient."}
+		}
+		return nil
+	}
+	if (o.side == 1 && o.qty*px*(1+cfg.TakerFee) > m.balances[cfg.Quote]) || (o.side == -1 && o.qty > m.balances[cfg.Base]+1e-12) {
+		return &APIError{Code: ErrNewOrderRejected, Msg: "Account has insufficient balance for requested action."}
+	}
+	return nil
+}
+
+func sgn(x float64) int {
+	if x > 0 {
+		return 1
+	}
+	if x < 0 {
+		return -1
+	}
+	return 0
+}
+
+func (m *Mock) unrealised() float64 {
+	return m.position * (m.price - m.entry)
+}
+
+// SetPrice moves the price and fills any orders it crosses
+func (m *Mock) SetPrice(price float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.price = price
+	for id := int64(1); id <= m.nextID; id++ {
+		if o := m.orders[id]; o != nil && o.status == "NEW" {
+			m.match(o, false)
+		}
+	}
+}
+
+// Fills the order if the price allows it, new says the order was just placed (so a limit that crosses is a taker)
+// Must be called with the lock held
+func (m *Mock) match(o *mockOrder, new bool) {
+	p := m.price
+	crosses := func(limit float64) bool { return (o.side == 1 && p <= limit) || (o.side == -1 && p >= limit) }
+	stopped := (o.side == 1 && p >= o.stop) || (o.side == -1 && p <= o.stop)
+	switch o.typ {
+	case "MARKET":
+		m.execute(o, p, false)
+	case "LIMIT":
+		if crosses(o.price) {
+			if new {
+				m.execute(o, p, false)
+			} else {
+				m.execute(o, o.price, true)
+			}
+		}
+	case "STOP_LOSS", "STOP_MARKET":
+		if stopped {
+			m.execute(o, p, false)
+		}
+	case "STOP_LOSS_LIMIT", "STOP":
+		if !o.triggered && stopped {
+			// Once triggered it is a new limit order
+			o.triggered, new = true, true
+		}
+		if o.triggered && crosses(o.price) {
+			if new {
+				m.execute(o, p, false)
+			} else {
+				m.execute(o, o.price, true)
+			}
+		}
+	}
+}
+
+// Fills the rest of the order at the price, must be called with the lock held
+func (m *Mock) execute(o *mockOrder, price float64, maker bool) {
+	cfg := m.Config
+	qty := o.qty - o.executed
+	if o.reduceOnly {
+		// A reduce-only order can only close what is left of the position
+		if o.side == sgn(m.position) || m.position == 0 {
+			o.status = "EXPIRED"
+			m.publish(o, "EXPIRED", 0, 0, 0, false)
+			return
+		}
+		qty = math.Min(qty, math.Abs(m.position))
+	}
+	rate := cfg.TakerFee
+	if maker {
+		rate = cfg.MakerFee
+	}
+	fee := qty * price * rate
+
+	if cfg.Market == Futures {
+		// Realise the PnL on the part that closes the position
+		if sgn(m.position) == -o.side {
+			closed := math.Min(qty, math.Abs(m.position))
+			m.wallet += closed * (price - m.entry) * float64(-o.side)
+		}
+		m.wallet -= fee
+		newPos := m.position + float64(o.side)*qty
+		switch {
+		case math.Abs(newPos) < 1e-12:
+			m.position, m.entry = 0, 0
+		case sgn(m.position) == o.side || m.position == 0:
+			m.entry = (m.entry*math.Abs(m.position) + price*qty) / math.Abs(newPos)
+			m.position = newPos
+		case sgn(newPos) != sgn(m.position):
+			m.position, m.entry = newPos, price
+		default:
+			m.position = newPos
+		}
+	} else {
+		cost := qty * price
+		if (o.side == 1 && cost+fee > m.balances[cfg.Quote]) || (o.side == -1 && qty > m.balances[cfg.Base]+1e-12) {
+			// The balance was spent by another order since this one was placed
+			o.status = "EXPIRED"
+			m.publish(o, "EXPIRED", 0, 0, 0, false)
+			return
+		}
+		m.balances[cfg.Base] += float64(o.side) * qty
+		m.balances[cfg.Quote] -= float64(o.side)*cost + fee
+	}
+
+	o.executed += qty
+	o.quote += qty * price
+	o.status = "FILLED"
+	m.publish(o, "TRADE", qty, price, fee, maker)
+}
+
+// The order as the REST endpoints return it
+func (m *Mock) status(o *mockOrder) map[string]any {
+	s := map[string]any{
+		"symbol": m.Config.Symbol, "orderId": o.id, "clientOrderId": o.clientID, "status": o.status, "type": o.typ,
+		"side": side(o.side), "price": fmtNum(o.price), "stopPrice": fmtNum(o.stop), "origQty": fmtNum(o.qty),
+		"executedQty": fmtNum(o.executed), "time": o.time,
+	}
+	if m.Config.Market == Futures {
+		s["cumQuote"], s["reduceOnly"] = fmtNum(o.quote), o.reduceOnly
+		avg := 0.0
+		if o.executed > 0 {
+			avg = o.quote / o.executed
+		}
+		s["avgPrice"] = fmtNum(avg)
+	} else {
+		s["cummulativeQuoteQty"] = fmtNum(o.quote)
+	}
+	return s
+}
+
+// Sends the order event to the user data streams, must be called with the lock held
+func (m *Mock) publish(o *mockOrder, exec string, qty, price, fee float64, maker bool) {
+	now := time.Now().UnixMilli()
+	e := map[string]any{
+		"s": m.Config.Symbol, "c": o.clientID, "S": side(o.side), "o": o.typ, "f": "GTC", "q": fmtNum(o.qty),
+		"p": fmtNum(o.price), "x": exec, "X": o.status, "i": o.id, "l": fmtNum(qty), "z": fmtNum(o.executed),
+		"L": fmtNum(price), "n": fmtNum(fee), "N": m.Config.Quote, "T": now, "m": maker,
+	}
+	var msg []byte
+	if m.Config.Market == Futures {
+		e["sp"], e["R"] = fmtNum(o.stop), o.reduceOnly
+		msg, _ = json.Marshal(map[string]any{"e": "ORDER_TRADE_UPDATE", "E": now, "T": now, "o": e})
+	} else {
+		e["e"], e["E"], e["P"], e["Z"], e["r"], e["C"] = "executionReport", now, fmtNum(o.stop), fmtNum(o.quote), "NONE", ""
+		if exec == "CANCELED" {
+			// On spot the cancel has its own client ID and the order's is moved to "C"
+			e["c"], e["C"] = fmt.Sprintf("cancel-%d", o.id), o.clientID
+		}
+		msg, _ = json.Marshal(e)
+	}
+	for _, subs := range m.keys {
+		for _, ch := range subs {
+			select {
+			case ch <- msg:
+			default: // Slow reader, the event is dropped (the client reconciles over REST)
+			}
+		}
+	}
+}
+
+func (m *Mock) account(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cfg := m.Config
+	if cfg.Market == Futures {
+		margin := m.wallet + m.unrealised()
+		used := math.Abs(m.position) * m.price / cfg.Leverage
+		writeJSON(w, map[string]any{
+			"totalWalletBalance": fmtNum(m.wallet),
+			"totalMarginBalance": fmtNum(margin),
+			"availableBalance":   fmtNum(margin - used),
+			"positions": []map[string]string{{
+				"symbol": cfg.Symbol, "positionAmt": fmtNum(m.position), "entryPrice": fmtNum(m.entry), "unrealizedProfit": fmtNum(m.unrealised()),
+			}},
+		})
+		return
+	}
+	var balances []Balance
+	for _, asset := range []string{cfg.Base, cfg.Quote} {
+		balances = append(balances, Balance{Asset: asset, Free: fmtNum(m.balances[asset]), Locked: "0"})
+	}
+	writeJSON(w, SpotAccount{Balances: balances})
+}
+
+func (m *Mock) listenKey(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-MBX-APIKEY") != m.Config.APIKey {
+		mockError(w, http.StatusUnauthorized, ErrAPIKey, "Invalid API-key, IP, or permissions for action.")
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := r.URL.Query().Get("listenKey")
+	switch r.Method {
+	case http.MethodPost:
+		b := make([]byte, 32)
+		rand.Read(b)
+		key = hex.EncodeToString(b)
+		m.keys[key] = nil
+		writeJSON(w, listenKeyResp{ListenKey: key})
+	case http.MethodPut, http.MethodDelete:
+		if _, ok := m.keys[key]; !ok && key != "" {
+			mockError(w, http.StatusBadRequest, -1125, "This listenKey does not exist.")
+			return
+		}
+		if r.Method == http.MethodDelete {
+			for _, ch := range m.keys[key] {
+				close(ch)
+			}
+			delete(m.keys, key)
+		}
+		writeJSON(w, struct{}{})
+	default:
+		mockError(w, http.StatusMethodNotAllowed, -1000, "Unsupported method.")
+	}
+}
+
+// The user data stream websocket
+func (m *Mock) stream(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	ch := make(chan []byte, 256)
+	m.mu.Lock()
+	if _, ok := m.keys[key]; !ok {
+		m.mu.Unlock()
+		mockError(w, http.StatusBadRequest, -1125, "This listenKey does not exist.")
+		return
+	}
+	m.keys[key] = append(m.keys[key], ch)
+	m.mu.Unlock()
+
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		m.unsubscribe(key, ch)
+		return
+	}
+	defer conn.CloseNow()
+	ctx := conn.CloseRead(r.Context()) // Cancelled once the client goes away
+	for {
+		select {
+		case <-ctx.Done():
+			m.unsubscribe(key, ch)
+			return
+		case msg, ok := <-ch:
+			if !ok { // The listen key was closed or the stream dropped
+				conn.Close(websocket.StatusNormalClosure, "listen key closed")
+				return
+			}
+			if err := conn.Write(ctx, websocket.MessageText, msg); err != nil {
+				m.unsubscribe(key, ch)
+				return
+			}
+		}
+	}
+}
+
+func (m *Mock) unsubscribe(key string, ch chan []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	subs := m.keys[key]
+	for i, c := range subs {
+		if c == ch {
+			m.keys[key] = append(subs[:i], subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// DropStreams disconnects every user data stream and expires the listen keys, like Binance does every 24 hours
+func (m *Mock) DropStreams() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, subs := range m.keys {
+		for _, ch := range subs {
+			close(ch)
+		}
+		delete(m.keys, key)
+	}
+}
+
+// Serve runs the mock on the address until the context is cancelled
+func (m *Mock) Serve(ctx context.Context, addr string) error {
+	srv := &http.Server{Addr: addr, Handler: m}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
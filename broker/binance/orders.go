@@ -0,0 +1,195 @@
+package binance
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// Placing, cancelling and querying orders, and the account balances
+// The order types map onto Binance as:
+//   market -> MARKET, limit -> LIMIT (GTC)
+//   stop -> STOP_LOSS on spot, STOP_MARKET on futures (triggers at Price)
+//   stop-limit -> STOP_LOSS_LIMIT on spot, STOP on futures (triggers at StopPrice, then a limit at Price)
+// Reduce-only is only a thing on futures, spot can not go short so an exit is just a sell of what is held
+
+// The state of an order as Binance reports it
+type OrderStatus struct {
+	Symbol      string `json:"symbol"`
+	OrderID     int64  `json:"orderId"`
+	ClientID    string `json:"clientOrderId"`
+	Status      string `json:"status"` // NEW, PARTIALLY_FILLED, FILLED, CANCELED, REJECTED or EXPIRED
+	Type        string `json:"type"`
+	Side        string `json:"side"`
+	Price       string `json:"price"`
+	StopPrice   string `json:"stopPrice"`
+	OrigQty     string `json:"origQty"`
+	ExecutedQty string `json:"executedQty"`
+	SpotQuote   string `json:"cummulativeQuoteQty"` // Spot (the typo is Binance's)
+	FutQuote    string `json:"cumQuote"`            // Futures
+	AvgPrice    string `json:"avgPrice"`            // Futures only
+	ReduceOnly  bool   `json:"reduceOnly"`
+}
+
+func (s OrderStatus) Filled() float64 {
+	return parseFloat(s.ExecutedQty)
+}
+
+// Average fill price, spot only gives the quote quantity so it is worked out from that
+func (s OrderStatus) Average() float64 {
+	if avg := parseFloat(s.AvgPrice); avg > 0 {
+		return avg
+	}
+	quote := parseFloat(s.SpotQuote) + parseFloat(s.FutQuote)
+	if filled := s.Filled(); filled > 0 {
+		return quote / filled
+	}
+	return 0
+}
+
+func side(s int) string {
+	if s == 1 {
+		return "BUY"
+	}
+	return "SELL"
+}
+
+// The Binance order type for the market
+func (c *Client) orderType(o models.Order) (string, error) {
+	switch o.Type {
+	case "", "market":
+		return "MARKET", nil
+	case "limit":
+		return "LIMIT", nil
+	case "stop":
+		if c.Market == Futures {
+			return "STOP_MARKET", nil
+		}
+		return "STOP_LOSS", nil
+	case "stop-limit":
+		if c.Market == Futures {
+			return "STOP", nil
+		}
+		return "STOP_LOSS_LIMIT", nil
+	}
+	return "", fmt.Errorf("unknown order type %q", o.Type)
+}
+
+// Builds the params for the order, the quantity and prices are rounded to the filters and checked against them
+// last is the last price, used for the notional check of market orders (0 to skip it)
+func (c *Client) orderParams(o models.Order, f Filters, last float64) (url.Values, error) {
+	typ, err := c.orderType(o)
+	if err != nil {
+		return nil, err
+	}
+	if o.Side != 1 && o.Side != -1 {
+		return nil, fmt.Errorf("invalid side %d", o.Side)
+	}
+	qty := f.RoundQty(o.Qty)
+	params := url.Values{
+		"symbol":           {o.Symbol},
+		"side":             {side(o.Side)},
+		"type":             {typ},
+		"quantity":         {f.FormatQty(qty)},
+		"newOrderRespType": {"RESULT"},
+	}
+	if o.ClientID != "" {
+		params.Set("newClientOrderId", o.ClientID)
+	}
+	if o.ReduceOnly && c.Market == Futures {
+		params.Set("reduceOnly", "true")
+	}
+
+	price, check := 0.0, last
+	switch o.Type {
+	case "limit":
+		price = f.RoundPrice(o.Price)
+		params.Set("price", f.FormatPrice(price))
+		params.Set("timeInForce", "GTC")
+		check = price
+	case "stop":
+		params.Set("stopPrice", f.FormatPrice(f.RoundPrice(o.Price)))
+		check = f.RoundPrice(o.Price)
+	case "stop-limit":
+		price = f.RoundPrice(o.Price)
+		params.Set("price", f.FormatPrice(price))
+		params.Set("stopPrice", f.FormatPrice(f.RoundPrice(o.StopPrice)))
+		params.Set("timeInForce", "GTC")
+		check = price
+	}
+	if err := f.Check(qty, check, price == 0); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// PlaceOrder sends the order, the client order ID is passed on so the fills on the user data stream can be matched to it
+func (c *Client) PlaceOrder(ctx context.Context, o models.Order, f Filters, last float64) (OrderStatus, error) {
+	params, err := c.orderParams(o, f, last)
+	if err != nil {
+		return OrderStatus{}, err
+	}
+	var status OrderStatus
+	err = c.do(ctx, http.MethodPost, c.paths().order, params, true, false, &status)
+	return status, err
+}
+
+func (c *Client) CancelOrder(ctx context.Context, symbol string, orderID int64) (OrderStatus, error) {
+	var status OrderStatus
+	params := url.Values{"symbol": {symbol}, "orderId": {strconv.FormatInt(orderID, 10)}}
+	err := c.do(ctx, http.MethodDelete, c.paths().order, params, true, false, &status)
+	return status, err
+}
+
+// QueryOrder looks the order up by the Binance order ID, or by the client order ID if orderID is 0
+func (c *Client) QueryOrder(ctx context.Context, symbol string, orderID int64, clientID string) (OrderStatus, error) {
+	params := url.Values{"symbol": {symbol}}
+	if orderID != 0 {
+		params.Set("orderId", strconv.FormatInt(orderID, 10))
+	} else {
+		params.Set("origClientOrderId", clientID)
+	}
+	var status OrderStatus
+	err := c.do(ctx, http.MethodGet, c.paths().order, params, true, false, &status)
+	return status, err
+}
+
+type Balance struct {
+	Asset  string `json:"asset"`
+	Free   string `json:"free"`
+	Locked string `json:"locked"`
+}
+
+type SpotAccount struct {
+	Balances []Balance `json:"balances"`
+}
+
+type FuturesPosition struct {
+	Symbol           string `json:"symbol"`
+	PositionAmt      string `json:"positionAmt"` // Signed, negative when short
+	EntryPrice       string `json:"entryPrice"`
+	UnrealizedProfit string `json:"unrealizedProfit"`
+}
+
+type FuturesAccount struct {
+	TotalWalletBalance string            `json:"totalWalletBalance"`
+	TotalMarginBalance string            `json:"totalMarginBalance"` // Wallet balance plus the unrealised PnL
+	AvailableBalance   string            `json:"availableBalance"`
+	Positions          []FuturesPosition `json:"positions"`
+}
+
+func (c *Client) SpotAccount(ctx context.Context) (SpotAccount, error) {
+	var acct SpotAccount
+	err := c.do(ctx, http.MethodGet, paths[Spot].account, nil, true, false, &acct)
+	return acct, err
+}
+
+func (c *Client) FuturesAccount(ctx context.Context) (FuturesAccount, error) {
+	var acct FuturesAccount
+	err := c.do(ctx, http.MethodGet, paths[Futures].account, nil, true, false, &acct)
+	return acct, err
+}
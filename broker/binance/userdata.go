@@ -0,0 +1,215 @@
+package binance
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// The user data stream, Binance pushes an event on it for every change to our orders (including each fill)
+// It needs a listen key which expires after 60 minutes unless it is kept alive, so it is kept alive every 30 minutes
+// The connection is also dropped by Binance after 24 hours, so on any read error it reconnects with a new listen key
+// Events can be missed while reconnecting, so OnReconnect is called after each reconnect for the orders to be checked over REST
+
+// An order event from the stream (executionReport on spot, ORDER_TRADE_UPDATE on futures)
+type OrderUpdate struct {
+	Symbol    string
+	OrderID   int64
+	ClientID  string // For a cancel this is the ID of the cancel request, the order's ID is in OrigClientID
+	OrigID    string
+	Side      int
+	Type      string // Binance order type
+	Exec      string // Execution type: NEW, TRADE, CANCELED, REJECTED, EXPIRED, ...
+	Status    string // Order status after the event
+	LastQty   float64
+	LastPrice float64
+	Fee       float64
+	FeeAsset  string
+	Maker     bool
+	Time      int64 // ms
+	Reject    string
+}
+
+type listenKeyResp struct {
+	ListenKey string `json:"listenKey"`
+}
+
+func (c *Client) NewListenKey(ctx context.Context) (string, error) {
+	var resp listenKeyResp
+	err := c.do(ctx, http.MethodPost, c.paths().listenKey, nil, false, true, &resp)
+	return resp.ListenKey, err
+}
+
+func (c *Client) KeepAlive(ctx context.Context, key string) error {
+	return c.do(ctx, http.MethodPut, c.paths().listenKey, url.Values{"listenKey": {key}}, false, true, nil)
+}
+
+func (c *Client) CloseListenKey(ctx context.Context, key string) error {
+	return c.do(ctx, http.MethodDelete, c.paths().listenKey, url.Values{"listenKey": {key}}, false, true, nil)
+}
+
+// The event fields are single letters and some only differ by case (e.g. "x" and "X"), which encoding/json would mix up
+// since it matches keys case-insensitively, so the events are read through a map instead
+type event map[string]json.RawMessage
+
+func (e event) str(k string) string {
+	var s string
+	json.Unmarshal(e[k], &s)
+	return s
+}
+
+// The numbers come as strings apart from the IDs and times
+func (e event) num(k string) float64 {
+	f, _ := strconv.ParseFloat(e.str(k), 64)
+	return f
+}
+
+func (e event) int(k string) int64 {
+	var i int64
+	json.Unmarshal(e[k], &i)
+	return i
+}
+
+func (e event) bool(k string) bool {
+	var b bool
+	json.Unmarshal(e[k], &b)
+	return b
+}
+
+// Parses an order event, returns false for the other events (balance/account updates etc.)
+func parseUpdate(msg []byte) (OrderUpdate, bool) {
+	var e event
+	if json.Unmarshal(msg, &e) != nil {
+		return OrderUpdate{}, false
+	}
+	switch e.str("e") {
+	case "executionReport":
+	case "ORDER_TRADE_UPDATE":
+		// The order fields are in "o" on futures
+		var o event
+		if json.Unmarshal(e["o"], &o) != nil {
+			return OrderUpdate{}, false
+		}
+		e = o
+	default:
+		return OrderUpdate{}, false
+	}
+	u := OrderUpdate{
+		Symbol:    e.str("s"),
+		OrderID:   e.int("i"),
+		ClientID:  e.str("c"),
+		OrigID:    e.str("C"),
+		Side:      1,
+		Type:      e.str("o"),
+		Exec:      e.str("x"),
+		Status:    e.str("X"),
+		LastQty:   e.num("l"),
+		LastPrice: e.num("L"),
+		Fee:       e.num("n"),
+		FeeAsset:  e.str("N"),
+		Maker:     e.bool("m"),
+		Time:      e.int("T"),
+		Reject:    e.str("r"),
+	}
+	if e.str("S") == "SELL" {
+		u.Side = -1
+	}
+	return u, true
+}
+
+// UserStream streams our order events until the context is cancelled, onReconnect (if not nil) is called after every reconnect
+func (c *Client) UserStream(ctx context.Context, onReconnect func()) (<-chan OrderUpdate, error) {
+	key, conn, err := c.dialUserStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	updates := make(chan OrderUpdate, 256)
+
+	go func() {
+		defer close(updates)
+		for {
+			c.readUserStream(ctx, key, conn, updates)
+			if ctx.Err() != nil {
+				return
+			}
+			// Keep trying to reconnect, the open orders are still on the exchange
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Second):
+				}
+				if key, conn, err = c.dialUserStream(ctx); err == nil {
+					break
+				}
+				log.Println("User data stream reconnect failed:", err)
+			}
+			log.Println("User data stream reconnected")
+			if onReconnect != nil {
+				onReconnect()
+			}
+		}
+	}()
+	return updates, nil
+}
+
+func (c *Client) dialUserStream(ctx context.Context) (string, *websocket.Conn, error) {
+	key, err := c.NewListenKey(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	conn, _, err := websocket.Dial(ctx, c.WSURL+key, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	conn.SetReadLimit(1 << 20)
+	return key, conn, nil
+}
+
+// Reads the events off one connection until it errors, keeping the listen key alive in the meantime
+func (c *Client) readUserStream(ctx context.Context, key string, conn *websocket.Conn, updates chan<- OrderUpdate) {
+	done := make(chan struct{})
+	defer close(done)
+	defer conn.Close(websocket.StatusNormalClosure, "Closing the connection")
+	go func() {
+		ticker := time.NewTicker(30 * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				// The listen key is closed with a fresh context since ctx may already be cancelled
+				closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				c.CloseListenKey(closeCtx, key)
+				cancel()
+				return
+			case <-ticker.C:
+				if err := c.KeepAlive(ctx, key); err != nil {
+					log.Println("Listen key keepalive failed:", err)
+				}
+			}
+		}
+	}()
+
+	for {
+		_, msg, err := conn.Read(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Println("User data stream read error:", err)
+			}
+			return
+		}
+		if u, ok := parseUpdate(msg); ok {
+			select {
+			case updates <- u:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package broker
+
+import (
+	"context"
+	"log"
+	"math"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+	bot "github.com/Reece-Ogidih/CT-Bot/bot"
+)
+
+// A Broker executes the orders made from the strategy signals, either simulated (Paper) or for real on an exchange
+// The fills are reported on the broker's fill channel since limit and stop orders can fill long after they were submitted
+
+type Broker interface {
+	Submit(ctx context.Context, o models.Order) (int64, error) // Returns the ID the broker gave the order
+	Cancel(ctx context.Context, id int64) error
+	Account(ctx context.Context) (Account, error)
+}
+
+type Position struct {
+	Symbol     string
+	Qty        float64 // Signed, negative when short
+	EntryPrice float64 // Average entry price
+}
+
+type Account struct {
+	Cash      float64
+	Equity    float64 // Cash plus the value of the positions at the last price
+	Positions map[string]Position
+}
+
+// The quantity a reduce-only order closes, up to its Qty (all of the position if Qty is 0)
+func reduceQty(o models.Order, pos float64) float64 {
+	if o.Qty > 0 {
+		return math.Min(o.Qty, math.Abs(pos))
+	}
+	return math.Abs(pos)
+}
+
+func sign(x float64) int {
+	if x > 0 {
+		return 1
+	}
+	if x < 0 {
+		return -1
+	}
+	return 0
+}
+
+// Turns a strategy signal into an order for the account, entries are sized by the sizer (see bot/sizing.go)
+// The sizing rationale goes on the order's reason so it ends up in bot_trades.notes
+// Returns false if there is nothing to do (an exit with no position, an entry when already in that direction, or an entry the sizer skipped)
+func OrderFromSignal(sig models.Signal, acct Account, sizer *bot.Sizer) (models.Order, bool) {
+	pos := acct.Positions[sig.Symbol]
+	o := models.Order{Symbol: sig.Symbol, Created: sig.OpenTime, Reason: sig.Reason, Confidence: sig.Confidence}
+
+	if sig.Kind == bot.SignalExit {
+		if sign(pos.Qty) != sig.Side {
+			return o, false
+		}
+		o.Side, o.Type, o.Qty, o.ReduceOnly = -sig.Side, "market", math.Abs(pos.Qty), true
+		if sig.Fraction > 0 && sig.Fraction < 1 {
+			o.Qty *= sig.Fraction
+		}
+		return o, true
+	}
+
+	if sign(pos.Qty) == sig.Side {
+		return o, false
+	}
+	size := sizer.Size(sig, acct.Equity)
+	if size.Qty <= 0 {
+		log.Printf("Skipping the %s entry: %s", sig.Symbol, size.Rationale)
+		return o, false
+	}
+	o.Reason += "; " + size.Rationale
+	o.Side, o.Type, o.Qty = sig.Side, "market", size.Qty
+	if sig.Order == "limit" || sig.Order == "stop" {
+		o.Type, o.Price = sig.Order, size.Price
+	}
+	return o, true
+}
@@ -0,0 +1,96 @@
+package broker
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+	bot "github.com/Reece-Ogidih/CT-Bot/bot"
+)
+
+// Stores every fill in bot_trades as it comes in on the channel, until the channel is closed
+// The mode (e.g. "paper" or "live") goes at the start of the notes so the paper trades can be told apart from the real ones
+func LogFills(conn *sql.DB, mode string, fills <-chan models.Fill) {
+	stmt, err := conn.Prepare(`
+	INSERT INTO bot_trades (timestamp_ms, action, price, quantity, confidence_score, client_order_id, notes)
+	VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		log.Println("Preparation error for bot trades:", err)
+		for range fills { // Still need to drain the channel so the broker does not block
+		}
+		return
+	}
+	defer stmt.Close()
+
+	for f := range fills {
+		action := "BUY"
+		if f.Side == -1 {
+			action = "SELL"
+		}
+		// There is no score until the ML model is hooked up, so store NULL rather than a confidence of 0
+		var confidence any
+		if f.Confidence != 0 {
+			confidence = f.Confidence
+		}
+		notes := fmt.Sprintf("%s %s order %d: %s (fee %.6f)", mode, f.Type, f.OrderID, f.Reason, f.Fee)
+		var clientID any
+		if f.ClientID != "" {
+			clientID = f.ClientID
+		}
+		// The DEX candles use seconds for the OpenTime but the table is in ms
+		if _, err := stmt.Exec(bot.OpenTimeMs(f.OpenTime), action, f.Price, f.Qty, confidence, clientID, notes); err != nil {
+			log.Println("Error inserting bot trade:", err)
+		}
+	}
+}
+
+// Stores the OMS audit trail in order_events as it comes in on the channel, until the channel is closed
+func LogAudit(conn *sql.DB, entries <-chan AuditEntry) {
+	stmt, err := conn.Prepare(`
+	INSERT INTO order_events (timestamp_ms, client_order_id, order_id, venue_order_id, event, from_state, to_state, symbol, side, order_type,
+		qty, price, stop_price, reduce_only, oco_group, filled_qty, avg_price, fees, reason, detail)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		log.Println("Preparation error for order events:", err)
+		for range entries { // Still need to drain the channel so the OMS does not block
+		}
+		return
+	}
+	defer stmt.Close()
+
+	for e := range entries {
+		o := e.Order
+		_, err := stmt.Exec(e.Time, o.ClientID, o.ID, o.VenueID, e.Event, string(e.From), string(e.To), o.Symbol, o.Side, o.Type,
+			o.Qty, o.Price, o.StopPrice, o.ReduceOnly, o.OCO, o.FilledQty, o.AvgPrice, o.Fees, o.Reason, e.Detail)
+		if err != nil {
+			log.Println("Error inserting order event:", err)
+		}
+	}
+}
+
+// Stores the risk manager's blocks, halts and resumes in risk_events as they come in on the channel, until the channel is closed
+func LogRisk(conn *sql.DB, events <-chan RiskEvent) {
+	stmt, err := conn.Prepare(`
+	INSERT INTO risk_events (timestamp_ms, rule, action, client_order_id, symbol, side, order_type, qty, price, equity, detail)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		log.Println("Preparation error for risk events:", err)
+		for range events { // Still need to drain the channel so the risk manager does not block
+		}
+		return
+	}
+	defer stmt.Close()
+
+	for e := range events {
+		o := e.Order
+		// Halts and resumes are not for an order
+		var clientID, symbol, side, orderType any
+		if o.Symbol != "" {
+			clientID, symbol, side, orderType = o.ClientID, o.Symbol, o.Side, o.Type
+		}
+		if _, err := stmt.Exec(e.Time, string(e.Rule), e.Action, clientID, symbol, side, orderType, o.Qty, o.Price, e.Equity, e.Detail); err != nil {
+			log.Println("Error inserting risk event:", err)
+		}
+	}
+}
@@ -0,0 +1,468 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// The order management system sits between the strategies and the execution venue (the paper broker or an exchange)
+// Every order gets a client order ID and goes through an explicit lifecycle:
+//   new -> acknowledged -> partially filled -> filled
+//   new -> rejected, and any open order -> cancelled or expired
+// A fill can also arrive before the venue has acknowledged the order (e.g. a market order on the paper broker), so new can go straight to filled
+// Submitting the same client order ID twice returns the existing order instead of placing it again, so a retry can never double up a position
+// Each state change is kept in an audit trail (and published on the Audit channel so it can be stored in order_events)
+// The OMS is itself a Broker, so anything that trades through a Broker can be pointed at it
+
+type OrderState string
+
+const (
+	OrderNew             OrderState = "new"
+	OrderAcknowledged    OrderState = "acknowledged"
+	OrderPartiallyFilled OrderState = "partially_filled"
+	OrderFilled          OrderState = "filled"
+	OrderCancelled       OrderState = "cancelled"
+	OrderRejected        OrderState = "rejected"
+	OrderExpired         OrderState = "expired"
+)
+
+// Open orders can still fill
+func (s OrderState) Open() bool {
+	return s == OrderNew || s == OrderAcknowledged || s == OrderPartiallyFilled
+}
+
+// The states each state is allowed to move to
+var transitions = map[OrderState][]OrderState{
+	OrderNew:             {OrderAcknowledged, OrderRejected, OrderPartiallyFilled, OrderFilled, OrderCancelled},
+	OrderAcknowledged:    {OrderPartiallyFilled, OrderFilled, OrderCancelled, OrderExpired},
+	OrderPartiallyFilled: {OrderPartiallyFilled, OrderFilled, OrderCancelled, OrderExpired},
+}
+
+// Venues with a lot size (e.g. Binance) round the quantity down before placing the order
+// The OMS keeps the rounded quantity so it knows when the order has been filled
+type QtyRounder interface {
+	RoundQty(symbol string, qty float64) float64
+}
+
+func canMove(from, to OrderState) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+type ManagedOrder struct {
+	models.Order
+	VenueID   int64 // ID the venue gave the order
+	State     OrderState
+	FilledQty float64
+	AvgPrice  float64 // Average fill price
+	Fees      float64
+	Detail    string // Why the order was rejected, cancelled or expired
+	Created   int64  // Unix time in ms
+	Updated   int64
+}
+
+type AuditEntry struct {
+	Time   int64  // Unix time in ms
+	Event  string // "submit", "ack", "reject", "fill", "cancel" or "expire"
+	From   OrderState
+	To     OrderState
+	Order  ManagedOrder // The order after the event
+	Detail string
+}
+
+type OMS struct {
+	Venue  Broker
+	Audit  chan<- AuditEntry // If set, every audit entry is also sent here (e.g. to LogAudit)
+	Now    func() time.Time  // Clock used for the timestamps and expiry, defaults to time.Now
+	prefix string
+	mu     sync.Mutex
+	orders map[string]*ManagedOrder // By client order ID
+	byID   map[int64]string         // OMS order ID to client order ID
+	groups map[string][]string      // OCO group to client order IDs
+	trail  []AuditEntry
+	seq    int64
+}
+
+// The prefix goes on the generated client order IDs, it should be different for each run (e.g. the start time) so IDs are never reused on the venue
+func NewOMS(venue Broker, prefix string) *OMS {
+	return &OMS{
+		Venue:  venue,
+		Now:    time.Now,
+		prefix: prefix,
+		orders: make(map[string]*ManagedOrder),
+		byID:   make(map[int64]string),
+		groups: make(map[string][]string),
+	}
+}
+
+func (m *OMS) now() int64 {
+	return m.Now().UnixMilli()
+}
+
+// Moves the order to a new state and records it, must be called with the lock held
+func (m *OMS) move(o *ManagedOrder, to OrderState, event, detail string) bool {
+	from := o.State
+	if !canMove(from, to) {
+		log.Printf("OMS: order %s can not go from %s to %s (%s)", o.ClientID, from, to, event)
+		return false
+	}
+	o.State, o.Updated = to, m.now()
+	if detail != "" && (to == OrderRejected || to == OrderCancelled || to == OrderExpired) {
+		o.Detail = detail
+	}
+	m.record(o, event, from, detail)
+	return true
+}
+
+func (m *OMS) record(o *ManagedOrder, event string, from OrderState, detail string) {
+	entry := AuditEntry{Time: m.now(), Event: event, From: from, To: o.State, Order: *o, Detail: detail}
+	m.trail = append(m.trail, entry)
+	if m.Audit != nil {
+		m.Audit <- entry
+	}
+}
+
+// Submit places the order on the venue and returns the OMS order ID
+// If the order has no client order ID one is generated, if it has one that was already used the existing order is returned
+func (m *OMS) Submit(ctx context.Context, o models.Order) (int64, error) {
+	m.mu.Lock()
+	if o.ClientID == "" {
+		m.seq++
+		o.ClientID = fmt.Sprintf("%s-%d", m.prefix, m.seq)
+	}
+	if existing, ok := m.orders[o.ClientID]; ok {
+		m.mu.Unlock()
+		return existing.ID, nil
+	}
+	m.seq++
+	o.ID = m.seq
+	if o.Type == "" {
+		o.Type = "market"
+	}
+	if r, ok := m.Venue.(QtyRounder); ok && o.Qty > 0 {
+		o.Qty = r.RoundQty(o.Symbol, o.Qty)
+	}
+	mo := &ManagedOrder{Order: o, State: OrderNew, Created: m.now(), Updated: m.now()}
+	m.orders[o.ClientID] = mo
+	m.byID[o.ID] = o.ClientID
+	m.record(mo, "submit", "", "")
+	m.mu.Unlock()
+
+	// Reject anything the venue should never see
+	if err := m.validate(ctx, o); err != nil {
+		m.mu.Lock()
+		m.move(mo, OrderRejected, "reject", err.Error())
+		m.mu.Unlock()
+		return o.ID, err
+	}
+
+	// The lock is not held while the venue has the order, since the venue can report a fill before it returns
+	venueID, err := m.Venue.Submit(ctx, o)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		if mo.State == OrderNew {
+			m.move(mo, OrderRejected, "reject", err.Error())
+		}
+		return o.ID, err
+	}
+	mo.VenueID = venueID
+	if mo.State == OrderNew {
+		m.move(mo, OrderAcknowledged, "ack", "")
+	}
+	return o.ID, nil
+}
+
+func (m *OMS) validate(ctx context.Context, o models.Order) error {
+	if o.Side != 1 && o.Side != -1 {
+		return fmt.Errorf("invalid side %d", o.Side)
+	}
+	switch o.Type {
+	case "market":
+	case "limit", "stop":
+		if o.Price <= 0 {
+			return fmt.Errorf("%s order needs a price", o.Type)
+		}
+	case "stop-limit":
+		if o.Price <= 0 || o.StopPrice <= 0 {
+			return fmt.Errorf("stop-limit order needs a price and a stop price")
+		}
+	default:
+		return fmt.Errorf("unknown order type %q", o.Type)
+	}
+	if !o.ReduceOnly {
+		if o.Qty <= 0 {
+			return fmt.Errorf("invalid quantity %f", o.Qty)
+		}
+		return nil
+	}
+
+	// A reduce-only order has to be against an open position
+	acct, err := m.Venue.Account(ctx)
+	if err != nil {
+		return fmt.Errorf("could not check the position: %w", err)
+	}
+	if sign(acct.Positions[o.Symbol].Qty) != -o.Side {
+		return fmt.Errorf("reduce-only order with no position to reduce")
+	}
+	return nil
+}
+
+// SubmitOCO places the orders as one group, once one of them (partially) fills the others are cancelled
+// If any of them is rejected the rest are cancelled as well
+// The whole group is registered before the first order goes to the venue, and if a member has already filled by the time a submit returns
+// (e.g. a market order on the paper broker) the rest of the group is not placed at all
+func (m *OMS) SubmitOCO(ctx context.Context, orders ...models.Order) ([]int64, error) {
+	m.mu.Lock()
+	m.seq++
+	group := fmt.Sprintf("%s-oco-%d", m.prefix, m.seq)
+	orders = append([]models.Order(nil), orders...)
+	for i := range orders {
+		orders[i].OCO = group
+		if orders[i].ClientID == "" {
+			m.seq++
+			orders[i].ClientID = fmt.Sprintf("%s-%d", m.prefix, m.seq)
+		}
+		m.groups[group] = append(m.groups[group], orders[i].ClientID)
+	}
+	m.mu.Unlock()
+
+	var ids []int64
+	for _, o := range orders {
+		id, err := m.Submit(ctx, o)
+		if err != nil {
+			m.cancelGroup(ctx, group, "", "oco order rejected")
+			return ids, err
+		}
+		ids = append(ids, id)
+		if filled, ok := m.groupFilled(group); ok {
+			m.cancelGroup(ctx, group, filled, "other oco order filled")
+			return ids, nil
+		}
+	}
+	return ids, nil
+}
+
+// The first member of the group that has (partially) filled
+func (m *OMS) groupFilled(group string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range m.groups[group] {
+		if o, ok := m.orders[id]; ok && o.FilledQty > 0 {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// Cancels the open orders of a group apart from the one given
+func (m *OMS) cancelGroup(ctx context.Context, group, except, detail string) {
+	m.mu.Lock()
+	members := append([]string(nil), m.groups[group]...)
+	m.mu.Unlock()
+	for _, id := range members {
+		if id == except {
+			continue
+		}
+		if o, ok := m.Order(id); ok && o.State.Open() {
+			if err := m.cancel(ctx, id, OrderCancelled, "cancel", detail); err != nil {
+				log.Printf("OMS: could not cancel %s: %v", id, err)
+			}
+		}
+	}
+}
+
+// OnFill updates the order the fill is for, this has to be given every fill from the venue (see Track)
+func (m *OMS) OnFill(ctx context.Context, f models.Fill) {
+	m.mu.Lock()
+	mo, ok := m.orders[f.ClientID]
+	if !ok {
+		m.mu.Unlock()
+		log.Printf("OMS: fill for unknown order %q (venue order %d)", f.ClientID, f.OrderID)
+		return
+	}
+	if mo.VenueID == 0 {
+		mo.VenueID = f.OrderID
+	}
+	// Reduce-only orders take their quantity from the position, so the first fill sets it
+	if mo.Qty == 0 {
+		mo.Qty = f.Qty
+	}
+	mo.AvgPrice = (mo.AvgPrice*mo.FilledQty + f.Price*f.Qty) / (mo.FilledQty + f.Qty)
+	mo.FilledQty += f.Qty
+	mo.Fees += f.Fee
+	to := OrderPartiallyFilled
+	if mo.FilledQty >= mo.Qty*(1-1e-9) {
+		to = OrderFilled
+	}
+	m.move(mo, to, "fill", fmt.Sprintf("%.8f @ %.8f", f.Qty, f.Price))
+	group, clientID := mo.OCO, mo.ClientID
+	m.mu.Unlock()
+
+	if group != "" {
+		m.cancelGroup(ctx, group, clientID, "other oco order filled")
+	}
+}
+
+// OnVenueCancel ends the lifecycle of an order the venue cancelled by itself (e.g. the paper broker when a stop is bigger than the pool)
+func (m *OMS) OnVenueCancel(clientID, detail string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mo, ok := m.orders[clientID]
+	if !ok {
+		log.Printf("OMS: venue cancel for unknown order %q", clientID)
+		return
+	}
+	if mo.State.Open() {
+		m.move(mo, OrderCancelled, "cancel", "venue: "+detail)
+	}
+}
+
+// Track passes every fill from the venue to OnFill and then on to out (e.g. LogFills), out is closed once fills is closed
+func (m *OMS) Track(ctx context.Context, fills <-chan models.Fill, out chan<- models.Fill) {
+	for f := range fills {
+		m.OnFill(ctx, f)
+		if out != nil {
+			out <- f
+		}
+	}
+	if out != nil {
+		close(out)
+	}
+}
+
+// Cancel cancels an open order by its OMS order ID
+func (m *OMS) Cancel(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	clientID, ok := m.byID[id]
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown order %d", id)
+	}
+	return m.CancelClient(ctx, clientID)
+}
+
+// CancelClient cancels an open order by its client order ID
+func (m *OMS) CancelClient(ctx context.Context, clientID string) error {
+	return m.cancel(ctx, clientID, OrderCancelled, "cancel", "")
+}
+
+func (m *OMS) cancel(ctx context.Context, clientID string, to OrderState, event, detail string) error {
+	m.mu.Lock()
+	mo, ok := m.orders[clientID]
+	if !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("unknown order %q", clientID)
+	}
+	if !mo.State.Open() {
+		state := mo.State
+		m.mu.Unlock()
+		return fmt.Errorf("order %q is already %s", clientID, state)
+	}
+	venueID := mo.VenueID
+	m.mu.Unlock()
+
+	// An order the venue has not acknowledged yet has nothing to cancel on the venue
+	if venueID != 0 {
+		if err := m.Venue.Cancel(ctx, venueID); err != nil {
+			return fmt.Errorf("venue could not cancel %q: %w", clientID, err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if mo.State.Open() {
+		m.move(mo, to, event, detail)
+	}
+	return nil
+}
+
+// Expire cancels the open orders that are past their Expires time
+func (m *OMS) Expire(ctx context.Context) {
+	now := m.now()
+	m.mu.Lock()
+	var expired []string
+	for id, o := range m.orders {
+		if o.State.Open() && o.Expires > 0 && now >= o.Expires {
+			expired = append(expired, id)
+		}
+	}
+	m.mu.Unlock()
+	sort.Strings(expired)
+	for _, id := range expired {
+		if err := m.cancel(ctx, id, OrderExpired, "expire", "past expiry"); err != nil {
+			log.Printf("OMS: could not expire %s: %v", id, err)
+		}
+	}
+}
+
+// Flatten cancels every open order and closes every position with a reduce-only market order (e.g. when the risk manager halts trading)
+func (m *OMS) Flatten(ctx context.Context, reason string) {
+	for _, o := range m.OpenOrders() {
+		if err := m.cancel(ctx, o.ClientID, OrderCancelled, "cancel", reason); err != nil {
+			log.Printf("OMS: could not cancel %s: %v", o.ClientID, err)
+		}
+	}
+	acct, err := m.Venue.Account(ctx)
+	if err != nil {
+		log.Println("OMS: could not get the positions to flatten:", err)
+		return
+	}
+	for _, pos := range acct.Positions {
+		if pos.Qty == 0 {
+			continue
+		}
+		o := models.Order{Symbol: pos.Symbol, Side: -sign(pos.Qty), Type: "market", Qty: math.Abs(pos.Qty), ReduceOnly: true, Reason: reason}
+		if _, err := m.Submit(ctx, o); err != nil {
+			log.Printf("OMS: could not close the %s position: %v", pos.Symbol, err)
+		}
+	}
+}
+
+func (m *OMS) Account(ctx context.Context) (Account, error) {
+	return m.Venue.Account(ctx)
+}
+
+// Order returns a copy of the order with the client order ID
+func (m *OMS) Order(clientID string) (ManagedOrder, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.orders[clientID]
+	if !ok {
+		return ManagedOrder{}, false
+	}
+	return *o, true
+}
+
+// OpenOrders returns the orders that can still fill, oldest first
+func (m *OMS) OpenOrders() []ManagedOrder {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var open []ManagedOrder
+	for _, o := range m.orders {
+		if o.State.Open() {
+			open = append(open, *o)
+		}
+	}
+	sort.Slice(open, func(i, j int) bool { return open[i].ID < open[j].ID })
+	return open
+}
+
+// Trail returns the audit trail so far
+func (m *OMS) Trail() []AuditEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]AuditEntry(nil), m.trail...)
+}
@@ -0,0 +1,341 @@
+package broker
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// The OMS in front of the paper broker, with no fees, spread or price impact so the fills are at the price given to the broker
+// The fills are handed to OnFill by deliver rather than Track, so each step happens in a known order (TestOMSTrack goes through Track)
+
+type omsTest struct {
+	oms   *OMS
+	paper *Paper
+	fills chan models.Fill
+	now   time.Time
+}
+
+func newOMSTest(t *testing.T) *omsTest {
+	t.Helper()
+	ot := &omsTest{now: time.UnixMilli(1_760_000_000_000)}
+	ot.paper = NewPaper(PaperConfig{InitialBalance: 10000})
+	ot.fills = make(chan models.Fill, 16)
+	ot.paper.Fills = ot.fills
+	ot.oms = NewOMS(ot.paper, "test")
+	ot.oms.Now = func() time.Time { return ot.now }
+	ot.paper.Cancelled = func(o models.Order, detail string) { ot.oms.OnVenueCancel(o.ClientID, detail) }
+	ot.price(100)
+	return ot
+}
+
+func (ot *omsTest) price(p float64) {
+	ot.paper.Update("SOLUSDT", models.CandleStick{OpenTime: ot.now.UnixMilli(), Close: p})
+}
+
+// Passes the fills the paper broker has made so far to the OMS
+func (ot *omsTest) deliver() {
+	for {
+		select {
+		case f := <-ot.fills:
+			ot.oms.OnFill(context.Background(), f)
+		default:
+			return
+		}
+	}
+}
+
+func (ot *omsTest) order(t *testing.T, clientID string) ManagedOrder {
+	t.Helper()
+	o, ok := ot.oms.Order(clientID)
+	if !ok {
+		t.Fatalf("no order %q", clientID)
+	}
+	return o
+}
+
+func (ot *omsTest) position(t *testing.T) float64 {
+	t.Helper()
+	acct, err := ot.paper.Account(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return acct.Positions["SOLUSDT"].Qty
+}
+
+func order(clientID string, side int, typ string, qty, price float64) models.Order {
+	return models.Order{ClientID: clientID, Symbol: "SOLUSDT", Side: side, Type: typ, Qty: qty, Price: price}
+}
+
+// The events (and the state each one moved to) of one order in the audit trail
+func events(trail []AuditEntry, clientID string) []string {
+	var out []string
+	for _, e := range trail {
+		if e.Order.ClientID == clientID {
+			out = append(out, e.Event+":"+string(e.To))
+		}
+	}
+	return out
+}
+
+func TestOMSLifecycle(t *testing.T) {
+	ot := newOMSTest(t)
+	ctx := context.Background()
+	audit := make(chan AuditEntry, 64)
+	ot.oms.Audit = audit
+
+	// A market order is acknowledged when the venue takes it and filled once its fill comes back
+	if _, err := ot.oms.Submit(ctx, order("buy", 1, "market", 2, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if o := ot.order(t, "buy"); o.State != OrderAcknowledged || o.VenueID == 0 {
+		t.Fatalf("before the fill %+v", o)
+	}
+	ot.deliver()
+	if o := ot.order(t, "buy"); o.State != OrderFilled || o.FilledQty != 2 || o.AvgPrice != 100 {
+		t.Fatalf("after the fill %+v", o)
+	}
+
+	// A limit fills in two parts, the rest is then cancelled
+	if _, err := ot.oms.Submit(ctx, order("sell", -1, "limit", 2, 110)); err != nil {
+		t.Fatal(err)
+	}
+	sell := ot.order(t, "sell")
+	ot.oms.OnFill(ctx, models.Fill{OrderID: sell.VenueID, ClientID: "sell", Symbol: "SOLUSDT", Side: -1, Qty: 0.5, Price: 110})
+	ot.oms.OnFill(ctx, models.Fill{OrderID: sell.VenueID, ClientID: "sell", Symbol: "SOLUSDT", Side: -1, Qty: 0.5, Price: 112})
+	if o := ot.order(t, "sell"); o.State != OrderPartiallyFilled || o.FilledQty != 1 || o.AvgPrice != 111 {
+		t.Fatalf("after the partial fills %+v", o)
+	}
+	if err := ot.oms.CancelClient(ctx, "sell"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ot.oms.CancelClient(ctx, "sell"); err == nil || !strings.Contains(err.Error(), "already cancelled") {
+		t.Fatalf("cancelled twice: %v", err)
+	}
+	// Gone from the venue as well
+	ot.price(120)
+	if len(ot.fills) != 0 {
+		t.Fatal("the cancelled limit filled on the venue")
+	}
+
+	// Rejected by the OMS before the venue sees it, and by the venue
+	if _, err := ot.oms.Submit(ctx, order("no-price", 1, "limit", 1, 0)); err == nil {
+		t.Fatal("limit with no price was placed")
+	}
+	if _, err := ot.oms.Submit(ctx, models.Order{ClientID: "no-quote", Symbol: "BTCUSDT", Side: 1, Qty: 1}); err == nil {
+		t.Fatal("order with no price on the venue was placed")
+	}
+	for _, id := range []string{"no-price", "no-quote"} {
+		if o := ot.order(t, id); o.State != OrderRejected || o.Detail == "" {
+			t.Fatalf("%s: %+v", id, o)
+		}
+	}
+
+	// A reduce-only stop the position is gone for by the time it triggers is cancelled by the venue
+	stop := order("stop", -1, "stop", 0, 90)
+	stop.ReduceOnly = true
+	if _, err := ot.oms.Submit(ctx, stop); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ot.oms.Submit(ctx, models.Order{ClientID: "close", Symbol: "SOLUSDT", Side: -1, ReduceOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+	ot.deliver()
+	ot.price(85)
+	if o := ot.order(t, "stop"); o.State != OrderCancelled || !strings.HasPrefix(o.Detail, "venue: ") {
+		t.Fatalf("stop with no position %+v", o)
+	}
+	if o := ot.order(t, "close"); o.State != OrderFilled || o.Qty != 2 {
+		t.Fatalf("reduce-only with no quantity %+v", o)
+	}
+
+	trail := ot.oms.Trail()
+	want := map[string][]string{
+		"buy":      {"submit:new", "ack:acknowledged", "fill:filled"},
+		"sell":     {"submit:new", "ack:acknowledged", "fill:partially_filled", "fill:partially_filled", "cancel:cancelled"},
+		"no-price": {"submit:new", "reject:rejected"},
+		"no-quote": {"submit:new", "reject:rejected"},
+		"stop":     {"submit:new", "ack:acknowledged", "cancel:cancelled"},
+	}
+	for id, w := range want {
+		if got := events(trail, id); strings.Join(got, " ") != strings.Join(w, " ") {
+			t.Errorf("%s: events %v, want %v", id, got, w)
+		}
+	}
+	// Every entry went out on the Audit channel too, in the same order
+	if len(audit) != len(trail) {
+		t.Fatalf("%d entries on the channel, %d in the trail", len(audit), len(trail))
+	}
+	for i := range trail {
+		if e := <-audit; e.Event != trail[i].Event || e.Order.ClientID != trail[i].Order.ClientID || e.Time != ot.now.UnixMilli() {
+			t.Fatalf("entry %d on the channel %+v, in the trail %+v", i, e, trail[i])
+		}
+	}
+}
+
+func TestOMSClientID(t *testing.T) {
+	ot := newOMSTest(t)
+	ctx := context.Background()
+
+	// A retried submit with the same client ID gives back the first order
+	first, err := ot.oms.Submit(ctx, order("retry", 1, "market", 1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := ot.oms.Submit(ctx, order("retry", 1, "market", 1, 0))
+	if err != nil || again != first {
+		t.Fatalf("retry gave order %d (%v), want %d", again, err, first)
+	}
+	ot.deliver()
+	if pos := ot.position(t); pos != 1 {
+		t.Fatalf("position %v after a retried submit, want 1", pos)
+	}
+
+	// The generated IDs are unique and carry the prefix
+	a, _ := ot.oms.Submit(ctx, order("", 1, "limit", 1, 90))
+	b, _ := ot.oms.Submit(ctx, order("", 1, "limit", 1, 90))
+	open := ot.oms.OpenOrders()
+	if a == b || len(open) != 2 || open[0].ClientID == open[1].ClientID || !strings.HasPrefix(open[0].ClientID, "test-") {
+		t.Fatalf("generated orders %d and %d: %+v", a, b, open)
+	}
+}
+
+func TestOMSOCO(t *testing.T) {
+	ot := newOMSTest(t)
+	ctx := context.Background()
+	if _, err := ot.oms.Submit(ctx, order("entry", 1, "market", 1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	ot.deliver()
+
+	// Take-profit and stop on the position, once one fills the other is cancelled on the venue
+	tp, sl := order("tp", -1, "limit", 1, 110), order("sl", -1, "stop", 1, 90)
+	tp.ReduceOnly, sl.ReduceOnly = true, true
+	ids, err := ot.oms.SubmitOCO(ctx, tp, sl)
+	if err != nil || len(ids) != 2 {
+		t.Fatalf("oco ids %v: %v", ids, err)
+	}
+	if o := ot.order(t, "sl"); o.State != OrderAcknowledged || o.OCO == "" || o.OCO != ot.order(t, "tp").OCO {
+		t.Fatalf("stop of the group %+v", o)
+	}
+	ot.price(111)
+	ot.deliver()
+	if o := ot.order(t, "tp"); o.State != OrderFilled {
+		t.Fatalf("take-profit %+v", o)
+	}
+	if o := ot.order(t, "sl"); o.State != OrderCancelled || o.Detail != "other oco order filled" {
+		t.Fatalf("stop after the take-profit filled %+v", o)
+	}
+	ot.price(80)
+	if len(ot.fills) != 0 || ot.position(t) != 0 {
+		t.Fatal("the cancelled stop filled on the venue")
+	}
+
+	// A member the OMS rejects takes the rest of the group with it
+	ids, err = ot.oms.SubmitOCO(ctx, order("a", 1, "limit", 1, 70), order("b", 1, "limit", 1, 0))
+	if err == nil || len(ids) != 1 {
+		t.Fatalf("oco with a bad member: ids %v, %v", ids, err)
+	}
+	if o := ot.order(t, "a"); o.State != OrderCancelled || o.Detail != "oco order rejected" {
+		t.Fatalf("other member %+v", o)
+	}
+}
+
+func TestOMSExpire(t *testing.T) {
+	ot := newOMSTest(t)
+	ctx := context.Background()
+	o := order("gtd", 1, "limit", 1, 95)
+	o.Expires = ot.now.Add(time.Minute).UnixMilli()
+	if _, err := ot.oms.Submit(ctx, o); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ot.oms.Submit(ctx, order("gtc", 1, "limit", 1, 95)); err != nil {
+		t.Fatal(err)
+	}
+
+	ot.now = ot.now.Add(59 * time.Second)
+	ot.oms.Expire(ctx)
+	if o := ot.order(t, "gtd"); o.State != OrderAcknowledged {
+		t.Fatalf("expired early %+v", o)
+	}
+	ot.now = ot.now.Add(time.Second)
+	ot.oms.Expire(ctx)
+	if o := ot.order(t, "gtd"); o.State != OrderExpired || o.Detail != "past expiry" {
+		t.Fatalf("past its expiry %+v", o)
+	}
+	if o := ot.order(t, "gtc"); o.State != OrderAcknowledged {
+		t.Fatalf("order with no expiry %+v", o)
+	}
+	// Only the order with no expiry is left on the venue
+	ot.price(90)
+	ot.deliver()
+	if pos := ot.position(t); pos != 1 {
+		t.Fatalf("position %v, want 1 from the order with no expiry", pos)
+	}
+	if got := events(ot.oms.Trail(), "gtd"); got[len(got)-1] != "expire:expired" {
+		t.Fatalf("events %v", got)
+	}
+}
+
+func TestOMSFlatten(t *testing.T) {
+	ot := newOMSTest(t)
+	ctx := context.Background()
+	if _, err := ot.oms.Submit(ctx, order("short", -1, "market", 3, 0)); err != nil {
+		t.Fatal(err)
+	}
+	ot.deliver()
+	if _, err := ot.oms.Submit(ctx, order("add", -1, "limit", 1, 105)); err != nil {
+		t.Fatal(err)
+	}
+
+	ot.oms.Flatten(ctx, "halted")
+	if o := ot.order(t, "add"); o.State != OrderCancelled || o.Detail != "halted" {
+		t.Fatalf("open order after flattening %+v", o)
+	}
+	open := ot.oms.OpenOrders()
+	if len(open) != 1 || open[0].Side != 1 || open[0].Qty != 3 || !open[0].ReduceOnly || open[0].Reason != "halted" {
+		t.Fatalf("closing orders %+v", open)
+	}
+	ot.deliver()
+	if pos := ot.position(t); pos != 0 || len(ot.oms.OpenOrders()) != 0 {
+		t.Fatalf("position %v and %d open orders after flattening", pos, len(ot.oms.OpenOrders()))
+	}
+}
+
+func TestOMSTrack(t *testing.T) {
+	ot := newOMSTest(t)
+	ctx := context.Background()
+	// Unbuffered, so the paper broker has to have let go of its lock before the OMS cancels the sibling through it
+	fills := make(chan models.Fill)
+	ot.paper.Fills = fills
+	out := make(chan models.Fill, 4)
+	go ot.oms.Track(ctx, fills, out)
+
+	if _, err := ot.oms.SubmitOCO(ctx, order("lo", 1, "limit", 1, 95), order("hi", 1, "stop", 1, 105)); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		ot.price(94)
+		close(done)
+	}()
+	select {
+	case f := <-out:
+		if f.ClientID != "lo" {
+			t.Fatalf("fill %+v", f)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the fill never came through Track")
+	}
+	<-done
+	if o := ot.order(t, "hi"); o.State != OrderCancelled {
+		t.Fatalf("sibling %+v", o)
+	}
+	close(fills)
+	if _, ok := <-out; ok {
+		t.Fatal("out was not closed")
+	}
+}
@@ -0,0 +1,307 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// Paper trading broker, runs on the live prices with a virtual balance so the bot can be run for weeks without risking any funds
+// The fills are modelled on a DEX:
+// Market orders fill at the last price, paying half the spread plus the price impact of swapping through a constant product pool
+// with the current liquidity (so larger orders get worse prices, and an order bigger than the pool is rejected)
+// Limit orders fill at the limit once the price trades through it, stop orders trigger at the stop and then fill like a market order
+// Stop-limit orders become a limit order at Price once the price trades through the StopPrice
+// Limit/stop orders only look at the move between price updates, so an order is never filled on a price from before it was placed
+// A waiting order that can not be filled when it triggers (bigger than the pool, or a reduce-only order with no position left) is cancelled
+// and reported on Cancelled, so the OMS can end its lifecycle, a market order that can not be filled straight away is rejected instead
+// The fills and cancels are only sent once the lock is released, since whoever gets them (e.g. the OMS cancelling an OCO sibling) can call back in
+
+type PaperConfig struct {
+	InitialBalance float64
+	MakerFee       float64 // Fee as a fraction of the notional for limit fills
+	TakerFee       float64 // Fee as a fraction of the notional for market and stop fills
+	Spread         float64 // Full bid/ask spread as a fraction of the price
+	Liquidity      float64 // Pool liquidity in USD used until SetLiquidity is called, 0 for no price impact
+	FillOnNextTick bool    // Fill market orders on the next price update instead of straight away (models the latency)
+}
+
+// The fees of the Solana DEXs are around 0.25%, the liquidity is a rough figure for the main SOL/USDC pools
+func DefaultPaperConfig() PaperConfig {
+	return PaperConfig{
+		InitialBalance: 10000,
+		MakerFee:       0.0025,
+		TakerFee:       0.0025,
+		Spread:         0.0002,
+		Liquidity:      20_000_000,
+	}
+}
+
+type quote struct {
+	price     float64
+	liquidity float64
+	openTime  int64
+}
+
+type Paper struct {
+	Config    PaperConfig
+	Fills     chan<- models.Fill                  // If set, every fill is sent here without the lock held (e.g. to LogFills)
+	Cancelled func(o models.Order, detail string) // If set, called (without the lock held) for every waiting order the broker cancelled itself (e.g. OMS.OnVenueCancel)
+	mu        sync.Mutex
+	cash      float64
+	positions map[string]Position
+	quotes    map[string]quote
+	open      []models.Order
+	triggered map[int64]bool // Stop-limit orders that have been triggered and are now waiting as a limit
+	nextID    int64
+}
+
+func NewPaper(cfg PaperConfig) *Paper {
+	return &Paper{
+		Config:    cfg,
+		cash:      cfg.InitialBalance,
+		positions: make(map[string]Position),
+		quotes:    make(map[string]quote),
+		triggered: make(map[int64]bool),
+	}
+}
+
+// Sets the pool liquidity used for the price impact (e.g. from bot.DexSnapshot)
+func (p *Paper) SetLiquidity(symbol string, usd float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	q := p.quotes[symbol]
+	q.liquidity = usd
+	p.quotes[symbol] = q
+}
+
+// Update gives the broker the latest candle (final or not) from the live stream, the close is taken as the current price
+// Any waiting orders the price has moved through are filled, and the fills are returned
+func (p *Paper) Update(symbol string, c models.CandleStick) []models.Fill {
+	p.mu.Lock()
+
+	q := p.quotes[symbol]
+	prev := q.price
+	if prev == 0 {
+		prev = c.Close
+	}
+	q.price, q.openTime = c.Close, c.OpenTime
+	if q.liquidity == 0 {
+		q.liquidity = p.Config.Liquidity
+	}
+	p.quotes[symbol] = q
+	low, high := math.Min(prev, c.Close), math.Max(prev, c.Close)
+
+	var fills []models.Fill
+	var cancelled []models.Order
+	var details []string
+	kept := p.open[:0]
+	for _, o := range p.open {
+		if o.Symbol != symbol {
+			kept = append(kept, o)
+			continue
+		}
+		var price float64
+		var maker, ok bool
+		switch o.Type {
+		case "limit":
+			ok = (o.Side == 1 && low <= o.Price) || (o.Side == -1 && high >= o.Price)
+			price, maker = o.Price, true
+		case "stop":
+			if ok = (o.Side == 1 && high >= o.Price) || (o.Side == -1 && low <= o.Price); ok {
+				price = o.Price
+			}
+		case "stop-limit":
+			if (o.Side == 1 && high >= o.StopPrice) || (o.Side == -1 && low <= o.StopPrice) {
+				p.triggered[o.ID] = true
+			}
+			if p.triggered[o.ID] {
+				ok = (o.Side == 1 && low <= o.Price) || (o.Side == -1 && high >= o.Price)
+				price, maker = o.Price, true
+			}
+		default:
+			ok, price = true, q.price
+		}
+		if !ok {
+			kept = append(kept, o)
+			continue
+		}
+		delete(p.triggered, o.ID)
+		if !maker {
+			var err error
+			if price, err = p.marketPrice(o, price, q.liquidity); err != nil {
+				// Not enough liquidity for the order, it is cancelled rather than left to fill at a silly price
+				cancelled, details = append(cancelled, o), append(details, err.Error())
+				continue
+			}
+		}
+		if f, ok := p.fill(o, price, maker, q.openTime); ok {
+			fills = append(fills, f)
+		} else {
+			cancelled, details = append(cancelled, o), append(details, "reduce-only order with no position to reduce")
+		}
+	}
+	p.open = kept
+	p.mu.Unlock()
+
+	p.send(fills)
+	for i, o := range cancelled {
+		if p.Cancelled != nil {
+			p.Cancelled(o, details[i])
+		} else {
+			log.Printf("Paper: cancelled order %d (%s): %s", o.ID, o.ClientID, details[i])
+		}
+	}
+	return fills
+}
+
+// The price after half the spread and the price impact, for an AMM with liquidity L the reserves on each side are worth L/2
+// Buying q USD worth gives an average price of p*R/(R-q) and selling gives p*R/(R+q), where R = L/2
+func (p *Paper) marketPrice(o models.Order, price, liquidity float64) (float64, error) {
+	price *= 1 + float64(o.Side)*p.Config.Spread/2
+	if liquidity <= 0 {
+		return price, nil
+	}
+	qty := o.Qty
+	if o.ReduceOnly {
+		qty = reduceQty(o, p.positions[o.Symbol].Qty)
+	}
+	reserve, notional := liquidity/2, qty*price
+	if o.Side == 1 {
+		if notional >= reserve {
+			return 0, fmt.Errorf("order of %.2f USD is larger than the pool (%.2f USD)", notional, reserve)
+		}
+		return price * reserve / (reserve - notional), nil
+	}
+	return price * reserve / (reserve + notional), nil
+}
+
+// Applies the fill to the cash and the position, must be called with the lock held
+func (p *Paper) fill(o models.Order, price float64, maker bool, openTime int64) (models.Fill, bool) {
+	pos := p.positions[o.Symbol]
+	qty := o.Qty
+	if o.ReduceOnly {
+		if sign(pos.Qty) != -o.Side {
+			return models.Fill{}, false
+		}
+		qty = reduceQty(o, pos.Qty)
+	}
+	if qty <= 0 {
+		return models.Fill{}, false
+	}
+
+	rate := p.Config.TakerFee
+	if maker {
+		rate = p.Config.MakerFee
+	}
+	fee := qty * price * rate
+	p.cash -= float64(o.Side)*qty*price + fee
+
+	// The entry price only changes when adding to the position, a reversal starts again from the fill price
+	newQty := pos.Qty + float64(o.Side)*qty
+	switch {
+	case math.Abs(newQty) < 1e-12:
+		delete(p.positions, o.Symbol)
+	case sign(pos.Qty) == o.Side || pos.Qty == 0:
+		pos.EntryPrice = (pos.EntryPrice*math.Abs(pos.Qty) + price*qty) / math.Abs(newQty)
+		pos.Symbol, pos.Qty = o.Symbol, newQty
+		p.positions[o.Symbol] = pos
+	case sign(newQty) != sign(pos.Qty):
+		p.positions[o.Symbol] = Position{Symbol: o.Symbol, Qty: newQty, EntryPrice: price}
+	default:
+		pos.Qty = newQty
+		p.positions[o.Symbol] = pos
+	}
+
+	f := models.Fill{OrderID: o.ID, ClientID: o.ClientID, Symbol: o.Symbol, OpenTime: openTime, Side: o.Side, Qty: qty, Price: price, Fee: fee, Maker: maker,
+		Type: o.Type, Reason: o.Reason, Confidence: o.Confidence}
+	return f, true
+}
+
+// Sends the fills on Fills, must be called without the lock held
+func (p *Paper) send(fills []models.Fill) {
+	if p.Fills == nil {
+		return
+	}
+	for _, f := range fills {
+		p.Fills <- f
+	}
+}
+
+func (p *Paper) Submit(ctx context.Context, o models.Order) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	p.mu.Lock()
+	id, fills, err := p.submit(o)
+	p.mu.Unlock()
+	p.send(fills)
+	return id, err
+}
+
+// Places the order and returns the fill if it filled straight away, must be called with the lock held
+func (p *Paper) submit(o models.Order) (int64, []models.Fill, error) {
+	q, ok := p.quotes[o.Symbol]
+	if !ok || q.price == 0 {
+		return 0, nil, fmt.Errorf("no price for %s yet", o.Symbol)
+	}
+	if o.Side != 1 && o.Side != -1 {
+		return 0, nil, fmt.Errorf("invalid side %d", o.Side)
+	}
+	if !o.ReduceOnly && o.Qty <= 0 {
+		return 0, nil, fmt.Errorf("invalid quantity %f", o.Qty)
+	}
+	if (o.Type == "limit" || o.Type == "stop" || o.Type == "stop-limit") && o.Price <= 0 {
+		return 0, nil, fmt.Errorf("%s order needs a price", o.Type)
+	}
+	if o.Type == "stop-limit" && o.StopPrice <= 0 {
+		return 0, nil, fmt.Errorf("stop-limit order needs a stop price")
+	}
+	p.nextID++
+	o.ID = p.nextID
+	if o.Type == "" {
+		o.Type = "market"
+	}
+
+	if o.Type == "market" && !p.Config.FillOnNextTick {
+		price, err := p.marketPrice(o, q.price, q.liquidity)
+		if err != nil {
+			return 0, nil, err
+		}
+		f, ok := p.fill(o, price, false, q.openTime)
+		if !ok {
+			return 0, nil, fmt.Errorf("reduce-only order with no %s position to reduce", o.Symbol)
+		}
+		return o.ID, []models.Fill{f}, nil
+	}
+	p.open = append(p.open, o)
+	return o.ID, nil, nil
+}
+
+func (p *Paper) Cancel(ctx context.Context, id int64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, o := range p.open {
+		if o.ID == id {
+			p.open = append(p.open[:i], p.open[i+1:]...)
+			delete(p.triggered, id)
+			return nil
+		}
+	}
+	return fmt.Errorf("order %d is not open", id)
+}
+
+func (p *Paper) Account(ctx context.Context) (Account, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	acct := Account{Cash: p.cash, Equity: p.cash, Positions: make(map[string]Position, len(p.positions))}
+	for sym, pos := range p.positions {
+		acct.Positions[sym] = pos
+		acct.Equity += pos.Qty * p.quotes[sym].price
+	}
+	return acct, nil
+}
@@ -0,0 +1,436 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// The risk manager sits in front of the execution venue (the OMS places its orders through it), so nothing reaches the venue without passing the limits
+// Before each order (pre-trade):
+//   - the position in the symbol after the order, and the gross exposure over all symbols, are kept under a fraction of the equity
+//   - only so many entries are allowed per hour
+//   - no entries during the cooldown after too many losing trades in a row
+//   - nothing but reduce-only orders while trading is halted
+// Continuously (Check on every candle, and on every order):
+//   - trading halts once the loss since the start of the (UTC) day goes over the daily limit, until the next day
+//   - trading halts once the drawdown from the equity peak goes over the limit, until Resume is called (e.g. a restart)
+//   - trading halts while the kill file exists, the manual kill switch
+// Reduce-only orders are always let through so a position can still be closed
+// A blocked order fails with a RiskError naming the rule, so the OMS rejects it, and every block, halt and resume is kept and sent on Events (e.g. to risk_events)
+
+type RiskRule string
+
+const (
+	RuleMaxPosition  RiskRule = "max_position"
+	RuleMaxGross     RiskRule = "max_gross"
+	RuleDailyLoss    RiskRule = "daily_loss"
+	RuleMaxDrawdown  RiskRule = "max_drawdown"
+	RuleTradeRate    RiskRule = "trade_rate"
+	RuleLossCooldown RiskRule = "loss_cooldown"
+	RuleKillSwitch   RiskRule = "kill_switch"
+	RuleNoPrice      RiskRule = "no_price" // The order could not be valued, so it is blocked rather than let through unchecked
+)
+
+type RiskLimits struct {
+	MaxPosition      float64       // Notional of the position in one symbol as a fraction of the equity, 0 for no limit
+	MaxGross         float64       // Notional of all the positions as a fraction of the equity, 0 for no limit
+	MaxDailyLoss     float64       // Fraction of the equity at the start of the day, 0 for no limit
+	MaxDrawdown      float64       // Fraction below the equity peak, 0 for no limit
+	MaxTradesPerHour int           // Entries in any hour, 0 for no limit
+	MaxLosses        int           // Losing trades in a row before the cooldown, 0 for no cooldown
+	Cooldown         time.Duration // How long entries are blocked after MaxLosses losing trades
+	Flatten          bool          // Close every position (and cancel the open orders) when trading halts
+}
+
+// A little room over the sizing's full size so fees and slippage on a full size entry do not trip the position limit
+func DefaultRiskLimits() RiskLimits {
+	return RiskLimits{
+		MaxPosition:      1.5,
+		MaxGross:         2,
+		MaxDailyLoss:     0.05,
+		MaxDrawdown:      0.15,
+		MaxTradesPerHour: 20,
+		MaxLosses:        3,
+		Cooldown:         time.Hour,
+		Flatten:          true,
+	}
+}
+
+// Parses a comma separated list of key=value, for example "max_position=1,daily_loss=0.03,max_losses=4,cooldown=2h,flatten=false"
+// The keys are max_position, max_gross, daily_loss, drawdown, trades_per_hour, max_losses, cooldown and flatten
+func ParseRiskLimits(s string) (RiskLimits, error) {
+	limits := DefaultRiskLimits()
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return limits, fmt.Errorf("risk setting %q should be key=value", part)
+		}
+		var err error
+		switch key {
+		case "max_position":
+			limits.MaxPosition, err = strconv.ParseFloat(val, 64)
+		case "max_gross":
+			limits.MaxGross, err = strconv.ParseFloat(val, 64)
+		case "daily_loss":
+			limits.MaxDailyLoss, err = strconv.ParseFloat(val, 64)
+		case "drawdown":
+			limits.MaxDrawdown, err = strconv.ParseFloat(val, 64)
+		case "trades_per_hour":
+			limits.MaxTradesPerHour, err = strconv.Atoi(val)
+		case "max_losses":
+			limits.MaxLosses, err = strconv.Atoi(val)
+		case "cooldown":
+			limits.Cooldown, err = time.ParseDuration(val)
+		case "flatten":
+			limits.Flatten, err = strconv.ParseBool(val)
+		default:
+			return limits, fmt.Errorf("unknown risk setting %q", key)
+		}
+		if err != nil {
+			return limits, fmt.Errorf("invalid risk setting %q: %w", part, err)
+		}
+	}
+	return limits, nil
+}
+
+type RiskError struct {
+	Rule   RiskRule
+	Detail string
+}
+
+func (e *RiskError) Error() string {
+	return fmt.Sprintf("blocked by risk rule %s: %s", e.Rule, e.Detail)
+}
+
+type RiskEvent struct {
+	Time   int64 // Unix time in ms
+	Rule   RiskRule
+	Action string       // "block", "halt", "resume" or "cooldown"
+	Order  models.Order // The blocked order, empty for the others
+	Equity float64
+	Detail string
+}
+
+// The position as worked out from the fills, to find the result of each trade for the losing streak
+type riskPosition struct {
+	qty   float64 // Signed
+	entry float64 // Average entry price
+	pnl   float64 // Of the trade so far, after fees
+}
+
+type Risk struct {
+	Limits   RiskLimits
+	Venue    Broker
+	KillFile string           // If set, trading halts while this file exists
+	Events   chan<- RiskEvent // If set, every event is also sent here (e.g. to LogRisk)
+	Now      func() time.Time // Defaults to time.Now
+	mu       sync.Mutex
+	prices   map[string]float64 // Last price of each symbol, from Update
+	halted   RiskRule           // Rule that halted trading, "" while trading
+	haltInfo string
+	byFile   bool   // The halt came from the kill file, so it is lifted once the file is removed
+	day      string // UTC date dayStart is for
+	dayStart float64
+	peak     float64
+	entries  []int64 // Times of the recent entries, for the trade rate
+	losses   int     // Losing trades in a row
+	cooldown int64   // Entries are blocked until this time (ms)
+	pos      map[string]*riskPosition
+	events   []RiskEvent
+}
+
+func NewRisk(limits RiskLimits, venue Broker) *Risk {
+	return &Risk{
+		Limits: limits,
+		Venue:  venue,
+		Now:    time.Now,
+		prices: make(map[string]float64),
+		pos:    make(map[string]*riskPosition),
+	}
+}
+
+// Records an event, must be called with the lock held
+func (r *Risk) record(rule RiskRule, action string, o models.Order, equity float64, detail string) {
+	e := RiskEvent{Time: r.Now().UnixMilli(), Rule: rule, Action: action, Order: o, Equity: equity, Detail: detail}
+	log.Printf("Risk: %s %s: %s", action, rule, detail)
+	r.events = append(r.events, e)
+	if r.Events != nil {
+		r.Events <- e
+	}
+}
+
+// Update gives the risk manager the latest candle, the close is used to value the positions and the market orders
+func (r *Risk) Update(symbol string, c models.CandleStick) {
+	r.mu.Lock()
+	r.prices[symbol] = c.Close
+	r.mu.Unlock()
+}
+
+// Halt stops all new entries until Resume is called, this is the manual kill switch
+func (r *Risk) Halt(detail string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.halt(RuleKillSwitch, 0, detail)
+}
+
+// Must be called with the lock held, returns false if trading was already halted
+func (r *Risk) halt(rule RiskRule, equity float64, detail string) bool {
+	if r.halted != "" {
+		return false
+	}
+	r.halted, r.haltInfo = rule, detail
+	r.record(rule, "halt", models.Order{}, equity, detail)
+	return true
+}
+
+// Resume lets trading carry on after a halt, the equity peak and the start of the day are reset so the same loss does not halt it again straight away
+// This is the only way out of a drawdown halt, so it is the only place the peak is reset
+func (r *Risk) Resume() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.halted == "" {
+		return
+	}
+	r.peak, r.day = 0, ""
+	r.resume(0)
+}
+
+// The halts that end by themselves (the next day, the kill file removed) keep the peak, a drawdown carries on across days
+func (r *Risk) resume(equity float64) {
+	if r.halted == "" {
+		return
+	}
+	rule := r.halted
+	r.halted, r.haltInfo, r.byFile = "", "", false
+	r.record(rule, "resume", models.Order{}, equity, "trading resumed")
+}
+
+// Halted returns the rule trading is halted by, "" if it is not
+func (r *Risk) Halted() (RiskRule, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.halted, r.haltInfo
+}
+
+// Updates the day start and peak equity and checks the halts, must be called with the lock held
+// Returns true if trading was halted by this call
+func (r *Risk) observe(equity float64) bool {
+	now := r.Now().UTC()
+	tripped := false
+	if day := now.Format("2006-01-02"); day != r.day {
+		r.day, r.dayStart = day, equity
+		if r.halted == RuleDailyLoss {
+			r.resume(equity)
+		}
+	}
+	if r.peak == 0 || equity > r.peak {
+		r.peak = equity
+	}
+
+	if r.KillFile != "" {
+		_, err := os.Stat(r.KillFile)
+		switch {
+		case err == nil:
+			if r.halt(RuleKillSwitch, equity, fmt.Sprintf("kill file %s exists", r.KillFile)) {
+				r.byFile, tripped = true, true
+			}
+		case r.byFile && os.IsNotExist(err):
+			r.resume(equity)
+		}
+	}
+	if r.Limits.MaxDailyLoss > 0 && r.dayStart > 0 {
+		if loss := (r.dayStart - equity) / r.dayStart; loss >= r.Limits.MaxDailyLoss {
+			tripped = r.halt(RuleDailyLoss, equity, fmt.Sprintf("down %.2f%% today (equity %.2f from %.2f), the limit is %.2f%%",
+				loss*100, equity, r.dayStart, r.Limits.MaxDailyLoss*100)) || tripped
+		}
+	}
+	if r.Limits.MaxDrawdown > 0 && r.peak > 0 {
+		if dd := (r.peak - equity) / r.peak; dd >= r.Limits.MaxDrawdown {
+			tripped = r.halt(RuleMaxDrawdown, equity, fmt.Sprintf("drawdown %.2f%% (equity %.2f from a peak of %.2f), the limit is %.2f%%",
+				dd*100, equity, r.peak, r.Limits.MaxDrawdown*100)) || tripped
+		}
+	}
+	return tripped
+}
+
+// Check reads the account and checks the halts, it should be called on every candle
+// Returns true if trading has just halted and the positions should be closed (see OMS.Flatten)
+func (r *Risk) Check(ctx context.Context) bool {
+	acct, err := r.Venue.Account(ctx)
+	if err != nil {
+		log.Println("Risk: could not get the account:", err)
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.observe(acct.Equity) && r.Limits.Flatten
+}
+
+// Checks an order against the limits, must be called with the lock held
+func (r *Risk) check(o models.Order, acct Account) *RiskError {
+	if r.halted != "" {
+		return &RiskError{Rule: r.halted, Detail: "trading is halted: " + r.haltInfo}
+	}
+	now := r.Now().UnixMilli()
+	if now < r.cooldown {
+		return &RiskError{Rule: RuleLossCooldown, Detail: fmt.Sprintf("cooling down after %d losing trades until %s",
+			r.Limits.MaxLosses, time.UnixMilli(r.cooldown).UTC().Format(time.RFC3339))}
+	}
+	if r.Limits.MaxTradesPerHour > 0 {
+		recent := r.entries[:0]
+		for _, t := range r.entries {
+			if now-t < time.Hour.Milliseconds() {
+				recent = append(recent, t)
+			}
+		}
+		r.entries = recent
+		if len(recent) >= r.Limits.MaxTradesPerHour {
+			return &RiskError{Rule: RuleTradeRate, Detail: fmt.Sprintf("%d entries in the last hour, the limit is %d", len(recent), r.Limits.MaxTradesPerHour)}
+		}
+	}
+
+	price := o.Price
+	if o.Type == "market" || price <= 0 {
+		price = r.prices[o.Symbol]
+	}
+	if price <= 0 {
+		return &RiskError{Rule: RuleNoPrice, Detail: "no price for " + o.Symbol}
+	}
+	if acct.Equity <= 0 {
+		return &RiskError{Rule: RuleMaxPosition, Detail: fmt.Sprintf("equity is %.2f", acct.Equity)}
+	}
+	after := math.Abs(acct.Positions[o.Symbol].Qty+float64(o.Side)*o.Qty) * price
+	if r.Limits.MaxPosition > 0 && after > r.Limits.MaxPosition*acct.Equity {
+		return &RiskError{Rule: RuleMaxPosition, Detail: fmt.Sprintf("%s position would be %.2f (%.1f%% of equity), the limit is %.1f%%",
+			o.Symbol, after, after/acct.Equity*100, r.Limits.MaxPosition*100)}
+	}
+	if r.Limits.MaxGross > 0 {
+		gross := after
+		for symbol, pos := range acct.Positions {
+			if symbol == o.Symbol {
+				continue
+			}
+			p := r.prices[symbol]
+			if p <= 0 {
+				p = pos.EntryPrice
+			}
+			gross += math.Abs(pos.Qty) * p
+		}
+		if gross > r.Limits.MaxGross*acct.Equity {
+			return &RiskError{Rule: RuleMaxGross, Detail: fmt.Sprintf("gross exposure would be %.2f (%.1f%% of equity), the limit is %.1f%%",
+				gross, gross/acct.Equity*100, r.Limits.MaxGross*100)}
+		}
+	}
+	return nil
+}
+
+// Submit checks the order against the limits and passes it on to the venue if it is allowed
+func (r *Risk) Submit(ctx context.Context, o models.Order) (int64, error) {
+	if o.ReduceOnly {
+		return r.Venue.Submit(ctx, o)
+	}
+	acct, err := r.Venue.Account(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("could not check the risk limits: %w", err)
+	}
+
+	r.mu.Lock()
+	r.observe(acct.Equity)
+	if rerr := r.check(o, acct); rerr != nil {
+		r.record(rerr.Rule, "block", o, acct.Equity, rerr.Detail)
+		r.mu.Unlock()
+		return 0, rerr
+	}
+	r.entries = append(r.entries, r.Now().UnixMilli())
+	r.mu.Unlock()
+	return r.Venue.Submit(ctx, o)
+}
+
+func (r *Risk) Cancel(ctx context.Context, id int64) error {
+	return r.Venue.Cancel(ctx, id)
+}
+
+func (r *Risk) Account(ctx context.Context) (Account, error) {
+	return r.Venue.Account(ctx)
+}
+
+// Passes the lot size rounding of the venue through to the OMS (see QtyRounder)
+func (r *Risk) RoundQty(symbol string, qty float64) float64 {
+	if rounder, ok := r.Venue.(QtyRounder); ok {
+		return rounder.RoundQty(symbol, qty)
+	}
+	return qty
+}
+
+// OnFill follows the positions to find the result of each trade, too many losing trades in a row starts the cooldown
+func (r *Risk) OnFill(f models.Fill) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.pos[f.Symbol]
+	if !ok {
+		p = &riskPosition{}
+		r.pos[f.Symbol] = p
+	}
+	p.pnl -= f.Fee
+	if p.qty == 0 || sign(p.qty) == f.Side {
+		p.entry = (p.entry*math.Abs(p.qty) + f.Price*f.Qty) / (math.Abs(p.qty) + f.Qty)
+		p.qty += float64(f.Side) * f.Qty
+		return
+	}
+
+	closed := math.Min(f.Qty, math.Abs(p.qty))
+	p.pnl += closed * (f.Price - p.entry) * float64(sign(p.qty))
+	p.qty += float64(f.Side) * f.Qty
+	if math.Abs(p.qty) < 1e-9 {
+		p.qty = 0
+	}
+	if p.qty != 0 && sign(p.qty) != f.Side {
+		return // Only part of the position was closed
+	}
+
+	// The trade is over (if the fill reversed the position, the rest opens the next one)
+	if p.pnl < 0 {
+		r.losses++
+	} else {
+		r.losses = 0
+	}
+	p.pnl, p.entry = 0, f.Price
+	if r.Limits.MaxLosses > 0 && r.losses >= r.Limits.MaxLosses {
+		r.cooldown = r.Now().Add(r.Limits.Cooldown).UnixMilli()
+		r.record(RuleLossCooldown, "cooldown", models.Order{}, 0, fmt.Sprintf("%d losing trades in a row, no entries for %s", r.losses, r.Limits.Cooldown))
+		r.losses = 0
+	}
+}
+
+// Track passes every fill to OnFill and then on to out, out is closed once fills is closed (the same as OMS.Track)
+func (r *Risk) Track(fills <-chan models.Fill, out chan<- models.Fill) {
+	for f := range fills {
+		r.OnFill(f)
+		if out != nil {
+			out <- f
+		}
+	}
+	if out != nil {
+		close(out)
+	}
+}
+
+// Log returns the events so far
+func (r *Risk) Log() []RiskEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RiskEvent(nil), r.events...)
+}
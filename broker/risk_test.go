@@ -0,0 +1,331 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// The risk manager in front of a fake venue whose account is set by the test, with the clock moved by hand
+
+type fakeVenue struct {
+	acct      Account
+	submitted []models.Order
+}
+
+func (v *fakeVenue) Submit(ctx context.Context, o models.Order) (int64, error) {
+	v.submitted = append(v.submitted, o)
+	return int64(len(v.submitted)), nil
+}
+
+func (v *fakeVenue) Cancel(ctx context.Context, id int64) error { return nil }
+
+func (v *fakeVenue) Account(ctx context.Context) (Account, error) { return v.acct, nil }
+
+type riskTest struct {
+	risk  *Risk
+	venue *fakeVenue
+	now   time.Time
+}
+
+func newRiskTest(limits RiskLimits, equity float64) *riskTest {
+	rt := &riskTest{venue: &fakeVenue{acct: Account{Equity: equity, Positions: map[string]Position{}}}}
+	rt.now = time.Date(2025, 10, 10, 12, 0, 0, 0, time.UTC)
+	rt.risk = NewRisk(limits, rt.venue)
+	rt.risk.Now = func() time.Time { return rt.now }
+	rt.risk.Update("SOLUSDT", models.CandleStick{Close: 100})
+	return rt
+}
+
+// The rule that blocked the order, "" if it went through to the venue
+func (rt *riskTest) submit(t *testing.T, o models.Order) RiskRule {
+	t.Helper()
+	sent := len(rt.venue.submitted)
+	_, err := rt.risk.Submit(context.Background(), o)
+	var rerr *RiskError
+	switch {
+	case err == nil:
+		if len(rt.venue.submitted) != sent+1 {
+			t.Fatalf("order %+v was allowed but never reached the venue", o)
+		}
+		return ""
+	case errors.As(err, &rerr):
+		if len(rt.venue.submitted) != sent {
+			t.Fatalf("blocked order %+v reached the venue", o)
+		}
+		return rerr.Rule
+	}
+	t.Fatal(err)
+	return ""
+}
+
+// Checks the halts with the account at this equity
+func (rt *riskTest) check(equity float64) bool {
+	rt.venue.acct.Equity = equity
+	return rt.risk.Check(context.Background())
+}
+
+func buyOrder(symbol string, qty float64) models.Order {
+	return models.Order{Symbol: symbol, Side: 1, Type: "market", Qty: qty}
+}
+
+func TestRiskPreTrade(t *testing.T) {
+	sell := func(qty float64) models.Order {
+		return models.Order{Symbol: "SOLUSDT", Side: -1, Type: "market", Qty: qty}
+	}
+	limitBuy := buyOrder("SOLUSDT", 11)
+	limitBuy.Type, limitBuy.Price = "limit", 90
+	reduce := sell(50)
+	reduce.ReduceOnly = true
+
+	tests := []struct {
+		name      string
+		limits    RiskLimits
+		positions []Position
+		setup     func(t *testing.T, rt *riskTest)
+		order     models.Order
+		want      RiskRule
+	}{
+		{name: "under the position limit", limits: RiskLimits{MaxPosition: 1}, order: buyOrder("SOLUSDT", 10)},
+		{name: "over the position limit", limits: RiskLimits{MaxPosition: 1}, order: buyOrder("SOLUSDT", 11), want: RuleMaxPosition},
+		{name: "adding to the position", limits: RiskLimits{MaxPosition: 1}, positions: []Position{{Symbol: "SOLUSDT", Qty: 5}},
+			order: buyOrder("SOLUSDT", 6), want: RuleMaxPosition},
+		{name: "cutting a position over the limit", limits: RiskLimits{MaxPosition: 1}, positions: []Position{{Symbol: "SOLUSDT", Qty: 15}},
+			order: sell(5)},
+		{name: "reversing past the limit", limits: RiskLimits{MaxPosition: 1}, positions: []Position{{Symbol: "SOLUSDT", Qty: 5}},
+			order: sell(16), want: RuleMaxPosition},
+		{name: "limit order valued at its price", limits: RiskLimits{MaxPosition: 1}, order: limitBuy},
+		{name: "under the gross limit", limits: RiskLimits{MaxGross: 1.5}, positions: []Position{{Symbol: "ETHUSDT", Qty: 10, EntryPrice: 40}},
+			setup: func(t *testing.T, rt *riskTest) { rt.risk.Update("ETHUSDT", models.CandleStick{Close: 50}) }, order: buyOrder("SOLUSDT", 10)},
+		{name: "over the gross limit", limits: RiskLimits{MaxGross: 1.5}, positions: []Position{{Symbol: "ETHUSDT", Qty: 10, EntryPrice: 40}},
+			setup: func(t *testing.T, rt *riskTest) { rt.risk.Update("ETHUSDT", models.CandleStick{Close: 50}) }, order: buyOrder("SOLUSDT", 11), want: RuleMaxGross},
+		{name: "gross at the entry price with no last price", limits: RiskLimits{MaxGross: 1.5}, positions: []Position{{Symbol: "ETHUSDT", Qty: 10, EntryPrice: 60}},
+			order: buyOrder("SOLUSDT", 10), want: RuleMaxGross},
+		{name: "no price to value the order", limits: RiskLimits{MaxPosition: 1}, order: buyOrder("BTCUSDT", 0.001), want: RuleNoPrice},
+		{name: "under the trade rate", limits: RiskLimits{MaxTradesPerHour: 2}, setup: func(t *testing.T, rt *riskTest) {
+			rt.submit(t, buyOrder("SOLUSDT", 1))
+		}, order: buyOrder("SOLUSDT", 1)},
+		{name: "over the trade rate", limits: RiskLimits{MaxTradesPerHour: 2}, setup: func(t *testing.T, rt *riskTest) {
+			rt.submit(t, buyOrder("SOLUSDT", 1))
+			rt.now = rt.now.Add(30 * time.Minute)
+			rt.submit(t, buyOrder("SOLUSDT", 1))
+		}, order: buyOrder("SOLUSDT", 1), want: RuleTradeRate},
+		{name: "the first entry out of the hour", limits: RiskLimits{MaxTradesPerHour: 2}, setup: func(t *testing.T, rt *riskTest) {
+			rt.submit(t, buyOrder("SOLUSDT", 1))
+			rt.now = rt.now.Add(30 * time.Minute)
+			rt.submit(t, buyOrder("SOLUSDT", 1))
+			rt.now = rt.now.Add(30 * time.Minute)
+		}, order: buyOrder("SOLUSDT", 1)},
+		{name: "entry while halted", limits: RiskLimits{}, setup: func(t *testing.T, rt *riskTest) { rt.risk.Halt("manual") },
+			order: buyOrder("SOLUSDT", 1), want: RuleKillSwitch},
+		{name: "reduce-only while halted", limits: RiskLimits{MaxPosition: 1}, positions: []Position{{Symbol: "SOLUSDT", Qty: 50}},
+			setup: func(t *testing.T, rt *riskTest) { rt.risk.Halt("manual") }, order: reduce},
+		{name: "reduce-only during the cooldown and over the limits", limits: RiskLimits{MaxPosition: 0.1, MaxTradesPerHour: 1}, positions: []Position{{Symbol: "SOLUSDT", Qty: 50}},
+			setup: func(t *testing.T, rt *riskTest) {
+				rt.submit(t, buyOrder("SOLUSDT", 0.1))
+				rt.risk.cooldown = rt.now.Add(time.Hour).UnixMilli()
+			}, order: reduce},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rt := newRiskTest(tc.limits, 1000)
+			for _, p := range tc.positions {
+				rt.venue.acct.Positions[p.Symbol] = p
+			}
+			if tc.setup != nil {
+				tc.setup(t, rt)
+			}
+			if got := rt.submit(t, tc.order); got != tc.want {
+				t.Fatalf("rule %q, want %q", got, tc.want)
+			}
+			if tc.want != "" {
+				events := rt.risk.Log()
+				if last := events[len(events)-1]; last.Action != "block" || last.Rule != tc.want || last.Order.Qty != tc.order.Qty {
+					t.Fatalf("last event %+v", last)
+				}
+			}
+		})
+	}
+}
+
+func TestRiskLossCooldown(t *testing.T) {
+	rt := newRiskTest(RiskLimits{MaxLosses: 2, Cooldown: time.Hour}, 1000)
+	trade := func(entry, exit float64) {
+		rt.risk.OnFill(models.Fill{Symbol: "SOLUSDT", Side: 1, Qty: 1, Price: entry})
+		rt.risk.OnFill(models.Fill{Symbol: "SOLUSDT", Side: -1, Qty: 1, Price: exit})
+	}
+
+	// A win in between breaks the streak
+	trade(100, 90)
+	trade(100, 110)
+	trade(100, 90)
+	if got := rt.submit(t, buyOrder("SOLUSDT", 1)); got != "" {
+		t.Fatalf("blocked by %s after a loss, a win and a loss", got)
+	}
+
+	// The fees can turn a trade that made a little into a loss
+	rt.risk.OnFill(models.Fill{Symbol: "SOLUSDT", Side: 1, Qty: 1, Price: 100, Fee: 1})
+	// Half closed, the trade is not over yet
+	rt.risk.OnFill(models.Fill{Symbol: "SOLUSDT", Side: -1, Qty: 0.5, Price: 101})
+	if rt.risk.cooldown != 0 {
+		t.Fatal("cooling down on a trade that was still open")
+	}
+	rt.risk.OnFill(models.Fill{Symbol: "SOLUSDT", Side: -1, Qty: 0.5, Price: 101, Fee: 1})
+	if got := rt.submit(t, buyOrder("SOLUSDT", 1)); got != RuleLossCooldown {
+		t.Fatalf("rule %q after two losing trades in a row, want %q", got, RuleLossCooldown)
+	}
+	rt.now = rt.now.Add(59 * time.Minute)
+	if got := rt.submit(t, buyOrder("SOLUSDT", 1)); got != RuleLossCooldown {
+		t.Fatalf("rule %q before the cooldown is over", got)
+	}
+	rt.now = rt.now.Add(time.Minute)
+	if got := rt.submit(t, buyOrder("SOLUSDT", 1)); got != "" {
+		t.Fatalf("blocked by %s after the cooldown", got)
+	}
+
+	// The streak starts again after the cooldown, and a reversal ends the trade it closes
+	rt.risk.OnFill(models.Fill{Symbol: "SOLUSDT", Side: 1, Qty: 1, Price: 100})
+	rt.risk.OnFill(models.Fill{Symbol: "SOLUSDT", Side: -1, Qty: 2, Price: 95})
+	if rt.risk.cooldown > rt.now.UnixMilli() {
+		t.Fatal("cooling down after one loss")
+	}
+	rt.risk.OnFill(models.Fill{Symbol: "SOLUSDT", Side: 1, Qty: 1, Price: 97})
+	if got := rt.submit(t, buyOrder("SOLUSDT", 1)); got != RuleLossCooldown {
+		t.Fatalf("rule %q after a losing long and a losing short, want %q", got, RuleLossCooldown)
+	}
+	var cooldowns int
+	for _, e := range rt.risk.Log() {
+		if e.Action == "cooldown" {
+			cooldowns++
+		}
+	}
+	if cooldowns != 2 {
+		t.Fatalf("%d cooldown events, want 2", cooldowns)
+	}
+}
+
+func TestRiskDailyLossAndDrawdown(t *testing.T) {
+	rt := newRiskTest(RiskLimits{MaxDailyLoss: 0.05, MaxDrawdown: 0.12, Flatten: true}, 0)
+	halted := func(want RiskRule) {
+		t.Helper()
+		if rule, _ := rt.risk.Halted(); rule != want {
+			t.Fatalf("halted by %q, want %q", rule, want)
+		}
+	}
+
+	// Day one starts at 1100 (the peak) and loses 5.5%
+	if rt.check(1100) {
+		t.Fatal("halted at the start")
+	}
+	if !rt.check(1040) {
+		t.Fatal("the daily loss did not halt trading")
+	}
+	halted(RuleDailyLoss)
+	if rt.check(1030) {
+		t.Fatal("asked to flatten again while halted")
+	}
+	if got := rt.submit(t, buyOrder("SOLUSDT", 1)); got != RuleDailyLoss {
+		t.Fatalf("entry blocked by %q while halted", got)
+	}
+
+	// The next day starts from the equity it opens at
+	rt.now = rt.now.Add(24 * time.Hour)
+	if rt.check(1040) {
+		t.Fatal("halted at the start of the next day")
+	}
+	halted("")
+	rt.check(990) // Down 4.8% on the day and 10% from the peak
+
+	// Day three is only down 3.5%, but the peak from day one is kept so the drawdown is 13.2%
+	rt.now = rt.now.Add(24 * time.Hour)
+	rt.check(990)
+	if !rt.check(955) {
+		t.Fatal("the drawdown did not halt trading")
+	}
+	halted(RuleMaxDrawdown)
+
+	// A drawdown halt does not end with the day
+	rt.now = rt.now.Add(24 * time.Hour)
+	rt.check(955)
+	halted(RuleMaxDrawdown)
+
+	// Only Resume ends it, and the peak starts again from there
+	rt.risk.Resume()
+	halted("")
+	if rt.check(955) || rt.check(920) {
+		t.Fatal("halted again on the same drawdown after resuming")
+	}
+
+	var got []string
+	for _, e := range rt.risk.Log() {
+		if e.Action != "block" {
+			got = append(got, e.Action+" "+string(e.Rule))
+		}
+	}
+	want := []string{"halt daily_loss", "resume daily_loss", "halt max_drawdown", "resume max_drawdown"}
+	if len(got) != len(want) {
+		t.Fatalf("events %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("events %v, want %v", got, want)
+		}
+	}
+
+	// Without Flatten the halt is still made but nothing is asked to close
+	rt = newRiskTest(RiskLimits{MaxDailyLoss: 0.05}, 0)
+	rt.check(1000)
+	if rt.check(900) {
+		t.Fatal("asked to flatten with Flatten off")
+	}
+	halted(RuleDailyLoss)
+}
+
+func TestRiskKillFile(t *testing.T) {
+	rt := newRiskTest(RiskLimits{Flatten: true}, 0)
+	rt.risk.KillFile = filepath.Join(t.TempDir(), "KILL")
+	if rt.check(1000) {
+		t.Fatal("halted with no kill file")
+	}
+
+	if err := os.WriteFile(rt.risk.KillFile, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if !rt.check(1000) {
+		t.Fatal("the kill file did not halt trading")
+	}
+	if rule, _ := rt.risk.Halted(); rule != RuleKillSwitch {
+		t.Fatalf("halted by %q", rule)
+	}
+	if got := rt.submit(t, buyOrder("SOLUSDT", 1)); got != RuleKillSwitch {
+		t.Fatalf("entry blocked by %q with the kill file there", got)
+	}
+
+	// Removing the file lifts the halt it made
+	if err := os.Remove(rt.risk.KillFile); err != nil {
+		t.Fatal(err)
+	}
+	rt.check(1000)
+	if rule, _ := rt.risk.Halted(); rule != "" {
+		t.Fatalf("still halted by %q after the kill file was removed", rule)
+	}
+	if got := rt.submit(t, buyOrder("SOLUSDT", 1)); got != "" {
+		t.Fatalf("entry blocked by %q after the kill file was removed", got)
+	}
+
+	// But not a manual halt
+	rt.risk.Halt("manual")
+	rt.check(1000)
+	if rule, _ := rt.risk.Halted(); rule != RuleKillSwitch {
+		t.Fatal("a manual halt was lifted with no kill file")
+	}
+	rt.risk.Resume()
+	if rule, _ := rt.risk.Halted(); rule != "" {
+		t.Fatalf("still halted by %q after Resume", rule)
+	}
+}
@@ -0,0 +1,61 @@
+package solana // Execution on Solana through a swap aggregator, kept apart from the broker package since it is only needed for on-chain trading
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Solana writes the public keys and signatures in base58 (the Bitcoin alphabet, no 0/O/I/l)
+// The leading zero bytes are written as leading '1's
+
+const alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var alphabetIndex = func() [256]int {
+	var idx [256]int
+	for i := range idx {
+		idx[i] = -1
+	}
+	for i := 0; i < len(alphabet); i++ {
+		idx[alphabet[i]] = i
+	}
+	return idx
+}()
+
+func EncodeBase58(b []byte) string {
+	zeros := 0
+	for zeros < len(b) && b[zeros] == 0 {
+		zeros++
+	}
+	n := new(big.Int).SetBytes(b)
+	base, mod := big.NewInt(58), new(big.Int)
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, base, mod)
+		out = append(out, alphabet[mod.Int64()])
+	}
+	for i := 0; i < zeros; i++ {
+		out = append(out, '1')
+	}
+	// The digits came out least significant first
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+func DecodeBase58(s string) ([]byte, error) {
+	n, base := new(big.Int), big.NewInt(58)
+	zeros := 0
+	for zeros < len(s) && s[zeros] == '1' {
+		zeros++
+	}
+	for i := 0; i < len(s); i++ {
+		d := alphabetIndex[s[i]]
+		if d < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", s[i])
+		}
+		n.Mul(n, base)
+		n.Add(n, big.NewInt(int64(d)))
+	}
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
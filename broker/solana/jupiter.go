@@ -0,0 +1,154 @@
+package solana
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// The swap aggregator (Jupiter, or anything with the same quote/swap API)
+// A quote gives the best route for the amount, then the swap endpoint builds the (unsigned) transaction for that quote
+// The quote has to be passed back to the swap endpoint as it came, so the raw JSON is kept with it
+// The amounts are in the token's base units (lamports for SOL, 1e-6 for USDC)
+
+type QuoteRequest struct {
+	InputMint   string
+	OutputMint  string
+	Amount      uint64 // Of the input mint for ExactIn, of the output mint for ExactOut
+	SlippageBps int    // The swap fails on-chain if the price moves further than this
+	ExactOut    bool
+}
+
+type Quote struct {
+	InputMint      string          `json:"inputMint"`
+	OutputMint     string          `json:"outputMint"`
+	InAmount       string          `json:"inAmount"`
+	OutAmount      string          `json:"outAmount"`
+	Threshold      string          `json:"otherAmountThreshold"` // The worst the other side can be after the slippage
+	SwapMode       string          `json:"swapMode"`
+	SlippageBps    int             `json:"slippageBps"`
+	PriceImpactPct string          `json:"priceImpactPct"` // As a fraction, e.g. "0.0012" is 0.12%
+	Raw            json.RawMessage `json:"-"`
+}
+
+func (q Quote) In() uint64 {
+	n, _ := strconv.ParseUint(q.InAmount, 10, 64)
+	return n
+}
+
+func (q Quote) Out() uint64 {
+	n, _ := strconv.ParseUint(q.OutAmount, 10, 64)
+	return n
+}
+
+func (q Quote) PriceImpact() float64 {
+	f, _ := strconv.ParseFloat(q.PriceImpactPct, 64)
+	return f
+}
+
+type SwapTx struct {
+	Transaction          []byte // Unsigned, the signature slots are zeroed
+	LastValidBlockHeight uint64 // The transaction expires after this block height
+	PriorityFee          uint64 // Lamports
+}
+
+type Aggregator interface {
+	Quote(ctx context.Context, req QuoteRequest) (Quote, error)
+	Swap(ctx context.Context, q Quote, user string, priorityFee uint64) (SwapTx, error)
+}
+
+type Jupiter struct {
+	BaseURL string
+	HTTP    *http.Client
+}
+
+func NewJupiter() *Jupiter {
+	return &Jupiter{BaseURL: "https://quote-api.jup.ag/v6", HTTP: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (j *Jupiter) do(req *http.Request, out any) error {
+	resp, err := j.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		var apiErr struct {
+			Error     string `json:"error"`
+			ErrorCode string `json:"errorCode"`
+		}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("aggregator error %s: %s (HTTP %d)", apiErr.ErrorCode, apiErr.Error, resp.StatusCode)
+		}
+		return fmt.Errorf("aggregator error: %s (HTTP %d)", body, resp.StatusCode)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return err
+	}
+	if q, ok := out.(*Quote); ok {
+		q.Raw = body
+	}
+	return nil
+}
+
+func (j *Jupiter) Quote(ctx context.Context, r QuoteRequest) (Quote, error) {
+	params := url.Values{
+		"inputMint":   {r.InputMint},
+		"outputMint":  {r.OutputMint},
+		"amount":      {strconv.FormatUint(r.Amount, 10)},
+		"slippageBps": {strconv.Itoa(r.SlippageBps)},
+		"swapMode":    {"ExactIn"},
+	}
+	if r.ExactOut {
+		params.Set("swapMode", "ExactOut")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.BaseURL+"/quote?"+params.Encode(), nil)
+	if err != nil {
+		return Quote{}, err
+	}
+	var q Quote
+	err = j.do(req, &q)
+	return q, err
+}
+
+func (j *Jupiter) Swap(ctx context.Context, q Quote, user string, priorityFee uint64) (SwapTx, error) {
+	body, err := json.Marshal(map[string]any{
+		"quoteResponse":             q.Raw,
+		"userPublicKey":             user,
+		"wrapAndUnwrapSol":          true,
+		"dynamicComputeUnitLimit":   true, // Simulates the swap for the compute units, so the priority fee is not paid on unused units
+		"prioritizationFeeLamports": priorityFee,
+	})
+	if err != nil {
+		return SwapTx{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, j.BaseURL+"/swap", bytes.NewReader(body))
+	if err != nil {
+		return SwapTx{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var resp struct {
+		SwapTransaction           string `json:"swapTransaction"` // base64
+		LastValidBlockHeight      uint64 `json:"lastValidBlockHeight"`
+		PrioritizationFeeLamports uint64 `json:"prioritizationFeeLamports"`
+	}
+	if err := j.do(req, &resp); err != nil {
+		return SwapTx{}, err
+	}
+	tx, err := base64.StdEncoding.DecodeString(resp.SwapTransaction)
+	if err != nil {
+		return SwapTx{}, fmt.Errorf("decoding swap transaction: %w", err)
+	}
+	return SwapTx{Transaction: tx, LastValidBlockHeight: resp.LastValidBlockHeight, PriorityFee: resp.PrioritizationFeeLamports}, nil
+}
@@ -0,0 +1,66 @@
+package solana
+
+import (
+	"crypto/ed25519" // Solana keys are ed25519
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The local keypair the swaps are signed with, the private key never leaves the bot (the aggregator only gets the public key)
+// It can be loaded from a Solana CLI keypair file (a JSON array of the 64 bytes) or a base58 string (what the wallets export)
+
+type Keypair struct {
+	private ed25519.PrivateKey
+}
+
+func NewKeypair(private ed25519.PrivateKey) (Keypair, error) {
+	if len(private) != ed25519.PrivateKeySize {
+		return Keypair{}, fmt.Errorf("private key is %d bytes, expected %d", len(private), ed25519.PrivateKeySize)
+	}
+	return Keypair{private: private}, nil
+}
+
+// Makes a new random keypair (e.g. for trying things out on the mock)
+func GenerateKeypair() (Keypair, error) {
+	_, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return Keypair{}, err
+	}
+	return Keypair{private: private}, nil
+}
+
+// LoadKeypair reads a keypair from a Solana CLI JSON file, or takes the string itself as a base58 key if it is not a file
+func LoadKeypair(pathOrKey string) (Keypair, error) {
+	data, err := os.ReadFile(pathOrKey)
+	if err != nil {
+		key, decErr := DecodeBase58(strings.TrimSpace(pathOrKey))
+		if decErr != nil {
+			return Keypair{}, fmt.Errorf("not a keypair file (%v) or a base58 key (%v)", err, decErr)
+		}
+		return NewKeypair(key)
+	}
+	var raw []byte
+	var ints []int
+	if err := json.Unmarshal(data, &ints); err != nil {
+		return Keypair{}, fmt.Errorf("reading keypair file: %w", err)
+	}
+	for _, b := range ints {
+		raw = append(raw, byte(b))
+	}
+	return NewKeypair(raw)
+}
+
+func (k Keypair) PublicKey() ed25519.PublicKey {
+	return k.private.Public().(ed25519.PublicKey)
+}
+
+// The address of the wallet
+func (k Keypair) Address() string {
+	return EncodeBase58(k.PublicKey())
+}
+
+func (k Keypair) Sign(message []byte) []byte {
+	return ed25519.Sign(k.private, message)
+}
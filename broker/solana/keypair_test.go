@@ -0,0 +1,96 @@
+package solana
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBase58(t *testing.T) {
+	cases := []struct {
+		b   []byte
+		enc string
+	}{
+		{nil, ""},
+		{[]byte{0}, "1"},
+		{[]byte{0, 0, 1}, "112"},
+		{[]byte("Hello World!"), "2NEpo7TZRRrLZSi2U"},
+		{make([]byte, 32), "11111111111111111111111111111111"}, // The system program
+	}
+	for _, c := range cases {
+		if enc := EncodeBase58(c.b); enc != c.enc {
+			t.Errorf("EncodeBase58(%x) = %q, want %q", c.b, enc, c.enc)
+		}
+		dec, err := DecodeBase58(c.enc)
+		if err != nil || !bytes.Equal(dec, c.b) {
+			t.Errorf("DecodeBase58(%q) = %x, %v, want %x", c.enc, dec, err, c.b)
+		}
+	}
+
+	// The mint addresses are 32 byte keys
+	usdc, err := DecodeBase58(USDCMint)
+	if err != nil || len(usdc) != 32 || EncodeBase58(usdc) != USDCMint {
+		t.Errorf("USDC mint decoded to %d bytes, %v", len(usdc), err)
+	}
+
+	for _, bad := range []string{"0", "O", "I", "l", "abc+", "2NEpo7 TZ"} {
+		if _, err := DecodeBase58(bad); err == nil {
+			t.Errorf("DecodeBase58(%q) did not fail", bad)
+		}
+	}
+}
+
+func TestLoadKeypair(t *testing.T) {
+	k := testKeypair(t)
+
+	// A Solana CLI keypair file is a JSON array of the 64 bytes
+	ints := make([]int, len(k.private))
+	for i, b := range k.private {
+		ints[i] = int(b)
+	}
+	data, _ := json.Marshal(ints)
+	path := filepath.Join(t.TempDir(), "id.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fromFile, err := LoadKeypair(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fromFile.Address() != k.Address() {
+		t.Errorf("file keypair address %s, want %s", fromFile.Address(), k.Address())
+	}
+
+	// The wallets export the same 64 bytes in base58
+	fromString, err := LoadKeypair(" " + EncodeBase58(k.private) + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fromString.Address() != k.Address() {
+		t.Errorf("base58 keypair address %s, want %s", fromString.Address(), k.Address())
+	}
+	msg := []byte("message")
+	if !bytes.Equal(fromString.Sign(msg), k.Sign(msg)) {
+		t.Error("loaded keypair signs differently")
+	}
+
+	// Only the public key is not enough to sign with
+	if _, err := LoadKeypair(k.Address()); err == nil {
+		t.Error("loaded a public key as a keypair")
+	}
+	if _, err := LoadKeypair("not a key"); err == nil {
+		t.Error("loaded an invalid key")
+	}
+	bad := filepath.Join(t.TempDir(), "bad.json")
+	os.WriteFile(bad, []byte(`{"key": 1}`), 0o600)
+	if _, err := LoadKeypair(bad); err == nil {
+		t.Error("loaded a file that is not a JSON array")
+	}
+	short := filepath.Join(t.TempDir(), "short.json")
+	os.WriteFile(short, []byte(`[1, 2, 3]`), 0o600)
+	if _, err := LoadKeypair(short); err == nil {
+		t.Error("loaded a 3 byte keypair")
+	}
+}
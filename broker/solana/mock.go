@@ -0,0 +1,414 @@
+package solana
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A local mock of the aggregator API (GET /quote, POST /swap) and the Solana RPC (POST /rpc), so the swaps can be tried out with no funds
+// The quotes come from a constant product pool around the price set with SetPrice (or POST /mock/price?price=...)
+// The swap transactions have the real layout, and the mock checks the signatures the same as the validators would
+// Each wallet starts with the configured SOL and USDC the first time it is seen
+// The block height moves on with time, and a transaction lands in the next block as long as its priority fee is at least MinPriorityFee
+// (so a low fee behaves like a busy network: the transaction is never included and its blockhash expires)
+// When it lands the swap is priced again, and it fails with the slippage error if the price has moved past the quote's threshold
+
+type MockConfig struct {
+	Price          float64 // USDC per SOL
+	Liquidity      float64 // Pool liquidity in USD
+	SOL            float64 // Starting balances of each wallet
+	USDC           float64
+	BlockTime      time.Duration
+	ValidBlocks    uint64 // Blocks a transaction's blockhash is valid for
+	MinPriorityFee uint64 // Lamports, transactions paying less never land
+	Swap           SwapConfig
+}
+
+func DefaultMockConfig() MockConfig {
+	return MockConfig{
+		Price:       150,
+		Liquidity:   20_000_000,
+		SOL:         1,
+		USDC:        10000,
+		BlockTime:   400 * time.Millisecond,
+		ValidBlocks: 150,
+		Swap:        DefaultSwapConfig(),
+	}
+}
+
+type mockSwap struct {
+	user      string
+	quote     Quote
+	fee       uint64
+	lastValid uint64
+}
+
+type mockWallet struct {
+	lamports uint64
+	usdc     float64
+}
+
+type mockStatus struct {
+	slot   uint64
+	landAt uint64
+	err    json.RawMessage
+	swap   *mockSwap
+	done   bool
+}
+
+type Mock struct {
+	Config   MockConfig
+	mu       sync.Mutex
+	price    float64
+	start    time.Time
+	swaps    map[string]*mockSwap // By blockhash
+	wallets  map[string]*mockWallet
+	statuses map[string]*mockStatus // By signature
+	mux      *http.ServeMux
+}
+
+func NewMock(cfg MockConfig) *Mock {
+	m := &Mock{
+		Config:   cfg,
+		price:    cfg.Price,
+		start:    time.Now(),
+		swaps:    make(map[string]*mockSwap),
+		wallets:  make(map[string]*mockWallet),
+		statuses: make(map[string]*mockStatus),
+		mux:      http.NewServeMux(),
+	}
+	m.mux.HandleFunc("GET /quote", m.quoteHandler)
+	m.mux.HandleFunc("POST /swap", m.swapHandler)
+	m.mux.HandleFunc("POST /rpc", m.rpcHandler)
+	m.mux.HandleFunc("POST /mock/price", func(w http.ResponseWriter, r *http.Request) {
+		price, err := strconv.ParseFloat(r.URL.Query().Get("price"), 64)
+		if err != nil || price <= 0 {
+			http.Error(w, `{"error":"invalid price"}`, http.StatusBadRequest)
+			return
+		}
+		m.SetPrice(price)
+		json.NewEncoder(w).Encode(map[string]float64{"price": price})
+	})
+	return m
+}
+
+func (m *Mock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mux.ServeHTTP(w, r)
+}
+
+func (m *Mock) SetPrice(price float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.price = price
+}
+
+// Must be called with the lock held
+func (m *Mock) height() uint64 {
+	return 1000 + uint64(time.Since(m.start)/m.Config.BlockTime)
+}
+
+func (m *Mock) wallet(address string) *mockWallet {
+	w, ok := m.wallets[address]
+	if !ok {
+		w = &mockWallet{lamports: units(m.Config.SOL, 9), usdc: m.Config.USDC}
+		m.wallets[address] = w
+	}
+	return w
+}
+
+// Prices the swap on the pool, returns the input and output amounts in base units and the price impact
+// Must be called with the lock held
+func (m *Mock) pool(inputMint string, amount uint64, exactOut bool) (in, out uint64, impact float64, err error) {
+	cfg := m.Config.Swap
+	reserve := m.Config.Liquidity / 2
+	buy := inputMint == cfg.QuoteMint
+	sol := whole(amount, cfg.BaseDecimals) // Both modes are given in SOL by the Swapper, but the mock handles USDC ExactIn too
+	if buy && !exactOut {
+		sol = whole(amount, cfg.QuoteDecimals) / m.price
+	}
+	notional := sol * m.price
+	avg := m.price * reserve / (reserve + notional)
+	if buy {
+		if notional >= reserve {
+			return 0, 0, 0, fmt.Errorf("not enough liquidity")
+		}
+		avg = m.price * reserve / (reserve - notional)
+	}
+	impact = math.Abs(avg-m.price) / m.price
+	if buy {
+		return units(sol*avg, cfg.QuoteDecimals), units(sol, cfg.BaseDecimals), impact, nil
+	}
+	return units(sol, cfg.BaseDecimals), units(sol*avg, cfg.QuoteDecimals), impact, nil
+}
+
+func mockError(w http.ResponseWriter, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg, "errorCode": code})
+}
+
+func (m *Mock) quoteHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	cfg := m.Config.Swap
+	input, output := q.Get("inputMint"), q.Get("outputMint")
+	if !(input == cfg.QuoteMint && output == cfg.BaseMint) && !(input == cfg.BaseMint && output == cfg.QuoteMint) {
+		mockError(w, "TOKEN_NOT_TRADABLE", "The token is not tradable")
+		return
+	}
+	amount, err := strconv.ParseUint(q.Get("amount"), 10, 64)
+	if err != nil || amount == 0 {
+		mockError(w, "INVALID_AMOUNT", "amount must be a positive integer")
+		return
+	}
+	slippage, _ := strconv.Atoi(q.Get("slippageBps"))
+	exactOut := q.Get("swapMode") == "ExactOut"
+
+	m.mu.Lock()
+	in, out, impact, err := m.pool(input, amount, exactOut)
+	m.mu.Unlock()
+	if err != nil {
+		mockError(w, "COULD_NOT_FIND_ANY_ROUTE", "Could not find any route")
+		return
+	}
+	mode, threshold := "ExactIn", float64(out)*(1-float64(slippage)/10000)
+	if exactOut {
+		mode, threshold = "ExactOut", float64(in)*(1+float64(slippage)/10000)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"inputMint": input, "outputMint": output, "inAmount": strconv.FormatUint(in, 10), "outAmount": strconv.FormatUint(out, 10),
+		"otherAmountThreshold": strconv.FormatUint(uint64(threshold), 10), "swapMode": mode, "slippageBps": slippage,
+		"priceImpactPct": strconv.FormatFloat(impact, 'f', -1, 64),
+		"routePlan":      []map[string]any{{"swapInfo": map[string]string{"label": "Mock Pool"}, "percent": 100}},
+	})
+}
+
+// The mock swap program's ID, it only has to look like a key
+var mockProgram = sha256.Sum256([]byte("mock swap program"))
+
+// Builds a transaction with one signer (the user) and no instructions, the blockhash identifies the swap
+func mockTransaction(user []byte, blockhash []byte) []byte {
+	tx := appendCompactU16(nil, 1)
+	tx = append(tx, make([]byte, 64)...)
+	tx = append(tx, 0x80, 1, 0, 1) // Version 0, 1 required signature, 0 read-only signed, 1 read-only unsigned
+	tx = appendCompactU16(tx, 2)
+	tx = append(tx, user...)
+	tx = append(tx, mockProgram[:]...)
+	tx = append(tx, blockhash...)
+	tx = appendCompactU16(tx, 0) // Instructions
+	tx = appendCompactU16(tx, 0) // Address table lookups
+	return tx
+}
+
+func (m *Mock) swapHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		QuoteResponse json.RawMessage `json:"quoteResponse"`
+		UserPublicKey string          `json:"userPublicKey"`
+		PriorityFee   uint64          `json:"prioritizationFeeLamports"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		mockError(w, "INVALID_REQUEST", err.Error())
+		return
+	}
+	var q Quote
+	if err := json.Unmarshal(req.QuoteResponse, &q); err != nil || q.InAmount == "" {
+		mockError(w, "INVALID_QUOTE", "quoteResponse is missing or invalid")
+		return
+	}
+	user, err := DecodeBase58(req.UserPublicKey)
+	if err != nil || len(user) != 32 {
+		mockError(w, "INVALID_PUBLIC_KEY", "userPublicKey is not a valid public key")
+		return
+	}
+	blockhash := make([]byte, 32)
+	rand.Read(blockhash)
+
+	m.mu.Lock()
+	lastValid := m.height() + m.Config.ValidBlocks
+	m.swaps[EncodeBase58(blockhash)] = &mockSwap{user: req.UserPublicKey, quote: q, fee: req.PriorityFee, lastValid: lastValid}
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"swapTransaction":           base64.StdEncoding.EncodeToString(mockTransaction(user, blockhash)),
+		"lastValidBlockHeight":      lastValid,
+		"prioritizationFeeLamports": req.PriorityFee,
+	})
+}
+
+// Lands the transactions whose block has come, must be called with the lock held
+func (m *Mock) settle() {
+	cfg := m.Config.Swap
+	height := m.height()
+	for _, st := range m.statuses {
+		if st.done || height < st.landAt {
+			continue
+		}
+		st.done, st.slot = true, st.landAt
+		s := st.swap
+		wallet := m.wallet(s.user)
+		wallet.lamports -= baseFeeLamports + s.fee
+
+		// Priced again at the current price, and checked against the quote's threshold
+		q := s.quote
+		exactOut := q.SwapMode == "ExactOut"
+		amount := q.In()
+		if exactOut {
+			amount = q.Out()
+		}
+		in, out, _, err := m.pool(q.InputMint, amount, exactOut)
+		threshold, _ := strconv.ParseUint(q.Threshold, 10, 64)
+		if err != nil || (exactOut && in > threshold) || (!exactOut && out < threshold) {
+			st.err = json.RawMessage(`{"InstructionError":[0,{"Custom":6001}]}`) // Slippage tolerance exceeded
+			continue
+		}
+		if q.InputMint == cfg.QuoteMint {
+			wallet.usdc -= whole(in, cfg.QuoteDecimals)
+			wallet.lamports += out
+		} else {
+			wallet.lamports -= in
+			wallet.usdc += whole(out, cfg.QuoteDecimals)
+		}
+	}
+}
+
+type rpcRequest struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+func (m *Mock) rpcHandler(w http.ResponseWriter, r *http.Request) {
+	var req rpcRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRPC(w, nil, nil, &RPCError{Code: -32700, Message: "Parse error"})
+		return
+	}
+	param := func(i int, v any) error {
+		if i >= len(req.Params) {
+			return fmt.Errorf("missing param %d", i)
+		}
+		return json.Unmarshal(req.Params[i], v)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.settle()
+	switch req.Method {
+	case "sendTransaction":
+		var encoded string
+		if err := param(0, &encoded); err != nil {
+			writeRPC(w, req.ID, nil, &RPCError{Code: -32602, Message: "Invalid params: " + err.Error()})
+			return
+		}
+		result, rpcErr := m.send(encoded)
+		writeRPC(w, req.ID, result, rpcErr)
+	case "getSignatureStatuses":
+		var sigs []string
+		param(0, &sigs)
+		values := make([]any, len(sigs))
+		height := m.height()
+		for i, sig := range sigs {
+			if st, ok := m.statuses[sig]; ok && st.done {
+				confirmations := height - st.slot
+				values[i] = map[string]any{"slot": st.slot, "confirmations": confirmations, "err": st.err, "confirmationStatus": "confirmed"}
+			}
+		}
+		writeRPC(w, req.ID, map[string]any{"context": map[string]uint64{"slot": height}, "value": values}, nil)
+	case "getBlockHeight":
+		writeRPC(w, req.ID, m.height(), nil)
+	case "getBalance":
+		var address string
+		param(0, &address)
+		writeRPC(w, req.ID, map[string]any{"context": map[string]uint64{"slot": m.height()}, "value": m.wallet(address).lamports}, nil)
+	case "getTokenAccountsByOwner":
+		var owner string
+		var filter struct {
+			Mint string `json:"mint"`
+		}
+		param(0, &owner)
+		param(1, &filter)
+		var accounts []any
+		if filter.Mint == m.Config.Swap.QuoteMint {
+			amount := strconv.FormatFloat(m.wallet(owner).usdc, 'f', m.Config.Swap.QuoteDecimals, 64)
+			accounts = append(accounts, map[string]any{"account": map[string]any{"data": map[string]any{"parsed": map[string]any{
+				"info": map[string]any{"tokenAmount": map[string]any{"uiAmountString": amount, "decimals": m.Config.Swap.QuoteDecimals}},
+			}}}})
+		}
+		writeRPC(w, req.ID, map[string]any{"context": map[string]uint64{"slot": m.height()}, "value": accounts}, nil)
+	default:
+		writeRPC(w, req.ID, nil, &RPCError{Code: -32601, Message: "Method not found"})
+	}
+}
+
+// Must be called with the lock held
+func (m *Mock) send(encoded string) (any, *RPCError) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, &RPCError{Code: -32602, Message: "invalid transaction: failed to decode base64"}
+	}
+	tx, err := verifyTransaction(raw)
+	if err != nil {
+		return nil, &RPCError{Code: -32003, Message: "Transaction signature verification failure"}
+	}
+	sig := EncodeBase58(tx.sigs[0])
+	if _, ok := m.statuses[sig]; ok {
+		return sig, nil // Rebroadcast of a transaction that already landed (or is about to)
+	}
+	s, ok := m.swaps[EncodeBase58(tx.blockhash)]
+	if !ok || m.height() > s.lastValid {
+		return nil, &RPCError{Code: -32002, Message: "Transaction simulation failed: Blockhash not found"}
+	}
+
+	// The preflight checks the wallet can pay for it
+	cfg := m.Config.Swap
+	wallet := m.wallet(s.user)
+	need := baseFeeLamports + s.fee
+	if s.quote.InputMint == cfg.BaseMint {
+		need += s.quote.In()
+	} else if whole(s.quote.In(), cfg.QuoteDecimals) > wallet.usdc {
+		return nil, &RPCError{Code: -32002, Message: "Transaction simulation failed: Error processing Instruction 0: insufficient funds"}
+	}
+	if need > wallet.lamports {
+		return nil, &RPCError{Code: -32002, Message: "Transaction simulation failed: Attempt to debit an account but found no record of a prior credit."}
+	}
+
+	// Too low a fee and it is never included
+	if s.fee >= m.Config.MinPriorityFee {
+		m.statuses[sig] = &mockStatus{landAt: m.height() + 1, swap: s}
+	}
+	return sig, nil
+}
+
+func writeRPC(w http.ResponseWriter, id json.RawMessage, result any, rpcErr *RPCError) {
+	w.Header().Set("Content-Type", "application/json")
+	resp := map[string]any{"jsonrpc": "2.0", "id": id}
+	if rpcErr != nil {
+		resp["error"] = rpcErr
+	} else {
+		resp["result"] = result
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Serve runs the mock on the address until the context is cancelled
+func (m *Mock) Serve(ctx context.Context, addr string) error {
+	srv := &http.Server{Addr: addr, Handler: m}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
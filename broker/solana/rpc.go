@@ -0,0 +1,157 @@
+package solana
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// The Solana JSON-RPC methods needed to send and confirm the swaps and read the balances
+// The transactions are sent with maxRetries 0 so the RPC node does not rebroadcast them itself, the Swapper does that while it waits
+
+type SignatureStatus struct {
+	Slot               uint64          `json:"slot"`
+	Confirmations      *uint64         `json:"confirmations"`
+	Err                json.RawMessage `json:"err"`                // null if it succeeded
+	ConfirmationStatus string          `json:"confirmationStatus"` // processed, confirmed or finalized
+}
+
+func (s SignatureStatus) Failed() bool {
+	return len(s.Err) > 0 && string(s.Err) != "null"
+}
+
+type RPC interface {
+	SendTransaction(ctx context.Context, tx []byte) (string, error)
+	SignatureStatus(ctx context.Context, sig string) (*SignatureStatus, error) // nil if the transaction has not been seen
+	BlockHeight(ctx context.Context) (uint64, error)
+	Balance(ctx context.Context, address string) (uint64, error) // Lamports
+	TokenBalance(ctx context.Context, owner, mint string) (float64, error)
+}
+
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+type RPCClient struct {
+	URL  string
+	HTTP *http.Client
+	id   atomic.Int64
+}
+
+func NewRPCClient(url string) *RPCClient {
+	if url == "" {
+		url = "https://api.mainnet-beta.solana.com"
+	}
+	return &RPCClient{URL: url, HTTP: &http.Client{Timeout: 15 * time.Second}}
+}
+
+func (c *RPCClient) call(ctx context.Context, method string, params []any, out any) error {
+	body, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": c.id.Add(1), "method": method, "params": params})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var rpcResp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *RPCError       `json:"error"`
+	}
+	if err := json.Unmarshal(data, &rpcResp); err != nil {
+		return fmt.Errorf("%s: %s (HTTP %d)", method, data, resp.StatusCode)
+	}
+	if rpcResp.Error != nil {
+		return rpcResp.Error
+	}
+	return json.Unmarshal(rpcResp.Result, out)
+}
+
+func (c *RPCClient) SendTransaction(ctx context.Context, tx []byte) (string, error) {
+	var sig string
+	err := c.call(ctx, "sendTransaction", []any{
+		base64.StdEncoding.EncodeToString(tx),
+		map[string]any{"encoding": "base64", "maxRetries": 0, "preflightCommitment": "confirmed"},
+	}, &sig)
+	return sig, err
+}
+
+func (c *RPCClient) SignatureStatus(ctx context.Context, sig string) (*SignatureStatus, error) {
+	var resp struct {
+		Value []*SignatureStatus `json:"value"`
+	}
+	if err := c.call(ctx, "getSignatureStatuses", []any{[]string{sig}}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Value) == 0 {
+		return nil, nil
+	}
+	return resp.Value[0], nil
+}
+
+func (c *RPCClient) BlockHeight(ctx context.Context) (uint64, error) {
+	var height uint64
+	err := c.call(ctx, "getBlockHeight", []any{map[string]string{"commitment": "confirmed"}}, &height)
+	return height, err
+}
+
+func (c *RPCClient) Balance(ctx context.Context, address string) (uint64, error) {
+	var resp struct {
+		Value uint64 `json:"value"`
+	}
+	err := c.call(ctx, "getBalance", []any{address, map[string]string{"commitment": "confirmed"}}, &resp)
+	return resp.Value, err
+}
+
+// The balance of the token over all of the owner's accounts for it, in whole tokens
+func (c *RPCClient) TokenBalance(ctx context.Context, owner, mint string) (float64, error) {
+	var resp struct {
+		Value []struct {
+			Account struct {
+				Data struct {
+					Parsed struct {
+						Info struct {
+							TokenAmount struct {
+								UIAmountString string `json:"uiAmountString"`
+							} `json:"tokenAmount"`
+						} `json:"info"`
+					} `json:"parsed"`
+				} `json:"data"`
+			} `json:"account"`
+		} `json:"value"`
+	}
+	err := c.call(ctx, "getTokenAccountsByOwner", []any{
+		owner, map[string]string{"mint": mint}, map[string]string{"encoding": "jsonParsed", "commitment": "confirmed"},
+	}, &resp)
+	if err != nil {
+		return 0, err
+	}
+	total := 0.0
+	for _, acct := range resp.Value {
+		amount, _ := strconv.ParseFloat(acct.Account.Data.Parsed.Info.TokenAmount.UIAmountString, 64)
+		total += amount
+	}
+	return total, nil
+}
@@ -0,0 +1,311 @@
+package solana
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+	bot "github.com/Reece-Ogidih/CT-Bot/bot"
+	"github.com/Reece-Ogidih/CT-Bot/broker"
+)
+
+// Swapper trades SOL against USDC on-chain through the aggregator, it is a broker.Broker so it can go behind the OMS
+// Each order is one swap: buys are ExactOut (the SOL quantity is exact, the USDC paid is quoted), sells are ExactIn
+// Before signing, the quote is checked against the limits: the price impact, and how far the quoted price is from the reference price
+// (the last candle close, from Update) so a bad route or a thin pool is never traded into
+// The transaction is signed locally, sent with the RPC's retries off, and rebroadcast until it confirms or its blockhash expires
+// If it expires (usually the priority fee was too low for how busy the network is) a new quote and transaction are made with a higher priority fee
+// Only market orders are possible, a swap fills straight away or not at all, and spot can not go short so a sell is limited to the SOL held
+// The position is only the SOL the bot has bought itself (plus StartSOL), tracked from its own swaps, so the rest of the wallet is never traded
+
+const USDCMint = "EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v"
+
+type SwapConfig struct {
+	Symbol         string // The symbol the orders use
+	BaseMint       string
+	QuoteMint      string
+	BaseDecimals   int
+	QuoteDecimals  int
+	SlippageBps    int           // Passed to the aggregator, the swap fails on-chain if the price moves further than this
+	MaxPriceImpact float64       // Fraction, quotes with more price impact are not traded
+	MaxDeviation   float64       // Fraction the quoted price can be worse than the reference price
+	PriorityFee    uint64        // Lamports on the first attempt
+	MaxPriorityFee uint64        // Lamports, the fee is never raised above this
+	FeeMultiplier  float64       // The priority fee is multiplied by this after each expired attempt
+	Attempts       int           // Attempts before giving up on the order
+	Rebroadcast    time.Duration // How often the transaction is resent while waiting for it to confirm
+	PollEvery      time.Duration // How often the signature status is checked
+	ReserveSOL     float64       // SOL kept back for the transaction fees, never sold
+	StartSOL       float64       // SOL in the wallet that is already the bot's position when it starts (e.g. after a restart)
+}
+
+func DefaultSwapConfig() SwapConfig {
+	return SwapConfig{
+		Symbol:         "SOLUSDT",
+		BaseMint:       bot.SOLToken,
+		QuoteMint:      USDCMint,
+		BaseDecimals:   9,
+		QuoteDecimals:  6,
+		SlippageBps:    50,
+		MaxPriceImpact: 0.005,
+		MaxDeviation:   0.01,
+		PriorityFee:    10_000,
+		MaxPriorityFee: 2_000_000,
+		FeeMultiplier:  2,
+		Attempts:       4,
+		Rebroadcast:    2 * time.Second,
+		PollEvery:      500 * time.Millisecond,
+		ReserveSOL:     0.05,
+	}
+}
+
+// The base fee of a transaction with one signature
+const baseFeeLamports = 5000
+
+type Swapper struct {
+	Config     SwapConfig
+	Aggregator Aggregator
+	RPC        RPC
+	Key        Keypair
+	Fills      chan<- models.Fill // If set, every fill is sent here (e.g. to the OMS)
+	mu         sync.Mutex
+	price      float64 // Reference price
+	held       float64 // The bot's own SOL position
+	entry      float64 // Average price it was bought at, 0 for StartSOL
+	nextID     int64
+}
+
+func NewSwapper(cfg SwapConfig, agg Aggregator, rpc RPC, key Keypair) *Swapper {
+	return &Swapper{Config: cfg, Aggregator: agg, RPC: rpc, Key: key, held: cfg.StartSOL}
+}
+
+// Adds a confirmed swap to the position
+func (s *Swapper) track(side int, qty, price float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if side == 1 {
+		s.entry = (s.entry*s.held + price*qty) / (s.held + qty)
+		s.held += qty
+		return
+	}
+	if s.held = math.Max(s.held-qty, 0); s.held < 1e-9 {
+		s.held, s.entry = 0, 0
+	}
+}
+
+// Update gives the swapper the latest candle from the live stream, the close is the reference price for the quote checks
+func (s *Swapper) Update(symbol string, c models.CandleStick) {
+	if symbol != s.Config.Symbol {
+		return
+	}
+	s.mu.Lock()
+	s.price = c.Close
+	s.mu.Unlock()
+}
+
+func (s *Swapper) refPrice() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.price
+}
+
+func units(amount float64, decimals int) uint64 {
+	return uint64(math.Round(amount * math.Pow10(decimals)))
+}
+
+func whole(amount uint64, decimals int) float64 {
+	return float64(amount) / math.Pow10(decimals)
+}
+
+// The quoted SOL quantity and price in USDC
+func (s *Swapper) quoted(q Quote, side int) (qty, price float64) {
+	cfg := s.Config
+	base, quote := q.Out(), q.In()
+	if side == -1 {
+		base, quote = q.In(), q.Out()
+	}
+	qty = whole(base, cfg.BaseDecimals)
+	if qty > 0 {
+		price = whole(quote, cfg.QuoteDecimals) / qty
+	}
+	return qty, price
+}
+
+// Checks the quote against the limits
+func (s *Swapper) check(q Quote, side int) error {
+	if impact := q.PriceImpact(); impact > s.Config.MaxPriceImpact {
+		return fmt.Errorf("price impact %.3f%% over the limit of %.3f%%", impact*100, s.Config.MaxPriceImpact*100)
+	}
+	_, price := s.quoted(q, side)
+	ref := s.refPrice()
+	if ref <= 0 || price <= 0 {
+		return nil
+	}
+	// Positive when the quote is worse than the reference
+	worse := float64(side) * (price - ref) / ref
+	if worse > s.Config.MaxDeviation {
+		return fmt.Errorf("quoted price %.4f is %.2f%% worse than the reference %.4f", price, worse*100, ref)
+	}
+	return nil
+}
+
+var errExpired = errors.New("transaction expired before it confirmed")
+
+// Sends the signed transaction and waits for it to confirm, rebroadcasting it until then
+func (s *Swapper) confirm(ctx context.Context, tx []byte, sig string, lastValid uint64) error {
+	if _, err := s.RPC.SendTransaction(ctx, tx); err != nil {
+		return err
+	}
+	sent := time.Now()
+	ticker := time.NewTicker(s.Config.PollEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		status, err := s.RPC.SignatureStatus(ctx, sig)
+		if err != nil {
+			log.Println("Could not get the signature status:", err)
+			continue
+		}
+		if status != nil {
+			if status.Failed() {
+				return fmt.Errorf("swap %s failed on-chain: %s", sig, status.Err)
+			}
+			if status.ConfirmationStatus == "confirmed" || status.ConfirmationStatus == "finalized" {
+				return nil
+			}
+			continue // Processed, just waiting on the confirmations
+		}
+		height, err := s.RPC.BlockHeight(ctx)
+		if err == nil && height > lastValid {
+			return errExpired
+		}
+		if time.Since(sent) >= s.Config.Rebroadcast {
+			if _, err := s.RPC.SendTransaction(ctx, tx); err != nil {
+				log.Println("Rebroadcast failed:", err)
+			}
+			sent = time.Now()
+		}
+	}
+}
+
+// Submit swaps straight away and only returns once the swap has confirmed (or failed), the fill is sent on Fills before it returns
+func (s *Swapper) Submit(ctx context.Context, o models.Order) (int64, error) {
+	cfg := s.Config
+	if o.Symbol != cfg.Symbol {
+		return 0, fmt.Errorf("unknown symbol %s", o.Symbol)
+	}
+	if o.Type != "" && o.Type != "market" {
+		return 0, fmt.Errorf("%s orders are not possible with swaps, only market", o.Type)
+	}
+	if o.Side != 1 && o.Side != -1 {
+		return 0, fmt.Errorf("invalid side %d", o.Side)
+	}
+
+	qty := o.Qty
+	if o.Side == -1 {
+		// Can only sell what is held
+		acct, err := s.Account(ctx)
+		if err != nil {
+			return 0, err
+		}
+		held := acct.Positions[cfg.Symbol].Qty
+		if o.ReduceOnly && (qty <= 0 || qty > held) {
+			qty = held
+		}
+		if qty > held+1e-9 {
+			return 0, fmt.Errorf("can not sell %.4f SOL with %.4f held (no shorting on spot)", qty, held)
+		}
+	}
+	if qty <= 0 {
+		return 0, fmt.Errorf("invalid quantity %f", qty)
+	}
+
+	req := QuoteRequest{InputMint: cfg.QuoteMint, OutputMint: cfg.BaseMint, Amount: units(qty, cfg.BaseDecimals), SlippageBps: cfg.SlippageBps, ExactOut: true}
+	if o.Side == -1 {
+		req = QuoteRequest{InputMint: cfg.BaseMint, OutputMint: cfg.QuoteMint, Amount: units(qty, cfg.BaseDecimals), SlippageBps: cfg.SlippageBps}
+	}
+
+	s.mu.Lock()
+	s.nextID++
+	id := s.nextID
+	s.mu.Unlock()
+
+	fee := cfg.PriorityFee
+	for attempt := 1; attempt <= cfg.Attempts; attempt++ {
+		q, err := s.Aggregator.Quote(ctx, req)
+		if err != nil {
+			return 0, fmt.Errorf("quote: %w", err)
+		}
+		if err := s.check(q, o.Side); err != nil {
+			return 0, err
+		}
+		swap, err := s.Aggregator.Swap(ctx, q, s.Key.Address(), fee)
+		if err != nil {
+			return 0, fmt.Errorf("swap transaction: %w", err)
+		}
+		signed, sig, err := SignTransaction(swap.Transaction, s.Key)
+		if err != nil {
+			return 0, err
+		}
+
+		err = s.confirm(ctx, signed, sig, swap.LastValidBlockHeight)
+		if errors.Is(err, errExpired) {
+			log.Printf("Swap %s expired with a priority fee of %d lamports (attempt %d of %d)", sig, fee, attempt, cfg.Attempts)
+			fee = uint64(math.Min(float64(fee)*cfg.FeeMultiplier, float64(cfg.MaxPriorityFee)))
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		// The fill is at the quoted amounts, the actual amounts can only be better (or worse within the slippage)
+		filled, price := s.quoted(q, o.Side)
+		feeSOL := whole(baseFeeLamports+swap.PriorityFee, 9)
+		f := models.Fill{OrderID: id, ClientID: o.ClientID, Symbol: o.Symbol, OpenTime: time.Now().UnixMilli(), Side: o.Side, Qty: filled, Price: price,
+			Fee: feeSOL * price, Type: "market", Reason: o.Reason, Confidence: o.Confidence}
+		log.Printf("Swap %s confirmed: %d %.4f SOL @ %.4f", sig, o.Side, filled, price)
+		s.track(o.Side, filled, price)
+		if s.Fills != nil {
+			s.Fills <- f
+		}
+		return id, nil
+	}
+	return 0, fmt.Errorf("swap not confirmed after %d attempts", cfg.Attempts)
+}
+
+func (s *Swapper) Cancel(ctx context.Context, id int64) error {
+	return fmt.Errorf("order %d: swaps fill straight away, there is nothing to cancel", id)
+}
+
+// Account reads the wallet, the USDC is the cash and the position is the bot's own SOL
+// The position is capped at the wallet's SOL less the reserve for fees, in case some was moved out by hand
+func (s *Swapper) Account(ctx context.Context) (broker.Account, error) {
+	cfg := s.Config
+	acct := broker.Account{Positions: make(map[string]broker.Position)}
+	lamports, err := s.RPC.Balance(ctx, s.Key.Address())
+	if err != nil {
+		return acct, err
+	}
+	cash, err := s.RPC.TokenBalance(ctx, s.Key.Address(), cfg.QuoteMint)
+	if err != nil {
+		return acct, err
+	}
+	acct.Cash, acct.Equity = cash, cash
+	s.mu.Lock()
+	sol, entry := math.Min(s.held, whole(lamports, 9)-cfg.ReserveSOL), s.entry
+	s.mu.Unlock()
+	if sol > 1e-9 {
+		acct.Positions[cfg.Symbol] = broker.Position{Symbol: cfg.Symbol, Qty: sol, EntryPrice: entry}
+		acct.Equity += sol * s.refPrice()
+	}
+	return acct, nil
+}
@@ -0,0 +1,275 @@
+package solana
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+)
+
+// The swapper against the mock aggregator and RPC (mock.go) over a real HTTP server, with the blocks made short so the tests are quick
+// Every request goes through a recorder first, which keeps the priority fees of the swap transactions asked for
+
+type recorder struct {
+	mock *Mock
+	mu   sync.Mutex
+	fees []uint64 // The priority fee of each POST /swap
+	sent func()   // If set, called after each sendTransaction has reached the mock
+}
+
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	var req struct {
+		Method      string `json:"method"`
+		PriorityFee uint64 `json:"prioritizationFeeLamports"`
+	}
+	json.Unmarshal(body, &req)
+
+	rec.mu.Lock()
+	if r.URL.Path == "/swap" {
+		rec.fees = append(rec.fees, req.PriorityFee)
+	}
+	sent := rec.sent
+	rec.mu.Unlock()
+	rec.mock.ServeHTTP(w, r)
+	if sent != nil && req.Method == "sendTransaction" {
+		sent()
+	}
+}
+
+func (rec *recorder) swapFees() []uint64 {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]uint64(nil), rec.fees...)
+}
+
+func testMockConfig() MockConfig {
+	cfg := DefaultMockConfig()
+	cfg.BlockTime = 10 * time.Millisecond
+	cfg.ValidBlocks = 5
+	cfg.Swap.PollEvery = 5 * time.Millisecond
+	cfg.Swap.Rebroadcast = 20 * time.Millisecond
+	return cfg
+}
+
+func newTestSwapper(t *testing.T, cfg MockConfig) (*Swapper, *Mock, *recorder) {
+	t.Helper()
+	mock := NewMock(cfg)
+	rec := &recorder{mock: mock}
+	srv := httptest.NewServer(rec)
+	t.Cleanup(srv.Close)
+
+	agg := NewJupiter()
+	agg.BaseURL = srv.URL
+	s := NewSwapper(cfg.Swap, agg, NewRPCClient(srv.URL+"/rpc"), testKeypair(t))
+	return s, mock, rec
+}
+
+func testCtx(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func buy(qty float64) models.Order {
+	return models.Order{ClientID: "buy", Symbol: "SOLUSDT", Side: 1, Qty: qty, Type: "market"}
+}
+
+func TestSwapRoundTrip(t *testing.T) {
+	cfg := testMockConfig()
+	s, _, _ := newTestSwapper(t, cfg)
+	ctx := testCtx(t)
+	fills := make(chan models.Fill, 2)
+	s.Fills = fills
+	s.Update("SOLUSDT", models.CandleStick{Close: 150})
+
+	// The wallet's own SOL is not the bot's position
+	acct, err := s.Account(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acct.Positions) != 0 || acct.Cash != cfg.USDC {
+		t.Fatalf("account before trading %+v", acct)
+	}
+	if _, err := s.Submit(ctx, models.Order{Symbol: "SOLUSDT", Side: -1, Qty: 0.5}); err == nil || !strings.Contains(err.Error(), "no shorting") {
+		t.Fatalf("sold the wallet's SOL: %v", err)
+	}
+
+	if _, err := s.Submit(ctx, buy(0.5)); err != nil {
+		t.Fatal(err)
+	}
+	f := <-fills
+	if f.Side != 1 || f.Qty != 0.5 || math.Abs(f.Price-150) > 0.1 || f.ClientID != "buy" {
+		t.Fatalf("buy fill %+v", f)
+	}
+	acct, err = s.Account(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := acct.Positions["SOLUSDT"]
+	if pos.Qty != 0.5 || pos.EntryPrice != f.Price {
+		t.Fatalf("position after the buy %+v, want 0.5 @ %.4f", pos, f.Price)
+	}
+	if math.Abs(acct.Cash-(cfg.USDC-f.Qty*f.Price)) > 0.01 {
+		t.Fatalf("cash %.4f after paying %.4f", acct.Cash, f.Qty*f.Price)
+	}
+
+	// Reduce-only with no quantity sells all of the position, and no more
+	if _, err := s.Submit(ctx, models.Order{Symbol: "SOLUSDT", Side: -1, ReduceOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+	if f := <-fills; f.Side != -1 || f.Qty != 0.5 {
+		t.Fatalf("sell fill %+v", f)
+	}
+	acct, err = s.Account(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acct.Positions) != 0 {
+		t.Fatalf("position after selling it all %+v", acct.Positions)
+	}
+}
+
+func TestSwapStartSOL(t *testing.T) {
+	cfg := testMockConfig()
+	cfg.Swap.StartSOL = 0.3
+	s, _, _ := newTestSwapper(t, cfg)
+	ctx := testCtx(t)
+	acct, err := s.Account(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos := acct.Positions["SOLUSDT"]; pos.Qty != 0.3 {
+		t.Fatalf("position %+v, want the 0.3 SOL it started with", pos)
+	}
+
+	// Never more than the wallet has less the reserve
+	cfg.Swap.StartSOL = 5
+	s, _, _ = newTestSwapper(t, cfg)
+	acct, err = s.Account(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos := acct.Positions["SOLUSDT"]; math.Abs(pos.Qty-(cfg.SOL-cfg.Swap.ReserveSOL)) > 1e-9 {
+		t.Fatalf("position %+v, want the wallet's %.2f SOL less the reserve", pos, cfg.SOL)
+	}
+}
+
+func TestSwapQuoteChecks(t *testing.T) {
+	// A thin pool, 1 SOL is 3% of one side
+	cfg := testMockConfig()
+	cfg.Liquidity = 10_000
+	s, _, _ := newTestSwapper(t, cfg)
+	ctx := testCtx(t)
+	if _, err := s.Submit(ctx, buy(1)); err == nil || !strings.Contains(err.Error(), "price impact") {
+		t.Fatalf("thin pool: %v", err)
+	}
+
+	// The quote is 7% above the last close
+	s, _, rec := newTestSwapper(t, testMockConfig())
+	s.Update("SOLUSDT", models.CandleStick{Close: 140})
+	if _, err := s.Submit(ctx, buy(0.1)); err == nil || !strings.Contains(err.Error(), "worse than the reference") {
+		t.Fatalf("buy above the reference: %v", err)
+	}
+	// Buying below the reference is fine, but the sell is worse by the same amount
+	s.Update("SOLUSDT", models.CandleStick{Close: 160})
+	if _, err := s.Submit(ctx, buy(0.1)); err != nil {
+		t.Fatalf("buy below the reference: %v", err)
+	}
+	if _, err := s.Submit(ctx, models.Order{Symbol: "SOLUSDT", Side: -1, Qty: 0.1}); err == nil || !strings.Contains(err.Error(), "worse than the reference") {
+		t.Fatalf("sell below the reference: %v", err)
+	}
+	// Only the one buy got as far as a transaction
+	if fees := rec.swapFees(); len(fees) != 1 {
+		t.Fatalf("%d swap transactions, want 1", len(fees))
+	}
+}
+
+func TestSwapFeeEscalation(t *testing.T) {
+	// The network needs 40000 lamports, the fee doubles from 10000 after each expired attempt
+	cfg := testMockConfig()
+	cfg.MinPriorityFee = 40_000
+	cfg.Swap.PriorityFee = 10_000
+	cfg.Swap.FeeMultiplier = 2
+	cfg.Swap.MaxPriorityFee = 40_000
+	s, _, rec := newTestSwapper(t, cfg)
+	ctx := testCtx(t)
+	if _, err := s.Submit(ctx, buy(0.1)); err != nil {
+		t.Fatal(err)
+	}
+	if fees := rec.swapFees(); len(fees) != 3 || fees[0] != 10_000 || fees[1] != 20_000 || fees[2] != 40_000 {
+		t.Fatalf("priority fees %v, want [10000 20000 40000]", fees)
+	}
+
+	// Capped at 30000 it never lands
+	cfg.MinPriorityFee = 50_000
+	cfg.Swap.MaxPriorityFee = 30_000
+	s, _, rec = newTestSwapper(t, cfg)
+	if _, err := s.Submit(ctx, buy(0.1)); err == nil || !strings.Contains(err.Error(), "not confirmed after 4 attempts") {
+		t.Fatalf("under the network's fee: %v", err)
+	}
+	if fees := rec.swapFees(); len(fees) != 4 || fees[2] != 30_000 || fees[3] != 30_000 {
+		t.Fatalf("priority fees %v, want [10000 20000 30000 30000]", fees)
+	}
+	acct, err := s.Account(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acct.Positions) != 0 || acct.Cash != cfg.USDC {
+		t.Fatalf("account after nothing landed %+v", acct)
+	}
+}
+
+func TestSwapFailsOnChain(t *testing.T) {
+	cfg := testMockConfig()
+	s, mock, rec := newTestSwapper(t, cfg)
+	ctx := testCtx(t)
+	fills := make(chan models.Fill, 1)
+	s.Fills = fills
+
+	// The price runs away between the quote and the transaction landing, past the 0.5% slippage
+	rec.sent = func() { mock.SetPrice(cfg.Price * 1.02) }
+	_, err := s.Submit(ctx, buy(0.1))
+	if err == nil || !strings.Contains(err.Error(), "failed on-chain") || !strings.Contains(err.Error(), "6001") {
+		t.Fatalf("slippage exceeded: %v", err)
+	}
+	select {
+	case f := <-fills:
+		t.Fatalf("fill %+v from a failed swap", f)
+	default:
+	}
+	acct, err := s.Account(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acct.Positions) != 0 || acct.Cash != cfg.USDC {
+		t.Fatalf("account after the failed swap %+v", acct)
+	}
+	// A failed swap is not retried
+	if fees := rec.swapFees(); len(fees) != 1 {
+		t.Fatalf("%d swap transactions, want 1", len(fees))
+	}
+}
+
+func TestSwapContext(t *testing.T) {
+	// The transaction never lands and the context runs out before the blockhash expires
+	cfg := testMockConfig()
+	cfg.MinPriorityFee = math.MaxUint64
+	cfg.ValidBlocks = 1000
+	s, _, _ := newTestSwapper(t, cfg)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := s.Submit(ctx, buy(0.1)); err != context.DeadlineExceeded {
+		t.Fatalf("submit after the deadline: %v", err)
+	}
+}
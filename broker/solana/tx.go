@@ -0,0 +1,124 @@
+package solana
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+)
+
+// Just enough of the Solana transaction format to sign the swap transactions the aggregator builds
+// A transaction is: compact-u16 number of signatures, the 64 byte signatures, then the message
+// The message starts with a version byte (0x80 | version) for versioned transactions, then the header
+// (number of required signatures, read-only signed, read-only unsigned), the account keys and the recent blockhash
+// The first accounts are the signers, each signature goes in the slot of its signer's key
+
+type transaction struct {
+	sigOffset int      // Where the signatures start
+	sigs      [][]byte // Slices into the raw transaction
+	message   []byte
+	signers   [][]byte // The keys that have to sign, in signature order
+	blockhash []byte
+}
+
+// The compact-u16 length prefix, 7 bits per byte with the top bit set if another byte follows
+func readCompactU16(b []byte) (int, int, error) {
+	n := 0
+	for i := 0; i < 3; i++ {
+		if i >= len(b) {
+			return 0, 0, errors.New("truncated length")
+		}
+		n |= int(b[i]&0x7f) << (7 * i)
+		if b[i]&0x80 == 0 {
+			return n, i + 1, nil
+		}
+	}
+	return 0, 0, errors.New("length too long")
+}
+
+func appendCompactU16(b []byte, n int) []byte {
+	for {
+		c := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func parseTransaction(raw []byte) (transaction, error) {
+	var tx transaction
+	numSigs, off, err := readCompactU16(raw)
+	if err != nil {
+		return tx, fmt.Errorf("signature count: %w", err)
+	}
+	tx.sigOffset = off
+	if len(raw) < off+numSigs*64 {
+		return tx, errors.New("truncated signatures")
+	}
+	for i := 0; i < numSigs; i++ {
+		tx.sigs = append(tx.sigs, raw[off+i*64:off+(i+1)*64])
+	}
+	tx.message = raw[off+numSigs*64:]
+
+	msg := tx.message
+	if len(msg) > 0 && msg[0]&0x80 != 0 {
+		if version := msg[0] & 0x7f; version != 0 {
+			return tx, fmt.Errorf("unsupported transaction version %d", version)
+		}
+		msg = msg[1:]
+	}
+	if len(msg) < 3 {
+		return tx, errors.New("truncated message header")
+	}
+	required := int(msg[0])
+	numKeys, n, err := readCompactU16(msg[3:])
+	if err != nil {
+		return tx, fmt.Errorf("account count: %w", err)
+	}
+	keys := msg[3+n:]
+	if len(keys) < numKeys*32+32 || required > numKeys || required != numSigs {
+		return tx, errors.New("malformed account keys")
+	}
+	for i := 0; i < required; i++ {
+		tx.signers = append(tx.signers, keys[i*32:(i+1)*32])
+	}
+	tx.blockhash = keys[numKeys*32 : numKeys*32+32]
+	return tx, nil
+}
+
+// SignTransaction puts the keypair's signature on the transaction, returns the signed transaction and its ID (the first signature)
+func SignTransaction(raw []byte, k Keypair) ([]byte, string, error) {
+	signed := append([]byte(nil), raw...)
+	tx, err := parseTransaction(signed)
+	if err != nil {
+		return nil, "", err
+	}
+	pub := k.PublicKey()
+	slot := -1
+	for i, key := range tx.signers {
+		if bytes.Equal(key, pub) {
+			slot = i
+		}
+	}
+	if slot < 0 {
+		return nil, "", fmt.Errorf("%s is not a signer of the transaction", k.Address())
+	}
+	copy(tx.sigs[slot], k.Sign(tx.message))
+	return signed, EncodeBase58(tx.sigs[0]), nil
+}
+
+// Checks every signature on the transaction (used by the mock in place of the validators)
+func verifyTransaction(raw []byte) (transaction, error) {
+	tx, err := parseTransaction(raw)
+	if err != nil {
+		return tx, err
+	}
+	for i, key := range tx.signers {
+		if !ed25519.Verify(ed25519.PublicKey(key), tx.message, tx.sigs[i]) {
+			return tx, fmt.Errorf("signature %d does not verify", i)
+		}
+	}
+	return tx, nil
+}
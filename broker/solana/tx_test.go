@@ -0,0 +1,118 @@
+package solana
+
+import (
+	"bytes"
+	"crypto/rand"
+	"strings"
+	"testing"
+)
+
+// The swap transactions are built the same way the mock builds them, with the user as the only signer
+
+func testTransaction(t *testing.T, k Keypair) []byte {
+	t.Helper()
+	blockhash := make([]byte, 32)
+	rand.Read(blockhash)
+	return mockTransaction(k.PublicKey(), blockhash)
+}
+
+func testKeypair(t *testing.T) Keypair {
+	t.Helper()
+	k, err := GenerateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestSignVerify(t *testing.T) {
+	k := testKeypair(t)
+	raw := testTransaction(t, k)
+
+	// Unsigned it does not verify
+	if _, err := verifyTransaction(raw); err == nil {
+		t.Fatal("unsigned transaction verified")
+	}
+	signed, sig, err := SignTransaction(raw, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw[1:65], make([]byte, 64)) {
+		t.Fatal("SignTransaction changed the transaction it was given")
+	}
+	tx, err := verifyTransaction(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The ID is the first signature, and only the signature changed
+	if sig != EncodeBase58(tx.sigs[0]) {
+		t.Fatalf("signature %s, want %s", sig, EncodeBase58(tx.sigs[0]))
+	}
+	if !bytes.Equal(signed[65:], raw[65:]) {
+		t.Fatal("signing changed the message")
+	}
+
+	// Changing the message after signing breaks the signature
+	tampered := append([]byte(nil), signed...)
+	tampered[len(tampered)-3] ^= 1
+	if _, err := verifyTransaction(tampered); err == nil {
+		t.Fatal("tampered transaction verified")
+	}
+}
+
+func TestSignWrongSigner(t *testing.T) {
+	raw := testTransaction(t, testKeypair(t))
+	other := testKeypair(t)
+	if _, _, err := SignTransaction(raw, other); err == nil || !strings.Contains(err.Error(), "is not a signer") {
+		t.Fatalf("signed by another key: %v", err)
+	}
+
+	// A signature from another key in the signer's slot does not verify
+	forged := append([]byte(nil), raw...)
+	copy(forged[1:65], other.Sign(raw[65:]))
+	if _, err := verifyTransaction(forged); err == nil {
+		t.Fatal("signature from the wrong key verified")
+	}
+}
+
+func TestParseTruncated(t *testing.T) {
+	k := testKeypair(t)
+	raw := testTransaction(t, k)
+	cases := []struct {
+		name string
+		raw  []byte
+		want string
+	}{
+		{"empty", nil, "truncated length"},
+		{"signatures", raw[:30], "truncated signatures"},
+		{"header", raw[:67], "truncated message header"},
+		{"keys", raw[:len(raw)-20], "malformed account keys"},
+	}
+	for _, c := range cases {
+		if _, _, err := SignTransaction(c.raw, k); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: %v, want %q", c.name, err, c.want)
+		}
+		if _, err := verifyTransaction(c.raw); err == nil {
+			t.Errorf("%s: truncated transaction verified", c.name)
+		}
+	}
+
+	legacy := append([]byte(nil), raw...)
+	legacy[65] = 0x81 // Version 1
+	if _, err := parseTransaction(legacy); err == nil || !strings.Contains(err.Error(), "unsupported transaction version") {
+		t.Errorf("version 1: %v", err)
+	}
+}
+
+func TestCompactU16(t *testing.T) {
+	for _, n := range []int{0, 1, 127, 128, 300, 16383, 16384, 65535} {
+		b := appendCompactU16(nil, n)
+		got, size, err := readCompactU16(b)
+		if err != nil || got != n || size != len(b) {
+			t.Errorf("%d: got %d (%d bytes of %d), %v", n, got, size, len(b), err)
+		}
+	}
+	if _, _, err := readCompactU16([]byte{0x80, 0x80, 0x80}); err == nil {
+		t.Error("4 byte length read")
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+	"github.com/Reece-Ogidih/CT-Bot/backtest"
+	bot "github.com/Reece-Ogidih/CT-Bot/bot"
+	_ "github.com/go-sql-driver/mysql" // Need to pull the candles from the DB
+	"github.com/joho/godotenv"         // Need to load secret info
+)
+
+// Backtests the trendline strategy over the historical candles in hist_candles_1m
+// The strategy settings are read from .env the same as PrepTrain and the live bot (see bot/setup.go for the list)
+// Example: go run ./cmd/Backtest -from 2025-01-01T00:00:00Z -to 2026-01-01T00:00:00Z -trades trades.csv -equity equity.csv
+
+func init() {
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatal("Error loading .env file")
+	}
+}
+
+// Format the string used to connect to the database here
+func getDSN() string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s",
+		os.Getenv("DB_USER"),
+		os.Getenv("DB_PASS"),
+		os.Getenv("DB_HOST"),
+		os.Getenv("DB_PORT"),
+		os.Getenv("DB_NAME"),
+	)
+}
+
+// Times can be given as RFC3339 or as ms since epoch
+func parseTime(s string) (int64, error) {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return ms, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (use RFC3339 or ms): %w", s, err)
+	}
+	return t.UnixMilli(), nil
+}
+
+func writeCSV(path string, write func(f *os.File) error) {
+	if path == "" {
+		return
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if err := write(f); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Written to", path)
+}
+
+func main() {
+	cfg := backtest.DefaultConfig()
+	fromStr := flag.String("from", "", "start of the range (RFC3339 or ms), defaults to the first candle")
+	toStr := flag.String("to", "", "end of the range (RFC3339 or ms), defaults to the last candle")
+	flag.Float64Var(&cfg.InitialCash, "cash", cfg.InitialCash, "starting cash")
+	flag.Float64Var(&cfg.Fraction, "fraction", cfg.Fraction, "fraction of the equity put into each entry")
+	flag.Float64Var(&cfg.MakerFee, "maker-fee", cfg.MakerFee, "maker fee as a fraction of the notional")
+	flag.Float64Var(&cfg.TakerFee, "taker-fee", cfg.TakerFee, "taker fee as a fraction of the notional")
+	flag.Float64Var(&cfg.Spread, "spread", cfg.Spread, "bid/ask spread as a fraction of the price")
+	flag.Float64Var(&cfg.Slippage, "slippage", cfg.Slippage, "slippage as a fraction of the price")
+	flag.BoolVar(&cfg.FillOnClose, "fill-close", cfg.FillOnClose, "fill market orders at the signal close instead of the next open")
+	flag.IntVar(&cfg.OrderExpiry, "expiry", cfg.OrderExpiry, "candles a limit/stop entry stays open (0 for no expiry)")
+	tradesOut := flag.String("trades", "", "write the trade list to this CSV file")
+	equityOut := flag.String("equity", "", "write the equity curve to this CSV file")
+	flag.Parse()
+
+	from, to := int64(0), int64(1<<62)
+	var err error
+	if *fromStr != "" {
+		if from, err = parseTime(*fromStr); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *toStr != "" {
+		if to, err = parseTime(*toStr); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	db, err := sql.Open("mysql", getDSN())
+	if err != nil {
+		log.Fatal("DB connection error:", err)
+	}
+	defer db.Close()
+
+	// The candles are streamed from the DB rather than loaded all at once
+	rows, err := db.Query(`
+	SELECT open_times_ms, open, high, low, close, volume
+	FROM hist_candles_1m
+	WHERE open_times_ms BETWEEN ? AND ?
+	ORDER BY open_times_ms ASC
+	`, from, to)
+	if err != nil {
+		log.Fatal("query error:", err)
+	}
+	defer rows.Close()
+	feed := backtest.FromRows(rows)
+
+	settings, err := bot.StrategySettingsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The first WINDOW_SIZE candles fill the window and seed the ADX and the entry gates, the backtest starts after them
+	size := settings.WindowSize
+	warmup := make([]models.CandleStick, 0, size)
+	for len(warmup) < size {
+		c, ok := feed.Next()
+		if !ok {
+			log.Fatalf("Not enough candles to fill the window: %v", feed.Err())
+		}
+		warmup = append(warmup, c)
+	}
+	window, err := bot.NewSlidingWindow[models.CandleStick]("SOLUSDT", "1m", size, bot.AxisIndex)
+	if err != nil {
+		log.Fatalf("Error creating the window: %v", err)
+	}
+	window.Fill(warmup)
+	adxCalc := bot.ADXCalculator{Period: settings.ADXPeriod}
+	if err := adxCalc.Seed(warmup); err != nil {
+		log.Fatal(err)
+	}
+
+	// The same strategy as PrepTrain and the live bot, including RETEST_BARS, REGIME_GATE, HTF_INTERVAL and EXITS
+	setup, err := bot.NewTrendlineSetup(window, settings, bot.LiveADX(&adxCalc), warmup)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// SIZING sizes the entries from the confidence the same as the live bot, without it every entry is Fraction of the equity
+	engine := backtest.New[models.CandleStick](setup, cfg)
+	if env := os.Getenv("SIZING"); env != "" {
+		sizingCfg, err := bot.ParseSizingConfig(env)
+		if err != nil {
+			log.Fatalf("Error parsing SIZING: %v", err)
+		}
+		sizingCfg.Fraction = cfg.Fraction
+		engine.Sizer = bot.NewSizer(sizingCfg, time.Minute)
+	}
+
+	start := time.Now()
+	result, err := engine.Run(context.Background(), feed)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(result.Summary)
+	fmt.Printf("Backtest took %s\n", time.Since(start).Round(time.Millisecond))
+
+	writeCSV(*tradesOut, func(f *os.File) error { return result.WriteTrades(f) })
+	writeCSV(*equityOut, func(f *os.File) error { return result.WriteEquity(f) })
+}
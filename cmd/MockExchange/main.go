@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/Reece-Ogidih/CT-Bot/broker/binance"
+	"github.com/Reece-Ogidih/CT-Bot/broker/solana"
+	"github.com/joho/godotenv" // Need to load secret info
+)
+
+// Runs the mock Binance exchange locally so the live execution can be tried out with no account or funds
+// It uses the same BINANCE_API_KEY/BINANCE_API_SECRET as the bot (or the mock defaults if they are not set)
+// Then run the bot with BINANCE_BASE_URL=http://localhost:8090 BINANCE_WS_URL=ws://localhost:8090/ws/ go run ./cmd/bot -mode live
+// The price only moves when told to: curl -X POST 'localhost:8090/mock/price?price=151.2'
+// With -market solana it mocks the swap aggregator and the Solana RPC instead, run the bot with
+// JUPITER_URL=http://localhost:8090 SOLANA_RPC_URL=http://localhost:8090/rpc go run ./cmd/bot -mode onchain
+
+func init() {
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatal("Error loading .env file")
+	}
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:8090", "address to listen on")
+	market := flag.String("market", "futures", "spot, futures or solana")
+	price := flag.Float64("price", 0, "starting price (defaults to the mock default)")
+	balance := flag.Float64("balance", 0, "starting quote balance (defaults to the mock default)")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *market == "solana" {
+		cfg := solana.DefaultMockConfig()
+		if *price > 0 {
+			cfg.Price = *price
+		}
+		if *balance > 0 {
+			cfg.USDC = *balance
+		}
+		fmt.Printf("Mock aggregator on http://%s and Solana RPC on http://%s/rpc (price %.2f, wallets start with %.2f USDC and %.2f SOL)\n",
+			*addr, *addr, cfg.Price, cfg.USDC, cfg.SOL)
+		if err := solana.NewMock(cfg).Serve(ctx, *addr); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *market != string(binance.Spot) && *market != string(binance.Futures) {
+		log.Fatalf("Unknown market %q", *market)
+	}
+
+	cfg := binance.DefaultMockConfig(binance.Market(*market))
+	if *price > 0 {
+		cfg.Price = *price
+	}
+	if *balance > 0 {
+		cfg.Balance = *balance
+	}
+	if key := os.Getenv("BINANCE_API_KEY"); key != "" {
+		cfg.APIKey, cfg.Secret = key, os.Getenv("BINANCE_API_SECRET")
+	}
+
+	fmt.Printf("Mock %s exchange for %s on http://%s (price %.2f, balance %.2f %s)\n", *market, cfg.Symbol, *addr, cfg.Price, cfg.Balance, cfg.Quote)
+	if err := binance.NewMock(cfg).Serve(ctx, *addr); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	models "github.com/Reece-Ogidih/CT-Bot/Models"
 	bot "github.com/Reece-Ogidih/CT-Bot/bot"
+	"github.com/Reece-Ogidih/CT-Bot/render"
 	_ "github.com/go-sql-driver/mysql" // Need to save the data to the MySQL DB & Pull from Hist DB
 	"github.com/joho/godotenv"         // Need to load secret info
 )
@@ -113,15 +118,70 @@ func getADXCandles(candles []models.CandleStick) (fullCandles []models.EnrichedC
 	return enriched
 }
 
-func main() {
-	// First pull some of the key info from .env
-	adx_threshold, err := strconv.Atoi(os.Getenv("ADX_THRESHOLD")) // This is the required ADX for a trade to be placed
+// Helper function to label each candle with its market regime, the regime config is taken from .env where it has been set
+// Setting REGIME_HMM_STATES will fit a hidden Markov model on the log returns and use it for the high volatility regime
+// The HMM is only fitted on the first REGIME_HMM_FIT fraction of the candles (defaults to 0.3), otherwise the labels would be using returns from the future
+// The candles in that fit window are labelled unknown, so only the candles after it get a regime from the HMM
+func getRegimes(candles []models.CandleStick, cfg bot.RegimeConfig) []bot.RegimeState {
+	fitEnd := 0
+	if states := os.Getenv("REGIME_HMM_STATES"); states != "" {
+		n, err := strconv.Atoi(states)
+		if err != nil {
+			log.Fatalf("Error parsing REGIME_HMM_STATES: %v", err)
+		}
+		fitFrac := 0.3
+		if frac := os.Getenv("REGIME_HMM_FIT"); frac != "" {
+			fitFrac, err = strconv.ParseFloat(frac, 64)
+			if err != nil || fitFrac <= 0 || fitFrac >= 1 {
+				log.Fatalf("REGIME_HMM_FIT should be a fraction between 0 and 1, got %q", frac)
+			}
+		}
+		fitEnd = int(float64(len(candles)) * fitFrac)
+		hmm, err := bot.FitHMM(bot.LogReturns(candles[:fitEnd]), n, 20)
+		if err != nil {
+			log.Fatalf("Error fitting HMM: %v", err)
+		}
+		cfg.HMM = hmm
+	}
+	regimes := bot.ClassifyRegimes(candles, cfg)
+	for i := 0; i < fitEnd; i++ {
+		regimes[i] = bot.RegimeState{Regime: bot.RegimeUnknown, HMMState: -1}
+	}
+	return regimes
+}
+
+// Draws the window at the time of a trade with the lines that were broken and the entry marker, so each trade can be checked by eye
+func renderTrade(dir, format string, window *bot.Window[models.EnrichedCandle], res, sup models.Trendline, entry int) {
+	candles := window.Candles()
+	last := candles[len(candles)-1]
+	chart := render.FromEnriched(fmt.Sprintf("Trade at %d (entry %d)", last.OpenTime, entry), candles)
+	chart.Xs = make([]float64, len(candles))
+	for i := range chart.Xs {
+		chart.Xs[i] = window.X(i)
+	}
+	chart.Lines = []render.Line{{Line: res, IsResist: true}, {Line: sup}}
+	kind := render.EntryLong
+	if entry == -1 {
+		kind = render.EntryShort
+	}
+	chart.Markers = []render.Marker{{Kind: kind, OpenTime: last.OpenTime, Price: last.Close}}
+
+	f, err := os.Create(filepath.Join(dir, fmt.Sprintf("trade_%d.%s", last.OpenTime, format)))
 	if err != nil {
-		log.Fatalf("Error parsing ADX_THRESHOLD: %v", err) // Note: These can be converted from strings to integers and then to float64 since there is no decimals
+		log.Println("Could not create trade chart:", err)
+		return
+	}
+	defer f.Close()
+	if err := chart.Write(f, format); err != nil {
+		log.Println("Could not render trade chart:", err)
 	}
-	adx_min, err := strconv.Atoi(os.Getenv("ADX_MIN")) // This is the required ADX for a position to be held
+}
+
+func main() {
+	// First pull the strategy settings from .env, the backtests and the live bot read exactly the same ones (see bot/setup.go)
+	settings, err := bot.StrategySettingsFromEnv()
 	if err != nil {
-		log.Fatalf("Error parsing ADX_MIN: %v", err)
+		log.Fatal(err)
 	}
 
 	// Connect to the DB
@@ -137,235 +197,201 @@ func main() {
 		fmt.Println("Error:", err)
 	}
 
+	// The candles can optionally be converted into a different bar type first (e.g. BAR_TYPE=heikin-ashi or BAR_TYPE=renko with BAR_PARAMS=0.5)
+	if barType := os.Getenv("BAR_TYPE"); barType != "" {
+		var params []float64
+		for _, p := range strings.Split(os.Getenv("BAR_PARAMS"), ",") {
+			if strings.TrimSpace(p) == "" {
+				continue
+			}
+			val, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+			if err != nil {
+				log.Fatalf("Error parsing BAR_PARAMS: %v", err)
+			}
+			params = append(params, val)
+		}
+		transformer, err := bot.NewBarTransformer(barType, params)
+		if err != nil {
+			log.Fatal(err)
+		}
+		candles = bot.TransformDataset(models.Dataset{Candles: candles}, transformer).Candles
+	}
+
 	// Transform them into the form that holds the ADX as well
 	fullCandles := getADXCandles(candles)
 
+	// Label the regimes and then trim them so they line up with the enriched candles
+	regimes := getRegimes(candles, settings.Regime)
+	regimes = regimes[len(regimes)-len(fullCandles):]
+
 	// The next step here is to now create the sliding window and then create the detection for when to lodge BUY vs Sell orders
 	// Will immediately place the first N candles into the window
-	size, err := strconv.Atoi(os.Getenv("WINDOW_SIZE")) // Grab the window size from .env
+	size := settings.WindowSize
+	// The window uses the bar index as the x-axis, otherwise the trendline gradients would be practically 0
+	window, err := bot.NewSlidingWindow[models.EnrichedCandle]("SOLUSDT", "1m", size, bot.AxisIndex)
 	if err != nil {
-		log.Fatalf("Error parsing WINDOW_SIZE: %v", err)
+		log.Fatalf("Error creating the window: %v", err)
 	}
-	window := bot.SlidingWindowTrain{
-		Symbol:      "SOLUSDT",
-		Interval:    "1m",
-		Size:        size,
-		Candles:     fullCandles[:size],
-		SupLine:     models.Trendline{},
-		ResLine:     models.Trendline{},
-		Initialised: true,
-		Idxs:        make([]int, size),
+
+	window.Fill(fullCandles[:size])
+
+	// Setting TRENDLINE_EVENTS=1 stores the lifecycle of every trendline (created, touched, broken, expired, replaced) in trendline_events
+	// The run ID is the start time so the lines of different runs can be told apart
+	var eventsDone chan struct{}
+	if os.Getenv("TRENDLINE_EVENTS") == "1" {
+		events := make(chan models.TrendlineEvent, 1024)
+		eventsDone = make(chan struct{})
+		window.Events = events
+		go func() {
+			bot.LogTrendlineEvents(db, time.Now().UnixMilli(), events)
+			close(eventsDone)
+		}()
 	}
 
-	// For loop to fill in the Indices
-	for i := range window.Idxs {
-		window.Idxs[i] = i
+	// Keep the plain candles (including the window) for the candlestick patterns, since the patterns need the previous candles
+	plainCandles := make([]models.CandleStick, len(fullCandles))
+	for i, c := range fullCandles {
+		plainCandles[i] = c.CandleStick()
 	}
+	patternCfg := bot.DefaultPatternConfig()
 
 	// Now need to remove these first N candles from the remaining dataset
 	fullCandles = fullCandles[size:]
+	regimes = regimes[size:]
 
-	// Now will compute the support and resistance trendlines
-	window.ComputeTrendlines()
+	// Setting RENDER_TRADES to a directory draws a chart of the window for every entry (RENDER_FORMAT is svg, png or html, defaults to svg)
+	renderDir := os.Getenv("RENDER_TRADES")
+	renderFormat := os.Getenv("RENDER_FORMAT")
+	if renderFormat == "" {
+		renderFormat = "svg"
+	}
+	if renderDir != "" {
+		if err := os.MkdirAll(renderDir, 0o755); err != nil {
+			log.Fatalf("Error creating RENDER_TRADES directory: %v", err)
+		}
+	}
 
-	// The logic that will be implemented is that the bot will wait for a breakout or for certain number of candles to pass.
+	// The breakout + ADX logic itself is the trendline strategy (bot.TrendlineStrategy) so the backtests and the live bot trade exactly the same way
+	// The bot will wait for a breakout or for certain number of candles to pass.
 	// In the case of breakout, ADX will be evaluated, if the trend strength is high enough it will trigger a "trade"
 	// No matter if a trade is triggered or not, once there is a breakout or n candles pass, New trendlines will be drawn.
-	// Will first set a counter for candles since the last update
-	candlesSinceUpdate := 0
+	// The setup also wires in RETEST_BARS, REGIME_GATE, HTF_INTERVAL and EXITS, and draws the first trendlines and chart pattern
+	setup, err := bot.NewTrendlineSetup(window, settings, bot.EnrichedADX, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	strategy := setup.Strategy
+	ctx := context.Background()
 
-	// Will also add some lines to note if bot is in a trade as well as entry and exit positions
-	entrySignal := 0
-	exitSignal := 0
-	currentPos := 0
-	inTrade := currentPos != 0
+	// The regimes are the labels above (which use the HMM) rather than the setup's own detector
+	setup.Gates.Regimes = nil
 
 	// Need the next var for some debugging prints within the loop
 	countprint := 0
 
-	// To ensure the bot will recalibrate the trendlines after N candles I declare 2 variables
-	// One variable is the max number of candles when the bot is not in a trade before it recalibrates
-	// The other is specifically how long it can hold trades
-	idleRecalibrate, err := strconv.Atoi(os.Getenv("IDLE_LIMIT")) // This would be frequency of redrawing the trendlines when outside of trades
-	if err != nil {
-		log.Fatalf("Error parsing IDLE_LIMIT: %v", err)
-	}
-	activeRecalibrate, err := strconv.Atoi(os.Getenv("ACTIVE_LIMIT")) // This means the bot can hold a trade for at most this long
-	if err != nil {
-		log.Fatalf("Error parsing ACTIVE_LIMIT: %v", err)
-	}
 	// Finally, the slice of candle data for Ml dev needs to be declared
 	var finalData []models.DevData
 
 	// Now can begin the loop
 	for i := 0; i < len(fullCandles); i++ {
 		newCandle := fullCandles[i] // The "current" candle as it would be in live stream
-		nextIdx := window.GetNextIdx()
-		adx := newCandle.ADX
-		plusDI := newCandle.PlusDI
-		minusDI := newCandle.MinusDI
-
-		// Must reset entry and exit signals for each candle
-		entrySignal = 0
-		exitSignal = 0
-
-		// Now check for breakouts, will need to declare this variable
-		var breakoutOccured bool
-		var brokeSup bool
-		var brokeRes bool
-
-		// Now need to flag if there was a breakout
-		// Must account for if we are in a trade
-		if currentPos == 1 {
-			brokeRes = false
-			brokeSup = window.CheckTrendlines2(window.SupLine, newCandle, false, currentPos, nextIdx)
-			breakoutOccured = brokeRes || brokeSup // || is the "or" operator in Go, so this is saying either broke res line or broke sup line
-		} else if currentPos == -1 {
-			brokeRes = window.CheckTrendlines2(window.ResLine, newCandle, true, currentPos, nextIdx)
-			brokeSup = false
-			breakoutOccured = brokeRes || brokeSup
-		} else {
-			brokeRes = window.CheckTrendlines2(window.ResLine, newCandle, true, currentPos, nextIdx)
-			brokeSup = window.CheckTrendlines2(window.SupLine, newCandle, false, currentPos, nextIdx)
-			breakoutOccured = brokeRes || brokeSup
-		}
+		nextIdx := window.NextIdx()
+		regime := regimes[i].Regime
+		setup.Gates.Regime = regime
+
+		// The chart pattern the candle arrived in (before the lines are redrawn)
+		chartPattern := strategy.Chart.Pattern
 
 		// This is the debugging part added to print
 
-		supY := window.SupLine.Gradient*float64(nextIdx) + window.SupLine.Intercept
-		resY := window.ResLine.Gradient*float64(nextIdx) + window.ResLine.Intercept
+		supY := bot.LineAt(window.SupLine, window.NextX(newCandle))
+		resY := bot.LineAt(window.ResLine, window.NextX(newCandle))
 
 		if countprint <= 100 {
 			countprint++
 			fmt.Printf("Pos=%d x=%d SupGrad=%.5f SupInt=%.5f SupY=%.5f ResGrad=%.5f ResInt=%.5f ResY=%.5f High=%.5f Low=%.5f\n",
-				currentPos, nextIdx,
+				strategy.Position, nextIdx,
 				window.SupLine.Gradient, window.SupLine.Intercept, supY,
 				window.ResLine.Gradient, window.ResLine.Intercept, resY,
 				newCandle.High, newCandle.Low)
 		}
 
-		// Add a checker for if we are in a trade and the adx drops (so little momentum in market), the bot should exit its position (anticipating reversal)
-		if inTrade && adx < float64(adx_min) {
-			if currentPos == 1 {
-				exitSignal = 1
-				currentPos = 0
-				inTrade = false
-			} else if currentPos == -1 {
-				exitSignal = -1
-				currentPos = 0
-				inTrade = false
-			} else {
-				fmt.Println("Error, intrade and currentPos don't align, candle:", nextIdx)
+		// The training labels are the strategy's signals (an exit and an entry on the same candle is a reversal)
+		// A partial take-profit is not the end of the trade, so it is not labelled as an exit
+		entrySignal, exitSignal := 0, 0
+		for _, s := range setup.OnCandle(ctx, newCandle) {
+			if s.Kind == bot.SignalEntry {
+				entrySignal = s.Side
+			} else if s.Fraction == 0 {
+				exitSignal = s.Side
 			}
 		}
-
-		// Can now do conditional actions based on breakouot detection
-		if breakoutOccured {
-			// First need to check to see if there was an ongoing trade as the bot would exit here
-			if brokeRes && currentPos == -1 {
-				exitSignal = -1 // Would exit here
-				currentPos = 0  // Reset our position to no trades
-				inTrade = false
-			}
-			if brokeSup && currentPos == 1 {
-				exitSignal = 1
-				currentPos = 0
-				inTrade = false
-			}
-
-			if adx >= float64(adx_threshold) {
-				// This would mean that there is a strong trend, bot should attempt to trigger trade here
-				// Note that the currentPos = 0 check would not be in the actual trading bot as it limits trades to only occuring when not in any positions
-				if brokeRes && plusDI > minusDI {
-					// Would enter into a long position here, so will update trackers accordingly
-					// Must check if this is a new entry or if theres an error
-					if currentPos == 0 {
-						entrySignal = 1
-						currentPos = 1
-						inTrade = true
-					} else if currentPos == -1 {
-						exitSignal = -1
-						entrySignal = 1
-						currentPos = 1
-						inTrade = true
-					} else {
-						fmt.Println("Error, proposing long whilst in long position:", newCandle)
-					}
-				} else if brokeSup && plusDI < minusDI {
-					// Would enter into a short position
-					// Must check if this is a new entry or if theres an error
-					if currentPos == 0 {
-						entrySignal = -1
-						currentPos = -1
-						inTrade = true
-					} else if currentPos == 1 {
-						exitSignal = 1
-						entrySignal = -1
-						currentPos = -1
-						inTrade = true
-					} else {
-						fmt.Println("Error, proposing short whilst in short position", newCandle)
-					}
-				}
+		sig := strategy.Last
+		chartBreak := strategy.ChartBreak
+
+		// Record which filters each breakout passed so they can be compared afterwards
+		// A wide candle can break both lines, then both are kept (breakout 2) rather than one of them hiding the other
+		breakoutDir, resFilters, supFilters := 0, 0, 0
+		if sig.Res.Broke {
+			breakoutDir, resFilters = 1, sig.Res.Filters.Mask()
+		}
+		if sig.Sup.Broke {
+			breakoutDir, supFilters = -1, sig.Sup.Filters.Mask()
+			if sig.Res.Broke {
+				breakoutDir = 2
 			}
+		}
 
-			// After a breakout, trendlines will be redrawn regardless of trend strength
-			candlesSinceUpdate = 0
-			window.NewWindowTrain(newCandle)
-			window.UpdateIdx(nextIdx)
-			window.ComputeTrendlines()
-
-		} else {
-			// After checking for breakout, increase the counter and then check to see if there have been too many candles that have passed
-			candlesSinceUpdate++
-			window.NewWindowTrain(newCandle) // Update the sliding window
-			window.UpdateIdx(nextIdx)
-
-			// Check for too long idle or holding a position
-			maxCandles := idleRecalibrate
-			if inTrade {
-				maxCandles = activeRecalibrate
-			}
-			if candlesSinceUpdate >= maxCandles {
-				// Exit any trades
-				if currentPos != 0 {
-					exitSignal = currentPos
-					currentPos = 0
-					inTrade = false
-				}
-
-				// Reset the counter and recalibrate the trendlines
-				candlesSinceUpdate = 0
-				window.ComputeTrendlines()
-			}
+		if renderDir != "" && entrySignal != 0 {
+			renderTrade(renderDir, renderFormat, window, sig.ResLine, sig.SupLine, entrySignal)
 		}
 
 		finalData = append(finalData, models.DevData{
-			OpenTime: newCandle.OpenTime,
-			Open:     newCandle.Open,
-			High:     newCandle.High,
-			Low:      newCandle.Low,
-			Close:    newCandle.Close,
-			Volume:   newCandle.Volume,
-			ADX:      newCandle.ADX,
-			Idx:      nextIdx,
-			SigEntry: entrySignal,
-			SigExit:  exitSignal,
+			OpenTime:    newCandle.OpenTime,
+			Open:        newCandle.Open,
+			High:        newCandle.High,
+			Low:         newCandle.Low,
+			Close:       newCandle.Close,
+			Volume:      newCandle.Volume,
+			ADX:         newCandle.ADX,
+			Idx:         nextIdx,
+			Regime:      int(regime),
+			SigEntry:    entrySignal,
+			SigExit:     exitSignal,
+			Breakout:    breakoutDir,
+			ResFilters:  resFilters,
+			SupFilters:  supFilters,
+			Chart:       int(chartPattern),
+			ChartBreak:  chartBreak.Direction,
+			ChartTarget: chartBreak.Target,
+			Patterns:    bot.PatternFlags(plainCandles, i+size, patternCfg),
 		})
 	}
 
-	// Insert the data into the DB
-	stmt, err := db.Prepare(`
-	INSERT INTO train_ml (open_times_ms, open, close, high, low, volume, adx, idx, sig_entry, sig_exit)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`)
+	// Wait for the last trendline events to be stored
+	if eventsDone != nil {
+		close(window.Events)
+		<-eventsDone
+	}
+
+	// Insert the data into the DB, there is one column per candlestick pattern so the statement is built from the pattern names
+	patternCols := bot.PatternColumns()
+	placeholders := strings.Repeat(", ?", len(patternCols))
+	stmt, err := db.Prepare(fmt.Sprintf(`
+	INSERT INTO train_ml (open_times_ms, open, close, high, low, volume, adx, idx, regime, sig_entry, sig_exit, breakout, res_breakout_filters, sup_breakout_filters, chart_pattern, chart_breakout, chart_target, %s)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?%s)
+	`, strings.Join(patternCols, ", "), placeholders))
 	if err != nil {
 		log.Fatal("Preparation error:", err)
 	}
 	defer stmt.Close()
 
 	for _, c := range finalData {
-		_, err := stmt.Exec(c.OpenTime, c.Open, c.Close, c.High, c.Low, c.Volume, c.ADX, c.Idx, c.SigEntry, c.SigExit)
+		args := []any{c.OpenTime, c.Open, c.Close, c.High, c.Low, c.Volume, c.ADX, c.Idx, c.Regime, c.SigEntry, c.SigExit, c.Breakout, c.ResFilters, c.SupFilters, c.Chart, c.ChartBreak, c.ChartTarget}
+		for _, flag := range c.Patterns {
+			args = append(args, flag)
+		}
+		_, err := stmt.Exec(args...)
 		if err != nil {
 			log.Println("Error inserting:", err)
 		}
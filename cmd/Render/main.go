@@ -0,0 +1,211 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+	bot "github.com/Reece-Ogidih/CT-Bot/bot"
+	"github.com/Reece-Ogidih/CT-Bot/render"
+	_ "github.com/go-sql-driver/mysql" // Need to pull the candles and trades from the DB
+	"github.com/joho/godotenv"         // Need to load secret info
+)
+
+// Draws a chart of the historical candles for any time range, with the ADX panel, the trendlines of the window ending at the last candle
+// and the trades logged in bot_trades as markers
+// Example: go run ./cmd/Render -from 2025-01-02T10:00:00Z -to 2025-01-02T14:00:00Z -format html -out chart.html
+
+func init() {
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatal("Error loading .env file")
+	}
+}
+
+// Format the string used to connect to the database here
+func getDSN() string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s",
+		os.Getenv("DB_USER"),
+		os.Getenv("DB_PASS"),
+		os.Getenv("DB_HOST"),
+		os.Getenv("DB_PORT"),
+		os.Getenv("DB_NAME"),
+	)
+}
+
+// Times can be given as RFC3339 or as ms since epoch
+func parseTime(s string) (int64, error) {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return ms, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (use RFC3339 or ms): %w", s, err)
+	}
+	return t.UnixMilli(), nil
+}
+
+func getCandles(db *sql.DB, from, to int64) ([]models.CandleStick, error) {
+	rows, err := db.Query(`
+	SELECT open_times_ms, open, high, low, close, volume
+	FROM hist_candles_1m
+	WHERE open_times_ms BETWEEN ? AND ?
+	ORDER BY open_times_ms ASC
+	`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	var candles []models.CandleStick
+	var candle models.CandleStick
+	for rows.Next() {
+		if err := rows.Scan(&candle.OpenTime, &candle.Open, &candle.High, &candle.Low, &candle.Close, &candle.Volume); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		candles = append(candles, candle)
+	}
+	return candles, rows.Err()
+}
+
+// The trades are shown as markers, the entries as triangles (long under the candle, short over it) and the exits as diamonds at the fill price
+// A fill is an exit if its order was reduce-only in order_events, fills with no order events (e.g. from before the OMS) are worked out
+// from the position built up by the fills in the range instead, a fill against the position is an exit
+func getTrades(db *sql.DB, from, to int64) ([]render.Marker, error) {
+	rows, err := db.Query(`
+	SELECT t.timestamp_ms, t.action, t.price, COALESCE(t.quantity, 0), COALESCE(t.notes, ''),
+		(SELECT MAX(e.reduce_only) FROM order_events e WHERE e.client_order_id = t.client_order_id)
+	FROM bot_trades t
+	WHERE t.timestamp_ms BETWEEN ? AND ?
+	ORDER BY t.timestamp_ms ASC, t.id ASC
+	`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	var markers []render.Marker
+	var position float64
+	for rows.Next() {
+		var m render.Marker
+		var action string
+		var qty float64
+		var reduceOnly sql.NullInt64
+		if err := rows.Scan(&m.OpenTime, &action, &m.Price, &qty, &m.Label, &reduceOnly); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		side := 1.0
+		if action == "SELL" {
+			side = -1
+		}
+		exit := position*side < 0
+		if reduceOnly.Valid {
+			exit = reduceOnly.Int64 != 0
+		}
+		switch {
+		case exit:
+			m.Kind = render.Exit
+		case side == 1:
+			m.Kind = render.EntryLong
+		default:
+			m.Kind = render.EntryShort
+		}
+		position += side * qty
+		if math.Abs(position) < 1e-12 {
+			position = 0
+		}
+		markers = append(markers, m)
+	}
+	return markers, rows.Err()
+}
+
+func main() {
+	fromStr := flag.String("from", "", "start of the range (RFC3339 or ms)")
+	toStr := flag.String("to", "", "end of the range (RFC3339 or ms)")
+	format := flag.String("format", "svg", "svg, png or html")
+	out := flag.String("out", "", "output file (defaults to chart.<format>)")
+	size := flag.Int("window", 100, "window size for the trendlines, 0 to not draw them")
+	adxPeriod := flag.Int("adx", 14, "ADX period")
+	trades := flag.Bool("trades", true, "draw the trades from bot_trades")
+	width := flag.Int("width", 1200, "width in pixels")
+	height := flag.Int("height", 800, "height in pixels")
+	flag.Parse()
+
+	from, err := parseTime(*fromStr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	to, err := parseTime(*toStr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *out == "" {
+		*out = "chart." + *format
+	}
+
+	db, err := sql.Open("mysql", getDSN())
+	if err != nil {
+		log.Fatal("DB connection error:", err)
+	}
+	defer db.Close()
+
+	// Fetch some extra candles before the range so the ADX has warmed up by the start of it
+	warmup := int64(3*(*adxPeriod)) * int64(time.Minute/time.Millisecond)
+	candles, err := getCandles(db, from-warmup, to)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(candles) == 0 {
+		log.Fatal("No candles in the range")
+	}
+
+	chart := render.NewChart(fmt.Sprintf("SOLUSDT 1m %s - %s", time.UnixMilli(from).UTC().Format(time.RFC3339), time.UnixMilli(to).UTC().Format(time.RFC3339)), candles)
+	chart.Width, chart.Height = *width, *height
+	chart.ADX, chart.PlusDI, chart.MinusDI, _, _, _, _, err = bot.CalculateADX(candles, *adxPeriod)
+	if err != nil {
+		log.Println("Could not calculate the ADX:", err)
+		chart.ADX, chart.PlusDI, chart.MinusDI = nil, nil, nil
+	}
+
+	// Trendlines of the window ending at the last candle, using the bar index as the x-axis (same as PrepTrain)
+	if *size > 0 && len(candles) >= *size {
+		window, err := bot.NewSlidingWindow[models.CandleStick]("SOLUSDT", "1m", *size, bot.AxisIndex)
+		if err != nil {
+			log.Fatal(err)
+		}
+		window.Fill(candles[len(candles)-*size:])
+		window.ComputeTrendlines()
+		start := candles[len(candles)-*size].OpenTime
+		chart.Lines = []render.Line{
+			{Line: window.ResLine, IsResist: true, From: start},
+			{Line: window.SupLine, From: start},
+		}
+		chart.Xs = make([]float64, len(candles))
+		for i := range chart.Xs {
+			chart.Xs[i] = float64(i - (len(candles) - *size))
+		}
+	}
+
+	if *trades {
+		chart.Markers, err = getTrades(db, from, to)
+		if err != nil {
+			log.Println("Could not load the trades:", err)
+		}
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if err := chart.Range(from, to).Write(f, *format); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Chart written to", *out)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"math"
+	"os"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+	bot "github.com/Reece-Ogidih/CT-Bot/bot"
+	"github.com/Reece-Ogidih/CT-Bot/broker"
+	"github.com/Reece-Ogidih/CT-Bot/broker/binance"
+)
+
+// Live trading mode (-mode live), the strategy signals are executed on Binance through the OMS
+// The keys are read from .env (BINANCE_API_KEY, BINANCE_API_SECRET), BINANCE_MARKET picks spot or futures (futures by default, the same as the candle stream)
+// BINANCE_BASE_URL and BINANCE_WS_URL point it somewhere else, e.g. the mock exchange from cmd/MockExchange
+// Every fill is written to bot_trades with "live" at the start of the notes
+
+// Connects to Binance and puts the OMS in front of it, the returned function stops the user data stream and the loggers
+// The sizer's lot size, tick and minimum notional are taken from the symbol's filters unless SIZING set them
+func startLive(ctx context.Context, symbol string, sizer *bot.Sizer, risk *broker.Risk) (*broker.OMS, func()) {
+	market := binance.Futures
+	if os.Getenv("BINANCE_MARKET") == string(binance.Spot) {
+		market = binance.Spot
+	}
+	client := binance.NewClient(market, os.Getenv("BINANCE_API_KEY"), os.Getenv("BINANCE_API_SECRET"))
+	if url := os.Getenv("BINANCE_BASE_URL"); url != "" {
+		client.BaseURL = url
+	}
+	if url := os.Getenv("BINANCE_WS_URL"); url != "" {
+		client.WSURL = url
+	}
+
+	exchange, err := binance.NewExchange(ctx, client)
+	if err != nil {
+		log.Fatal("Could not connect to Binance:", err)
+	}
+	if f, ok := exchange.Filters(symbol); ok {
+		if sizer.Config.StepSize == 0 {
+			sizer.Config.StepSize = f.StepSize
+		}
+		if sizer.Config.TickSize == 0 {
+			sizer.Config.TickSize = f.TickSize
+		}
+		sizer.Config.MinNotional = math.Max(sizer.Config.MinNotional, f.MinNotional)
+	}
+	conn, err := sql.Open("mysql", getDSN())
+	if err != nil {
+		log.Fatal("DB connection error:", err)
+	}
+
+	rawFills := make(chan models.Fill, 256)
+	exchange.Fills = rawFills
+	oms, wait := startOMS(conn, "live", exchange, rawFills, risk)
+
+	// The exchange closes rawFills once the stream has stopped
+	ctx, cancel := context.WithCancel(ctx)
+	if err := exchange.Start(ctx); err != nil {
+		log.Fatal("Could not start the user data stream:", err)
+	}
+	return oms, func() {
+		cancel()
+		wait()
+		conn.Close()
+	}
+}
@@ -2,14 +2,47 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
+	"os"
+	"strings"
+	"time"
 
+	histdata "github.com/Reece-Ogidih/CT-Bot/HistoricalData"
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
 	bot "github.com/Reece-Ogidih/CT-Bot/bot"
+	"github.com/Reece-Ogidih/CT-Bot/broker"
+	"github.com/Reece-Ogidih/CT-Bot/broker/solana"
 )
 
-// For now I will be using this to test that the live data is being correctly obtained
+// The live bot, runs the same trendline breakout + ADX logic as cmd/PrepTrain on the live candles and prints the trade signals
+// Setting INTRABAR=1 also checks the candles before they close, so a breakout can be acted on straight away
+// The strategy settings are read from .env the same as PrepTrain and the backtests (see bot/setup.go for the list)
+// With -mode paper the signals are also traded on a virtual account (see paper.go), with -mode live they are traded on Binance (see live.go)
+// and with -mode onchain they are swapped on Solana (see onchain.go)
+// The orders are checked against the risk limits set by RISK (see broker/risk.go), creating the file named by KILL_FILE (KILL by default) halts trading
+// EXITS sets the protective stops and targets (see bot/exits.go), checked on every candle including the ones that have not closed
+// The entries are sized from the signal confidence as set by SIZING (see bot/sizing.go), -fraction is the fraction of the equity at full size
+
 func main() {
+	paperCfg := broker.DefaultPaperConfig()
+	mode := flag.String("mode", "signals", "signals to only print the signals, paper to also trade them on a virtual account, live to trade them on Binance, onchain to swap them on Solana")
+	fraction := flag.Float64("fraction", 1, "fraction of the equity put into each entry at full size")
+	flag.Float64Var(&paperCfg.InitialBalance, "balance", paperCfg.InitialBalance, "starting balance of the paper account")
+	flag.Float64Var(&paperCfg.TakerFee, "fee", paperCfg.TakerFee, "paper fee as a fraction of the notional")
+	flag.Parse()
+	if *mode != "signals" && *mode != "paper" && *mode != "live" && *mode != "onchain" {
+		log.Fatalf("Unknown mode %q", *mode)
+	}
+
+	settings, err := bot.StrategySettingsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	size := settings.WindowSize
+	intrabar := os.Getenv("INTRABAR") == "1"
+
 	// Create the context object
 	ctx := context.Background()
 	candleStream, err := bot.FetchSOLUSDT()
@@ -22,20 +55,209 @@ func main() {
 			log.Fatal(err)
 		}
 	}
-	// Now can add in calculation of ADX
-	adxCalc := bot.ADXCalculator{Period: 14, Count: 0}
+	// Now can add in calculation of ADX, with the same period PrepTrain labelled the data with
+	adxCalc := bot.ADXCalculator{Period: settings.ADXPeriod, Count: 0}
+
+	// Also add the volume indicators (session VWAP with 1 and 2 std dev bands, OBV, MFI and CMF)
+	volCalc := bot.NewVolumeCalculator(14, []float64{1, 2})
+
+	// The regime detector needs a few hundred candles before the ATR percentile means anything, so the entry gates are primed with recent history
+	history, err := histdata.RecentCandles("SOLUSDT", "1m", 1000)
+	if err != nil {
+		log.Println("Could not prime the entry gates:", err)
+	}
+
+	// Candlestick patterns are checked on each final candle
+	patternDetector := bot.NewPatternDetector(bot.DefaultPatternConfig())
+
+	// Also keep the ADX on the 15m timeframe, built from the same 1m candles
+	mtf, err := bot.NewMultiTimeframe("1m")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := mtf.AddIndicator("15m", "ADX14", bot.NewADXIndicator(14)); err != nil {
+		log.Fatal(err)
+	}
+
+	// Horizontal support/resistance zones over the last 500 candles
+	levels, err := bot.NewLevelTracker[models.CandleStick]("SOLUSDT", "1m", 500, bot.DefaultLevelConfig())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, c := range history {
+		mtf.Update(c)
+	}
+	levels.Fill(history)
+
+	// Seed the ADX from the same history so it is ready on the first candle, otherwise it fetches its own candles on the first update
+	if err := adxCalc.Seed(history); err != nil {
+		log.Println("Could not seed ADX:", err)
+	}
+
+	// The sliding window of the most recent candles that the trendlines are drawn over, using the bar index as the x-axis (same as PrepTrain)
+	// If the fetch fails then fall back to the end of the history that was already pulled
+	window, err := bot.NewSlidingWindow[models.CandleStick]("SOLUSDT", "1m", size, bot.AxisIndex)
+	if err != nil {
+		log.Fatalf("Error creating the window: %v", err)
+	}
+	if err := window.Init(histdata.RecentCandles); err != nil {
+		if len(history) < size {
+			log.Fatal(err)
+		}
+		log.Println(err, "- using the recent history instead")
+		window.Fill(history[len(history)-size:])
+	}
+
+	// The same strategy as PrepTrain and the backtests, including BREAKOUT_CONFIRM, RETEST_BARS, REGIME_GATE, HTF_INTERVAL and EXITS
+	// The ADX is only calculated on closes, so the intrabar checks use the values from the last final candle
+	setup, err := bot.NewTrendlineSetup(window, settings, bot.LiveADX(&adxCalc), history)
+	if err != nil {
+		log.Fatal(err)
+	}
+	strategy := setup.Strategy
+	strategy.UseIntrabar = intrabar
+	fmt.Printf("Trendlines: res grad %.5f int %.5f, sup grad %.5f int %.5f\n",
+		window.ResLine.Gradient, window.ResLine.Intercept, window.SupLine.Gradient, window.SupLine.Intercept)
+
+	// How much goes into each entry, from the confidence and the realised vol
+	sizingCfg, err := bot.ParseSizingConfig(os.Getenv("SIZING"))
+	if err != nil {
+		log.Fatalf("Error parsing SIZING: %v", err)
+	}
+	sizingCfg.Fraction = *fraction
+	sizer := bot.NewSizer(sizingCfg, time.Minute)
+
+	// The venue is filled in once the mode has set it up
+	riskLimits, err := broker.ParseRiskLimits(os.Getenv("RISK"))
+	if err != nil {
+		log.Fatalf("Error parsing RISK: %v", err)
+	}
+	risk := broker.NewRisk(riskLimits, nil)
+	risk.KillFile = os.Getenv("KILL_FILE")
+	if risk.KillFile == "" {
+		risk.KillFile = "KILL"
+	}
+
+	var paper *broker.Paper
+	var swapper *solana.Swapper
+	var oms *broker.OMS
+	// The orders are made straight away, except on-chain where they go through the swap queue
+	submit := func(job func(ctx context.Context)) { job(ctx) }
+	switch *mode {
+	case "paper":
+		var stop func()
+		paper, oms, stop = startPaper(ctx, window.Symbol, paperCfg, risk)
+		defer stop()
+	case "live":
+		var stop func()
+		oms, stop = startLive(ctx, window.Symbol, sizer, risk)
+		defer stop()
+	case "onchain":
+		var stop func()
+		var queue *swapQueue
+		swapper, oms, queue, stop = startOnchain(ctx, risk)
+		submit = queue.add
+		defer stop()
+	}
+
+	// The history and the window are closed candles only, but the stream may still send one of them (e.g. it had closed before the fetch)
+	// Anything at or before the last candle already in the window is skipped so no candle is counted twice
+	var seen bot.StreamFilter
+	seen.Mark(window.Bar(window.Len() - 1).OpenTime)
+	if len(history) > 0 {
+		seen.Mark(history[len(history)-1].OpenTime)
+	}
 
 	// Now loop so that for each new entry on channel it will print to terminal.
 	for candle := range candleStream {
+		if seen.Skip(candle) {
+			continue
+		}
 		//fmt.Printf("%+v\n", candle) // This is just a checker for if the live candle stream works
+
+		// The paper broker gets the price first so any waiting orders fill before the new signals
+		if paper != nil {
+			paper.Update(window.Symbol, candle)
+		}
+		if swapper != nil {
+			swapper.Update(window.Symbol, candle)
+		}
+		risk.Update(window.Symbol, candle)
+
+		// Run the breakout logic, on the closed candles this also keeps the window and the recalibration counter up to date
+		// Until the ADX is ready no entries are allowed
+		for _, sig := range setup.OnCandle(ctx, candle) {
+			printSignal(sig)
+			if swapper != nil && sig.Kind == bot.SignalEntry && sig.Side == -1 {
+				continue // No shorting on-chain, the exit of the long comes as its own signal
+			}
+			if oms != nil {
+				submit(func(ctx context.Context) { trade(ctx, oms, sig, sizer) })
+			}
+		}
 		if !candle.IsFinal { // Only want to be calculating once per candle
 			continue
 		}
+		sizer.Update(candle)
+		if oms != nil {
+			if risk.Check(ctx) {
+				rule, detail := risk.Halted()
+				reason := fmt.Sprintf("risk halt %s: %s", rule, detail)
+				submit(func(ctx context.Context) { oms.Flatten(ctx, reason) })
+			}
+			oms.Expire(ctx)
+			printAccount(ctx, oms)
+		}
+		if strategy.Last.Recalibrated {
+			fmt.Printf("Trendlines redrawn on candle %d\n", candle.OpenTime)
+		}
+
+		// The ADX was updated by the strategy
+		if adxCalc.Count > 0 {
+			fmt.Printf("ADX:%.2f, %+v\n", adxCalc.PrevADX, candle)
+		}
+
+		// The regime was updated by the entry gates
+		if state := setup.Gates.RegimeState; setup.Gates.RegimeReady {
+			fmt.Printf("Regime:%s (ADX:%.2f, +DI:%.2f, -DI:%.2f, ATR pct:%.1f, slope:%.6f)\n",
+				state.Regime, state.ADX, state.PlusDI, state.MinusDI, state.ATRPercentile, state.Slope)
+		}
+
+		for _, event := range patternDetector.Update(candle) {
+			fmt.Printf("Pattern:%s (direction %d) on candle %d\n", event.Pattern, event.Direction, event.OpenTime)
+		}
+
+		for _, event := range levels.Update(candle) {
+			fmt.Printf("Level %s: zone %d (%.4f-%.4f, %d touches, strength %.2f) direction %d\n",
+				event.Kind, event.Zone.ID, event.Zone.Low, event.Zone.High, event.Zone.Touches, event.Zone.Strength, event.Direction)
+		}
+
+		mtf.Update(candle)
+		if htfADX, ok := mtf.Lookup("15m", "ADX14"); ok {
+			fmt.Printf("15m ADX:%.2f\n", htfADX)
+		}
 
-		// Now can calculate ADX
-		adx, ok := adxCalc.Update(candle)
-		if ok {
-			fmt.Printf("ADX:%.2f, %+v\n", adx, candle)
+		if vol, ok := volCalc.Update(candle); ok {
+			fmt.Printf("VWAP:%.4f (+/-1sd %.4f/%.4f), OBV:%.2f, MFI:%.2f, CMF:%.4f\n",
+				vol.VWAP.VWAP, vol.VWAP.Upper[0], vol.VWAP.Lower[0], vol.OBV, vol.MFI, vol.CMF)
 		}
 	}
 }
+
+// Prints the entries/exits
+func printSignal(sig models.Signal) {
+	when := "close"
+	if sig.Intrabar {
+		when = "intrabar"
+	}
+	side := "LONG"
+	if sig.Side == -1 {
+		side = "SHORT"
+	}
+	reason := sig.Reason
+	if sig.Fraction > 0 {
+		reason = fmt.Sprintf("%s %.0f%%", reason, sig.Fraction*100)
+	}
+	fmt.Printf("%s %s (%s, %s) at %.4f on candle %d\n", strings.ToUpper(sig.Kind), side, reason, when, sig.Price, sig.OpenTime)
+}
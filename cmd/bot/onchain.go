@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+	"github.com/Reece-Ogidih/CT-Bot/broker"
+	"github.com/Reece-Ogidih/CT-Bot/broker/solana"
+)
+
+// On-chain trading mode (-mode onchain), the strategy signals are swapped between USDC and SOL through the aggregator, through the OMS
+// SOLANA_KEYPAIR is the wallet to trade from (a Solana CLI keypair file or a base58 private key), it only ever signs locally
+// JUPITER_URL and SOLANA_RPC_URL point it at other endpoints (e.g. a private RPC, or the mock from cmd/MockExchange -market solana)
+// Spot can not go short, so the short entries are skipped (the exit of the long still goes through)
+// A swap can take a minute or more to confirm, so the orders are made off the candle loop by swapQueue, one at a time so an exit is only
+// sized once the entry before it has landed, each with swapTimeout to confirm. The result reaches the OMS (and the fills log) as usual
+// Only the SOL the bot buys is its position, SOLANA_START_SOL is the SOL already in the wallet it should count as its own (e.g. after a restart)
+
+const (
+	swapTimeout = 5 * time.Minute
+	swapBacklog = 16
+)
+
+// Runs the orders one at a time in the order they were added
+type swapQueue struct {
+	jobs chan func(ctx context.Context)
+	done chan struct{}
+}
+
+func newSwapQueue(ctx context.Context) *swapQueue {
+	q := &swapQueue{jobs: make(chan func(ctx context.Context), swapBacklog), done: make(chan struct{})}
+	go func() {
+		defer close(q.done)
+		for job := range q.jobs {
+			jobCtx, cancel := context.WithTimeout(ctx, swapTimeout)
+			job(jobCtx)
+			cancel()
+		}
+	}()
+	return q
+}
+
+// Never blocks the candle loop, if that many swaps are already waiting the order is dropped
+func (q *swapQueue) add(job func(ctx context.Context)) {
+	select {
+	case q.jobs <- job:
+	default:
+		log.Printf("%d swaps already waiting, dropping the order", swapBacklog)
+	}
+}
+
+// Waits for the swaps already added
+func (q *swapQueue) close() {
+	close(q.jobs)
+	<-q.done
+}
+
+// Sets up the swapper and the OMS in front of it, the orders are to be added to the returned queue, the returned function waits for them
+// and stops the loggers
+func startOnchain(ctx context.Context, risk *broker.Risk) (*solana.Swapper, *broker.OMS, *swapQueue, func()) {
+	key, err := solana.LoadKeypair(os.Getenv("SOLANA_KEYPAIR"))
+	if err != nil {
+		log.Fatal("Could not load SOLANA_KEYPAIR:", err)
+	}
+	agg := solana.NewJupiter()
+	if url := os.Getenv("JUPITER_URL"); url != "" {
+		agg.BaseURL = url
+	}
+	cfg := solana.DefaultSwapConfig()
+	if start := os.Getenv("SOLANA_START_SOL"); start != "" {
+		if cfg.StartSOL, err = strconv.ParseFloat(start, 64); err != nil {
+			log.Fatal("Could not parse SOLANA_START_SOL:", err)
+		}
+	}
+	swapper := solana.NewSwapper(cfg, agg, solana.NewRPCClient(os.Getenv("SOLANA_RPC_URL")), key)
+	log.Println("Trading from wallet", key.Address())
+
+	conn, err := sql.Open("mysql", getDSN())
+	if err != nil {
+		log.Fatal("DB connection error:", err)
+	}
+	rawFills := make(chan models.Fill, 256)
+	swapper.Fills = rawFills
+	oms, wait := startOMS(conn, "onchain", swapper, rawFills, risk)
+	queue := newSwapQueue(ctx)
+	return swapper, oms, queue, func() {
+		queue.close()
+		close(rawFills)
+		wait()
+		conn.Close()
+	}
+}
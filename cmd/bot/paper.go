@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+	bot "github.com/Reece-Ogidih/CT-Bot/bot"
+	"github.com/Reece-Ogidih/CT-Bot/broker"
+	_ "github.com/go-sql-driver/mysql" // Need to log the paper fills to bot_trades
+)
+
+// Paper trading mode (-mode paper), the strategy signals are routed to a simulated broker with a virtual balance
+// Every fill is written to bot_trades (with "paper" at the start of the notes) so the results can be checked before risking any funds
+// The orders go through the OMS, which keeps the state of each order and writes every change to order_events
+// and then through the risk manager, which blocks anything over the limits and writes why to risk_events
+
+// Format the string used to connect to the database here
+func getDSN() string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s",
+		os.Getenv("DB_USER"),
+		os.Getenv("DB_PASS"),
+		os.Getenv("DB_HOST"),
+		os.Getenv("DB_PORT"),
+		os.Getenv("DB_NAME"),
+	)
+}
+
+// Puts the OMS and the risk manager in front of the venue, the fills go from the venue (on rawFills) to the OMS, the risk manager, then on to bot_trades
+// The OMS audit trail goes to order_events and the risk events to risk_events
+// The start time goes on the client order IDs so they are unique across runs
+// The returned function waits for the loggers to finish, it should be called once rawFills has been closed
+func startOMS(conn *sql.DB, mode string, venue broker.Broker, rawFills <-chan models.Fill, risk *broker.Risk) (*broker.OMS, func()) {
+	tracked := make(chan models.Fill, 256)
+	fills := make(chan models.Fill, 256)
+	audit := make(chan broker.AuditEntry, 256)
+	events := make(chan broker.RiskEvent, 256)
+	fillsDone, auditDone, eventsDone := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go func() {
+		broker.LogFills(conn, mode, fills)
+		close(fillsDone)
+	}()
+	go func() {
+		broker.LogAudit(conn, audit)
+		close(auditDone)
+	}()
+	go func() {
+		broker.LogRisk(conn, events)
+		close(eventsDone)
+	}()
+
+	risk.Venue = venue
+	risk.Events = events
+	oms := broker.NewOMS(risk, fmt.Sprintf("%s-%d", mode, time.Now().UnixMilli()))
+	oms.Audit = audit
+	go oms.Track(context.Background(), rawFills, tracked)
+	go risk.Track(tracked, fills)
+
+	return oms, func() {
+		<-fillsDone // The trackers close their outputs once they have passed on the rest
+		close(audit)
+		close(events)
+		<-auditDone
+		<-eventsDone
+	}
+}
+
+// Sets up the paper broker and the OMS in front of it, with the loggers and the DEX liquidity poller, the returned function stops them
+func startPaper(ctx context.Context, symbol string, cfg broker.PaperConfig, risk *broker.Risk) (*broker.Paper, *broker.OMS, func()) {
+	conn, err := sql.Open("mysql", getDSN())
+	if err != nil {
+		log.Fatal("DB connection error:", err)
+	}
+
+	rawFills := make(chan models.Fill, 256)
+	paper := broker.NewPaper(cfg)
+	paper.Fills = rawFills
+	oms, wait := startOMS(conn, "paper", paper, rawFills, risk)
+	paper.Cancelled = func(o models.Order, detail string) { oms.OnVenueCancel(o.ClientID, detail) }
+
+	// The pool liquidity changes slowly, so once every 30s is plenty (DEX Screener is also used for the candles)
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			if snap, err := bot.DexSnapshot(bot.SOLToken); err != nil {
+				log.Println("Could not get the DEX liquidity:", err)
+			} else if snap.LiquidityUSD > 0 {
+				paper.SetLiquidity(symbol, snap.LiquidityUSD)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return paper, oms, func() {
+		cancel()
+		close(rawFills)
+		wait()
+		conn.Close()
+	}
+}
+
+// Routes a strategy signal to the broker, entries are sized by the sizer
+func trade(ctx context.Context, b broker.Broker, sig models.Signal, sizer *bot.Sizer) {
+	acct, err := b.Account(ctx)
+	if err != nil {
+		log.Println("Could not get the account:", err)
+		return
+	}
+	order, ok := broker.OrderFromSignal(sig, acct, sizer)
+	if !ok {
+		return
+	}
+	if _, err := b.Submit(ctx, order); err != nil {
+		log.Println("Order rejected:", err)
+	}
+}
+
+func printAccount(ctx context.Context, b broker.Broker) {
+	acct, err := b.Account(ctx)
+	if err != nil {
+		return
+	}
+	fmt.Printf("Account: cash %.2f, equity %.2f", acct.Cash, acct.Equity)
+	for _, pos := range acct.Positions {
+		fmt.Printf(", %s %.4f @ %.4f", pos.Symbol, pos.Qty, pos.EntryPrice)
+	}
+	fmt.Println()
+}
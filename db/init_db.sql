@@ -37,10 +37,33 @@ CREATE TABLE IF NOT EXISTS bot_trades (
     price DOUBLE NOT NULL,                  -- The price at which the action was held
     quantity DOUBLE,                        -- The quantity which allows to infer total investment
     confidence_score DOUBLE,                -- The output from the ML algorithm
+    client_order_id VARCHAR(64),            -- The order (in order_events) this fill was for
     notes TEXT,                             -- For now I named this notes but will likely record ADX val and anything else important to making this decision
     PRIMARY KEY (id)
 );
 
+-- Lifecycle of every trendline the bot draws, so the quality of the lines can be analysed and trades can be replayed
+CREATE TABLE IF NOT EXISTS trendline_events (
+    id INT NOT NULL AUTO_INCREMENT,
+    run_id BIGINT NOT NULL,                 -- Start time (ms) of the run, the line IDs restart from 1 in each run
+    line_id BIGINT NOT NULL,
+    symbol VARCHAR(20),
+    interval_str VARCHAR(10),
+    kind ENUM('created', 'touched', 'broken', 'expired', 'replaced') NOT NULL,
+    is_resist BOOLEAN,
+    open_times_ms BIGINT,                   -- OpenTime of the candle the event happened on
+    price DOUBLE,                           -- Price of the line at that candle
+    touches INT,
+    gradient DOUBLE,
+    intercept DOUBLE,
+    a1_time BIGINT,
+    a2_time BIGINT,
+    a1_price DOUBLE,
+    a2_price DOUBLE,
+    PRIMARY KEY (id),
+    INDEX (run_id, line_id)
+);
+
 DROP TABLE train_ml;
 
 -- To train ML model, will be good to add to the historical data both the ADX as well as variables to depict when the bot would enter and exit positions
@@ -54,12 +77,79 @@ CREATE TABLE IF NOT EXISTS train_ml (
     volume DOUBLE,
     adx DOUBLE,
     idx BIGINT,
+    regime TINYINT, -- Market regime: 0 unknown, 1 trending up, 2 trending down, 3 ranging, 4 high volatility
     sig_entry TINYINT, -- Will encode -1 for entry of short position, 1 for long and 0 for no action. Note these are catageroical not ordered.
     sig_exit TINYINT, -- Will encode -1 for exit short position, 1 for long and 0 for hold.
+    breakout TINYINT, -- 1 if a wick broke the resistance line, -1 for the support line, 2 if both lines broke on this candle and 0 for no breakout (whether or not it was confirmed)
+    res_breakout_filters TINYINT, -- Bitmask of the confirmation filters the resistance break passed (0 if it did not break): close=1, atr=2, pct=4, closes=8, volume=16, time=32
+    sup_breakout_filters TINYINT, -- Same for the support break, when both lines break each side is recorded in its own column
+    chart_pattern TINYINT, -- bot.ChartPattern the candle arrived in: 0 none, 1-3 channels, 4-6 triangles, 7-8 wedges, 9-10 flags, 11-12 pennants
+    chart_breakout TINYINT, -- 1 if the candle closed above the chart pattern, -1 if below and 0 if it stayed inside (or there was no pattern)
+    chart_target DOUBLE, -- Measured-move target of the chart pattern breakout, 0 if there was none
+    -- Candlestick patterns, 1 if the pattern completed on this candle (same order as the bot.Pattern constants)
+    pat_bullish_engulfing TINYINT,
+    pat_bearish_engulfing TINYINT,
+    pat_hammer TINYINT,
+    pat_shooting_star TINYINT,
+    pat_doji TINYINT,
+    pat_dragonfly_doji TINYINT,
+    pat_gravestone_doji TINYINT,
+    pat_long_legged_doji TINYINT,
+    pat_morning_star TINYINT,
+    pat_evening_star TINYINT,
+    pat_three_white_soldiers TINYINT,
+    pat_three_black_crows TINYINT,
+    pat_inside_bar TINYINT,
+    pat_outside_bar TINYINT,
     PRIMARY KEY (id)
 );
 
 TRUNCATE TABLE train_ml;
 
+-- Audit trail of the order management system, one row per state change of an order
+CREATE TABLE IF NOT EXISTS order_events (
+    id INT NOT NULL AUTO_INCREMENT,
+    timestamp_ms BIGINT NOT NULL,
+    client_order_id VARCHAR(64) NOT NULL,
+    order_id BIGINT,                        -- ID given by the OMS
+    venue_order_id BIGINT,                  -- ID given by the venue (paper broker or exchange)
+    event ENUM('submit', 'ack', 'reject', 'fill', 'cancel', 'expire') NOT NULL,
+    from_state VARCHAR(20),
+    to_state VARCHAR(20) NOT NULL,
+    symbol VARCHAR(20),
+    side TINYINT,                           -- 1 buy, -1 sell
+    order_type ENUM('market', 'limit', 'stop', 'stop-limit'),
+    qty DOUBLE,
+    price DOUBLE,
+    stop_price DOUBLE,
+    reduce_only BOOLEAN,
+    oco_group VARCHAR(64),
+    filled_qty DOUBLE,
+    avg_price DOUBLE,
+    fees DOUBLE,
+    reason TEXT,                            -- Why the strategy made the order
+    detail TEXT,                            -- Fill details, or why it was rejected/cancelled/expired
+    PRIMARY KEY (id),
+    INDEX (client_order_id)
+);
+
+-- Every order the risk manager blocked, and every halt, resume and cooldown, with the rule behind it
+CREATE TABLE IF NOT EXISTS risk_events (
+    id INT NOT NULL AUTO_INCREMENT,
+    timestamp_ms BIGINT NOT NULL,
+    rule VARCHAR(32) NOT NULL,              -- e.g. max_position, daily_loss, kill_switch
+    action ENUM('block', 'halt', 'resume', 'cooldown') NOT NULL,
+    client_order_id VARCHAR(64),            -- The blocked order (also rejected in order_events), NULL for the others
+    symbol VARCHAR(20),
+    side TINYINT,
+    order_type ENUM('market', 'limit', 'stop', 'stop-limit'),
+    qty DOUBLE,
+    price DOUBLE,
+    equity DOUBLE,                          -- Account equity at the time, 0 if it was not read
+    detail TEXT,
+    PRIMARY KEY (id),
+    INDEX (timestamp_ms)
+);
+
 
 SHOW TABLES;
\ No newline at end of file
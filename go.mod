@@ -2,10 +2,10 @@ module github.com/Reece-Ogidih/CT-Bot
 
 go 1.24.4
 
-require github.com/coder/websocket v1.8.13
-
 require (
-	filippo.io/edwards25519 v1.1.0 // indirect
-	github.com/go-sql-driver/mysql v1.9.3 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
+	github.com/coder/websocket v1.8.13
+	github.com/go-sql-driver/mysql v1.9.3
+	github.com/joho/godotenv v1.5.1
 )
+
+require filippo.io/edwards25519 v1.1.0 // indirect
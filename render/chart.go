@@ -0,0 +1,382 @@
+package render
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	models "github.com/Reece-Ogidih/CT-Bot/Models"
+	bot "github.com/Reece-Ogidih/CT-Bot/bot"
+)
+
+// This package draws charts of the candles with the volume, the ADX/+DI/-DI, the trendlines and the trade markers
+// It is meant for debugging the trendlines and the trades, so instead of reading the printed gradients and intercepts the window can just be looked at
+// The chart is laid out once and then drawn onto a canvas, there is one canvas for SVG and one for PNG so both always look the same
+// The HTML page is the SVG with the candle data embedded and a little bit of javascript for a crosshair/tooltip and zooming
+
+// A trendline to draw, the line is in the x-axis of the window it came from so the chart needs the x value of each candle (Chart.Xs)
+type Line struct {
+	Line     models.Trendline
+	IsResist bool
+	From     int64 // Only draw the line between these OpenTimes, 0 means from the start/to the end of the chart
+	To       int64
+}
+
+type MarkerKind int
+
+const (
+	EntryLong MarkerKind = iota
+	EntryShort
+	Exit
+)
+
+type Marker struct {
+	Kind     MarkerKind
+	OpenTime int64 // Candle the marker goes on
+	Price    float64
+	Label    string
+}
+
+type Chart struct {
+	Title   string
+	Candles []models.CandleStick
+	Xs      []float64 // x value of each candle on the trendlines' axis, if nil the candle's index is used
+	ADX     []float64 // Optional, same length as Candles (0 or NaN where there is no value)
+	PlusDI  []float64
+	MinusDI []float64
+	Lines   []Line
+	Markers []Marker
+	Width   int
+	Height  int
+}
+
+func NewChart(title string, candles []models.CandleStick) *Chart {
+	return &Chart{Title: title, Candles: candles, Width: 1200, Height: 800}
+}
+
+// Builds a chart from the enriched candles so the ADX panel is filled in
+func FromEnriched(title string, candles []models.EnrichedCandle) *Chart {
+	c := NewChart(title, make([]models.CandleStick, len(candles)))
+	c.ADX = make([]float64, len(candles))
+	c.PlusDI = make([]float64, len(candles))
+	c.MinusDI = make([]float64, len(candles))
+	for i, e := range candles {
+		c.Candles[i] = e.CandleStick()
+		c.ADX[i], c.PlusDI[i], c.MinusDI[i] = e.ADX, e.PlusDI, e.MinusDI
+	}
+	return c
+}
+
+// Gives a copy of the chart with only the candles between from and to (inclusive OpenTimes), the lines and markers are kept
+func (c *Chart) Range(from, to int64) *Chart {
+	start := sort.Search(len(c.Candles), func(i int) bool { return c.Candles[i].OpenTime >= from })
+	end := sort.Search(len(c.Candles), func(i int) bool { return c.Candles[i].OpenTime > to })
+	out := *c
+	out.Candles = c.Candles[start:end]
+	slice := func(vals []float64) []float64 {
+		if vals == nil {
+			return nil
+		}
+		return vals[start:end]
+	}
+	out.ADX, out.PlusDI, out.MinusDI = slice(c.ADX), slice(c.PlusDI), slice(c.MinusDI)
+	if c.Xs != nil {
+		out.Xs = c.Xs[start:end]
+	} else {
+		// Keep the original indices so the lines still line up
+		out.Xs = make([]float64, end-start)
+		for i := range out.Xs {
+			out.Xs[i] = float64(start + i)
+		}
+	}
+	return &out
+}
+
+func (c *Chart) x(i int) float64 {
+	if c.Xs != nil {
+		return c.Xs[i]
+	}
+	return float64(i)
+}
+
+func (c *Chart) hasADX() bool {
+	return len(c.ADX) == len(c.Candles) && len(c.ADX) > 0
+}
+
+// Colours used on the chart
+var (
+	colBackground = color.RGBA{255, 255, 255, 255}
+	colGrid       = color.RGBA{230, 230, 230, 255}
+	colText       = color.RGBA{60, 60, 60, 255}
+	colUp         = color.RGBA{38, 166, 154, 255}
+	colDown       = color.RGBA{239, 83, 80, 255}
+	colRes        = color.RGBA{214, 39, 40, 255}
+	colSup        = color.RGBA{31, 119, 180, 255}
+	colADX        = color.RGBA{40, 40, 40, 255}
+	colExit       = color.RGBA{255, 152, 0, 255}
+)
+
+type point struct{ x, y float64 }
+
+// Everything the chart is drawn with, implemented by the SVG and PNG canvases
+type canvas interface {
+	line(x1, y1, x2, y2 float64, col color.RGBA, width float64)
+	rect(x, y, w, h float64, col color.RGBA)
+	polygon(pts []point, col color.RGBA)
+	text(x, y float64, s string, col color.RGBA, alignRight bool)
+}
+
+// A panel is an area of the chart with its own y scale
+type panel struct {
+	top, height float64
+	min, max    float64
+}
+
+func (p panel) y(v float64) float64 {
+	if p.max == p.min {
+		return p.top + p.height/2
+	}
+	return p.top + (p.max-v)/(p.max-p.min)*p.height
+}
+
+// Pixel positions of everything on the chart, shared between the canvases and the HTML page
+type layout struct {
+	left, right     float64
+	slot            float64 // Width each candle gets
+	price, vol, adx panel
+	hasADX          bool
+	titleH, bottomH float64
+	width, height   float64
+	candles         int
+}
+
+const (
+	marginLeft  = 10
+	marginRight = 70 // Room for the price labels
+	titleHeight = 24
+	axisHeight  = 20
+)
+
+func (c *Chart) layout() layout {
+	l := layout{
+		width:   float64(c.Width),
+		height:  float64(c.Height),
+		left:    marginLeft,
+		right:   float64(c.Width) - marginRight,
+		titleH:  titleHeight,
+		bottomH: axisHeight,
+		hasADX:  c.hasADX(),
+		candles: len(c.Candles),
+	}
+	if len(c.Candles) > 0 {
+		l.slot = (l.right - l.left) / float64(len(c.Candles))
+	}
+
+	// Split the height between the panels, the price gets most of it
+	avail := l.height - l.titleH - l.bottomH
+	priceH, volH, adxH := avail*0.7, avail*0.3, 0.0
+	if l.hasADX {
+		priceH, volH, adxH = avail*0.6, avail*0.15, avail*0.25
+	}
+	l.price = panel{top: l.titleH, height: priceH - 5}
+	l.vol = panel{top: l.titleH + priceH, height: volH - 5}
+	l.adx = panel{top: l.titleH + priceH + volH, height: adxH - 5}
+
+	l.price.min, l.price.max = math.Inf(1), math.Inf(-1)
+	for _, cs := range c.Candles {
+		l.price.min = math.Min(l.price.min, cs.Low)
+		l.price.max = math.Max(l.price.max, cs.High)
+		l.vol.max = math.Max(l.vol.max, cs.Volume)
+	}
+	pad := (l.price.max - l.price.min) * 0.05
+	l.price.min -= pad
+	l.price.max += pad
+
+	l.adx.max = 50
+	if l.hasADX {
+		for i := range c.ADX {
+			for _, v := range []float64{c.ADX[i], c.PlusDI[i], c.MinusDI[i]} {
+				if !math.IsNaN(v) {
+					l.adx.max = math.Max(l.adx.max, v)
+				}
+			}
+		}
+	}
+	return l
+}
+
+func (l layout) cx(i int) float64 {
+	return l.left + (float64(i)+0.5)*l.slot
+}
+
+// Index of the candle with the given OpenTime (or the last candle before it), -1 if it is before the chart
+func (c *Chart) indexOf(openTime int64) int {
+	return sort.Search(len(c.Candles), func(i int) bool { return c.Candles[i].OpenTime > openTime }) - 1
+}
+
+func (c *Chart) draw(cv canvas) {
+	l := c.layout()
+	cv.rect(0, 0, l.width, l.height, colBackground)
+	cv.text(l.left, 16, c.Title, colText, false)
+	if len(c.Candles) == 0 {
+		return
+	}
+
+	// Grid and price labels
+	for k := 0; k <= 4; k++ {
+		v := l.price.min + (l.price.max-l.price.min)*float64(k)/4
+		y := l.price.y(v)
+		cv.line(l.left, y, l.right, y, colGrid, 1)
+		cv.text(l.width-5, y+4, formatPrice(v), colText, true)
+	}
+
+	// Time labels along the bottom
+	span := bot.OpenTimeMs(c.Candles[len(c.Candles)-1].OpenTime) - bot.OpenTimeMs(c.Candles[0].OpenTime)
+	layoutStr := "15:04"
+	if span > int64(24*time.Hour/time.Millisecond) {
+		layoutStr = "01/02 15:04"
+	}
+	for k := 0; k <= 4; k++ {
+		i := (len(c.Candles) - 1) * k / 4
+		x := l.cx(i)
+		cv.line(x, l.price.top, x, l.height-l.bottomH, colGrid, 1)
+		label := time.UnixMilli(bot.OpenTimeMs(c.Candles[i].OpenTime)).UTC().Format(layoutStr)
+		cv.text(x+2, l.height-5, label, colText, false)
+	}
+
+	// Candles and volume
+	bodyW := math.Max(l.slot*0.7, 1)
+	for i, cs := range c.Candles {
+		col := colUp
+		if cs.Close < cs.Open {
+			col = colDown
+		}
+		x := l.cx(i)
+		cv.line(x, l.price.y(cs.High), x, l.price.y(cs.Low), col, 1)
+		top, bottom := l.price.y(math.Max(cs.Open, cs.Close)), l.price.y(math.Min(cs.Open, cs.Close))
+		cv.rect(x-bodyW/2, top, bodyW, math.Max(bottom-top, 1), col)
+		if l.vol.max > 0 {
+			vy := l.vol.y(cs.Volume)
+			cv.rect(x-bodyW/2, vy, bodyW, l.vol.top+l.vol.height-vy, col)
+		}
+	}
+	cv.text(l.left+2, l.vol.top+12, "VOL", colText, false)
+
+	// ADX panel
+	if l.hasADX {
+		cv.line(l.left, l.adx.y(25), l.right, l.adx.y(25), colGrid, 1)
+		series := []struct {
+			vals []float64
+			col  color.RGBA
+		}{{c.ADX, colADX}, {c.PlusDI, colUp}, {c.MinusDI, colDown}}
+		for _, s := range series {
+			for i := 1; i < len(s.vals); i++ {
+				a, b := s.vals[i-1], s.vals[i]
+				if math.IsNaN(a) || math.IsNaN(b) || a == 0 || b == 0 {
+					continue
+				}
+				cv.line(l.cx(i-1), l.adx.y(a), l.cx(i), l.adx.y(b), s.col, 1.5)
+			}
+		}
+		cv.text(l.left+2, l.adx.top+12, "ADX +DI -DI", colText, false)
+	}
+
+	// Trendlines, clipped to the price panel
+	for _, ln := range c.Lines {
+		first, last := 0, len(c.Candles)-1
+		if ln.From != 0 {
+			first = max(c.indexOf(ln.From), 0)
+		}
+		if ln.To != 0 {
+			last = c.indexOf(ln.To)
+		}
+		if last <= first {
+			continue
+		}
+		x1, x2 := l.cx(first), l.cx(last)
+		y1 := l.price.y(ln.Line.Gradient*c.x(first) + ln.Line.Intercept)
+		y2 := l.price.y(ln.Line.Gradient*c.x(last) + ln.Line.Intercept)
+		if cx1, cy1, cx2, cy2, ok := clip(x1, y1, x2, y2, l.left, l.price.top, l.right, l.price.top+l.price.height); ok {
+			col := colSup
+			if ln.IsResist {
+				col = colRes
+			}
+			cv.line(cx1, cy1, cx2, cy2, col, 2)
+		}
+	}
+
+	// Trade markers, triangles under/over the candle for entries and a diamond at the price for exits
+	for _, m := range c.Markers {
+		i := c.indexOf(m.OpenTime)
+		if i < 0 {
+			continue
+		}
+		x := l.cx(i)
+		s := math.Max(math.Min(l.slot, 10), 5)
+		switch m.Kind {
+		case EntryLong:
+			y := l.price.y(c.Candles[i].Low) + 4
+			cv.polygon([]point{{x, y}, {x - s, y + s*1.5}, {x + s, y + s*1.5}}, colUp)
+		case EntryShort:
+			y := l.price.y(c.Candles[i].High) - 4
+			cv.polygon([]point{{x, y}, {x - s, y - s*1.5}, {x + s, y - s*1.5}}, colDown)
+		case Exit:
+			y := l.price.y(m.Price)
+			cv.polygon([]point{{x, y - s}, {x + s, y}, {x, y + s}, {x - s, y}}, colExit)
+		}
+		if m.Label != "" {
+			cv.text(x+s+2, l.price.y(m.Price)+4, m.Label, colText, false)
+		}
+	}
+}
+
+// Liang-Barsky clipping of a line segment to a rectangle
+func clip(x1, y1, x2, y2, xmin, ymin, xmax, ymax float64) (float64, float64, float64, float64, bool) {
+	t0, t1 := 0.0, 1.0
+	dx, dy := x2-x1, y2-y1
+	for _, edge := range [4][2]float64{{-dx, x1 - xmin}, {dx, xmax - x1}, {-dy, y1 - ymin}, {dy, ymax - y1}} {
+		p, q := edge[0], edge[1]
+		if p == 0 {
+			if q < 0 {
+				return 0, 0, 0, 0, false
+			}
+			continue
+		}
+		r := q / p
+		if p < 0 {
+			t0 = math.Max(t0, r)
+		} else {
+			t1 = math.Min(t1, r)
+		}
+		if t0 > t1 {
+			return 0, 0, 0, 0, false
+		}
+	}
+	return x1 + t0*dx, y1 + t0*dy, x1 + t1*dx, y1 + t1*dy, true
+}
+
+func formatPrice(v float64) string {
+	switch {
+	case math.Abs(v) >= 1000:
+		return fmt.Sprintf("%.1f", v)
+	case math.Abs(v) >= 1:
+		return fmt.Sprintf("%.3f", v)
+	}
+	return fmt.Sprintf("%.6f", v)
+}
+
+// Writes the chart in the given format ("svg", "png" or "html")
+func (c *Chart) Write(w io.Writer, format string) error {
+	switch format {
+	case "svg":
+		return c.SVG(w)
+	case "png":
+		return c.PNG(w)
+	case "html":
+		return c.HTML(w)
+	}
+	return fmt.Errorf("unknown chart format %q", format)
+}
@@ -0,0 +1,137 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"time"
+
+	bot "github.com/Reece-Ogidih/CT-Bot/bot"
+)
+
+// The HTML page is self-contained (no external scripts or styles), so it can just be opened from disk or attached to a trade
+// Hovering shows the candle under the cursor, the mouse wheel zooms in/out around the cursor, dragging pans and double click resets
+
+type htmlCandle struct {
+	Time    string   `json:"t"`
+	Open    float64  `json:"o"`
+	High    float64  `json:"h"`
+	Low     float64  `json:"l"`
+	Close   float64  `json:"c"`
+	Volume  float64  `json:"v"`
+	ADX     *float64 `json:"adx,omitempty"`
+	PlusDI  *float64 `json:"pdi,omitempty"`
+	MinusDI *float64 `json:"mdi,omitempty"`
+}
+
+type htmlData struct {
+	Left    float64      `json:"left"`
+	Slot    float64      `json:"slot"`
+	Width   int          `json:"width"`
+	Height  int          `json:"height"`
+	Candles []htmlCandle `json:"candles"`
+}
+
+func (c *Chart) HTML(w io.Writer) error {
+	l := c.layout()
+	data := htmlData{Left: l.left, Slot: l.slot, Width: c.Width, Height: c.Height}
+	value := func(vals []float64, i int) *float64 {
+		if len(vals) != len(c.Candles) || math.IsNaN(vals[i]) {
+			return nil
+		}
+		v := vals[i]
+		return &v
+	}
+	for i, cs := range c.Candles {
+		data.Candles = append(data.Candles, htmlCandle{
+			Time:    time.UnixMilli(bot.OpenTimeMs(cs.OpenTime)).UTC().Format("2006-01-02 15:04:05"),
+			Open:    cs.Open,
+			High:    cs.High,
+			Low:     cs.Low,
+			Close:   cs.Close,
+			Volume:  cs.Volume,
+			ADX:     value(c.ADX, i),
+			PlusDI:  value(c.PlusDI, i),
+			MinusDI: value(c.MinusDI, i),
+		})
+	}
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, htmlPage, html.EscapeString(c.Title), c.Width, c.Height, c.Width, c.Height, c.svgBody(), l.price.top, l.height-l.bottomH, js)
+	return err
+}
+
+const htmlPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { margin: 0; font-family: monospace; background: #fafafa; }
+#chart { display: block; cursor: crosshair; }
+#tip { position: fixed; pointer-events: none; background: rgba(255,255,255,0.95); border: 1px solid #999; padding: 4px 6px; font-size: 12px; display: none; white-space: pre; }
+</style>
+</head>
+<body>
+<svg id="chart" xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" preserveAspectRatio="none">
+%s<line id="cross" x1="0" y1="%.2f" x2="0" y2="%.2f" stroke="#888" stroke-dasharray="3,3" visibility="hidden"/>
+</svg>
+<div id="tip"></div>
+<script>
+const data = %s;
+const svg = document.getElementById("chart");
+const tip = document.getElementById("tip");
+const cross = document.getElementById("cross");
+let view = { x: 0, w: data.width };
+let drag = null;
+
+function toSVG(e) {
+  const pt = svg.createSVGPoint();
+  pt.x = e.clientX; pt.y = e.clientY;
+  return pt.matrixTransform(svg.getScreenCTM().inverse());
+}
+function setView() {
+  view.w = Math.min(Math.max(view.w, 20), data.width);
+  view.x = Math.min(Math.max(view.x, 0), data.width - view.w);
+  svg.setAttribute("viewBox", view.x + " 0 " + view.w + " " + data.height);
+}
+function fmt(v) { return v === undefined ? "-" : v.toFixed(Math.abs(v) >= 1 ? 3 : 6); }
+
+svg.addEventListener("mousemove", e => {
+  if (drag !== null) {
+    view.x = drag.x - (e.clientX - drag.cx) * view.w / svg.getBoundingClientRect().width;
+    setView();
+    return;
+  }
+  const p = toSVG(e);
+  const i = Math.floor((p.x - data.left) / data.slot);
+  if (i < 0 || i >= data.candles.length) { tip.style.display = "none"; cross.setAttribute("visibility", "hidden"); return; }
+  const c = data.candles[i];
+  const x = data.left + (i + 0.5) * data.slot;
+  cross.setAttribute("x1", x); cross.setAttribute("x2", x); cross.setAttribute("visibility", "visible");
+  let s = c.t + "\nO " + fmt(c.o) + "  H " + fmt(c.h) + "\nL " + fmt(c.l) + "  C " + fmt(c.c) + "\nV " + c.v.toFixed(2);
+  if (c.adx !== undefined) s += "\nADX " + c.adx.toFixed(2) + "  +DI " + fmt(c.pdi) + "  -DI " + fmt(c.mdi);
+  tip.textContent = s;
+  tip.style.left = (e.clientX + 14) + "px"; tip.style.top = (e.clientY + 14) + "px"; tip.style.display = "block";
+});
+svg.addEventListener("mouseleave", () => { tip.style.display = "none"; cross.setAttribute("visibility", "hidden"); drag = null; });
+svg.addEventListener("wheel", e => {
+  e.preventDefault();
+  const p = toSVG(e);
+  const scale = e.deltaY < 0 ? 0.8 : 1.25;
+  view.x = p.x - (p.x - view.x) * scale;
+  view.w *= scale;
+  setView();
+});
+svg.addEventListener("mousedown", e => { drag = { x: view.x, cx: e.clientX }; });
+svg.addEventListener("mouseup", () => { drag = null; });
+svg.addEventListener("dblclick", () => { view = { x: 0, w: data.width }; setView(); });
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,127 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"strings"
+)
+
+// PNG canvas, a very small rasteriser so there is no need for any image libraries outside the standard library
+// There is no anti-aliasing, which is fine for checking where the lines and markers are
+type pngCanvas struct {
+	img *image.RGBA
+}
+
+func (p *pngCanvas) set(x, y int, col color.RGBA) {
+	if image.Pt(x, y).In(p.img.Rect) {
+		p.img.SetRGBA(x, y, col)
+	}
+}
+
+func (p *pngCanvas) line(x1, y1, x2, y2 float64, col color.RGBA, width float64) {
+	steps := math.Ceil(math.Max(math.Abs(x2-x1), math.Abs(y2-y1)))
+	half := int(math.Max(width, 1)) / 2
+	thick := int(math.Max(width, 1))
+	for s := 0.0; s <= steps; s++ {
+		t := 0.0
+		if steps > 0 {
+			t = s / steps
+		}
+		x := int(math.Round(x1 + t*(x2-x1)))
+		y := int(math.Round(y1 + t*(y2-y1)))
+		for dx := 0; dx < thick; dx++ {
+			for dy := 0; dy < thick; dy++ {
+				p.set(x-half+dx, y-half+dy, col)
+			}
+		}
+	}
+}
+
+func (p *pngCanvas) rect(x, y, w, h float64, col color.RGBA) {
+	for py := int(math.Round(y)); py < int(math.Round(y+h)); py++ {
+		for px := int(math.Round(x)); px < int(math.Round(x+w)); px++ {
+			p.set(px, py, col)
+		}
+	}
+}
+
+// Fills the polygon using the even-odd rule, checking the centre of each pixel in its bounding box
+func (p *pngCanvas) polygon(pts []point, col color.RGBA) {
+	minX, minY, maxX, maxY := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, pt := range pts {
+		minX, maxX = math.Min(minX, pt.x), math.Max(maxX, pt.x)
+		minY, maxY = math.Min(minY, pt.y), math.Max(maxY, pt.y)
+	}
+	for py := int(math.Floor(minY)); py <= int(math.Ceil(maxY)); py++ {
+		for px := int(math.Floor(minX)); px <= int(math.Ceil(maxX)); px++ {
+			x, y := float64(px)+0.5, float64(py)+0.5
+			inside := false
+			for i, j := 0, len(pts)-1; i < len(pts); j, i = i, i+1 {
+				a, b := pts[i], pts[j]
+				if (a.y > y) != (b.y > y) && x < (b.x-a.x)*(y-a.y)/(b.y-a.y)+a.x {
+					inside = !inside
+				}
+			}
+			if inside {
+				p.set(px, py, col)
+			}
+		}
+	}
+}
+
+// 3x5 pixel font, each row is 3 bits (the top bit is the left pixel), drawn at twice the size
+var glyphs = map[rune][5]uint8{
+	'0': {7, 5, 5, 5, 7}, '1': {2, 6, 2, 2, 7}, '2': {7, 1, 7, 4, 7}, '3': {7, 1, 7, 1, 7}, '4': {5, 5, 7, 1, 1},
+	'5': {7, 4, 7, 1, 7}, '6': {7, 4, 7, 5, 7}, '7': {7, 1, 1, 1, 1}, '8': {7, 5, 7, 5, 7}, '9': {7, 5, 7, 1, 7},
+	'.': {0, 0, 0, 0, 2}, '-': {0, 0, 7, 0, 0}, '+': {0, 2, 7, 2, 0}, ':': {0, 2, 0, 2, 0}, '/': {1, 1, 2, 4, 4},
+	'_': {0, 0, 0, 0, 7}, '(': {1, 2, 2, 2, 1}, ')': {4, 2, 2, 2, 4},
+	'A': {2, 5, 7, 5, 5}, 'B': {6, 5, 6, 5, 6}, 'C': {3, 4, 4, 4, 3}, 'D': {6, 5, 5, 5, 6}, 'E': {7, 4, 6, 4, 7},
+	'F': {7, 4, 6, 4, 4}, 'G': {3, 4, 5, 5, 3}, 'H': {5, 5, 7, 5, 5}, 'I': {7, 2, 2, 2, 7}, 'J': {1, 1, 1, 5, 2},
+	'K': {5, 5, 6, 5, 5}, 'L': {4, 4, 4, 4, 7}, 'M': {5, 7, 7, 5, 5}, 'N': {6, 5, 5, 5, 5}, 'O': {2, 5, 5, 5, 2},
+	'P': {6, 5, 6, 4, 4}, 'Q': {2, 5, 5, 6, 3}, 'R': {6, 5, 6, 5, 5}, 'S': {3, 4, 2, 1, 6}, 'T': {7, 2, 2, 2, 2},
+	'U': {5, 5, 5, 5, 7}, 'V': {5, 5, 5, 5, 2}, 'W': {5, 5, 7, 7, 5}, 'X': {5, 5, 2, 5, 5}, 'Y': {5, 5, 2, 2, 2},
+	'Z': {7, 1, 2, 4, 7},
+}
+
+const (
+	glyphScale   = 2
+	glyphAdvance = 4 * glyphScale
+)
+
+// y is the baseline (same as SVG), characters that are not in the font are left as a gap
+func (p *pngCanvas) text(x, y float64, s string, col color.RGBA, alignRight bool) {
+	s = strings.ToUpper(s)
+	width := float64(len([]rune(s)) * glyphAdvance)
+	if alignRight {
+		x -= width
+	}
+	top := int(y) - 5*glyphScale
+	for k, r := range []rune(s) {
+		g, ok := glyphs[r]
+		if !ok {
+			continue
+		}
+		left := int(x) + k*glyphAdvance
+		for row := 0; row < 5; row++ {
+			for bit := 0; bit < 3; bit++ {
+				if g[row]&(4>>bit) == 0 {
+					continue
+				}
+				for dx := 0; dx < glyphScale; dx++ {
+					for dy := 0; dy < glyphScale; dy++ {
+						p.set(left+bit*glyphScale+dx, top+row*glyphScale+dy, col)
+					}
+				}
+			}
+		}
+	}
+}
+
+func (c *Chart) PNG(w io.Writer) error {
+	cv := &pngCanvas{img: image.NewRGBA(image.Rect(0, 0, c.Width, c.Height))}
+	c.draw(cv)
+	return png.Encode(w, cv.img)
+}
@@ -0,0 +1,57 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"image/color"
+	"io"
+	"strings"
+)
+
+// SVG canvas, each drawing call just appends an element
+type svgCanvas struct {
+	buf bytes.Buffer
+}
+
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func (s *svgCanvas) line(x1, y1, x2, y2 float64, col color.RGBA, width float64) {
+	fmt.Fprintf(&s.buf, `<line x1="%.2f" y1="%.2f" x2="%.2f" y2="%.2f" stroke="%s" stroke-width="%.1f"/>`+"\n",
+		x1, y1, x2, y2, svgColor(col), width)
+}
+
+func (s *svgCanvas) rect(x, y, w, h float64, col color.RGBA) {
+	fmt.Fprintf(&s.buf, `<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f" fill="%s"/>`+"\n", x, y, w, h, svgColor(col))
+}
+
+func (s *svgCanvas) polygon(pts []point, col color.RGBA) {
+	coords := make([]string, len(pts))
+	for i, p := range pts {
+		coords[i] = fmt.Sprintf("%.2f,%.2f", p.x, p.y)
+	}
+	fmt.Fprintf(&s.buf, `<polygon points="%s" fill="%s"/>`+"\n", strings.Join(coords, " "), svgColor(col))
+}
+
+func (s *svgCanvas) text(x, y float64, str string, col color.RGBA, alignRight bool) {
+	anchor := "start"
+	if alignRight {
+		anchor = "end"
+	}
+	fmt.Fprintf(&s.buf, `<text x="%.2f" y="%.2f" fill="%s" font-family="monospace" font-size="11" text-anchor="%s">%s</text>`+"\n",
+		x, y, svgColor(col), anchor, html.EscapeString(str))
+}
+
+func (c *Chart) svgBody() []byte {
+	cv := &svgCanvas{}
+	c.draw(cv)
+	return cv.buf.Bytes()
+}
+
+func (c *Chart) SVG(w io.Writer) error {
+	_, err := fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n%s</svg>\n",
+		c.Width, c.Height, c.Width, c.Height, c.svgBody())
+	return err
+}